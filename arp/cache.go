@@ -2,6 +2,7 @@ package arp
 
 import (
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -9,6 +10,7 @@ import (
 type cache struct {
 	lastUpdate int64
 	table      atomic.Value
+	mu         sync.Mutex // serializes set
 }
 
 func (c *cache) get() Table {
@@ -24,6 +26,21 @@ func (c *cache) get() Table {
 	return t
 }
 
+// set records mac as the MAC address of ip in the current table.
+func (c *cache) set(ip net.IP, mac net.HardwareAddr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, _ := c.table.Load().(Table)
+	nt := make(Table, 0, len(t)+1)
+	for _, e := range t {
+		if !e.IP.Equal(ip) {
+			nt = append(nt, e)
+		}
+	}
+	nt = append(nt, Entry{IP: ip, MAC: mac})
+	c.table.Store(nt)
+}
+
 var global = &cache{}
 
 func SearchMAC(ip net.IP) net.HardwareAddr {
@@ -33,3 +50,9 @@ func SearchMAC(ip net.IP) net.HardwareAddr {
 func SearchIP(mac net.HardwareAddr) net.IP {
 	return global.get().SearchIP(mac)
 }
+
+// Set records mac as the MAC address of ip so it is found without waiting for
+// the next refresh of the table.
+func Set(ip net.IP, mac net.HardwareAddr) {
+	global.set(ip, mac)
+}
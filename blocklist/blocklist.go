@@ -0,0 +1,213 @@
+// Package blocklist implements local domain blocking from hosts files, domain
+// lists and response policy zones (RPZ).
+package blocklist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/internal/dnsreply"
+	"github.com/nextdns/nextdns/internal/fileinfo"
+	"github.com/nextdns/nextdns/resolver"
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+// blockTTL is the TTL of synthesized block responses.
+const blockTTL = 60
+
+var errNotBlocked = errors.New("not blocked")
+
+// Response defines how blocked queries are answered. The zero value answers
+// with NXDOMAIN.
+type Response struct {
+	ipv4 net.IP
+	ipv6 net.IP
+}
+
+// ParseResponse parses a block response definition: "nxdomain", "null" to
+// answer with 0.0.0.0 and :: or an IP address.
+func ParseResponse(s string) (Response, error) {
+	switch strings.ToLower(s) {
+	case "", "nxdomain":
+		return Response{}, nil
+	case "null":
+		return Response{ipv4: net.IPv4zero, ipv6: net.IPv6zero}, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return Response{}, fmt.Errorf("%s: invalid block response", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return Response{ipv4: ip4}, nil
+	}
+	return Response{ipv6: ip}, nil
+}
+
+// Blocklist answers queries for names listed in local block lists.
+//
+// Supported file formats are hosts files (IP followed by names), domain lists
+// (one domain per line, blocking subdomains too) and response policy zones.
+// The format is detected automatically or can be forced by prefixing the path
+// with "hosts:", "domains:" or "rpz:".
+//
+// Files are reloaded automatically when they change.
+type Blocklist struct {
+	// Files is the list of block list files to load.
+	Files []string
+
+	// Response defines how blocked queries are answered.
+	Response Response
+
+	// Enabled returns whether blocking is currently enabled. If nil, blocking
+	// is always enabled.
+	Enabled func() bool
+
+	// OnError is called when a list cannot be loaded. The previously loaded
+	// version of the lists is kept in such case.
+	OnError func(err error)
+
+	mu        sync.RWMutex
+	trie      *trie
+	fileInfos []fileinfo.Info
+	expires   time.Time
+}
+
+// Resolve implements the resolver.Resolver interface. An error is returned if
+// q is not blocked.
+func (b *Blocklist) Resolve(ctx context.Context, q query.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	if b.Enabled != nil && !b.Enabled() {
+		return 0, i, errNotBlocked
+	}
+	t := b.get()
+	if t == nil {
+		return 0, i, errNotBlocked
+	}
+	e := t.lookup(strings.ToLower(q.Name))
+	var ips []net.IP
+	rcode := dnsmessage.RCodeSuccess
+	switch e.action {
+	case ActionNone, ActionPassthru:
+		return 0, i, errNotBlocked
+	case ActionBlock:
+		if b.Response.ipv4 == nil && b.Response.ipv6 == nil {
+			rcode = dnsmessage.RCodeNameError
+		}
+		ips = []net.IP{b.Response.ipv4, b.Response.ipv6}
+	case ActionNXDomain:
+		rcode = dnsmessage.RCodeNameError
+	case ActionLocalData:
+		ips = e.ips
+	}
+	i.Transport = "blocklist"
+	i.Blocked = true
+	n, err = dnsreply.Write(q, buf, rcode, false, answers(q, ips), nil)
+	return n, i, err
+}
+
+// answers returns the A or AAAA records of ips matching the query type.
+func answers(q query.Query, ips []net.IP) []dnsmessage.Resource {
+	name, err := dnsmessage.NewName(q.Name)
+	if err != nil {
+		return nil
+	}
+	rh := dnsmessage.ResourceHeader{
+		Name:  name,
+		Class: dnsmessage.ClassINET,
+		TTL:   blockTTL,
+	}
+	var rrs []dnsmessage.Resource
+	for _, ip := range ips {
+		if ip == nil {
+			continue
+		}
+		ip4 := ip.To4()
+		switch {
+		case q.Type == query.TypeA && ip4 != nil:
+			rr := &dnsmessage.AResource{}
+			copy(rr.A[:], ip4)
+			rh.Type = dnsmessage.TypeA
+			rrs = append(rrs, dnsmessage.Resource{Header: rh, Body: rr})
+		case q.Type == query.TypeAAAA && ip4 == nil:
+			rr := &dnsmessage.AAAAResource{}
+			copy(rr.AAAA[:], ip.To16())
+			rh.Type = dnsmessage.TypeAAAA
+			rrs = append(rrs, dnsmessage.Resource{Header: rh, Body: rr})
+		}
+	}
+	return rrs
+}
+
+func (b *Blocklist) get() *trie {
+	b.mu.RLock()
+	expired := !time.Now().Before(b.expires)
+	t := b.trie
+	b.mu.RUnlock()
+	if !expired {
+		return t
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refreshLocked()
+	return b.trie
+}
+
+func (b *Blocklist) refreshLocked() {
+	now := time.Now()
+	if now.Before(b.expires) {
+		return
+	}
+	b.expires = now.Add(5 * time.Second)
+
+	if b.fileInfos != nil && len(b.fileInfos) == len(b.Files) {
+		changed := false
+		for _, fi := range b.fileInfos {
+			if !fi.Equal(fi.Path) {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			return
+		}
+	}
+
+	t, fileInfos, err := b.load()
+	// Record the file states even on error so a broken file is not reparsed
+	// (and reported) until changed again.
+	b.fileInfos = fileInfos
+	if err != nil {
+		if b.OnError != nil {
+			b.OnError(err)
+		}
+		return
+	}
+	b.trie = t
+}
+
+func (b *Blocklist) load() (*trie, []fileinfo.Info, error) {
+	t := &trie{}
+	fileInfos := make([]fileinfo.Info, 0, len(b.Files))
+	var errs []error
+	for _, file := range b.Files {
+		format, path := splitFormat(file)
+		fi, err := fileinfo.Get(path)
+		fileInfos = append(fileInfos, fi)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := loadFile(t, format, path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fileInfos, errors.Join(errs...)
+	}
+	return t, fileInfos, nil
+}
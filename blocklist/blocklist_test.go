@@ -0,0 +1,149 @@
+package blocklist
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/internal/dnstest"
+)
+
+const testHosts = `# Comment
+127.0.0.1 localhost
+::1 localhost ip6-localhost
+0.0.0.0 ads.example.com tracker.example.com # inline comment
+0.0.0.0 0.0.0.0
+`
+
+const testDomains = `# Comment
+Malware.example
+*.wild.example
+`
+
+const testRPZ = `$TTL 300
+@ IN SOA localhost. admin.localhost. 1 3600 600 86400 60
+  IN NS  localhost.
+nx.example          CNAME .
+*.nx.example        CNAME .
+nodata.example      CNAME *.
+allowed.malware.example CNAME rpz-passthru.
+local.example       A     10.0.0.1
+local.example       A     10.0.0.2
+local.example       AAAA  fd00::1
+32.1.0.0.10.rpz-ip  CNAME .
+`
+
+func newTestBlocklist(t *testing.T, files map[string]string, resp string) *Blocklist {
+	t.Helper()
+	dir := t.TempDir()
+	b := &Blocklist{OnError: func(err error) { t.Errorf("OnError: %v", err) }}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		b.Files = append(b.Files, path)
+	}
+	var err error
+	if b.Response, err = ParseResponse(resp); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestBlocklist_Resolve(t *testing.T) {
+	files := map[string]string{
+		"hosts":      testHosts,
+		"domains":    testDomains,
+		"policy.rpz": testRPZ,
+	}
+	tests := []struct {
+		name       string
+		resp       string
+		qname      string
+		qtype      dnsmessage.Type
+		notBlocked bool
+		want       dnstest.Result
+	}{
+		{name: "hosts", qname: "ads.example.com.", qtype: dnsmessage.TypeA, want: dnstest.Result{RCode: dnsmessage.RCodeNameError}},
+		{name: "hosts subdomain", qname: "sub.ads.example.com.", qtype: dnsmessage.TypeA, notBlocked: true},
+		{name: "hosts localhost", qname: "localhost.", qtype: dnsmessage.TypeA, notBlocked: true},
+		{name: "domains", qname: "malware.example.", qtype: dnsmessage.TypeA, want: dnstest.Result{RCode: dnsmessage.RCodeNameError}},
+		{name: "domains subdomain", qname: "a.b.Malware.example.", qtype: dnsmessage.TypeA, want: dnstest.Result{RCode: dnsmessage.RCodeNameError}},
+		{name: "domains wildcard", qname: "a.wild.example.", qtype: dnsmessage.TypeA, want: dnstest.Result{RCode: dnsmessage.RCodeNameError}},
+		{name: "domains wildcard apex", qname: "wild.example.", qtype: dnsmessage.TypeA, notBlocked: true},
+		{name: "null A", resp: "null", qname: "ads.example.com.", qtype: dnsmessage.TypeA, want: dnstest.Result{Answers: []string{"ads.example.com. A 0.0.0.0 60"}}},
+		{name: "null AAAA", resp: "null", qname: "ads.example.com.", qtype: dnsmessage.TypeAAAA, want: dnstest.Result{Answers: []string{"ads.example.com. AAAA :: 60"}}},
+		{name: "IP", resp: "192.168.1.1", qname: "ads.example.com.", qtype: dnsmessage.TypeA, want: dnstest.Result{Answers: []string{"ads.example.com. A 192.168.1.1 60"}}},
+		{name: "IP other family", resp: "192.168.1.1", qname: "ads.example.com.", qtype: dnsmessage.TypeAAAA, want: dnstest.Result{}},
+		{name: "rpz nxdomain", resp: "null", qname: "nx.example.", qtype: dnsmessage.TypeA, want: dnstest.Result{RCode: dnsmessage.RCodeNameError}},
+		{name: "rpz nxdomain wildcard", resp: "null", qname: "a.nx.example.", qtype: dnsmessage.TypeA, want: dnstest.Result{RCode: dnsmessage.RCodeNameError}},
+		{name: "rpz nodata", qname: "nodata.example.", qtype: dnsmessage.TypeA, want: dnstest.Result{}},
+		{name: "rpz passthru", qname: "allowed.malware.example.", qtype: dnsmessage.TypeA, notBlocked: true},
+		{name: "rpz local data A", qname: "local.example.", qtype: dnsmessage.TypeA, want: dnstest.Result{Answers: []string{"local.example. A 10.0.0.1 60", "local.example. A 10.0.0.2 60"}}},
+		{name: "rpz local data AAAA", qname: "local.example.", qtype: dnsmessage.TypeAAAA, want: dnstest.Result{Answers: []string{"local.example. AAAA fd00::1 60"}}},
+		{name: "rpz ip trigger", qname: "32.1.0.0.10.rpz-ip.", qtype: dnsmessage.TypeA, notBlocked: true},
+		{name: "not listed", qname: "example.com.", qtype: dnsmessage.TypeA, notBlocked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBlocklist(t, files, tt.resp)
+			buf := make([]byte, 512)
+			n, i, err := b.Resolve(context.Background(), dnstest.NewQuery(t, tt.qname, tt.qtype), buf)
+			if tt.notBlocked {
+				if err == nil {
+					t.Fatalf("Resolve() err = nil, want not blocked")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve() err = %v", err)
+			}
+			if !i.Blocked || i.Transport != "blocklist" {
+				t.Errorf("Resolve() info = %+v, want blocked", i)
+			}
+			if got := dnstest.ParseResult(t, buf[:n]); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Resolve() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlocklist_Enabled(t *testing.T) {
+	b := newTestBlocklist(t, map[string]string{"hosts": testHosts}, "")
+	enabled := false
+	b.Enabled = func() bool { return enabled }
+	q := dnstest.NewQuery(t, "ads.example.com.", dnsmessage.TypeA)
+	buf := make([]byte, 512)
+	if _, _, err := b.Resolve(context.Background(), q, buf); err == nil {
+		t.Errorf("Resolve() err = nil while disabled, want not blocked")
+	}
+	enabled = true
+	if _, _, err := b.Resolve(context.Background(), q, buf); err != nil {
+		t.Errorf("Resolve() err = %v while enabled", err)
+	}
+}
+
+func Test_splitFormat(t *testing.T) {
+	tests := []struct {
+		file       string
+		wantFormat string
+		wantPath   string
+	}{
+		{"/etc/hosts", formatAuto, "/etc/hosts"},
+		{"rpz:/etc/block.txt", formatRPZ, "/etc/block.txt"},
+		{"domains:list", formatDomains, "list"},
+		{`C:\list.txt`, formatAuto, `C:\list.txt`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			format, path := splitFormat(tt.file)
+			if format != tt.wantFormat || path != tt.wantPath {
+				t.Errorf("splitFormat() = %q, %q, want %q, %q", format, path, tt.wantFormat, tt.wantPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package blocklist
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/zone"
+)
+
+const (
+	formatAuto    = ""
+	formatHosts   = "hosts"
+	formatDomains = "domains"
+	formatRPZ     = "rpz"
+)
+
+// splitFormat returns the format forced with a "format:" prefix in file if
+// any, and the path of the file.
+func splitFormat(file string) (format, path string) {
+	if f, p, found := strings.Cut(file, ":"); found {
+		switch f {
+		case formatHosts, formatDomains, formatRPZ:
+			return f, p
+		}
+	}
+	return formatAuto, file
+}
+
+func loadFile(t *trie, format, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if format == formatAuto {
+		format = detectFormat(path, r)
+	}
+	if format == formatRPZ {
+		return loadRPZ(t, r)
+	}
+	return loadList(t, format, r)
+}
+
+// detectFormat guesses if the file is a response policy zone from its
+// extension or content. Hosts files and domain lists are handled by the same
+// line based parser.
+func detectFormat(path string, r *bufio.Reader) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".rpz", ".zone":
+		return formatRPZ
+	}
+	head, _ := r.Peek(4096)
+	for line := range bytes.Lines(head) {
+		fields := bytes.Fields(line)
+		if len(fields) == 0 || fields[0][0] == ';' || fields[0][0] == '#' {
+			continue
+		}
+		if fields[0][0] == '$' {
+			return formatRPZ
+		}
+		for _, f := range fields {
+			if bytes.EqualFold(f, []byte("SOA")) {
+				return formatRPZ
+			}
+		}
+	}
+	return formatAuto
+}
+
+// ignoredHosts lists names commonly found in hosts based block lists that must
+// not be blocked.
+var ignoredHosts = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+	"0.0.0.0":               true,
+}
+
+// loadList parses hosts files ("0.0.0.0 example.com") and domain lists
+// ("example.com"). Names from hosts files are blocked as is while names from
+// domain lists are blocked with their subdomains. A "*." prefix in domain lists
+// blocks subdomains only. When format is forced, lines not matching the format
+// are ignored.
+func loadList(t *trie, format string, r io.Reader) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if idx := strings.IndexByte(line, '#'); idx != -1 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			continue
+		case len(fields) == 1 && format != formatHosts:
+			name := strings.ToLower(fields[0])
+			if sub, found := strings.CutPrefix(name, "*."); found {
+				t.insert(sub, true, ActionBlock, nil)
+				continue
+			}
+			t.insert(name, false, ActionBlock, nil)
+			t.insert(name, true, ActionBlock, nil)
+		case format != formatDomains && net.ParseIP(fields[0]) != nil:
+			for _, name := range fields[1:] {
+				name = strings.ToLower(strings.TrimSuffix(name, "."))
+				if ignoredHosts[name] {
+					continue
+				}
+				t.insert(name, false, ActionBlock, nil)
+			}
+		}
+	}
+	return s.Err()
+}
+
+// loadRPZ parses a response policy zone. Only QNAME triggers are supported,
+// other triggers are ignored. Supported actions are NXDOMAIN (CNAME .), NODATA
+// (CNAME *.), PASSTHRU (CNAME rpz-passthru.) and A/AAAA local data. Other
+// actions and local data are handled as a regular block.
+func loadRPZ(t *trie, r io.Reader) error {
+	var origin string
+	var errs []error
+	err := zone.Walk(r, func(rr dnsmessage.Resource) {
+		owner := rr.Header.Name.String()
+		switch rr.Header.Type {
+		case dnsmessage.TypeSOA:
+			origin = owner
+			return
+		case dnsmessage.TypeNS:
+			return
+		}
+		if owner == origin {
+			return
+		}
+		name := strings.TrimSuffix(owner, ".")
+		if origin != "" && origin != "." {
+			var found bool
+			if name, found = strings.CutSuffix(owner, "."+origin); !found {
+				errs = append(errs, fmt.Errorf("%s: outside of zone %s", owner, origin))
+				return
+			}
+		}
+		// Ignore triggers other than QNAME (rpz-ip, rpz-nsdname, rpz-nsip,
+		// rpz-client-ip).
+		if idx := strings.LastIndexByte(name, '.'); strings.HasPrefix(name[idx+1:], "rpz-") {
+			return
+		}
+		name, sub := strings.CutPrefix(name, "*.")
+		var a Action
+		var ips []net.IP
+		switch b := rr.Body.(type) {
+		case *dnsmessage.CNAMEResource:
+			switch b.CNAME.String() {
+			case ".":
+				a = ActionNXDomain
+			case "*.":
+				a = ActionNoData
+			case "rpz-passthru.":
+				a = ActionPassthru
+			default:
+				a = ActionBlock
+			}
+		case *dnsmessage.AResource:
+			a, ips = ActionLocalData, []net.IP{net.IP(b.A[:])}
+		case *dnsmessage.AAAAResource:
+			a, ips = ActionLocalData, []net.IP{net.IP(b.AAAA[:])}
+		default:
+			a = ActionBlock
+		}
+		t.insert(name, sub, a, ips)
+	})
+	if err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,137 @@
+package blocklist
+
+import (
+	"net"
+	"slices"
+	"strings"
+)
+
+// Action defines what to do with a query matching a blocklist entry.
+type Action uint8
+
+const (
+	// ActionNone means the name is not listed.
+	ActionNone Action = iota
+
+	// ActionBlock answers with the configured block response.
+	ActionBlock
+
+	// ActionNXDomain answers with NXDOMAIN regardless of the configured
+	// block response (RPZ "CNAME .").
+	ActionNXDomain
+
+	// ActionNoData answers with an empty response (RPZ "CNAME *.").
+	ActionNoData
+
+	// ActionPassthru exempts the name from blocking (RPZ "CNAME
+	// rpz-passthru.").
+	ActionPassthru
+
+	// ActionLocalData answers with the IPs attached to the entry (RPZ A/AAAA
+	// local data).
+	ActionLocalData
+)
+
+// trie is a suffix trie of domain labels, starting from the TLD. Children are
+// stored as sorted slices rather than maps to keep the memory footprint low
+// with large lists.
+type trie struct {
+	root node
+	size int
+}
+
+type node struct {
+	labels   []string
+	children []*node
+
+	// exact applies to the name ending at this node.
+	exact entry
+	// sub applies to all the subdomains of the name ending at this node.
+	sub entry
+}
+
+type entry struct {
+	action Action
+	// ips holds local data for ActionLocalData.
+	ips []net.IP
+}
+
+func (n *node) child(label string, create bool) *node {
+	i, found := slices.BinarySearch(n.labels, label)
+	if found {
+		return n.children[i]
+	}
+	if !create {
+		return nil
+	}
+	c := &node{}
+	n.labels = slices.Insert(n.labels, i, label)
+	n.children = slices.Insert(n.children, i, c)
+	return c
+}
+
+// insert adds name to the trie. When subdomains is true, the action applies to
+// subdomains of name and not to name itself. A passthru always wins over other
+// actions, otherwise the first definition of a name wins.
+func (t *trie) insert(name string, subdomains bool, a Action, ips []net.IP) {
+	n := &t.root
+	for label := range labels(name) {
+		n = n.child(label, true)
+	}
+	e := &n.exact
+	if subdomains {
+		e = &n.sub
+	}
+	switch {
+	case e.action == ActionNone:
+		t.size++
+	case a == ActionPassthru:
+		e.ips = nil
+	case a == ActionLocalData && e.action == ActionLocalData:
+		// Several records for the same name.
+		e.ips = append(e.ips, ips...)
+		return
+	default:
+		return
+	}
+	e.action = a
+	if a == ActionLocalData {
+		e.ips = append(e.ips, ips...)
+	}
+}
+
+// lookup returns the action for name. The most specific match wins.
+func (t *trie) lookup(name string) entry {
+	n := &t.root
+	var e entry
+	for label := range labels(name) {
+		if n.sub.action != ActionNone {
+			e = n.sub
+		}
+		if n = n.child(label, false); n == nil {
+			return e
+		}
+	}
+	if n.exact.action != ActionNone {
+		return n.exact
+	}
+	return e
+}
+
+// labels iterates over the labels of name starting from the TLD.
+func labels(name string) func(yield func(string) bool) {
+	return func(yield func(string) bool) {
+		name = strings.TrimSuffix(name, ".")
+		for name != "" {
+			var label string
+			if idx := strings.LastIndexByte(name, '.'); idx != -1 {
+				label, name = name[idx+1:], name[:idx]
+			} else {
+				label, name = name, ""
+			}
+			if !yield(label) {
+				return
+			}
+		}
+	}
+}
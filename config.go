@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"os/exec"
@@ -52,6 +53,41 @@ func cfg(args []string) error {
 		c.Parse("nextdns config edit", []string{"-config-file", tmp.Name()}, true)
 		c.File = ""
 		return c.Save()
+	case "validate":
+		file, err := configFile("nextdns config validate", args)
+		if err != nil {
+			return err
+		}
+		errs := config.ValidateFile(file)
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("%s: %d error(s) found", file, len(errs))
+		}
+		fmt.Printf("%s: configuration valid\n", file)
+		return nil
+	case "convert":
+		fs := flag.NewFlagSet("nextdns config convert", flag.ExitOnError)
+		file := fs.String("config-file", "", "Custom path to configuration file.")
+		write := fs.Bool("w", false, "Replace the configuration file, keeping a copy of the legacy file with the .legacy extension.")
+		_ = fs.Parse(args)
+		path, err := (&config.Config{File: *file}).ConfigFile()
+		if err != nil {
+			return err
+		}
+		b, err := config.ConvertFile(path)
+		if err != nil {
+			return err
+		}
+		if !*write {
+			_, err = os.Stdout.Write(b)
+			return err
+		}
+		if err := os.Rename(path, path+".legacy"); err != nil {
+			return err
+		}
+		return os.WriteFile(path, b, 0644)
 	case "wizard":
 		return installer("configure")
 	default:
@@ -60,6 +96,18 @@ func cfg(args []string) error {
 			"  config set [options]     set a configuration option\n" +
 			"                           (see config set -h for list of options)\n" +
 			"  config edit              edit configuration using default editor\n" +
+			"  config validate          check the configuration file\n" +
+			"  config convert [-w]      convert the configuration file to the\n" +
+			"                           structured format\n" +
 			"  config wizard            run the configuration wizard")
 	}
 }
+
+// configFile returns the path of the configuration file, set with the
+// -config-file flag or the default of the platform.
+func configFile(cmd string, args []string) (string, error) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	file := fs.String("config-file", "", "Custom path to configuration file.")
+	_ = fs.Parse(args)
+	return (&config.Config{File: *file}).ConfigFile()
+}
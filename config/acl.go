@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// aclRule is a client condition used to allow or deny access to the proxy.
+type aclRule struct {
+	profile
+	def string
+}
+
+// ACLRules is a list of client conditions. A condition is either an IP or
+// subnet, a MAC address or the name of the interface the query is received on.
+type ACLRules []aclRule
+
+// Match returns true if any of the rules matches the client.
+func (r ACLRules) Match(sourceIP, destIP net.IP, mac net.HardwareAddr) bool {
+	for _, rule := range r {
+		if rule.Match(sourceIP, destIP, mac, "", "") {
+			return true
+		}
+	}
+	return false
+}
+
+// String is the method to format the flag's value
+func (r *ACLRules) String() string {
+	return fmt.Sprint(r.Strings())
+}
+
+func (r *ACLRules) Strings() []string {
+	if r == nil {
+		return nil
+	}
+	var s []string
+	for _, rule := range *r {
+		s = append(s, rule.def)
+	}
+	return s
+}
+
+// Set is the method to set the flag value, part of the flag.Value interface.
+func (r *ACLRules) Set(value string) error {
+	value = strings.TrimSpace(value)
+	p, err := parseCondition(value)
+	if err != nil {
+		return err
+	}
+	if p.User != "" {
+		return fmt.Errorf("%s: user conditions are not supported", value)
+	}
+	if p.Device != "" {
+		return fmt.Errorf("%s: device conditions are not supported", value)
+	}
+	for _, rule := range *r {
+		if rule.def == value {
+			return nil
+		}
+	}
+	*r = append(*r, aclRule{profile: p, def: value})
+	return nil
+}
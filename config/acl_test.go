@@ -0,0 +1,41 @@
+package config
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestACLRules_Match(t *testing.T) {
+	var rules ACLRules
+	for _, v := range []string{"10.0.0.0/8", "192.168.1.5", "28:a0:2b:56:e9:66", "10.0.0.0/8"} {
+		if err := rules.Set(v); err != nil {
+			t.Fatalf("Set(%q) err = %v", v, err)
+		}
+	}
+	if got, want := rules.Strings(), []string{"10.0.0.0/8", "192.168.1.5", "28:a0:2b:56:e9:66"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Strings() = %v, want %v", got, want)
+	}
+	mac, _ := net.ParseMAC("28:a0:2b:56:e9:66")
+	tests := []struct {
+		name     string
+		sourceIP string
+		mac      net.HardwareAddr
+		want     bool
+	}{
+		{"subnet", "10.1.2.3", nil, true},
+		{"ip", "192.168.1.5", nil, true},
+		{"mac", "192.168.1.6", mac, true},
+		{"no match", "192.168.1.6", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.Match(net.ParseIP(tt.sourceIP), nil, tt.mac); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if err := rules.Set("@user"); err == nil {
+		t.Error("Set(@user) err = nil, want error")
+	}
+}
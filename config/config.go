@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -14,28 +15,58 @@ import (
 )
 
 type Config struct {
-	File                 string
-	Listens              []string
-	Control              string
-	ConfigDeprecated     Profiles
-	Profile              Profiles
-	Forwarders           Forwarders
-	LogQueries           bool
-	CacheSize            string
-	CacheMetrics         bool
-	CacheMaxAge          time.Duration
-	MaxTTL               time.Duration
-	ReportClientInfo     bool
-	DiscoveryDNS         string
-	MDNS                 string
-	DetectCaptivePortals bool
-	BogusPriv            bool
-	UseHosts             bool
-	Timeout              time.Duration
-	MaxInflightRequests  uint
-	SetupRouter          bool
-	AutoActivate         bool
-	Debug                bool
+	File                  string
+	Listens               []string
+	Control               string
+	ConfigDeprecated      Profiles
+	Profile               Profiles
+	Forwarders            Forwarders
+	DNSSECTrustAnchors    string
+	LogQueries            bool
+	CacheSize             string
+	CacheMetrics          bool
+	CacheMaxAge           time.Duration
+	MaxTTL                time.Duration
+	ReportClientInfo      bool
+	DiscoveryDNS          string
+	MDNS                  string
+	MDNSReflector         []string
+	MDNSReflectorServices []string
+	MDNSGateway           bool
+	NetBIOS               string
+	SSDP                  string
+	LocalDomain           string
+	DHCPLeases            []string
+	StaticDevices         string
+	DiscoveryStore        string
+	DiscoveryStoreMaxAge  time.Duration
+	WatchNeighbors        bool
+	DetectCaptivePortals  bool
+	BogusPriv             bool
+	Privacy               string
+	ECS                   string
+	Proxy                 string
+	RebindProtection      string
+	RebindAllowlist       []string
+	DNS64                 bool
+	DNS64Prefix           string
+	UseHosts              bool
+	ZoneFiles             []string
+	Blocklists            []string
+	BlocklistMode         string
+	BlocklistResponse     string
+	Timeout               time.Duration
+	MaxInflightRequests   uint
+	ACLAllow              ACLRules
+	ACLDeny               ACLRules
+	ACLAction             string
+	RateLimit             uint
+	RateLimitBurst        uint
+	RateLimitKey          string
+	RateLimitAction       string
+	SetupRouter           bool
+	AutoActivate          bool
+	Debug                 bool
 }
 
 func (c *Config) Parse(cmd string, args []string, useStorage bool) {
@@ -44,17 +75,61 @@ func (c *Config) Parse(cmd string, args []string, useStorage bool) {
 	}
 	fs := c.flagSet(cmd)
 	fs.Parse(args, useStorage)
-	defaultListen := "localhost:53"
+	c.setDefaultListen()
+	if c.SetupRouter && (len(c.Listens) > 1 || c.Listens[0] != defaultListen()) {
+		fmt.Fprintln(fs.flag.Output(), "WARNING: listen is ignored when setup-router is enabled")
+	}
+}
+
+// Load is like Parse but returns an error instead of exiting when the
+// arguments or the stored configuration are invalid. It is used to reload the
+// configuration of a running daemon.
+func (c *Config) Load(cmd string, args []string, useStorage bool) error {
+	if cmd == "" {
+		cmd = os.Args[0]
+	}
+	fs := c.flagSet(cmd)
+	fs.flag.Init(" "+cmd, flag.ContinueOnError)
+	fs.flag.SetOutput(io.Discard)
+	if err := fs.parse(args, useStorage); err != nil {
+		return err
+	}
+	c.setDefaultListen()
+	return nil
+}
+
+func defaultListen() string {
 	if runtime.GOOS == "windows" {
-		defaultListen = "127.0.0.1:53"
+		return "127.0.0.1:53"
 	}
+	return "localhost:53"
+}
+
+func (c *Config) setDefaultListen() {
 	if len(c.Listens) == 0 {
-		c.Listens = []string{defaultListen}
-	} else {
-		if c.SetupRouter && (len(c.Listens) > 1 || c.Listens[0] != defaultListen) {
-			fmt.Fprintln(fs.flag.Output(), "WARNING: listen is ignored when setup-router is enabled")
+		c.Listens = []string{defaultListen()}
+	}
+}
+
+// Diff returns the sorted names of the settings having a different value in c
+// and c2.
+func (c *Config) Diff(c2 *Config) []string {
+	s1, s2 := c.flagSet("").storage, c2.flagSet("").storage
+	var names []string
+	for name, e := range s1 {
+		if !slices.Equal(entryValues(e), entryValues(s2[name])) {
+			names = append(names, name)
 		}
 	}
+	slices.Sort(names)
+	return names
+}
+
+func entryValues(e service.ConfigEntry) []string {
+	if e, ok := e.(service.ConfigListEntry); ok {
+		return e.Strings()
+	}
+	return []string{e.String()}
 }
 
 func (c *Config) Save() error {
@@ -63,9 +138,25 @@ func (c *Config) Save() error {
 	if err != nil {
 		return err
 	}
+	if file := storerFile(cs); file != "" && isStructuredFile(file) {
+		return saveStructured(file, fs.storage)
+	}
 	return cs.SaveConfig(fs.storage)
 }
 
+// ConfigFile returns the path of the file the configuration is stored in.
+func (c *Config) ConfigFile() (string, error) {
+	cs, err := c.flagSet("").storer()
+	if err != nil {
+		return "", err
+	}
+	file := storerFile(cs)
+	if file == "" {
+		return "", errors.New("configuration not stored in a file")
+	}
+	return file, nil
+}
+
 func (c *Config) Write(w io.Writer) error {
 	fs := c.flagSet("")
 	for name, entry := range fs.storage {
@@ -87,7 +178,13 @@ func (c *Config) flagSet(cmd string) flagSet {
 	}
 	if cmd != "" {
 		fs.flag = flag.NewFlagSet(" "+cmd, flag.ExitOnError)
-		fs.flag.StringVar(&c.File, "config-file", "", "Custom path to configuration file.")
+		fs.flag.StringVar(&c.File, "config-file", "",
+			"Custom path to configuration file.\n"+
+				"\n"+
+				"Files with a .toml extension or using key = value lines are read\n"+
+				"in the structured format, with [listeners], [cache] and [discovery]\n"+
+				"sections and [[profile]] and [[forwarder]] tables. Use nextdns\n"+
+				"config convert to convert a legacy file.")
 	}
 	fs.BoolVar(&c.Debug, "debug", false, "Enable debug logs.")
 	fs.StringsVar(&c.Listens, "listen", "Listen address for UDP DNS proxy server.")
@@ -107,6 +204,8 @@ func (c *Config) flagSet(cmd string) flagSet {
 			"  to all hosts behind this interface.\n"+
 			"* @alice=abcdef: On macOS, Windows and Linux (systemd-logind), the\n"+
 			"  active interactive user can be used to pick a profile for localhost requests.\n"+
+			"* device:kids-ipad=abcdef: A device name defined in the -static-devices\n"+
+			"  file.\n"+
 			"\n"+
 			"This parameter can be repeated. The first match wins.")
 	fs.Var(&c.Forwarders, "forwarder",
@@ -114,15 +213,38 @@ func (c *Config) flagSet(cmd string) flagSet {
 			"\n"+
 			"Forwarders can be defined to send proxy DNS traffic to an alternative\n"+
 			"DNS upstream resolver for specific domains. The format of this parameter\n"+
-			"is [DOMAIN=]SERVER_ADDR[,SERVER_ADDR...].\n"+
+			"is [DOMAIN=]SERVER_ADDR[,SERVER_ADDR...][;OPTION=VALUE...].\n"+
 			"\n"+
 			"A SERVER_ADDR can ben either an IP[:PORT] for DNS53 (unencrypted UDP,\n"+
 			"TCP), or a HTTPS URL for a DNS over HTTPS server. For DoH, a bootstrap\n"+
 			"IP can be specified as follow: https://dns.nextdns.io#45.90.28.0.\n"+
+			"An Oblivious DoH (RFC 9230) target can be used through a relay with\n"+
+			"odoh://odoh.target.com/dns-query?relay=https://relay.com/proxy.\n"+
 			"Several servers can be specified, separated by commas to implement\n"+
-			"failover."+
+			"failover.\n"+
+			"\n"+
+			"Supported options are:\n"+
+			"* ecs: EDNS client subnet policy, strip (default) to never send it,\n"+
+			"  passthrough to forward the one sent by the client or synthesize to\n"+
+			"  send the client IP truncated to /24 for IPv4 and /56 for IPv6. The\n"+
+			"  prefix lengths can be customized with synthesize/20/48.\n"+
+			"* dnssec: set to validate to validate DNSSEC signatures locally.\n"+
+			"  Answers failing validation are answered with SERVFAIL. The chain\n"+
+			"  of trust is fetched through the forwarder.\n"+
+			"* ca: path of a PEM file with extra root CAs to trust for DoH servers.\n"+
+			"* pin: comma separated list of base64 SHA-256 hashes of the server\n"+
+			"  certificate public key (SPKI). One certificate of the chain must match.\n"+
+			"* cert, key: paths of a PEM client certificate and key for mutual TLS.\n"+
+			"* tls-min: minimum TLS version (1.2 or 1.3, default 1.3).\n"+
+			"* method: HTTP method used for DoH, post (default) or get. With get,\n"+
+			"  queries are sent with a zero ID (RFC 8484) to be cacheable by HTTP\n"+
+			"  caches. Queries too long for a GET request are sent with POST.\n"+
 			"\n"+
 			"This parameter can be repeated. The first match wins.")
+	fs.StringVar(&c.DNSSECTrustAnchors, "dnssec-trust-anchors", "",
+		"Path of the file where the state of the DNSSEC root trust anchors is\n"+
+			"stored to follow key rollovers (RFC 5011) across restarts. If empty,\n"+
+			"the built-in root anchors are used and the state is kept in memory.")
 	fs.BoolVar(&c.LogQueries, "log-queries", false, "Log DNS queries.")
 	fs.StringVar(&c.CacheSize, "cache-size", "0",
 		"Set the size of the cache in byte. Use 0 to disable caching. The value\n"+
@@ -153,6 +275,66 @@ func (c *Config) flagSet(cmd string) flagSet {
 		"Enable mDNS to discover client information and serve mDNS learned names over DNS.\n"+
 			"Use \"all\" to listen on all interface or an interface name to limit mDNS on a\n"+
 			"specific network interface. Use \"disabled\" to disable mDNS altogether.")
+	fs.StringsVar(&c.MDNSReflector, "mdns-reflector",
+		"The name of an interface to reflect mDNS queries and responses between,\n"+
+			"so services announced on one network, like printers or Chromecasts on\n"+
+			"another VLAN, can be discovered from the others. This parameter must be\n"+
+			"repeated for at least two interfaces.")
+	fs.StringsVar(&c.MDNSReflectorServices, "mdns-reflector-services",
+		"A service type, like _ipp._tcp or _googlecast._tcp, to reflect. When\n"+
+			"not defined, all services are reflected. Host name queries and\n"+
+			"announcements are always reflected. This parameter can be repeated.")
+	fs.BoolVar(&c.MDNSGateway, "mdns-gateway", false,
+		"Answer queries for .local names from the mDNS cache, querying the name\n"+
+			"over mDNS when not known. Unknown names are answered with NXDOMAIN\n"+
+			"instead of being sent upstream. Requires mdns to be enabled.")
+	fs.StringVar(&c.NetBIOS, "netbios", "disabled",
+		"Enable NetBIOS to discover the names of Windows clients. Use \"all\" to\n"+
+			"discover clients of all networks or an interface name to limit discovery\n"+
+			"to the networks of a specific interface. When enabled, a NetBIOS node\n"+
+			"status query is also sent to private IPv4 clients of unknown name.")
+	fs.StringVar(&c.SSDP, "ssdp", "disabled",
+		"Enable SSDP to discover the name and model of UPnP clients like smart TVs\n"+
+			"and game consoles. Use \"all\" to listen on all interface or an interface\n"+
+			"name to limit listening to a specific network interface. The description\n"+
+			"of announced devices is fetched over HTTP from the announcing client.")
+	fs.StringVar(&c.LocalDomain, "local-domain", "",
+		"A domain, like lan, under which discovered clients are resolvable by\n"+
+			"name. Names of this domain and reverse lookups of discovered addresses\n"+
+			"are answered locally and never sent upstream. Unknown names of the\n"+
+			"domain are answered with NXDOMAIN.")
+	fs.StringsVar(&c.DHCPLeases, "dhcp-leases",
+		"A DHCP lease source used to discover client names, in the FORMAT:PATH\n"+
+			"form. Supported formats are isc-dhcpd, dnsmasq, odhcpd, kea-csv and\n"+
+			"kea-api, for which the path is the URL of the Kea control agent:\n"+
+			"* kea-csv:/var/lib/kea/kea-leases6.csv\n"+
+			"* kea-api:http://127.0.0.1:8000/\n"+
+			"\n"+
+			"When not defined, the lease files of the known DHCP servers are used.\n"+
+			"This parameter can be repeated.")
+	fs.StringVar(&c.StaticDevices, "static-devices", "",
+		"A file naming the devices of the network. Each line maps a MAC address,\n"+
+			"an IP or a CIDR to a name with an optional model and profile:\n"+
+			"\n"+
+			"    00:1c:42:2e:60:4a kids-ipad model=iPad profile=abcdef\n"+
+			"    10.0.3.0/24 \"Guest device\"\n"+
+			"\n"+
+			"Names defined in this file take precedence over discovered names and\n"+
+			"can be used in profile conditions. The profile of a device takes\n"+
+			"precedence over the -profile rules. The file is reloaded on change.")
+	fs.StringVar(&c.DiscoveryStore, "discovery-store", "",
+		"Path of the file where discovered client names are stored so clients\n"+
+			"keep their name across restarts until they are discovered again.\n"+
+			"Stored names are only used when no other source knows the client.\n"+
+			"If empty, discovered names are kept in memory only.")
+	fs.DurationVar(&c.DiscoveryStoreMaxAge, "discovery-store-max-age", 7*24*time.Hour,
+		"Duration after which a stored client name not discovered again is\n"+
+			"forgotten. Use 0 to never forget stored names.")
+	fs.BoolVar(&c.WatchNeighbors, "watch-neighbors", false,
+		"Listen for the neighbor events of the kernel to learn the MAC address of\n"+
+			"new clients right away instead of waiting for the next ARP and NDP table\n"+
+			"read. The MAC address of an unknown client is also resolved in the\n"+
+			"background so it is known by its next queries. Only supported on Linux.")
 	fs.BoolVar(&c.DetectCaptivePortals, "detect-captive-portals", false,
 		"Automatic detection of captive portals and fallback on system DNS to\n"+
 			"allow the connection to establish.\n"+
@@ -160,6 +342,29 @@ func (c *Config) flagSet(cmd string) flagSet {
 			"Beware that enabling this feature can allow an attacker to force nextdns\n"+
 			"to disable DoH and leak unencrypted DNS traffic.")
 	fs.BoolVar(new(bool), "hardened-privacy", false, "Deprecated.")
+	fs.StringVar(&c.Privacy, "privacy", "off",
+		"Comma separated list of privacy features applied to queries sent over\n"+
+			"encrypted transports (DoH and ODoH), or off:\n"+
+			"* padding: pad queries to a multiple of 128 bytes (RFC 8467).\n"+
+			"* 0x20: randomize the case of query names and reject answers not\n"+
+			"  echoing it.\n"+
+			"* strip-options: remove EDNS options set by clients like cookies or\n"+
+			"  the MAC address option.")
+	fs.StringVar(&c.ECS, "ecs", "strip",
+		"EDNS client subnet policy for the NextDNS upstream, strip to never send\n"+
+			"it, passthrough to forward the one sent by the client or synthesize to\n"+
+			"send the client IP truncated to /24 for IPv4 and /56 for IPv6. The\n"+
+			"prefix lengths can be customized with synthesize/20/48. Forwarders use\n"+
+			"their own ecs option.")
+	fs.StringVar(&c.Proxy, "proxy", "",
+		"Proxy to use to connect to DoH upstreams, for networks where direct\n"+
+			"HTTPS connections are blocked. Supported formats are:\n"+
+			"* http://[user:pass@]host:port for HTTP CONNECT proxies, or https://\n"+
+			"  to connect to the proxy over TLS.\n"+
+			"* socks5://[user:pass@]host:port for SOCKS5 proxies.\n"+
+			"* env to use the proxy defined by the HTTPS_PROXY and NO_PROXY\n"+
+			"  environment variables.\n"+
+			"The proxy applies to DoH forwarders as well. Plain DNS is never proxied.")
 	fs.BoolVar(&c.BogusPriv, "bogus-priv", true,
 		"Bogus private reverse lookups.\n"+
 			"\n"+
@@ -167,14 +372,107 @@ func (c *Config) flagSet(cmd string) flagSet {
 			"answered with \"no such domain\" rather than being forwarded upstream.\n"+
 			"The set of prefixes affected is the list given in RFC6303, for IPv4\n"+
 			"and IPv6.")
+	fs.StringVar(&c.RebindProtection, "rebind-protection", "off",
+		"Protect against DNS rebinding attacks by inspecting upstream answers\n"+
+			"resolving public names to private (RFC1918, ULA), loopback or link-local\n"+
+			"addresses:\n"+
+			"* off: answers are not inspected.\n"+
+			"* strip: private addresses are removed from answers.\n"+
+			"* refuse: answers containing private addresses are refused.\n"+
+			"\n"+
+			"Names resolved locally, by discovery or by conditional forwarders are\n"+
+			"exempt.")
+	fs.BoolVar(&c.DNS64, "dns64", false,
+		"Enable DNS64 (RFC 6147) for IPv6-only networks.\n"+
+			"\n"+
+			"AAAA records are synthesized from A records for names without IPv6\n"+
+			"address, ipv4only.arpa is answered locally and reverse lookups of\n"+
+			"synthesized addresses are mapped to their IPv4 counterpart.")
+	fs.StringVar(&c.DNS64Prefix, "dns64-prefix", "64:ff9b::/96",
+		"IPv6 prefix used to synthesize DNS64 addresses. The prefix length must be\n"+
+			"32, 40, 48, 56, 64 or 96.")
+	fs.StringsVar(&c.RebindAllowlist, "rebind-allow",
+		"Domain allowed to resolve to private addresses when rebind protection is\n"+
+			"enabled. Subdomains are included.\n"+
+			"\n"+
+			"This parameter can be repeated.")
 	fs.BoolVar(&c.UseHosts, "use-hosts", true,
 		"Lookup /etc/hosts before sending queries to upstream resolver.")
+	fs.StringsVar(&c.ZoneFiles, "zone-file",
+		"Path to a zone file defining local records served before sending queries\n"+
+			"to upstream resolvers.\n"+
+			"\n"+
+			"The file uses the standard zone file format with $ORIGIN and $TTL\n"+
+			"directives and supports A, AAAA, CNAME, TXT, SRV, MX, PTR, NS and SOA\n"+
+			"records as well as wildcards (*.example.lan.). Names under a zone\n"+
+			"defining a SOA record are answered authoritatively (NXDOMAIN for unknown\n"+
+			"names), other names are only answered if defined. Files are reloaded\n"+
+			"automatically when changed.\n"+
+			"\n"+
+			"This parameter can be repeated.")
+	fs.StringsVar(&c.Blocklists, "blocklist",
+		"Path to a local list of domains to block.\n"+
+			"\n"+
+			"Hosts files (0.0.0.0 example.com), domain lists (one domain per line,\n"+
+			"subdomains included) and response policy zones (RPZ) are supported. The\n"+
+			"format is detected automatically or can be forced by prefixing the path\n"+
+			"with hosts:, domains: or rpz:. Files are reloaded automatically when\n"+
+			"changed.\n"+
+			"\n"+
+			"This parameter can be repeated.")
+	fs.StringVar(&c.BlocklistMode, "blocklist-mode", "fallback",
+		"Defines when local blocklists are applied:\n"+
+			"* always: all queries are checked against the blocklists.\n"+
+			"* fallback: only while falling back to plain DNS, when the NextDNS\n"+
+			"  filtering is not available.")
+	fs.StringVar(&c.BlocklistResponse, "blocklist-response", "nxdomain",
+		"Response sent for domains blocked by local blocklists: nxdomain, null\n"+
+			"(0.0.0.0 and ::) or an IP address.")
 	fs.DurationVar(&c.Timeout, "timeout", 5*time.Second, "Maximum duration allowed for a request before failing.")
 	fs.UintVar(&c.MaxInflightRequests, "max-inflight-requests", 256,
 		"Maximum number of inflight requests handled by the proxy. No additional\n"+
 			"requests will not be answered after this threshold is met. Increasing\n"+
 			"this value can reduce latency in case of burst of requests but it can\n"+
 			"also increase significantly memory usage.")
+	fs.Var(&c.ACLAllow, "allow",
+		"Only allow queries from clients matching this condition.\n"+
+			"\n"+
+			"The condition can be a subnet (10.0.3.0/24), an IP, a MAC address\n"+
+			"(00:1c:42:2e:60:4a) or the name of the interface the query is received\n"+
+			"on (eth0). When no allow rule is defined, all clients not explicitly\n"+
+			"denied are allowed. MAC addresses are matched against the ARP and NDP\n"+
+			"tables; the EDNS0 MAC option is only trusted from localhost forwarders.\n"+
+			"\n"+
+			"This parameter can be repeated.")
+	fs.Var(&c.ACLDeny, "deny",
+		"Deny queries from clients matching this condition. Deny rules take\n"+
+			"precedence over allow rules. See -allow for the condition format.\n"+
+			"\n"+
+			"This parameter can be repeated.")
+	fs.StringVar(&c.ACLAction, "deny-action", "refuse",
+		"Action taken for queries from denied clients:\n"+
+			"* refuse: answer with REFUSED.\n"+
+			"* drop: do not answer.")
+	fs.UintVar(&c.RateLimit, "rate-limit", 0,
+		"Maximum number of queries per second accepted from a single client. Set\n"+
+			"to 0 to disable rate limiting.")
+	fs.UintVar(&c.RateLimitBurst, "rate-limit-burst", 100,
+		"Maximum number of queries a client can send at once before being rate\n"+
+			"limited.")
+	fs.StringVar(&c.RateLimitKey, "rate-limit-key", "ip",
+		"Defines how clients are identified for rate limiting:\n"+
+			"* ip: per client IP address.\n"+
+			"* mac: per client MAC address when known, IP address otherwise.\n"+
+			"* subnet: per /24 IPv4 or /56 IPv6 client subnet. The prefix lengths\n"+
+			"  can be customized with subnet/20/48.\n"+
+			"Clients are identified by the source address of the query and the MAC\n"+
+			"address found in the ARP and NDP tables, never by EDNS0 options.")
+	fs.StringVar(&c.RateLimitAction, "rate-limit-action", "refuse",
+		"Action taken for queries over the rate limit:\n"+
+			"* refuse: answer with REFUSED.\n"+
+			"* drop: do not answer.\n"+
+			"* truncate: answer with an empty truncated response to force clients\n"+
+			"  to retry over TCP (TCP queries are refused).")
 	fs.BoolVar(&c.SetupRouter, "setup-router", false,
 		"Automatically configure NextDNS for a router setup.\n"+
 			"Common types of router are detected to integrate gracefully. Changes\n"+
@@ -213,17 +511,32 @@ type flagSet struct {
 }
 
 func (fs flagSet) Parse(args []string, useStorage bool) {
+	if err := fs.parse(args, useStorage); err != nil {
+		fmt.Fprintln(fs.flag.Output(), err)
+		if errors.Is(err, errUnrecognizedParameter) {
+			fs.flag.PrintDefaults()
+		}
+		os.Exit(2)
+	}
+}
+
+var errUnrecognizedParameter = errors.New("unrecognized parameter")
+
+func (fs flagSet) parse(args []string, useStorage bool) error {
 	// Parse a copy of args to get the config file.
 	_ = fs.flag.Parse(append([]string{}, args...))
 	if useStorage || fs.config.File != "" {
 		cs, err := fs.storer()
 		if err != nil {
-			fmt.Fprintln(fs.flag.Output(), err)
-			os.Exit(2)
+			return err
 		}
-		if err = cs.LoadConfig(fs.storage); err != nil {
-			fmt.Fprintln(fs.flag.Output(), err)
-			os.Exit(2)
+		if file := storerFile(cs); file != "" && isStructuredFile(file) {
+			err = loadStructured(file, fs.storage)
+		} else {
+			err = cs.LoadConfig(fs.storage)
+		}
+		if err != nil {
+			return err
 		}
 	}
 
@@ -238,12 +551,13 @@ func (fs flagSet) Parse(args []string, useStorage bool) {
 		}
 	}
 
-	_ = fs.flag.Parse(args)
+	if err := fs.flag.Parse(args); err != nil {
+		return err
+	}
 	if len(fs.flag.Args()) > 0 {
-		fmt.Fprintf(fs.flag.Output(), "Unrecognized parameter: %v\n", fs.flag.Args()[0])
-		fs.flag.PrintDefaults()
-		os.Exit(2)
+		return fmt.Errorf("%w: %v", errUnrecognizedParameter, fs.flag.Args()[0])
 	}
+	return nil
 }
 
 func (fs flagSet) StringsVar(p *[]string, name string, usage string) {
@@ -296,3 +610,11 @@ func (fs flagSet) storer() (service.ConfigStorer, error) {
 	}
 	return host.NewService(service.Config{Name: "nextdns"})
 }
+
+// storerFile returns the configuration file used by cs if any.
+func storerFile(cs service.ConfigStorer) string {
+	if f, ok := cs.(interface{ ConfigFile() string }); ok {
+		return f.ConfigFile()
+	}
+	return ""
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestConfig_LoadDiff(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "nextdns.conf")
+	load := func(content string, args ...string) *Config {
+		t.Helper()
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		var c Config
+		if err := c.Load("nextdns run", append([]string{"-config-file", file}, args...), false); err != nil {
+			t.Fatalf("Load() = %v", err)
+		}
+		return &c
+	}
+
+	c1 := load("profile abcdef\nlog-queries false\ntimeout 5s\n")
+	if got := c1.Diff(c1); len(got) != 0 {
+		t.Errorf("Diff() with itself = %v, want none", got)
+	}
+	c2 := load("profile abcdef\nprofile 10.0.0.0/8=123456\nlog-queries true\ntimeout 5s\nlisten :5353\n")
+	if got, want := c1.Diff(c2), []string{"listen", "log-queries", "profile"}; !slices.Equal(got, want) {
+		t.Errorf("Diff() = %v, want %v", got, want)
+	}
+
+	// Command line arguments take precedence over the stored configuration.
+	c3 := load("log-queries true\n", "-log-queries=false")
+	if c3.LogQueries {
+		t.Error("LogQueries = true, want false")
+	}
+
+	var c Config
+	if err := c.Load("nextdns run", []string{"-config-file", file, "extra"}, false); err == nil {
+		t.Error("Load() with extra argument: expected error")
+	}
+	if err := c.Load("nextdns run", []string{"-config-file", file, "-unknown-flag"}, false); err == nil {
+		t.Error("Load() with unknown flag: expected error")
+	}
+}
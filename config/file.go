@@ -0,0 +1,762 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/nextdns/nextdns/host/service"
+)
+
+// The structured configuration file uses a subset of TOML: key/value pairs
+// with string, boolean, integer or string array values, grouped in [section]
+// tables and [[profile]] and [[forwarder]] arrays of tables:
+//
+//	log-queries = true
+//
+//	[listeners]
+//	addrs = ["0.0.0.0:53"]
+//
+//	[cache]
+//	size = "10MB"
+//
+//	[discovery]
+//	report-client-info = true
+//	mdns = "all"
+//
+//	[[profile]]
+//	condition = "10.0.3.0/24"
+//	id = "abcdef"
+//
+//	[[forwarder]]
+//	domain = "corp.example.com"
+//	servers = ["https://doh.corp.example.com/dns-query"]
+//	ca = "/etc/ssl/corp-ca.pem"
+//
+// Settings not part of a section are set at the top level using their
+// command line flag name.
+
+// fileSections maps the keys of the sections of the structured configuration
+// file to the name of the setting they define.
+var fileSections = map[string]map[string]string{
+	"listeners": {
+		"addrs":                 "listen",
+		"max-inflight-requests": "max-inflight-requests",
+	},
+	"cache": {
+		"size":    "cache-size",
+		"max-age": "cache-max-age",
+		"max-ttl": "max-ttl",
+		"metrics": "cache-metrics",
+	},
+	"discovery": {
+		"report-client-info":      "report-client-info",
+		"dns":                     "discovery-dns",
+		"mdns":                    "mdns",
+		"mdns-reflector":          "mdns-reflector",
+		"mdns-reflector-services": "mdns-reflector-services",
+		"mdns-gateway":            "mdns-gateway",
+		"netbios":                 "netbios",
+		"ssdp":                    "ssdp",
+		"local-domain":            "local-domain",
+		"use-hosts":               "use-hosts",
+		"dhcp-leases":             "dhcp-leases",
+		"static-devices":          "static-devices",
+		"store":                   "discovery-store",
+		"store-max-age":           "discovery-store-max-age",
+		"watch-neighbors":         "watch-neighbors",
+	},
+}
+
+// fileSectionOrder is the order sections are written in.
+var fileSectionOrder = []string{"listeners", "cache", "discovery"}
+
+// FileError is an error found at a given line of a configuration file.
+type FileError struct {
+	Line int
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+func fileErrorf(line int, format string, a ...any) error {
+	return &FileError{Line: line, Err: fmt.Errorf(format, a...)}
+}
+
+// fileEntry is a setting value defined in a configuration file.
+type fileEntry struct {
+	name  string
+	value string
+	line  int
+	// array is true when the value comes from a structured file array.
+	array bool
+}
+
+// fileValue is the value of a key of a structured configuration file.
+type fileValue struct {
+	line   int
+	values []string
+	array  bool
+}
+
+// fileTable is a table of a structured configuration file.
+type fileTable struct {
+	name  string // empty for the top level table
+	array bool   // defined with [[name]]
+	line  int
+	keys  []string
+	vals  map[string]fileValue
+}
+
+func (t *fileTable) set(key string, v fileValue) error {
+	if _, found := t.vals[key]; found {
+		return fileErrorf(v.line, "%s: duplicate key", key)
+	}
+	t.keys = append(t.keys, key)
+	t.vals[key] = v
+	return nil
+}
+
+var (
+	structuredLine = regexp.MustCompile(`^(\[|[A-Za-z0-9_-]+\s*=)`)
+	bareKey        = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	bareValue      = regexp.MustCompile(`^(true|false|[+-]?[0-9][0-9_]*)$`)
+)
+
+// isStructured returns true if the configuration file content b uses the
+// structured format.
+func isStructured(b []byte) bool {
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return structuredLine.MatchString(line)
+	}
+	return false
+}
+
+// isStructuredFile returns true if file has a .toml extension or uses the
+// structured format.
+func isStructuredFile(file string) bool {
+	if filepath.Ext(file) == ".toml" {
+		return true
+	}
+	b, err := os.ReadFile(file)
+	return err == nil && isStructured(b)
+}
+
+// parseStructured parses the content of a structured configuration file.
+func parseStructured(b []byte) ([]*fileTable, error) {
+	root := &fileTable{vals: map[string]fileValue{}}
+	tables := []*fileTable{root}
+	cur := root
+	lines := strings.Split(string(b), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNum := i + 1
+		line := strings.TrimSpace(stripComment(lines[i]))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			t, err := parseTableHeader(line, lineNum)
+			if err != nil {
+				return nil, err
+			}
+			if !t.array {
+				for _, t2 := range tables {
+					if t2.name == t.name {
+						return nil, fileErrorf(lineNum, "[%s]: duplicate section", t.name)
+					}
+				}
+			}
+			tables = append(tables, t)
+			cur = t
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !bareKey.MatchString(key) {
+			return nil, fileErrorf(lineNum, "expected key = value")
+		}
+		value = strings.TrimSpace(value)
+		// Arrays can span multiple lines.
+		for strings.HasPrefix(value, "[") && !arrayClosed(value) && i+1 < len(lines) {
+			i++
+			value += " " + strings.TrimSpace(stripComment(lines[i]))
+		}
+		v, err := parseValue(value)
+		if err != nil {
+			return nil, fileErrorf(lineNum, "%s: %v", key, err)
+		}
+		v.line = lineNum
+		if err := cur.set(key, v); err != nil {
+			return nil, err
+		}
+	}
+	return tables, nil
+}
+
+func parseTableHeader(line string, lineNum int) (*fileTable, error) {
+	t := &fileTable{line: lineNum, vals: map[string]fileValue{}}
+	name := line
+	if strings.HasPrefix(line, "[[") {
+		if !strings.HasSuffix(line, "]]") {
+			return nil, fileErrorf(lineNum, "invalid table header")
+		}
+		t.array = true
+		name = line[2 : len(line)-2]
+	} else {
+		if !strings.HasSuffix(line, "]") {
+			return nil, fileErrorf(lineNum, "invalid section header")
+		}
+		name = line[1 : len(line)-1]
+	}
+	t.name = strings.TrimSpace(name)
+	if !bareKey.MatchString(t.name) {
+		return nil, fileErrorf(lineNum, "%s: invalid section name", t.name)
+	}
+	return t, nil
+}
+
+// stripComment removes the comment from line, ignoring # characters part of
+// a string.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// arrayClosed returns true if the array value s contains its closing bracket.
+func arrayClosed(s string) bool {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ']':
+			return true
+		}
+	}
+	return false
+}
+
+// parseValue parses a scalar or a string array value.
+func parseValue(s string) (fileValue, error) {
+	if rest, ok := strings.CutPrefix(s, "["); ok {
+		v := fileValue{array: true, values: []string{}}
+		for {
+			rest = strings.TrimSpace(rest)
+			if r, ok := strings.CutPrefix(rest, "]"); ok {
+				if strings.TrimSpace(r) != "" {
+					return v, fmt.Errorf("unexpected %q after array", strings.TrimSpace(r))
+				}
+				return v, nil
+			}
+			if strings.HasPrefix(rest, "[") {
+				return v, errors.New("nested arrays are not supported")
+			}
+			var item string
+			var err error
+			if item, rest, err = parseScalar(rest); err != nil {
+				return v, err
+			}
+			v.values = append(v.values, item)
+			rest = strings.TrimSpace(rest)
+			if r, ok := strings.CutPrefix(rest, ","); ok {
+				rest = r
+			} else if !strings.HasPrefix(rest, "]") {
+				return v, errors.New("expected , or ] in array")
+			}
+		}
+	}
+	item, rest, err := parseScalar(s)
+	if err != nil {
+		return fileValue{}, err
+	}
+	if strings.TrimSpace(rest) != "" {
+		return fileValue{}, fmt.Errorf("unexpected %q after value", strings.TrimSpace(rest))
+	}
+	return fileValue{values: []string{item}}, nil
+}
+
+// parseScalar parses a string, boolean or integer at the beginning of s and
+// returns it with the remaining of s.
+func parseScalar(s string) (v, rest string, err error) {
+	switch {
+	case s == "":
+		return "", "", errors.New("missing value")
+	case s[0] == '\'':
+		end := strings.IndexByte(s[1:], '\'')
+		if end == -1 {
+			return "", "", errors.New("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	case s[0] == '"':
+		var sb strings.Builder
+		for i := 1; i < len(s); i++ {
+			c := s[i]
+			switch c {
+			case '"':
+				return sb.String(), s[i+1:], nil
+			case '\\':
+				if i+1 >= len(s) {
+					return "", "", errors.New("unterminated string")
+				}
+				i++
+				switch s[i] {
+				case '"', '\\':
+					sb.WriteByte(s[i])
+				case 'n':
+					sb.WriteByte('\n')
+				case 't':
+					sb.WriteByte('\t')
+				case 'r':
+					sb.WriteByte('\r')
+				default:
+					return "", "", fmt.Errorf("invalid escape sequence \\%c", s[i])
+				}
+			default:
+				sb.WriteByte(c)
+			}
+		}
+		return "", "", errors.New("unterminated string")
+	}
+	end := strings.IndexAny(s, " \t,]")
+	if end == -1 {
+		end = len(s)
+	}
+	if !bareValue.MatchString(s[:end]) {
+		return "", "", fmt.Errorf("%s: invalid value, strings must be quoted", s[:end])
+	}
+	return strings.ReplaceAll(s[:end], "_", ""), s[end:], nil
+}
+
+// structuredEntries returns the settings defined by the tables of a
+// structured configuration file.
+func structuredEntries(tables []*fileTable) ([]fileEntry, error) {
+	var entries []fileEntry
+	add := func(name string, v fileValue) {
+		for _, value := range v.values {
+			entries = append(entries, fileEntry{name: name, value: value, line: v.line, array: v.array})
+		}
+	}
+	for _, t := range tables {
+		switch {
+		case t.name == "":
+			for _, key := range t.keys {
+				add(key, t.vals[key])
+			}
+		case t.array && t.name == "profile":
+			e, err := profileEntry(t)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		case t.array && t.name == "forwarder":
+			e, err := forwarderEntry(t)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		case !t.array && fileSections[t.name] != nil:
+			for _, key := range t.keys {
+				name, found := fileSections[t.name][key]
+				if !found {
+					return nil, fileErrorf(t.vals[key].line, "%s: unknown key in [%s]", key, t.name)
+				}
+				add(name, t.vals[key])
+			}
+		case t.array:
+			return nil, fileErrorf(t.line, "[[%s]]: unknown table", t.name)
+		default:
+			if t.name == "profile" || t.name == "forwarder" {
+				return nil, fileErrorf(t.line, "[%s]: must be defined as [[%s]]", t.name, t.name)
+			}
+			return nil, fileErrorf(t.line, "[%s]: unknown section", t.name)
+		}
+	}
+	return entries, nil
+}
+
+// tableString returns the single string value of key in t.
+func tableString(t *fileTable, key string) (string, bool, error) {
+	v, found := t.vals[key]
+	if !found {
+		return "", false, nil
+	}
+	if v.array || len(v.values) != 1 {
+		return "", true, fileErrorf(v.line, "%s: expected a string", key)
+	}
+	return v.values[0], true, nil
+}
+
+// profileEntry converts a [[profile]] table into a profile setting.
+func profileEntry(t *fileTable) (fileEntry, error) {
+	e := fileEntry{name: "profile", line: t.line}
+	for _, key := range t.keys {
+		if key != "id" && key != "condition" {
+			return e, fileErrorf(t.vals[key].line, "%s: unknown key in [[profile]]", key)
+		}
+	}
+	id, found, err := tableString(t, "id")
+	if err != nil {
+		return e, err
+	}
+	if !found || id == "" {
+		return e, fileErrorf(t.line, "[[profile]]: missing id")
+	}
+	cond, _, err := tableString(t, "condition")
+	if err != nil {
+		return e, err
+	}
+	e.value = id
+	if cond != "" {
+		e.value = cond + "=" + id
+	}
+	return e, nil
+}
+
+// forwarderEntry converts a [[forwarder]] table into a forwarder setting.
+func forwarderEntry(t *fileTable) (fileEntry, error) {
+	e := fileEntry{name: "forwarder", line: t.line}
+	domain, _, err := tableString(t, "domain")
+	if err != nil {
+		return e, err
+	}
+	servers, found := t.vals["servers"]
+	if !found || len(servers.values) == 0 {
+		return e, fileErrorf(t.line, "[[forwarder]]: missing servers")
+	}
+	var sb strings.Builder
+	if domain != "" {
+		sb.WriteString(domain)
+		sb.WriteByte('=')
+	}
+	sb.WriteString(strings.Join(servers.values, ","))
+	for _, key := range t.keys {
+		if key == "domain" || key == "servers" {
+			continue
+		}
+		fmt.Fprintf(&sb, ";%s=%s", key, strings.Join(t.vals[key].values, ","))
+	}
+	e.value = sb.String()
+	return e, nil
+}
+
+// readLegacy reads the settings of a legacy configuration file.
+func readLegacy(b []byte) []fileEntry {
+	var entries []fileEntry
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for lineNum := 1; sc.Scan(); lineNum++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, _ := strings.Cut(line, " ")
+		value = strings.TrimSpace(value)
+		if idx := strings.Index(value, " #"); idx != -1 {
+			value = strings.TrimSpace(value[:idx])
+		}
+		entries = append(entries, fileEntry{name: name, value: value, line: lineNum})
+	}
+	return entries
+}
+
+// readFile reads the settings of the configuration file, in legacy or
+// structured format.
+func readFile(file string) ([]fileEntry, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if filepath.Ext(file) == ".toml" || isStructured(b) {
+		tables, err := parseStructured(b)
+		if err != nil {
+			return nil, err
+		}
+		return structuredEntries(tables)
+	}
+	return readLegacy(b), nil
+}
+
+// applyEntries sets entries to storage and returns the errors found.
+func applyEntries(entries []fileEntry, storage map[string]service.ConfigEntry) []error {
+	var errs []error
+	for i, e := range entries {
+		entry := storage[e.name]
+		if entry == nil {
+			errs = append(errs, fileErrorf(e.line, "%s: unknown setting", e.name))
+			continue
+		}
+		if _, ok := entry.(service.ConfigListEntry); e.array && !ok {
+			if i == 0 || entries[i-1].line != e.line {
+				errs = append(errs, fileErrorf(e.line, "%s: expected a single value", e.name))
+			}
+			continue
+		}
+		if err := entry.Set(e.value); err != nil {
+			errs = append(errs, fileErrorf(e.line, "%s: %v", e.name, err))
+		}
+	}
+	return errs
+}
+
+// loadStructured loads the structured configuration file into storage.
+func loadStructured(file string, storage map[string]service.ConfigEntry) error {
+	entries, err := readFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	if errs := applyEntries(entries, storage); len(errs) > 0 {
+		return fmt.Errorf("%s: %w", file, errs[0])
+	}
+	return nil
+}
+
+// ValidateFile checks the configuration file, in legacy or structured format,
+// and returns the errors found. Errors are *FileError when the line is known.
+func ValidateFile(file string) []error {
+	entries, err := readFile(file)
+	if err != nil {
+		return []error{err}
+	}
+	var c Config
+	return applyEntries(entries, c.flagSet("").storage)
+}
+
+// ConvertFile returns the settings of the legacy configuration file converted
+// to the structured format. Comments are not preserved.
+func ConvertFile(file string) ([]byte, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if isStructured(b) {
+		return nil, fmt.Errorf("%s: already in structured format", file)
+	}
+	entries := readLegacy(b)
+	var c Config
+	storage := c.flagSet("").storage
+	if errs := applyEntries(entries, storage); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	var buf bytes.Buffer
+	if err := writeStructured(&buf, entries, storage); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// storageEntries returns the non default settings of storage sorted by name.
+func storageEntries(storage map[string]service.ConfigEntry) []fileEntry {
+	var entries []fileEntry
+	for _, name := range sortedKeys(storage) {
+		entry := storage[name]
+		if e, ok := entry.(service.ConfigDefaultTester); ok && e.IsDefault() {
+			continue
+		}
+		if e, ok := entry.(service.ConfigListEntry); ok {
+			for _, v := range e.Strings() {
+				entries = append(entries, fileEntry{name: name, value: v})
+			}
+			continue
+		}
+		entries = append(entries, fileEntry{name: name, value: entry.String()})
+	}
+	return entries
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// saveStructured writes the non default settings of storage to file in the
+// structured format.
+func saveStructured(file string, storage map[string]service.ConfigEntry) error {
+	var buf bytes.Buffer
+	if err := writeStructured(&buf, storageEntries(storage), storage); err != nil {
+		return err
+	}
+	return os.WriteFile(file, buf.Bytes(), 0644)
+}
+
+// writeStructured writes entries in the structured format. The storage is
+// used to know the type of the settings.
+func writeStructured(w io.Writer, entries []fileEntry, storage map[string]service.ConfigEntry) error {
+	// Group values by setting, keeping the order of first appearance.
+	var names []string
+	values := map[string][]string{}
+	for _, e := range entries {
+		if _, found := values[e.name]; !found {
+			names = append(names, e.name)
+		}
+		if _, ok := storage[e.name].(service.ConfigListEntry); ok {
+			values[e.name] = append(values[e.name], e.value)
+		} else {
+			// Last value wins for scalar settings.
+			values[e.name] = []string{e.value}
+		}
+	}
+	sectionOf := map[string][2]string{}
+	for section, keys := range fileSections {
+		for key, name := range keys {
+			sectionOf[name] = [2]string{section, key}
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	writeKey := func(key, name string) {
+		_, isList := storage[name].(service.ConfigListEntry)
+		fmt.Fprintf(bw, "%s = %s\n", key, formatValue(storage[name], values[name], isList))
+	}
+	first := true
+	header := func(h string) {
+		if !first {
+			bw.WriteString("\n")
+		}
+		first = false
+		if h != "" {
+			bw.WriteString(h + "\n")
+		}
+	}
+	for _, name := range names {
+		if _, inSection := sectionOf[name]; inSection || name == "profile" || name == "forwarder" {
+			continue
+		}
+		first = false
+		writeKey(name, name)
+	}
+	for _, section := range fileSectionOrder {
+		var keys []string
+		for _, name := range names {
+			if s := sectionOf[name]; s[0] == section {
+				keys = append(keys, name)
+			}
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		header("[" + section + "]")
+		for _, name := range keys {
+			writeKey(sectionOf[name][1], name)
+		}
+	}
+	for _, v := range values["profile"] {
+		header("[[profile]]")
+		before, after, ok := strings.Cut(v, "=")
+		if ok {
+			fmt.Fprintf(bw, "condition = %s\n", quote(strings.TrimSpace(before)))
+			v = after
+		}
+		fmt.Fprintf(bw, "id = %s\n", quote(strings.TrimSpace(v)))
+	}
+	for _, v := range values["forwarder"] {
+		header("[[forwarder]]")
+		addr := v
+		before, after, ok := strings.Cut(v, "=")
+		if ok && !strings.Contains(before, ";") {
+			fmt.Fprintf(bw, "domain = %s\n", quote(strings.TrimSpace(before)))
+			addr = strings.TrimSpace(after)
+		}
+		servers, options, _ := strings.Cut(addr, ";")
+		fmt.Fprintf(bw, "servers = %s\n", formatArray(strings.Split(servers, ",")))
+		for opt := range strings.SplitSeq(options, ";") {
+			if opt = strings.TrimSpace(opt); opt == "" {
+				continue
+			}
+			key, value, _ := strings.Cut(opt, "=")
+			if key == "pin" {
+				fmt.Fprintf(bw, "%s = %s\n", key, formatArray(strings.Split(value, ",")))
+				continue
+			}
+			fmt.Fprintf(bw, "%s = %s\n", key, quote(value))
+		}
+	}
+	return bw.Flush()
+}
+
+func formatValue(entry service.ConfigEntry, values []string, isList bool) string {
+	if isList {
+		return formatArray(values)
+	}
+	v := values[0]
+	switch entry.(type) {
+	case service.ConfigFlag, service.ConfigUint:
+		if bareValue.MatchString(v) {
+			return v
+		}
+	}
+	return quote(v)
+}
+
+func formatArray(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, v := range values {
+		quoted = append(quoted, quote(strings.TrimSpace(v)))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+func quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\r':
+			sb.WriteString(`\r`)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const legacyTestConfig = `# NextDNS
+profile abcdef
+profile 10.0.3.0/24=123456
+listen 0.0.0.0:53
+listen [::]:53
+log-queries true # inline comment
+cache-size 10MB
+max-inflight-requests 512
+report-client-info true
+mdns all
+forwarder corp.example.com=1.2.3.4,1.2.3.5
+forwarder lan.=192.168.0.1;ecs=synthesize/24/56;dnssec=validate
+forwarder https://doh.example.com/dns-query;method=get
+timeout 3s
+`
+
+func TestConvertFile_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	legacy := filepath.Join(dir, "nextdns.conf")
+	if err := os.WriteFile(legacy, []byte(legacyTestConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ConvertFile(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isStructured(b) {
+		t.Fatalf("converted file not detected as structured:\n%s", b)
+	}
+	structured := filepath.Join(dir, "nextdns.toml")
+	if err := os.WriteFile(structured, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if errs := ValidateFile(structured); len(errs) > 0 {
+		t.Fatalf("ValidateFile() = %v\n%s", errs, b)
+	}
+
+	var c1, c2 Config
+	if err := c1.Load("nextdns run", []string{"-config-file", legacy}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := c2.Load("nextdns run", []string{"-config-file", structured}, false); err != nil {
+		t.Fatal(err)
+	}
+	if diff := c1.Diff(&c2); len(diff) > 0 {
+		t.Errorf("settings differ after conversion: %v\n%s", diff, b)
+	}
+	if got, want := c2.Forwarders.Strings(), c1.Forwarders.Strings(); !slices.Equal(got, want) {
+		t.Errorf("forwarders = %v, want %v", got, want)
+	}
+}
+
+func TestValidateFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		wantLine []int
+	}{
+		{"valid", "log-queries = true\n[cache]\nsize = \"10MB\"\n\n[[profile]]\nid = \"abcdef\"\n", nil},
+		{"multi-line array", "[listeners]\naddrs = [\n  \"0.0.0.0:53\", # IPv4\n  \"[::]:53\",\n]\n", nil},
+		{"syntax", "log-queries = true\nlisten 0.0.0.0:53\n", []int{2}},
+		{"unquoted string", "\n\ncache-size = 10MB\n", []int{3}},
+		{"unknown setting", "log-queries = true\nunknown = 1\ntimeout = \"abc\"\n", []int{2, 3}},
+		{"unknown section key", "[cache]\nsize = \"1MB\"\nfoo = true\n", []int{3}},
+		{"array for scalar", "timeout = [\"1s\", \"2s\"]\n", []int{1}},
+		{"missing profile id", "[[profile]]\ncondition = \"10.0.0.0/8\"\n", []int{1}},
+		{"invalid forwarder option", "[[forwarder]]\nservers = [\"1.2.3.4\"]\nfoo = \"bar\"\n", []int{1}},
+		{"legacy", "log-queries true\ntimeout abc\nfoo bar\n", []int{2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "nextdns.conf")
+			if err := os.WriteFile(file, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			var lines []int
+			for _, err := range ValidateFile(file) {
+				var fe *FileError
+				if !errors.As(err, &fe) {
+					t.Fatalf("ValidateFile() error without line: %v", err)
+				}
+				lines = append(lines, fe.Line)
+			}
+			if !slices.Equal(lines, tt.wantLine) {
+				t.Errorf("ValidateFile() error lines = %v, want %v", lines, tt.wantLine)
+			}
+		})
+	}
+}
+
+func TestConfig_SaveStructured(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "nextdns.toml")
+	var c Config
+	if err := c.Load("nextdns config set", []string{"-config-file", file, "-profile", "abcdef", "-log-queries"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	var c2 Config
+	if err := c2.Load("nextdns run", []string{"-config-file", file}, false); err != nil {
+		t.Fatal(err)
+	}
+	if diff := c.Diff(&c2); len(diff) > 0 {
+		t.Errorf("settings differ after save: %v", diff)
+	}
+}
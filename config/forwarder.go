@@ -2,10 +2,13 @@ package config
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 
+	"github.com/nextdns/nextdns/dnssec"
 	"github.com/nextdns/nextdns/resolver"
+	"github.com/nextdns/nextdns/resolver/endpoint"
 	"github.com/nextdns/nextdns/resolver/query"
 )
 
@@ -16,20 +19,130 @@ type Resolver struct {
 	Domain string
 }
 
-// newResolver parses a server definition with an optional condition.
+// newResolver parses a server definition with an optional condition and
+// options. Options are appended to the server list separated by semicolons:
+//
+//	example.com=1.2.3.4,1.2.3.5;ecs=synthesize/24/56
 func newResolver(v string) (Resolver, error) {
 	before, after, ok := strings.Cut(v, "=")
 	var r Resolver
 	r.addr = v
-	if ok {
+	if ok && !strings.Contains(before, ";") {
 		r.addr = strings.TrimSpace(after)
 		r.Domain = fqdn(strings.TrimSpace(before))
 	}
+	servers, options, _ := strings.Cut(r.addr, ";")
 	var err error
-	r.Resolver, err = resolver.New(r.addr)
+	if r.Resolver, err = resolver.New(servers); err != nil {
+		return r, err
+	}
+	if options != "" {
+		err = r.setOptions(options)
+	}
 	return r, err
 }
 
+// setOptions applies semicolon separated key=value options to the resolver.
+func (r *Resolver) setOptions(options string) error {
+	dns, ok := r.Resolver.(*resolver.DNS)
+	if !ok {
+		return fmt.Errorf("%s: options not supported", options)
+	}
+	validate := false
+	var tc *endpoint.TLSConfig
+	useGET := false
+	for opt := range strings.SplitSeq(options, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(opt), "=")
+		switch key {
+		case "ecs":
+			p, err := resolver.ParseECSPolicy(value)
+			if err != nil {
+				return err
+			}
+			dns.DNS53.ECS = p
+			dns.DOH.ECS = p
+			dns.ODOH.ECS = p
+		case "dnssec":
+			switch value {
+			case "validate":
+				validate = true
+			case "off":
+				validate = false
+			default:
+				return fmt.Errorf("%s: invalid dnssec mode", value)
+			}
+		case "method":
+			switch value {
+			case "get":
+				useGET = true
+			case "post":
+				useGET = false
+			default:
+				return fmt.Errorf("%s: invalid DoH method", value)
+			}
+		case "ca", "pin", "cert", "key", "tls-min":
+			if tc == nil {
+				tc = &endpoint.TLSConfig{}
+			}
+			switch key {
+			case "ca":
+				tc.CAFile = value
+			case "pin":
+				tc.Pins = append(tc.Pins, strings.Split(value, ",")...)
+			case "cert":
+				tc.CertFile = value
+			case "key":
+				tc.KeyFile = value
+			case "tls-min":
+				v, err := endpoint.ParseTLSVersion(value)
+				if err != nil {
+					return err
+				}
+				tc.MinVersion = v
+			}
+		default:
+			return fmt.Errorf("%s: unsupported forwarder option", key)
+		}
+	}
+	if tc != nil {
+		if err := tc.Validate(); err != nil {
+			return err
+		}
+	}
+	if tc != nil || useGET {
+		endpoints := dohEndpoints(dns)
+		if len(endpoints) == 0 {
+			return errors.New("tls and method options require a DoH server")
+		}
+		for _, e := range endpoints {
+			e.TLS = tc
+			e.UseGET = useGET
+		}
+		dns.DOH.UseGET = useGET
+	}
+	if validate {
+		r.Resolver = &dnssec.Validator{Upstream: dns}
+	}
+	return nil
+}
+
+// dohEndpoints returns the DoH endpoints of dns.
+func dohEndpoints(dns *resolver.DNS) []*endpoint.DOHEndpoint {
+	var endpoints []*endpoint.DOHEndpoint
+	for _, p := range dns.Manager.Providers {
+		sp, ok := p.(endpoint.StaticProvider)
+		if !ok {
+			continue
+		}
+		for _, e := range sp {
+			if doh, ok := e.(*endpoint.DOHEndpoint); ok {
+				endpoints = append(endpoints, doh)
+			}
+		}
+	}
+	return endpoints
+}
+
 // Match returns true if the rule matches domain.
 func (r Resolver) Match(domain string) bool {
 	if r.Domain != "" {
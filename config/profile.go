@@ -14,6 +14,7 @@ type profile struct {
 	MAC     net.HardwareAddr
 	DestIPs []net.IP
 	User    string
+	Device  string
 }
 
 // newConfig parses a configuration id with an optional condition.
@@ -23,15 +24,28 @@ func newConfig(v string) (profile, error) {
 		return profile{ID: v}, nil
 	}
 
-	cond := strings.TrimSpace(before)
-	conf := strings.TrimSpace(after)
-	c := profile{ID: conf}
+	c, err := parseCondition(strings.TrimSpace(before))
+	if err != nil {
+		return profile{}, err
+	}
+	c.ID = strings.TrimSpace(after)
+	return c, nil
+}
 
+// parseCondition parses a client condition: a user (@name), a device name
+// (device:name), an IP or subnet, a MAC address or an interface name.
+func parseCondition(cond string) (profile, error) {
+	var c profile
 	if u, ok := strings.CutPrefix(cond, "@"); ok {
 		if u == "" {
 			return profile{}, fmt.Errorf("%s: invalid user condition format", cond)
 		}
 		c.User = u
+	} else if d, ok := strings.CutPrefix(cond, "device:"); ok {
+		if d == "" {
+			return profile{}, fmt.Errorf("%s: invalid device condition format", cond)
+		}
+		c.Device = d
 	} else if _, ipnet, err := net.ParseCIDR(cond); err == nil {
 		c.Prefix = ipnet
 	} else if ip := net.ParseIP(cond); ip != nil {
@@ -61,8 +75,14 @@ func newConfig(v string) (profile, error) {
 	return c, nil
 }
 
-// Match returns true if the rule matches ip or interface and mac.
-func (p profile) Match(sourceIP, destIP net.IP, mac net.HardwareAddr, user string) bool {
+// Match returns true if the rule matches ip or interface, mac, user and device
+// name.
+func (p profile) Match(sourceIP, destIP net.IP, mac net.HardwareAddr, user, device string) bool {
+	if p.Device != "" {
+		if !strings.EqualFold(p.Device, device) {
+			return false
+		}
+	}
 	if p.User != "" {
 		if user == "" {
 			return false
@@ -102,13 +122,16 @@ func (p profile) Match(sourceIP, destIP net.IP, mac net.HardwareAddr, user strin
 }
 
 func (p profile) isDefault() bool {
-	return p.Prefix == nil && len(p.MAC) == 0 && len(p.DestIPs) == 0 && p.User == ""
+	return p.Prefix == nil && len(p.MAC) == 0 && len(p.DestIPs) == 0 && p.User == "" && p.Device == ""
 }
 
 func (p profile) String() string {
 	if p.User != "" {
 		return fmt.Sprintf("@%s=%s", p.User, p.ID)
 	}
+	if p.Device != "" {
+		return fmt.Sprintf("device:%s=%s", p.Device, p.ID)
+	}
 	if p.MAC != nil {
 		return fmt.Sprintf("%s=%s", p.MAC, p.ID)
 	}
@@ -123,18 +146,24 @@ type Profiles []profile
 
 // Get returns the configuration matching the ip and mac conditions.
 func (ps *Profiles) Get(sourceIP, destIP net.IP, mac net.HardwareAddr) string {
-	return ps.get(sourceIP, destIP, mac, "")
+	return ps.get(sourceIP, destIP, mac, "", "")
 }
 
 // GetWithUser returns the configuration matching ip, mac and user conditions.
 func (ps *Profiles) GetWithUser(sourceIP, destIP net.IP, mac net.HardwareAddr, user string) string {
-	return ps.get(sourceIP, destIP, mac, user)
+	return ps.get(sourceIP, destIP, mac, user, "")
 }
 
-func (ps *Profiles) get(sourceIP, destIP net.IP, mac net.HardwareAddr, user string) string {
+// GetWithDevice returns the configuration matching ip, mac, user and device
+// name conditions.
+func (ps *Profiles) GetWithDevice(sourceIP, destIP net.IP, mac net.HardwareAddr, user, device string) string {
+	return ps.get(sourceIP, destIP, mac, user, device)
+}
+
+func (ps *Profiles) get(sourceIP, destIP net.IP, mac net.HardwareAddr, user, device string) string {
 	var def string
 	for _, p := range *ps {
-		if p.Match(sourceIP, destIP, mac, user) {
+		if p.Match(sourceIP, destIP, mac, user, device) {
 			if p.isDefault() {
 				def = p.ID
 				continue
@@ -154,6 +183,15 @@ func (ps *Profiles) HasUserRules() bool {
 	return false
 }
 
+func (ps *Profiles) HasDeviceRules() bool {
+	for _, p := range *ps {
+		if p.Device != "" {
+			return true
+		}
+	}
+	return false
+}
+
 // String is the method to format the flag's value
 func (ps *Profiles) String() string {
 	return fmt.Sprint(*ps)
@@ -179,10 +217,11 @@ func (ps *Profiles) Set(value string) error {
 	// Replace if c match the same criteria of an existing config
 	for i, _p := range *ps {
 		if (p.User != "" && p.User == _p.User) ||
+			(p.Device != "" && strings.EqualFold(p.Device, _p.Device)) ||
 			(p.MAC != nil && _p.MAC != nil && bytes.Equal(p.MAC, _p.MAC)) ||
 			(p.DestIPs != nil && _p.DestIPs != nil && ipListEqual(p.DestIPs, _p.DestIPs)) ||
 			(p.Prefix != nil && _p.Prefix != nil && p.Prefix.String() == _p.Prefix.String()) ||
-			(p.MAC == nil && p.Prefix == nil && p.DestIPs == nil && p.User == "" && _p.MAC == nil && _p.Prefix == nil && _p.DestIPs == nil && _p.User == "") {
+			(p.MAC == nil && p.Prefix == nil && p.DestIPs == nil && p.User == "" && p.Device == "" && _p.MAC == nil && _p.Prefix == nil && _p.DestIPs == nil && _p.User == "" && _p.Device == "") {
 			(*ps)[i] = p
 			return nil
 		}
@@ -143,3 +143,32 @@ func TestProfiles_Set_ReplacesUserRule(t *testing.T) {
 		t.Fatalf("Profiles.GetWithUser() = %v, want %v", got, "profile2")
 	}
 }
+
+func TestProfiles_GetWithDevice(t *testing.T) {
+	var ps Profiles
+	for _, def := range []string{
+		"device:Kids-iPad=device-profile",
+		"default-profile",
+	} {
+		if err := ps.Set(def); err != nil {
+			t.Fatalf("Profiles.Set(%s) = Err %v", def, err)
+		}
+	}
+	if !ps.HasDeviceRules() {
+		t.Fatal("Profiles.HasDeviceRules() = false, want true")
+	}
+	if got, want := ps.Strings()[0], "device:Kids-iPad=device-profile"; got != want {
+		t.Fatalf("Profiles.Strings()[0] = %v, want %v", got, want)
+	}
+
+	ip := net.ParseIP("10.0.0.2")
+	if got := ps.Get(ip, nil, nil); got != "default-profile" {
+		t.Fatalf("Profiles.Get() = %v, want %v", got, "default-profile")
+	}
+	if got := ps.GetWithDevice(ip, nil, nil, "", "kids-ipad"); got != "device-profile" {
+		t.Fatalf("Profiles.GetWithDevice() = %v, want %v", got, "device-profile")
+	}
+	if got := ps.GetWithDevice(ip, nil, nil, "", "nas"); got != "default-profile" {
+		t.Fatalf("Profiles.GetWithDevice() = %v, want %v", got, "default-profile")
+	}
+}
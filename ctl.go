@@ -9,18 +9,37 @@ import (
 
 	"github.com/nextdns/nextdns/config"
 	"github.com/nextdns/nextdns/ctl"
+	"github.com/nextdns/nextdns/discovery"
 )
 
-func ctlCmd(args []string) error {
-	cmd := args[0]
-	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+// newClientEvent is the name of the control event broadcast when a new client
+// appears on the network.
+const newClientEvent = "new-client"
+
+func clientEvent(c discovery.Client) ctl.Event {
+	return ctl.Event{Name: newClientEvent, Data: c}
+}
+
+// ctlDial connects to the control socket of the daemon, re-running the command
+// with sudo if not permitted.
+func ctlDial(args []string) (*ctl.Client, error) {
+	fs := flag.NewFlagSet(args[0], flag.ExitOnError)
 	control := fs.String("control", config.DefaultControl, "Address to the control socket")
 	_ = fs.Parse(args[1:])
 	cl, err := ctl.Dial(*control)
 	if err != nil {
 		if os.Geteuid() != 0 {
-			return syscall.Exec("/usr/bin/sudo", append([]string{"sudo", os.Args[0]}, args...), os.Environ())
+			return nil, syscall.Exec("/usr/bin/sudo", append([]string{"sudo", os.Args[0]}, args...), os.Environ())
 		}
+		return nil, err
+	}
+	return cl, nil
+}
+
+func ctlCmd(args []string) error {
+	cmd := args[0]
+	cl, err := ctlDial(args)
+	if err != nil {
 		return err
 	}
 	defer cl.Close()
@@ -41,3 +60,24 @@ func ctlCmd(args []string) error {
 	fmt.Println(string(b))
 	return nil
 }
+
+// ctlEvents prints the events broadcast by the daemon, one JSON object per
+// line, until the daemon stops.
+func ctlEvents(args []string) error {
+	cl, err := ctlDial(args)
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	for e := range cl.Events() {
+		b, err := json.Marshal(struct {
+			Name string `json:"name"`
+			Data any    `json:"data"`
+		}{e.Name, e.Data})
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	}
+	return nil
+}
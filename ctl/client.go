@@ -13,6 +13,7 @@ type Client struct {
 	c       net.Conn
 	mu      sync.Mutex
 	replies chan Event
+	events  chan Event
 }
 
 var errClientClosed = errors.New("client closed")
@@ -25,6 +26,7 @@ func Dial(addr string) (*Client, error) {
 	cl := &Client{
 		c:       c,
 		replies: make(chan Event, 16),
+		events:  make(chan Event, 16),
 	}
 	go cl.readLoop()
 	return cl, nil
@@ -35,6 +37,9 @@ func (c *Client) readLoop() {
 	defer func() {
 		_ = c.c.Close()
 		close(c.replies)
+		if c.events != nil {
+			close(c.events)
+		}
 	}()
 	for {
 		var e Event
@@ -46,10 +51,22 @@ func (c *Client) readLoop() {
 			// Never drop replies. If caller is slow and channel is full, this will
 			// block, providing backpressure instead of hanging Send forever.
 			c.replies <- e
+			continue
+		}
+		// Events broadcast by the server are dropped if not consumed.
+		select {
+		case c.events <- e:
+		default:
 		}
 	}
 }
 
+// Events returns the channel of the events broadcast by the server. The
+// channel is closed when the connection is closed.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
 func (c *Client) Send(e Event) (any, error) {
 	// Keep legacy signature but ensure it cannot hang forever.
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
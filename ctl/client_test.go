@@ -71,3 +71,41 @@ func TestClientSendContext_TimesOut(t *testing.T) {
 	}
 }
 
+func TestClientEvents(t *testing.T) {
+	cc, sc := net.Pipe()
+
+	c := &Client{
+		c:       cc,
+		replies: make(chan Event, 16),
+		events:  make(chan Event, 16),
+	}
+	go c.readLoop()
+	defer c.Close()
+
+	// Server side: broadcast an event and close.
+	go func() {
+		e := Event{Name: "new-client", Data: "00:1c:42:00:00:10"}
+		_, _ = sc.Write(e.Bytes())
+		_ = sc.Close()
+	}()
+
+	select {
+	case e, ok := <-c.Events():
+		if !ok {
+			t.Fatal("events channel closed before receiving the event")
+		}
+		if e.Name != "new-client" || e.Data != "00:1c:42:00:00:10" {
+			t.Fatalf("unexpected event: %#v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+	select {
+	case _, ok := <-c.Events():
+		if ok {
+			t.Fatal("unexpected event")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("events channel not closed")
+	}
+}
@@ -2,12 +2,19 @@ package discovery
 
 import (
 	"bufio"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"net"
+	"net/url"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/nextdns/nextdns/internal/fileinfo"
 )
 
 type leaseFile struct {
@@ -27,17 +34,121 @@ var leaseFiles = []leaseFile{
 	{"/var/lib/dnsmasq/dhcp.leases", "dnsmasq"},
 	{"/data/udapi-config/dnsmasq.lease", "dnsmasq"},
 	{"/home/pi/.router/run/dhcp/dnsmasq.leases", "dnsmasq"},
+	{"/tmp/hosts/odhcpd", "odhcpd"},
+	{"/var/lib/kea/kea-leases4.csv", "kea-csv"},
+	{"/var/lib/kea/kea-leases6.csv", "kea-csv"},
+	{"/usr/local/var/lib/kea/kea-leases4.csv", "kea-csv"},
+	{"/usr/local/var/lib/kea/kea-leases6.csv", "kea-csv"},
+	{"/var/db/kea/kea-leases4.csv", "kea-csv"},
+	{"/var/db/kea/kea-leases6.csv", "kea-csv"},
 }
 
+// LeaseFile defines a DHCP lease source.
+type LeaseFile struct {
+	// Path is the path of the lease file, or the URL of the Kea control
+	// agent for the kea-api format.
+	Path string
+
+	// Format is the format of the leases: isc-dhcpd, dnsmasq, odhcpd, kea-csv
+	// or kea-api.
+	Format string
+}
+
+var leaseFormats = []string{"isc-dhcpd", "dnsmasq", "odhcpd", "kea-csv", "kea-api"}
+
+// ParseLeaseFile parses a lease source definition in the FORMAT:PATH form.
+func ParseLeaseFile(s string) (LeaseFile, error) {
+	format, path, ok := strings.Cut(s, ":")
+	if !ok || path == "" {
+		return LeaseFile{}, fmt.Errorf("%s: invalid lease file, expected FORMAT:PATH", s)
+	}
+	if !slices.Contains(leaseFormats, format) {
+		return LeaseFile{}, fmt.Errorf("%s: unknown lease format (supported: %s)", format, strings.Join(leaseFormats, ", "))
+	}
+	if format == "kea-api" {
+		if u, err := url.Parse(path); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return LeaseFile{}, fmt.Errorf("%s: invalid Kea control agent URL", path)
+		}
+	}
+	return LeaseFile{Path: path, Format: format}, nil
+}
+
+const (
+	// keaAPIInterval is the minimum interval between two queries to the Kea
+	// control agent.
+	keaAPIInterval = 30 * time.Second
+
+	// keaAPIMaxBackoff is the maximum interval between two queries to the
+	// Kea control agent when it fails.
+	keaAPIMaxBackoff = 5 * time.Minute
+)
+
 type DHCP struct {
 	OnError func(err error)
 
-	mu       sync.RWMutex
-	macs     map[string][]string
-	addrs    map[string][]string
-	names    map[string][]string
-	fileInfo fileInfo
-	expires  time.Time
+	// LeaseFiles defines the lease sources to read. If empty, all the known
+	// lease files found on the system are used.
+	LeaseFiles []LeaseFile
+
+	mu      sync.RWMutex
+	macs    map[string][]string
+	addrs   map[string][]string
+	names   map[string][]string
+	duids   map[string][]string
+	sources map[string]*leaseSource
+	expires time.Time
+}
+
+// leases holds the names found in a lease source indexed by MAC, IP and
+// DHCPv6 DUID, and the addresses indexed by name.
+type leases struct {
+	macs, addrs, names, duids map[string][]string
+}
+
+func newLeases() leases {
+	return leases{
+		macs:  map[string][]string{},
+		addrs: map[string][]string{},
+		names: map[string][]string{},
+		duids: map[string][]string{},
+	}
+}
+
+// add adds a lease for hostname. The ip, mac and duid are optional.
+func (l leases) add(hostname, ip, mac, duid string) {
+	if hostname == "" || hostname == "*" || hostname == "-" {
+		return
+	}
+	name := absDomainName([]byte(hostname))
+	key := strings.ToLower(name)
+	if ip != "" {
+		ip = strings.ToLower(ip)
+		l.addrs[ip] = appendUniq(l.addrs[ip], name)
+		l.names[key] = appendUniq(l.names[key], ip)
+		l.names[key+"local."] = appendUniq(l.names[key+"local."], ip)
+	}
+	if duid = normalizeDUID(duid); duid != "" {
+		l.duids[duid] = appendUniq(l.duids[duid], name)
+		if mac == "" {
+			mac = duidMAC(duid)
+		}
+	}
+	if mac != "" {
+		mac = strings.ToLower(mac)
+		l.macs[mac] = appendUniq(l.macs[mac], name)
+	}
+}
+
+// leaseSource is the last known state of a lease source.
+type leaseSource struct {
+	fileInfo fileinfo.Info
+	leases   leases
+
+	// next is the time of the next fetch of a kea-api source, and backoff
+	// the interval to wait after a failed fetch.
+	next     time.Time
+	backoff  time.Duration
+	fetching bool
 }
 
 func (r *DHCP) refreshLocked() {
@@ -47,14 +158,66 @@ func (r *DHCP) refreshLocked() {
 	}
 	r.expires = now.Add(5 * time.Second)
 
-	file, format := findLeaseFile()
-	if file == "" {
-		return
+	files := r.leaseFiles()
+	if r.sources == nil {
+		r.sources = map[string]*leaseSource{}
+	}
+	changed := false
+	seen := map[string]bool{}
+	for _, lf := range files {
+		seen[lf.file] = true
+		updated, err := r.readLeaseLocked(lf.file, lf.format)
+		if err != nil {
+			if r.OnError != nil {
+				r.OnError(fmt.Errorf("readLease(%s, %s): %v", lf.file, lf.format, err))
+			}
+			continue
+		}
+		changed = changed || updated
+	}
+	for file := range r.sources {
+		if !seen[file] {
+			delete(r.sources, file)
+			changed = true
+		}
 	}
+	if changed {
+		r.mergeLocked(files)
+	}
+}
+
+// leaseFiles returns the lease sources to read.
+func (r *DHCP) leaseFiles() []leaseFile {
+	if len(r.LeaseFiles) > 0 {
+		files := make([]leaseFile, 0, len(r.LeaseFiles))
+		for _, lf := range r.LeaseFiles {
+			files = append(files, leaseFile{lf.Path, lf.Format})
+		}
+		return files
+	}
+	return findLeaseFiles()
+}
 
-	if err := r.readLeaseLocked(file, format); err != nil && r.OnError != nil {
-		r.OnError(fmt.Errorf("readLease(%s, %s): %v", file, format, err))
+// mergeLocked merges the leases of all the sources, in files order.
+func (r *DHCP) mergeLocked(files []leaseFile) {
+	all := newLeases()
+	for _, lf := range files {
+		src := r.sources[lf.file]
+		if src == nil {
+			continue
+		}
+		for _, m := range []struct{ dst, src map[string][]string }{
+			{all.macs, src.leases.macs},
+			{all.addrs, src.leases.addrs},
+			{all.names, src.leases.names},
+			{all.duids, src.leases.duids},
+		} {
+			for k, v := range m.src {
+				m.dst[k] = appendUniq(m.dst[k], v...)
+			}
+		}
 	}
+	r.macs, r.addrs, r.names, r.duids = all.macs, all.addrs, all.names, all.duids
 }
 
 func (r *DHCP) Name() string {
@@ -77,6 +240,15 @@ func (r *DHCP) LookupMAC(mac string) []string {
 	return r.macs[mac]
 }
 
+// LookupDUID returns the names of the DHCPv6 client with the given DUID, in
+// hexadecimal with or without separators.
+func (r *DHCP) LookupDUID(duid string) []string {
+	r.refresh()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.duids[normalizeDUID(duid)]
+}
+
 func (r *DHCP) LookupAddr(addr string) []string {
 	r.refresh()
 	r.mu.RLock()
@@ -103,41 +275,98 @@ func (r *DHCP) refresh() {
 	r.refreshLocked()
 }
 
-func findLeaseFile() (string, string) {
+// findLeaseFiles returns the known lease files present on the system.
+func findLeaseFiles() []leaseFile {
+	var files []leaseFile
 	for _, lease := range leaseFiles {
 		if _, err := os.Stat(lease.file); err == nil {
-			return lease.file, lease.format
+			files = append(files, lease)
 		}
 	}
-	return "", ""
+	return files
+}
+
+// readLeaseLocked reads the lease source if it changed since last read and
+// returns true if it was updated. Kea control agent sources are fetched in the
+// background, see fetchKeaLocked.
+func (r *DHCP) readLeaseLocked(file, format string) (bool, error) {
+	if format == "kea-api" {
+		r.fetchKeaLocked(file)
+		return false, nil
+	}
+	if src := r.sources[file]; src != nil && src.fileInfo.Equal(file) {
+		return false, nil
+	}
+	l, fi, err := readLeaseFile(file, format)
+	if err != nil {
+		return false, err
+	}
+	r.sources[file] = &leaseSource{fileInfo: fi, leases: l}
+	return true, nil
 }
 
-func (r *DHCP) readLeaseLocked(file, format string) error {
-	if r.fileInfo.Equal(file) {
-		return nil
+// fetchKeaLocked starts fetching the leases from the Kea control agent at
+// agentURL if due. The fetch is performed without holding r.mu so lookups are
+// not blocked by a slow agent. On success, the new leases are swapped in and
+// merged. On error, the last known leases are kept and the next fetch is
+// delayed with an exponential backoff.
+func (r *DHCP) fetchKeaLocked(agentURL string) {
+	src := r.sources[agentURL]
+	if src == nil {
+		src = &leaseSource{leases: newLeases()}
+		r.sources[agentURL] = src
 	}
+	if src.fetching || time.Now().Before(src.next) {
+		return
+	}
+	src.fetching = true
+	go func() {
+		l, err := fetchKeaLeases(agentURL)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		src.fetching = false
+		if err != nil {
+			src.backoff = min(max(2*src.backoff, keaAPIInterval), keaAPIMaxBackoff)
+			src.next = time.Now().Add(src.backoff)
+			if r.OnError != nil {
+				r.OnError(fmt.Errorf("readLease(%s, kea-api): %v", agentURL, err))
+			}
+			return
+		}
+		src.leases = l
+		src.backoff = 0
+		src.next = time.Now().Add(keaAPIInterval)
+		if r.sources[agentURL] == src {
+			r.mergeLocked(r.leaseFiles())
+		}
+	}()
+}
 
+// readLeaseFile parses the lease file in the given format.
+func readLeaseFile(file, format string) (l leases, fi fileinfo.Info, err error) {
 	f, err := os.Open(file)
 	if err != nil {
-		return err
+		return l, fi, err
 	}
-	var macs, addrs, names map[string][]string
+	defer f.Close()
+	l = newLeases()
 	switch format {
 	case "isc-dhcpd":
-		macs, addrs, names, err = readDHCPDLease(f)
+		l.macs, l.addrs, l.names, err = readDHCPDLease(f)
 	case "dnsmasq":
-		macs, addrs, names, err = readDNSMasqLease(f)
+		l.macs, l.addrs, l.names, err = readDNSMasqLease(f)
+	case "odhcpd":
+		err = readODHCPDLease(f, l)
+	case "kea-csv":
+		err = readKeaCSVLease(f, l, time.Now())
 	default:
-		return fmt.Errorf("unknown format: %s", format)
+		return l, fi, fmt.Errorf("unknown format: %s", format)
 	}
 	if err != nil {
-		return err
+		return l, fi, err
 	}
-	r.macs = macs
-	r.addrs = addrs
-	r.names = names
-	r.fileInfo, err = getFileInfo(file)
-	return err
+	fi, err = fileinfo.Get(file)
+	return l, fi, err
 }
 
 func readDHCPDLease(r io.Reader) (macs, addrs, names map[string][]string, err error) {
@@ -204,3 +433,36 @@ func readDNSMasqLease(r io.Reader) (macs, addrs, names map[string][]string, err
 	}
 	return macs, addrs, names, s.Err()
 }
+
+// normalizeDUID returns duid as lowercase colon separated hexadecimal bytes,
+// or an empty string if duid is not valid.
+func normalizeDUID(duid string) string {
+	b, err := hex.DecodeString(strings.NewReplacer(":", "", "-", "").Replace(duid))
+	if err != nil || len(b) < 2 {
+		return ""
+	}
+	return net.HardwareAddr(b).String()
+}
+
+// duidMAC returns the link-layer address of a DUID-LLT or DUID-LL with an
+// Ethernet hardware type, or an empty string for other DUIDs.
+func duidMAC(duid string) string {
+	b, err := hex.DecodeString(strings.ReplaceAll(duid, ":", ""))
+	if err != nil {
+		return ""
+	}
+	if len(b) < 4 || binary.BigEndian.Uint16(b[2:]) != 1 { // hardware type Ethernet
+		return ""
+	}
+	switch binary.BigEndian.Uint16(b) {
+	case 1: // DUID-LLT
+		if len(b) == 14 {
+			return net.HardwareAddr(b[8:]).String()
+		}
+	case 3: // DUID-LL
+		if len(b) == 10 {
+			return net.HardwareAddr(b[4:]).String()
+		}
+	}
+	return ""
+}
@@ -1,13 +1,17 @@
 package discovery
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 func Test_readDHCPDLease(t *testing.T) {
@@ -194,3 +198,224 @@ func TestDHCPConcurrentRefresh(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func Test_readODHCPDLease(t *testing.T) {
+	file := `# br-lan 000100012b1e3c4dd83add0a0b0c 0a0b0c0d laptop 1700000000 a4 128 fd00::a4/128 fd00:1::a4/128
+fd00::a4	laptop.lan	laptop
+fd00:1::a4	laptop.lan	laptop
+# br-lan 000300010011223344aa 0a0b0c0e - 1700000000 a5 128 fd00::a5/128
+# br-lan 3442622e6cb7 ipv4 iPad 1700000000 5 32 192.168.1.5/32
+`
+	l := newLeases()
+	if err := readODHCPDLease(strings.NewReader(file), l); err != nil {
+		t.Fatal(err)
+	}
+	wantMACs := map[string][]string{
+		"d8:3a:dd:0a:0b:0c": {"laptop."},
+		"34:42:62:2e:6c:b7": {"iPad."},
+	}
+	wantAddrs := map[string][]string{
+		"fd00::a4":    {"laptop."},
+		"fd00:1::a4":  {"laptop."},
+		"192.168.1.5": {"iPad."},
+	}
+	wantDUIDs := map[string][]string{
+		"00:01:00:01:2b:1e:3c:4d:d8:3a:dd:0a:0b:0c": {"laptop."},
+	}
+	if !reflect.DeepEqual(l.macs, wantMACs) {
+		t.Errorf("macs = %v, want %v", l.macs, wantMACs)
+	}
+	if !reflect.DeepEqual(l.addrs, wantAddrs) {
+		t.Errorf("addrs = %v, want %v", l.addrs, wantAddrs)
+	}
+	if !reflect.DeepEqual(l.duids, wantDUIDs) {
+		t.Errorf("duids = %v, want %v", l.duids, wantDUIDs)
+	}
+}
+
+func Test_readKeaCSVLease(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	v4 := `address,hwaddr,client_id,valid_lifetime,expire,subnet_id,fqdn_fwd,fqdn_rev,hostname,state,user_context,pool_id
+192.168.1.10,34:42:62:2e:6c:b7,,3600,1700003600,1,0,0,ipad,0,,0
+192.168.1.11,dc:a9:04:98:2c:fe,,3600,1700003600,1,0,0,mac,0,,0
+192.168.1.11,dc:a9:04:98:2c:fe,,0,1700000000,1,0,0,mac,0,,0
+192.168.1.12,00:0f:66:4c:fc:c8,,3600,1699990000,1,0,0,expired,0,,0
+192.168.1.13,00:0f:66:4c:fc:c9,,3600,1700003600,1,0,0,declined,1,,0
+192.168.1.14,00:0f:66:4c:fc:ca,,3600,1700003600,1,0,0,tv&#x2c living,0,,0
+`
+	v6 := `address,duid,valid_lifetime,expire,subnet_id,pref_lifetime,lease_type,iaid,prefix_len,fqdn_fwd,fqdn_rev,hostname,hwaddr,state,user_context,hwtype,hwaddr_source,pool_id
+fd00::10,00:03:00:01:34:42:62:2e:6c:b7,3600,1700003600,1,3000,0,1,128,0,0,ipad.lan.,,0,,,,0
+fd00::11,00:02:00:00:ab:11:01:02:03:04,3600,1700003600,1,3000,0,1,128,0,0,printer,,0,,,,0
+`
+	l := newLeases()
+	for _, file := range []string{v4, v6} {
+		if err := readKeaCSVLease(strings.NewReader(file), l, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wantAddrs := map[string][]string{
+		"192.168.1.10": {"ipad."},
+		"192.168.1.14": {"tv, living."},
+		"fd00::10":     {"ipad.lan."},
+		"fd00::11":     {"printer."},
+	}
+	wantMACs := map[string][]string{
+		"34:42:62:2e:6c:b7": {"ipad.", "ipad.lan."},
+		"00:0f:66:4c:fc:ca": {"tv, living."},
+	}
+	wantDUIDs := map[string][]string{
+		"00:03:00:01:34:42:62:2e:6c:b7": {"ipad.lan."},
+		"00:02:00:00:ab:11:01:02:03:04": {"printer."},
+	}
+	if !reflect.DeepEqual(l.addrs, wantAddrs) {
+		t.Errorf("addrs = %v, want %v", l.addrs, wantAddrs)
+	}
+	if !reflect.DeepEqual(l.macs, wantMACs) {
+		t.Errorf("macs = %v, want %v", l.macs, wantMACs)
+	}
+	if !reflect.DeepEqual(l.duids, wantDUIDs) {
+		t.Errorf("duids = %v, want %v", l.duids, wantDUIDs)
+	}
+}
+
+func Test_fetchKeaLeases(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var cmd struct {
+			Command string   `json:"command"`
+			Service []string `json:"service"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch cmd.Command {
+		case "lease4-get-all":
+			fmt.Fprint(w, `[{"result":0,"arguments":{"leases":[{"ip-address":"192.168.1.10","hw-address":"34:42:62:2e:6c:b7","hostname":"ipad","state":0},{"ip-address":"192.168.1.11","hw-address":"dc:a9:04:98:2c:fe","hostname":"mac","state":2}]}}]`)
+		case "lease6-get-all":
+			fmt.Fprint(w, `[{"result":1,"text":"server is likely to be offline"}]`)
+		}
+	}))
+	defer srv.Close()
+
+	l, err := fetchKeaLeases(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"192.168.1.10": {"ipad."}}
+	if !reflect.DeepEqual(l.addrs, want) {
+		t.Errorf("addrs = %v, want %v", l.addrs, want)
+	}
+}
+
+func TestDHCP_keaAPI(t *testing.T) {
+	var mu sync.Mutex
+	release := make(chan struct{})
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `[{"result":0,"arguments":{"leases":[{"ip-address":"192.168.1.10","hw-address":"34:42:62:2e:6c:b7","hostname":"ipad","state":0}]}}]`)
+	}))
+	defer srv.Close()
+
+	r := &DHCP{LeaseFiles: []LeaseFile{{srv.URL, "kea-api"}}}
+	start := time.Now()
+	if got := r.LookupAddr("192.168.1.10"); got != nil {
+		t.Errorf("LookupAddr() = %v, want nil while fetching", got)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("LookupAddr() blocked for %v", d)
+	}
+	close(release)
+	lookup := func() []string {
+		for range 100 {
+			if got := r.LookupAddr("192.168.1.10"); got != nil {
+				return got
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return nil
+	}
+	if got := lookup(); !reflect.DeepEqual(got, []string{"ipad."}) {
+		t.Fatalf("LookupAddr() = %v", got)
+	}
+
+	// A failed fetch keeps the last known leases.
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	r.mu.Lock()
+	r.expires = time.Time{}
+	r.sources[srv.URL].next = time.Time{}
+	r.mu.Unlock()
+	r.refresh()
+	for range 100 {
+		r.mu.RLock()
+		backoff := r.sources[srv.URL].backoff
+		r.mu.RUnlock()
+		if backoff > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := r.LookupAddr("192.168.1.10"); !reflect.DeepEqual(got, []string{"ipad."}) {
+		t.Errorf("LookupAddr() after failure = %v", got)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if src := r.sources[srv.URL]; src.backoff != keaAPIInterval || !src.next.After(time.Now()) {
+		t.Errorf("backoff = %v, next = %v", src.backoff, src.next)
+	}
+}
+
+func TestDHCP_LeaseFiles(t *testing.T) {
+	dir := t.TempDir()
+	dnsmasq := filepath.Join(dir, "dhcp.leases")
+	odhcpd := filepath.Join(dir, "odhcpd")
+	if err := os.WriteFile(dnsmasq, []byte("1700003600 d8:3a:dd:0a:0b:0c 192.168.1.4 laptop *\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(odhcpd, []byte("# br-lan 000100012b1e3c4dd83add0a0b0c 0a0b0c0d laptop 1700000000 a4 128 fd00::a4/128\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r := &DHCP{LeaseFiles: []LeaseFile{{dnsmasq, "dnsmasq"}, {odhcpd, "odhcpd"}}}
+	if got := r.LookupHost("laptop"); !reflect.DeepEqual(got, []string{"192.168.1.4", "fd00::a4"}) {
+		t.Errorf("LookupHost() = %v", got)
+	}
+	if got := r.LookupAddr("fd00::a4"); !reflect.DeepEqual(got, []string{"laptop."}) {
+		t.Errorf("LookupAddr() = %v", got)
+	}
+	if got := r.LookupDUID("000100012b1e3c4dd83add0a0b0c"); !reflect.DeepEqual(got, []string{"laptop."}) {
+		t.Errorf("LookupDUID() = %v", got)
+	}
+}
+
+func TestParseLeaseFile(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    LeaseFile
+		wantErr bool
+	}{
+		{"kea-csv:/var/lib/kea/kea-leases6.csv", LeaseFile{"/var/lib/kea/kea-leases6.csv", "kea-csv"}, false},
+		{"kea-api:http://127.0.0.1:8000/", LeaseFile{"http://127.0.0.1:8000/", "kea-api"}, false},
+		{"kea-api:/var/run/kea.sock", LeaseFile{}, true},
+		{"unknown:/tmp/leases", LeaseFile{}, true},
+		{"/tmp/leases", LeaseFile{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseLeaseFile(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLeaseFile() err = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLeaseFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
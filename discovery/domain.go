@@ -0,0 +1,350 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/internal/dnsreply"
+	"github.com/nextdns/nextdns/resolver"
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+// domainNegativeTTL is the TTL and minimum TTL of the SOA record returned with
+// negative answers.
+const domainNegativeTTL = 60
+
+var errNotLocal = errors.New("not a local domain name")
+
+// Domain is a resolver answering A, AAAA and PTR queries for the hosts known
+// by a Resolver under a local domain, like dnsmasq does for DHCP clients. Host
+// names are sanitized to a single lowercase label and deduplicated with a
+// numeric suffix, the first source of the Resolver winning. Unknown names of
+// the domain get a NXDOMAIN answer with a SOA record, and PTR queries for
+// unknown addresses return an error so they are handled by the next
+// resolvers.
+type Domain struct {
+	// Name is the local domain, like "lan".
+	Name string
+
+	// Resolver provides the hosts.
+	Resolver Resolver
+
+	mu      sync.RWMutex
+	names   map[string][]netip.Addr
+	addrs   map[netip.Addr]string
+	expires time.Time
+}
+
+func (d *Domain) domain() string {
+	return prepareHostLookup(strings.Trim(d.Name, "."))
+}
+
+// Resolve implements the resolver.Resolver interface.
+func (d *Domain) Resolve(ctx context.Context, q query.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	domain := d.domain()
+	name := strings.ToLower(q.Name)
+	if q.Class != query.ClassINET {
+		return 0, i, errNotLocal
+	}
+	if q.Type == query.TypePTR {
+		addr, ok := reverseAddr(name)
+		if !ok {
+			return 0, i, errNotLocal
+		}
+		host := d.lookupAddr(addr)
+		if host == "" {
+			return 0, i, errNotLocal
+		}
+		ptr, err := dnsmessage.NewName(host + "." + domain)
+		if err != nil {
+			return 0, i, err
+		}
+		i.Transport = "discovery"
+		n, err = d.reply(q, buf, dnsmessage.RCodeSuccess, []dnsmessage.Resource{{
+			Header: resourceHeader(q.Name, dnsmessage.TypePTR, 0),
+			Body:   &dnsmessage.PTRResource{PTR: ptr},
+		}}, false)
+		return n, i, err
+	}
+
+	var host string
+	switch {
+	case name == domain:
+	case strings.HasSuffix(name, "."+domain):
+		host = strings.TrimSuffix(name, "."+domain)
+	default:
+		return 0, i, errNotLocal
+	}
+	i.Transport = "discovery"
+	if host == "" {
+		// Apex of the domain.
+		var answers []dnsmessage.Resource
+		if q.Type == query.TypeSOA {
+			answers = append(answers, d.soa())
+		}
+		n, err = d.reply(q, buf, dnsmessage.RCodeSuccess, answers, len(answers) == 0)
+		return n, i, err
+	}
+	addrs, found := d.lookupHost(host)
+	if !found {
+		n, err = d.reply(q, buf, dnsmessage.RCodeNameError, nil, true)
+		return n, i, err
+	}
+	var answers []dnsmessage.Resource
+	for _, addr := range addrs {
+		switch {
+		case q.Type == query.TypeA && addr.Is4():
+			answers = append(answers, dnsmessage.Resource{
+				Header: resourceHeader(q.Name, dnsmessage.TypeA, 0),
+				Body:   &dnsmessage.AResource{A: addr.As4()},
+			})
+		case q.Type == query.TypeAAAA && addr.Is6():
+			answers = append(answers, dnsmessage.Resource{
+				Header: resourceHeader(q.Name, dnsmessage.TypeAAAA, 0),
+				Body:   &dnsmessage.AAAAResource{AAAA: addr.As16()},
+			})
+		}
+	}
+	n, err = d.reply(q, buf, dnsmessage.RCodeSuccess, answers, len(answers) == 0)
+	return n, i, err
+}
+
+func resourceHeader(name string, typ dnsmessage.Type, ttl uint32) dnsmessage.ResourceHeader {
+	n, _ := dnsmessage.NewName(name)
+	return dnsmessage.ResourceHeader{
+		Name:  n,
+		Type:  typ,
+		Class: dnsmessage.ClassINET,
+		TTL:   ttl,
+	}
+}
+
+func (d *Domain) soa() dnsmessage.Resource {
+	domain := d.domain()
+	ns, _ := dnsmessage.NewName(domain)
+	mbox, _ := dnsmessage.NewName("hostmaster." + domain)
+	return dnsmessage.Resource{
+		Header: resourceHeader(domain, dnsmessage.TypeSOA, domainNegativeTTL),
+		Body: &dnsmessage.SOAResource{
+			NS:      ns,
+			MBox:    mbox,
+			Serial:  1,
+			Refresh: 3600,
+			Retry:   600,
+			Expire:  86400,
+			MinTTL:  domainNegativeTTL,
+		},
+	}
+}
+
+// reply writes a response to q in buf, with a SOA record in the authority
+// section if withSOA is true.
+func (d *Domain) reply(q query.Query, buf []byte, rcode dnsmessage.RCode, answers []dnsmessage.Resource, withSOA bool) (int, error) {
+	var authorities []dnsmessage.Resource
+	if withSOA {
+		authorities = append(authorities, d.soa())
+	}
+	return dnsreply.Write(q, buf, rcode, true, answers, authorities)
+}
+
+func (d *Domain) lookupHost(host string) ([]netip.Addr, bool) {
+	d.refresh()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	addrs, found := d.names[host]
+	return addrs, found
+}
+
+func (d *Domain) lookupAddr(addr netip.Addr) string {
+	d.refresh()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.addrs[addr]
+}
+
+// refresh rebuilds the host table from the Resolver every 5 seconds.
+func (d *Domain) refresh() {
+	d.mu.RLock()
+	expired := !time.Now().Before(d.expires)
+	d.mu.RUnlock()
+	if !expired {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	if now.Before(d.expires) {
+		return
+	}
+	d.expires = now.Add(5 * time.Second)
+	d.names, d.addrs = buildDomainHosts(d.Resolver, d.domain())
+}
+
+type domainHost struct {
+	name  string
+	addrs []netip.Addr
+}
+
+// buildDomainHosts returns the sanitized host names of the hosts known by r
+// with their addresses, and the host name of each address. Sources are
+// visited in order and their names in lexical order so the result is stable.
+func buildDomainHosts(r Resolver, domain string) (map[string][]netip.Addr, map[netip.Addr]string) {
+	names := map[string][]netip.Addr{}
+	addrs := map[netip.Addr]string{}
+	// owners is the index of the source a name was first found in.
+	owners := map[string]int{}
+	for si, s := range r {
+		var hosts []domainHost
+		s.Visit(func(name string, values []string) {
+			host := sanitizeHostName(name, domain)
+			if host == "" {
+				return
+			}
+			var hostAddrs []netip.Addr
+			for _, v := range values {
+				if addr, err := netip.ParseAddr(v); err == nil && addr.Zone() == "" {
+					hostAddrs = append(hostAddrs, addr.Unmap())
+				}
+			}
+			if len(hostAddrs) > 0 {
+				slices.SortFunc(hostAddrs, netip.Addr.Compare)
+				hosts = append(hosts, domainHost{name: host, addrs: hostAddrs})
+			}
+		})
+		slices.SortFunc(hosts, func(a, b domainHost) int {
+			if c := strings.Compare(a.name, b.name); c != 0 {
+				return c
+			}
+			return a.addrs[0].Compare(b.addrs[0])
+		})
+		for _, h := range hosts {
+			// A host already known by one of its addresses keeps its first
+			// name, and gets the new addresses if found with the same name
+			// again, like a host found with and without the .local suffix.
+			name := ""
+			for _, addr := range h.addrs {
+				if n, found := addrs[addr]; found {
+					name = n
+					break
+				}
+			}
+			if name != "" && !isHostNameVariant(name, h.name) {
+				continue
+			}
+			if name == "" {
+				name = h.name
+				// The same name found by another source is the same host,
+				// like its IPv6 addresses found by mDNS and its IPv4
+				// address by DHCP. Within a source, it is another host.
+				for i := 2; names[name] != nil && owners[name] == si; i++ {
+					name = h.name + "-" + strconv.Itoa(i)
+				}
+				if names[name] == nil {
+					owners[name] = si
+				}
+			}
+			for _, addr := range h.addrs {
+				if _, found := addrs[addr]; !found {
+					addrs[addr] = name
+					names[name] = append(names[name], addr)
+				}
+			}
+		}
+	}
+	return names, addrs
+}
+
+// isHostNameVariant returns true if name is host or host with a numeric
+// deduplication suffix.
+func isHostNameVariant(name, host string) bool {
+	if name == host {
+		return true
+	}
+	suffix, ok := strings.CutPrefix(name, host+"-")
+	if !ok || suffix == "" {
+		return false
+	}
+	_, err := strconv.Atoi(suffix)
+	return err == nil
+}
+
+// sanitizeHostName returns name as a single lowercase DNS label made of
+// letters, digits and hyphens, or an empty string if name is not a usable host
+// name. The .local and domain suffixes are removed and only the first label of
+// the remaining name is kept.
+func sanitizeHostName(name, domain string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if _, err := netip.ParseAddr(name); err == nil {
+		return ""
+	}
+	name = strings.TrimSuffix(name, ".local")
+	name = strings.TrimSuffix(name, "."+strings.TrimSuffix(domain, "."))
+	if i := strings.IndexByte(name, '.'); i != -1 {
+		name = name[:i]
+	}
+	if !isValidName(name) {
+		return ""
+	}
+	var b strings.Builder
+	dash := false
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(c)
+		default:
+			dash = true
+		}
+	}
+	s := b.String()
+	if len(s) > 63 {
+		s = strings.TrimRight(s[:63], "-")
+	}
+	return s
+}
+
+// reverseAddr returns the address of a PTR query name.
+func reverseAddr(name string) (netip.Addr, bool) {
+	if s, ok := strings.CutSuffix(name, ".in-addr.arpa."); ok {
+		labels := strings.Split(s, ".")
+		if len(labels) != 4 {
+			return netip.Addr{}, false
+		}
+		slices.Reverse(labels)
+		addr, err := netip.ParseAddr(strings.Join(labels, "."))
+		return addr, err == nil
+	}
+	if s, ok := strings.CutSuffix(name, ".ip6.arpa."); ok {
+		labels := strings.Split(s, ".")
+		if len(labels) != 32 {
+			return netip.Addr{}, false
+		}
+		var b [16]byte
+		for i, l := range labels {
+			if len(l) != 1 {
+				return netip.Addr{}, false
+			}
+			v, err := strconv.ParseUint(l, 16, 8)
+			if err != nil {
+				return netip.Addr{}, false
+			}
+			if i&1 == 0 {
+				b[15-i/2] |= byte(v)
+			} else {
+				b[15-i/2] |= byte(v) << 4
+			}
+		}
+		return netip.AddrFrom16(b), true
+	}
+	return netip.Addr{}, false
+}
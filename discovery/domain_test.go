@@ -0,0 +1,126 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/internal/dnstest"
+)
+
+func TestDomain_Resolve(t *testing.T) {
+	d := &Domain{
+		Name: "lan",
+		Resolver: Resolver{
+			testSource{
+				"Living Room TV.": {"192.168.1.20"},
+				"laptop.":         {"192.168.1.10"},
+				"laptop.local.":   {"192.168.1.10"},
+			},
+			testSource{
+				"laptop.local.": {"fd00::10"},
+				"nas.lan.":      {"192.168.1.30", "00:1c:42:2e:60:4a"},
+				"tv.":           {"192.168.1.20"},
+			},
+		},
+	}
+	tests := []struct {
+		name        string
+		typ         dnsmessage.Type
+		wantErr     bool
+		wantRCode   dnsmessage.RCode
+		wantAnswers []string
+		wantSOA     bool
+	}{
+		{"laptop.lan.", dnsmessage.TypeA, false, dnsmessage.RCodeSuccess, []string{"192.168.1.10"}, false},
+		{"LAPTOP.lan.", dnsmessage.TypeAAAA, false, dnsmessage.RCodeSuccess, []string{"fd00::10"}, false},
+		{"living-room-tv.lan.", dnsmessage.TypeA, false, dnsmessage.RCodeSuccess, []string{"192.168.1.20"}, false},
+		{"nas.lan.", dnsmessage.TypeA, false, dnsmessage.RCodeSuccess, []string{"192.168.1.30"}, false},
+		{"nas.lan.", dnsmessage.TypeAAAA, false, dnsmessage.RCodeSuccess, nil, true},
+		{"tv.lan.", dnsmessage.TypeA, false, dnsmessage.RCodeNameError, nil, true},
+		{"unknown.lan.", dnsmessage.TypeA, false, dnsmessage.RCodeNameError, nil, true},
+		{"lan.", dnsmessage.TypeSOA, false, dnsmessage.RCodeSuccess, []string{"lan."}, false},
+		{"10.1.168.192.in-addr.arpa.", dnsmessage.TypePTR, false, dnsmessage.RCodeSuccess, []string{"laptop.lan."}, false},
+		{"0.1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa.", dnsmessage.TypePTR, false, dnsmessage.RCodeSuccess, []string{"laptop.lan."}, false},
+		{"99.1.168.192.in-addr.arpa.", dnsmessage.TypePTR, true, 0, nil, false},
+		{"example.com.", dnsmessage.TypeA, true, 0, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+tt.typ.String(), func(t *testing.T) {
+			buf := make([]byte, 512)
+			n, _, err := d.Resolve(context.Background(), dnstest.NewQuery(t, tt.name, tt.typ), buf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var m dnsmessage.Message
+			if err := m.Unpack(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			if m.Header.RCode != tt.wantRCode {
+				t.Errorf("RCode = %v, want %v", m.Header.RCode, tt.wantRCode)
+			}
+			var answers []string
+			for _, rr := range m.Answers {
+				switch b := rr.Body.(type) {
+				case *dnsmessage.AResource:
+					answers = append(answers, net.IP(b.A[:]).String())
+				case *dnsmessage.AAAAResource:
+					answers = append(answers, net.IP(b.AAAA[:]).String())
+				case *dnsmessage.PTRResource:
+					answers = append(answers, b.PTR.String())
+				case *dnsmessage.SOAResource:
+					answers = append(answers, rr.Header.Name.String())
+				}
+			}
+			if !reflect.DeepEqual(answers, tt.wantAnswers) {
+				t.Errorf("answers = %v, want %v", answers, tt.wantAnswers)
+			}
+			if gotSOA := len(m.Authorities) == 1 && m.Authorities[0].Header.Type == dnsmessage.TypeSOA; gotSOA != tt.wantSOA {
+				t.Errorf("authority SOA = %v, want %v", gotSOA, tt.wantSOA)
+			}
+		})
+	}
+}
+
+func Test_buildDomainHosts_dedupe(t *testing.T) {
+	names, _ := buildDomainHosts(Resolver{testSource{
+		"printer.":       {"192.168.1.2"},
+		"Printer.local.": {"192.168.1.3"},
+	}}, "lan.")
+	want := map[string]bool{"printer": true, "printer-2": true}
+	for name := range names {
+		if !want[name] {
+			t.Errorf("unexpected name %s", name)
+		}
+		delete(want, name)
+	}
+	if len(want) > 0 {
+		t.Errorf("missing names %v", want)
+	}
+}
+
+func Test_sanitizeHostName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Living Room TV.", "living-room-tv"},
+		{"laptop.local.", "laptop"},
+		{"nas.lan.", "nas"},
+		{"host.example.com.", "host"},
+		{"--weird__name--.", "weird-name"},
+		{"192.168.1.1", ""},
+		{"331e87e5-3018-5336-23f3-595cdea48d9b.local.", ""},
+		{"!!!.", ""},
+	}
+	for _, tt := range tests {
+		if got := sanitizeHostName(tt.name, "lan."); got != tt.want {
+			t.Errorf("sanitizeHostName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/nextdns/nextdns/internal/fileinfo"
 )
 
 var hostsFiles = []string{
@@ -23,7 +25,7 @@ type Hosts struct {
 	mu       sync.RWMutex
 	addrs    map[string][]string
 	names    map[string][]string
-	fileInfo fileInfo
+	fileInfo fileinfo.Info
 	expires  time.Time
 }
 
@@ -92,7 +94,7 @@ func (r *Hosts) readHostsLocked(file string) (err error) {
 
 	r.names = names
 	r.addrs = addrs
-	r.fileInfo, err = getFileInfo(file)
+	r.fileInfo, err = fileinfo.Get(file)
 	return err
 }
 
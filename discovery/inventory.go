@@ -0,0 +1,239 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/arp"
+	"github.com/nextdns/nextdns/ndp"
+)
+
+const (
+	// inventoryInterval is the interval at which the inventory is updated.
+	inventoryInterval = 30 * time.Second
+
+	inventoryMaxEntries = 1000
+)
+
+// Client is a device of the network identified by its MAC address.
+type Client struct {
+	MAC       string       `json:"mac"`
+	IPs       []string     `json:"ips"`
+	Names     []ClientName `json:"names,omitempty"`
+	Model     string       `json:"model,omitempty"`
+	Profile   string       `json:"profile,omitempty"`
+	FirstSeen time.Time    `json:"first_seen"`
+	LastSeen  time.Time    `json:"last_seen"`
+}
+
+// ClientName is a name of a client with the source it was found by.
+type ClientName struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+// Inventory keeps track of the clients found in the ARP and NDP tables, merged
+// by MAC address with the names found by the discovery sources.
+type Inventory struct {
+	// Sources returns the sources the client names are found by.
+	Sources func() Resolver
+
+	// Profile returns the profile a query from ip and mac is sent to.
+	Profile func(ip net.IP, mac net.HardwareAddr) string
+
+	// OnNewClient is called when a client not seen before appears. Clients
+	// found by the first update after start are not reported.
+	OnNewClient func(c Client)
+
+	mu      sync.Mutex
+	clients map[string]*Client
+	updated bool
+}
+
+// Run updates the inventory every 30 seconds until ctx is cancelled.
+func (inv *Inventory) Run(ctx context.Context) {
+	inv.update(time.Now(), neighbors())
+	ticker := time.NewTicker(inventoryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			inv.update(time.Now(), neighbors())
+		}
+	}
+}
+
+// Clients updates the inventory and returns the known clients sorted by MAC
+// address.
+func (inv *Inventory) Clients() []Client {
+	inv.update(time.Now(), neighbors())
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	clients := make([]Client, 0, len(inv.clients))
+	for _, c := range inv.clients {
+		clients = append(clients, *c)
+	}
+	slices.SortFunc(clients, func(a, b Client) int {
+		return strings.Compare(a.MAC, b.MAC)
+	})
+	return clients
+}
+
+// neighbors returns the MAC address of each IP of the ARP and NDP tables.
+func neighbors() map[string]string {
+	m := map[string]string{}
+	if t, err := arp.Get(); err == nil {
+		for _, e := range t {
+			m[e.IP.String()] = e.MAC.String()
+		}
+	}
+	if t, err := ndp.Get(); err == nil {
+		for _, e := range t {
+			m[e.IP.String()] = e.MAC.String()
+		}
+	}
+	return m
+}
+
+// update updates the clients with the addresses in macs, IP to MAC, and the
+// names found by the sources.
+func (inv *Inventory) update(now time.Time, macs map[string]string) {
+	found := map[string]*Client{}
+	for ip, mac := range macs {
+		if !isClientMAC(mac) || !isClientIP(ip) {
+			continue
+		}
+		c := found[mac]
+		if c == nil {
+			c = &Client{MAC: mac}
+			found[mac] = c
+		}
+		c.IPs = appendUniq(c.IPs, ip)
+	}
+	var r Resolver
+	if inv.Sources != nil {
+		r = inv.Sources()
+	}
+	for _, s := range r {
+		source := s.Name()
+		addName := func(name, mac string) {
+			if c := found[mac]; c != nil && name != "" {
+				n := ClientName{Name: absDomainName([]byte(name)), Source: source}
+				if !slices.Contains(c.Names, n) {
+					c.Names = append(c.Names, n)
+				}
+			}
+		}
+		s.Visit(func(name string, addrs []string) {
+			if net.ParseIP(name) != nil {
+				// Reverse lookup caches are keyed by address.
+				for _, n := range addrs {
+					addName(n, macs[strings.ToLower(name)])
+				}
+				return
+			}
+			for _, addr := range addrs {
+				if mac, err := net.ParseMAC(addr); err == nil {
+					addName(name, mac.String())
+				} else {
+					addName(name, macs[strings.ToLower(addr)])
+				}
+			}
+		})
+	}
+	for _, c := range found {
+		slices.SortFunc(c.Names, func(a, b ClientName) int {
+			if n := strings.Compare(a.Name, b.Name); n != 0 {
+				return n
+			}
+			return strings.Compare(a.Source, b.Source)
+		})
+		ip := clientIP(c.IPs)
+		c.Model = r.LookupModel(ip.String())
+		if inv.Profile != nil {
+			mac, _ := net.ParseMAC(c.MAC)
+			c.Profile = inv.Profile(ip, mac)
+		}
+	}
+
+	var added []Client
+	inv.mu.Lock()
+	if inv.clients == nil {
+		inv.clients = map[string]*Client{}
+	}
+	for mac, c := range found {
+		if old := inv.clients[mac]; old != nil {
+			c.FirstSeen = old.FirstSeen
+		} else {
+			c.FirstSeen = now
+			if inv.updated {
+				added = append(added, *c)
+			}
+		}
+		c.LastSeen = now
+		inv.clients[mac] = c
+	}
+	for len(inv.clients) > inventoryMaxEntries {
+		inv.removeOldestEntry()
+	}
+	inv.updated = true
+	inv.mu.Unlock()
+
+	if inv.OnNewClient != nil {
+		for _, c := range added {
+			inv.OnNewClient(c)
+		}
+	}
+}
+
+func (inv *Inventory) removeOldestEntry() {
+	var oldestMAC string
+	oldestTime := time.Now()
+	for k, v := range inv.clients {
+		if v.LastSeen.Before(oldestTime) {
+			oldestTime = v.LastSeen
+			oldestMAC = k
+		}
+	}
+	if oldestMAC != "" {
+		delete(inv.clients, oldestMAC)
+	}
+}
+
+// clientIP returns the address used to select the profile of a client, its
+// first IPv4 address if any.
+func clientIP(ips []string) net.IP {
+	var first net.IP
+	for _, s := range ips {
+		ip := net.ParseIP(s)
+		if ip.To4() != nil {
+			return ip
+		}
+		if first == nil {
+			first = ip
+		}
+	}
+	return first
+}
+
+// isClientMAC returns true if mac is the unicast MAC address of a client, not
+// an incomplete entry or a multicast address.
+func isClientMAC(mac string) bool {
+	m, err := net.ParseMAC(mac)
+	if err != nil || len(m) == 0 {
+		return false
+	}
+	return m[0]&1 == 0 && !bytes.Equal(m, make(net.HardwareAddr, len(m)))
+}
+
+func isClientIP(s string) bool {
+	ip := net.ParseIP(s)
+	return ip != nil && !ip.IsMulticast() && !ip.IsUnspecified() && !ip.IsLoopback()
+}
@@ -0,0 +1,95 @@
+package discovery
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInventory_update(t *testing.T) {
+	src := testSource{
+		"laptop.":           {"192.168.1.10", "fe80::10"},
+		"192.168.1.20":      {"tv."},
+		"printer.":          {"00:1c:42:2e:60:4a"},
+		"unknown.":          {"192.168.1.99"},
+		"gone-from-arp.lan": {"192.168.1.30"},
+	}
+	var added []Client
+	inv := &Inventory{
+		Sources: func() Resolver { return Resolver{src} },
+		Profile: func(ip net.IP, mac net.HardwareAddr) string {
+			return "profile-" + ip.String()
+		},
+		OnNewClient: func(c Client) { added = append(added, c) },
+	}
+	start := time.Now()
+	inv.update(start, map[string]string{
+		"192.168.1.10":  "00:1c:42:00:00:10",
+		"fe80::10":      "00:1c:42:00:00:10",
+		"192.168.1.20":  "00:1c:42:00:00:20",
+		"192.168.1.40":  "00:1c:42:2e:60:4a",
+		"192.168.1.254": "00:00:00:00:00:00",
+		"224.0.0.251":   "01:00:5e:00:00:fb",
+	})
+	if len(added) != 0 {
+		t.Errorf("first update reported new clients: %v", added)
+	}
+
+	later := start.Add(time.Minute)
+	inv.update(later, map[string]string{
+		"192.168.1.10": "00:1c:42:00:00:10",
+		"192.168.1.50": "00:1c:42:00:00:50",
+	})
+	if len(added) != 1 || added[0].MAC != "00:1c:42:00:00:50" {
+		t.Errorf("added = %v, want 00:1c:42:00:00:50", added)
+	}
+
+	want := []Client{
+		{
+			MAC:       "00:1c:42:00:00:10",
+			IPs:       []string{"192.168.1.10"},
+			Names:     []ClientName{{"laptop.", "test"}},
+			Profile:   "profile-192.168.1.10",
+			FirstSeen: start,
+			LastSeen:  later,
+		},
+		{
+			MAC:       "00:1c:42:00:00:20",
+			IPs:       []string{"192.168.1.20"},
+			Names:     []ClientName{{"tv.", "test"}},
+			Profile:   "profile-192.168.1.20",
+			FirstSeen: start,
+			LastSeen:  start,
+		},
+		{
+			MAC:       "00:1c:42:00:00:50",
+			IPs:       []string{"192.168.1.50"},
+			Profile:   "profile-192.168.1.50",
+			FirstSeen: later,
+			LastSeen:  later,
+		},
+		{
+			MAC:       "00:1c:42:2e:60:4a",
+			IPs:       []string{"192.168.1.40"},
+			Names:     []ClientName{{"printer.", "test"}},
+			Profile:   "profile-192.168.1.40",
+			FirstSeen: start,
+			LastSeen:  start,
+		},
+	}
+	inv.mu.Lock()
+	var got []Client
+	for _, mac := range []string{"00:1c:42:00:00:10", "00:1c:42:00:00:20", "00:1c:42:00:00:50", "00:1c:42:2e:60:4a"} {
+		if c := inv.clients[mac]; c != nil {
+			got = append(got, *c)
+		}
+	}
+	if len(inv.clients) != len(want) {
+		t.Errorf("got %d clients, want %d", len(inv.clients), len(want))
+	}
+	inv.mu.Unlock()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("clients =\n%v\nwant\n%v", got, want)
+	}
+}
@@ -0,0 +1,173 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// readKeaCSVLease parses a Kea memfile lease file (kea-leases4.csv or
+// kea-leases6.csv). The file is append only: the last entry for an address
+// supersedes the previous ones. Released, declined, reclaimed and expired
+// leases are ignored.
+func readKeaCSVLease(r io.Reader, l leases, now time.Time) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	col := map[string]int{}
+	for i, name := range header {
+		col[strings.TrimSpace(name)] = i
+	}
+	if _, found := col["address"]; !found {
+		return errors.New("missing address column")
+	}
+	get := func(rec []string, name string) string {
+		if i, found := col[name]; found && i < len(rec) {
+			return rec[i]
+		}
+		return ""
+	}
+
+	type lease struct{ hostname, mac, duid string }
+	current := map[string]lease{}
+	var order []string
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		addr := get(rec, "address")
+		if addr == "" {
+			continue
+		}
+		if _, found := current[addr]; !found {
+			order = append(order, addr)
+		}
+		if state := get(rec, "state"); (state != "" && state != "0") || get(rec, "valid_lifetime") == "0" {
+			delete(current, addr)
+			continue
+		}
+		if expire, err := strconv.ParseInt(get(rec, "expire"), 10, 64); err == nil && expire < now.Unix() {
+			delete(current, addr)
+			continue
+		}
+		current[addr] = lease{
+			// Kea escapes commas in the hostname.
+			hostname: strings.ReplaceAll(get(rec, "hostname"), "&#x2c", ","),
+			mac:      get(rec, "hwaddr"),
+			duid:     get(rec, "duid"),
+		}
+	}
+	for _, addr := range order {
+		if le, found := current[addr]; found {
+			l.add(le.hostname, addr, le.mac, le.duid)
+		}
+	}
+	return nil
+}
+
+// keaLease is a lease returned by the Kea lease4-get-all and lease6-get-all
+// commands.
+type keaLease struct {
+	IPAddress string `json:"ip-address"`
+	HWAddress string `json:"hw-address"`
+	DUID      string `json:"duid"`
+	Hostname  string `json:"hostname"`
+	State     int    `json:"state"`
+}
+
+type keaResponse struct {
+	Result    int    `json:"result"`
+	Text      string `json:"text"`
+	Arguments struct {
+		Leases []keaLease `json:"leases"`
+	} `json:"arguments"`
+}
+
+const (
+	keaResultSuccess = 0
+	keaResultEmpty   = 3
+)
+
+// fetchKeaLeases queries the DHCPv4 and DHCPv6 leases from the Kea control
+// agent at agentURL. An error is returned only if both queries fail.
+func fetchKeaLeases(agentURL string) (leases, error) {
+	l := newLeases()
+	var errs []error
+	for _, cmd := range []struct{ command, service string }{
+		{"lease4-get-all", "dhcp4"},
+		{"lease6-get-all", "dhcp6"},
+	} {
+		ls, err := keaCommand(agentURL, cmd.command, cmd.service)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", cmd.command, err))
+			continue
+		}
+		for _, le := range ls {
+			if le.State != 0 {
+				continue
+			}
+			l.add(le.Hostname, le.IPAddress, le.HWAddress, le.DUID)
+		}
+	}
+	if len(errs) == 2 {
+		return l, errors.Join(errs...)
+	}
+	return l, nil
+}
+
+func keaCommand(agentURL, command, service string) ([]keaLease, error) {
+	body, err := json.Marshal(map[string]any{
+		"command": command,
+		"service": []string{service},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", agentURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status: %d", res.StatusCode)
+	}
+	var resps []keaResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, 16<<20)).Decode(&resps); err != nil {
+		return nil, err
+	}
+	if len(resps) == 0 {
+		return nil, errors.New("empty response")
+	}
+	switch resps[0].Result {
+	case keaResultSuccess:
+		return resps[0].Arguments.Leases, nil
+	case keaResultEmpty:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("result %d: %s", resps[0].Result, resps[0].Text)
+}
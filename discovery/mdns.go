@@ -48,6 +48,7 @@ type MDNS struct {
 	mu    sync.RWMutex
 	addrs map[string]mdnsEntry
 	names map[string]mdnsEntry
+	conns []*net.UDPConn
 }
 
 type mdnsEntry struct {
@@ -98,6 +99,10 @@ func (r *MDNS) Start(ctx context.Context, filter string) error {
 		return err
 	}
 
+	r.mu.Lock()
+	r.conns = conns
+	r.mu.Unlock()
+
 	go func() {
 		backoff := 100 * time.Millisecond
 		maxBackoff := 30 * time.Second
@@ -121,6 +126,9 @@ func (r *MDNS) Start(ctx context.Context, filter string) error {
 		}
 
 		<-ctx.Done()
+		r.mu.Lock()
+		r.conns = nil
+		r.mu.Unlock()
 		for _, conn := range conns {
 			_ = conn.Close()
 		}
@@ -129,6 +137,47 @@ func (r *MDNS) Start(ctx context.Context, filter string) error {
 	return nil
 }
 
+// Query sends an mDNS query for the A and AAAA records of name on the
+// interfaces the discovery is started on. Answers are added to the cache.
+func (r *MDNS) Query(name string) error {
+	r.mu.RLock()
+	conns := r.conns
+	r.mu.RUnlock()
+	if len(conns) == 0 {
+		return errors.New("mdns not started")
+	}
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return err
+	}
+	b := dnsmessage.NewBuilder(make([]byte, 0, 514), dnsmessage.Header{})
+	_ = b.StartQuestions()
+	for _, t := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		if err = b.Question(dnsmessage.Question{Name: n, Type: t, Class: dnsmessage.ClassINET}); err != nil {
+			return err
+		}
+	}
+	buf, err := b.Finish()
+	if err != nil {
+		return err
+	}
+	return r.send(conns, buf)
+}
+
+// send sends msg to the mDNS group of each conn.
+func (r *MDNS) send(conns []*net.UDPConn, msg []byte) (err error) {
+	for _, conn := range conns {
+		addr := ipv4Addr
+		if udpAddr, ok := conn.LocalAddr().(*net.UDPAddr); ok && udpAddr.IP.To4() == nil {
+			addr = ipv6Addr
+		}
+		if _, e := conn.WriteTo(msg, addr); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
 func (r *MDNS) Name() string {
 	return "mdns"
 }
@@ -0,0 +1,102 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/internal/dnsreply"
+	"github.com/nextdns/nextdns/resolver"
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+const (
+	// mdnsGatewayTTL is the TTL of the answers of the gateway.
+	mdnsGatewayTTL = 10
+
+	// mdnsGatewayTimeout is the default time the gateway waits for an answer
+	// to its mDNS query.
+	mdnsGatewayTimeout = time.Second
+)
+
+var errNotMDNS = errors.New("not an mDNS name")
+
+// MDNSGateway is a resolver answering unicast DNS queries for .local names
+// from the cache of an MDNS source. Names not in the cache are queried over
+// mDNS, and answered with NXDOMAIN if no answer is received before Timeout.
+// Queries for other names return an error so they are handled by the next
+// resolvers.
+type MDNSGateway struct {
+	MDNS *MDNS
+
+	// Timeout is the time to wait for an answer to an mDNS query, one
+	// second if zero.
+	Timeout time.Duration
+}
+
+// Resolve implements the resolver.Resolver interface.
+func (g *MDNSGateway) Resolve(ctx context.Context, q query.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	name := strings.ToLower(q.Name)
+	if q.Class != query.ClassINET || !strings.HasSuffix(name, ".local.") {
+		return 0, i, errNotMDNS
+	}
+	i.Transport = "mdns"
+	addrs := g.MDNS.LookupHost(name)
+	if len(addrs) == 0 && (q.Type == query.TypeA || q.Type == query.TypeAAAA) {
+		addrs = g.query(ctx, name)
+	}
+	if len(addrs) == 0 {
+		n, err = dnsreply.Write(q, buf, dnsmessage.RCodeNameError, false, nil, nil)
+		return n, i, err
+	}
+	var answers []dnsmessage.Resource
+	for _, v := range addrs {
+		addr, err := netip.ParseAddr(v)
+		if err != nil {
+			continue
+		}
+		addr = addr.Unmap()
+		switch {
+		case q.Type == query.TypeA && addr.Is4():
+			answers = append(answers, dnsmessage.Resource{
+				Header: resourceHeader(q.Name, dnsmessage.TypeA, mdnsGatewayTTL),
+				Body:   &dnsmessage.AResource{A: addr.As4()},
+			})
+		case q.Type == query.TypeAAAA && addr.Is6() && addr.Zone() == "":
+			answers = append(answers, dnsmessage.Resource{
+				Header: resourceHeader(q.Name, dnsmessage.TypeAAAA, mdnsGatewayTTL),
+				Body:   &dnsmessage.AAAAResource{AAAA: addr.As16()},
+			})
+		}
+	}
+	n, err = dnsreply.Write(q, buf, dnsmessage.RCodeSuccess, false, answers, nil)
+	return n, i, err
+}
+
+// query sends an mDNS query for name and waits for the answer to be cached.
+func (g *MDNSGateway) query(ctx context.Context, name string) []string {
+	if err := g.MDNS.Query(name); err != nil {
+		return nil
+	}
+	timeout := g.Timeout
+	if timeout == 0 {
+		timeout = mdnsGatewayTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if addrs := g.MDNS.LookupHost(name); len(addrs) > 0 {
+				return addrs
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/internal/dnstest"
+)
+
+func TestMDNSGateway_Resolve(t *testing.T) {
+	m := &MDNS{
+		addrs: map[string]mdnsEntry{},
+		names: map[string]mdnsEntry{},
+	}
+	addEntry(m.names, "printer.local.", "192.168.2.10")
+	addEntry(m.names, "printer.local.", "fd00::10")
+	addEntry(m.names, "printer.local.", "fe80::10%eth0")
+	g := &MDNSGateway{MDNS: m}
+	tests := []struct {
+		name        string
+		typ         dnsmessage.Type
+		wantErr     bool
+		wantRCode   dnsmessage.RCode
+		wantAnswers []string
+	}{
+		{"printer.local.", dnsmessage.TypeA, false, dnsmessage.RCodeSuccess, []string{"192.168.2.10"}},
+		{"Printer.LOCAL.", dnsmessage.TypeAAAA, false, dnsmessage.RCodeSuccess, []string{"fd00::10"}},
+		{"printer.local.", dnsmessage.TypeTXT, false, dnsmessage.RCodeSuccess, nil},
+		{"unknown.local.", dnsmessage.TypeA, false, dnsmessage.RCodeNameError, nil},
+		{"example.com.", dnsmessage.TypeA, true, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+tt.typ.String(), func(t *testing.T) {
+			buf := make([]byte, 512)
+			n, i, err := g.Resolve(context.Background(), dnstest.NewQuery(t, tt.name, tt.typ), buf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if i.Transport != "mdns" {
+				t.Errorf("Transport = %q, want mdns", i.Transport)
+			}
+			var msg dnsmessage.Message
+			if err := msg.Unpack(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Header.RCode != tt.wantRCode {
+				t.Errorf("RCode = %v, want %v", msg.Header.RCode, tt.wantRCode)
+			}
+			var answers []string
+			for _, rr := range msg.Answers {
+				switch b := rr.Body.(type) {
+				case *dnsmessage.AResource:
+					answers = append(answers, net.IP(b.A[:]).String())
+				case *dnsmessage.AAAAResource:
+					answers = append(answers, net.IP(b.AAAA[:]).String())
+				}
+			}
+			if !reflect.DeepEqual(answers, tt.wantAnswers) {
+				t.Errorf("answers = %v, want %v", answers, tt.wantAnswers)
+			}
+		})
+	}
+}
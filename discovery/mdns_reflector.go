@@ -0,0 +1,346 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+const (
+	// mdnsReflectorDedupWindow is the time during which a packet identical
+	// to a reflected one is not reflected again.
+	mdnsReflectorDedupWindow = time.Second
+
+	// mdnsReflectorAddrsInterval is the interval at which the local
+	// addresses are refreshed.
+	mdnsReflectorAddrsInterval = 30 * time.Second
+)
+
+// MDNSReflector forwards mDNS queries and responses received on one of its
+// interfaces to the other ones, so services announced on one network, like
+// printers and Chromecasts on a IoT VLAN, can be discovered from the others.
+//
+// Packets sent by the host itself, received on an interface not part of
+// Interfaces, or identical to a packet reflected less than a second ago are
+// not reflected, so two reflectors on the same networks do not loop.
+type MDNSReflector struct {
+	// Interfaces is the list of the names of the interfaces to reflect
+	// between. At least two interfaces are required.
+	Interfaces []string
+
+	// Services is the list of service types, like _ipp._tcp, to reflect. When
+	// empty, all services are reflected. Packets without service names, like
+	// host name queries and announcements, are always reflected.
+	Services []string
+
+	OnError func(err error)
+
+	mu         sync.Mutex
+	seen       map[uint64]time.Time
+	localAddrs map[string]struct{}
+	addrsAt    time.Time
+}
+
+// reflectorConn is a multicast conn joined on all the interfaces of the
+// reflector.
+type reflectorConn interface {
+	readFrom(b []byte) (n, ifIndex int, src net.IP, err error)
+	writeTo(b []byte, ifi *net.Interface) error
+	Close() error
+}
+
+// Run reflects mDNS packets until ctx is cancelled.
+func (r *MDNSReflector) Run(ctx context.Context) error {
+	if len(r.Interfaces) < 2 {
+		return errors.New("at least two interfaces are required")
+	}
+	ifaces := make(map[int]*net.Interface, len(r.Interfaces))
+	for _, name := range r.Interfaces {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		ifaces[ifi.Index] = ifi
+	}
+	if len(ifaces) < 2 {
+		return errors.New("at least two interfaces are required")
+	}
+	var conns []reflectorConn
+	var err error
+	for _, listen := range []func(map[int]*net.Interface) (reflectorConn, error){listenReflector4, listenReflector6} {
+		var c reflectorConn
+		if c, err = listen(ifaces); err == nil {
+			conns = append(conns, c)
+		}
+	}
+	if len(conns) == 0 {
+		return err
+	}
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.reflect(ctx, c, ifaces)
+		}()
+	}
+	<-ctx.Done()
+	for _, c := range conns {
+		_ = c.Close()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (r *MDNSReflector) reflect(ctx context.Context, c reflectorConn, ifaces map[int]*net.Interface) {
+	buf := make([]byte, 65536)
+	for {
+		n, ifIndex, src, err := c.readFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if err, ok := err.(*net.OpError); ok && (err.Timeout() || err.Temporary()) {
+				continue
+			}
+			if r.OnError != nil {
+				r.OnError(fmt.Errorf("read: %v", err))
+			}
+			return
+		}
+		if ifaces[ifIndex] == nil || r.isLocalAddr(src) {
+			continue
+		}
+		msg := buf[:n]
+		if !r.allowed(msg) || r.isDuplicate(msg, time.Now()) {
+			continue
+		}
+		for index, ifi := range ifaces {
+			if index == ifIndex {
+				continue
+			}
+			if err := c.writeTo(msg, ifi); err != nil && !isErrNetUnreachableOrInvalid(err) && r.OnError != nil {
+				r.OnError(fmt.Errorf("%s: %v", ifi.Name, err))
+			}
+		}
+	}
+}
+
+// isLocalAddr returns true if ip is an address of the host.
+func (r *MDNSReflector) isLocalAddr(ip net.IP) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now := time.Now(); now.Sub(r.addrsAt) > mdnsReflectorAddrsInterval {
+		r.addrsAt = now
+		r.localAddrs = map[string]struct{}{}
+		addrs, _ := net.InterfaceAddrs()
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				r.localAddrs[ipNet.IP.String()] = struct{}{}
+			}
+		}
+	}
+	_, found := r.localAddrs[ip.String()]
+	return found
+}
+
+// isDuplicate returns true if msg was reflected less than
+// mdnsReflectorDedupWindow before now, and records it otherwise.
+func (r *MDNSReflector) isDuplicate(msg []byte, now time.Time) bool {
+	h := xxhash.Sum64(msg)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, found := r.seen[h]; found && now.Sub(t) < mdnsReflectorDedupWindow {
+		return true
+	}
+	if r.seen == nil {
+		r.seen = map[uint64]time.Time{}
+	}
+	for k, t := range r.seen {
+		if now.Sub(t) >= mdnsReflectorDedupWindow {
+			delete(r.seen, k)
+		}
+	}
+	r.seen[h] = now
+	return false
+}
+
+// allowed returns true if msg is a valid mDNS message to be reflected
+// according to Services.
+func (r *MDNSReflector) allowed(msg []byte) bool {
+	types, err := mdnsServiceTypes(msg)
+	if err != nil {
+		return false
+	}
+	if len(r.Services) == 0 || len(types) == 0 {
+		return true
+	}
+	for _, s := range r.Services {
+		s = strings.TrimSuffix(strings.ToLower(strings.TrimSuffix(s, ".")), ".local")
+		for _, t := range types {
+			if t == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mdnsServiceTypes returns the service types, like _ipp._tcp, of the names
+// and PTR targets of msg.
+func mdnsServiceTypes(msg []byte) ([]string, error) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		return nil, err
+	}
+	var types []string
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range questions {
+		types = appendServiceType(types, q.Name.String())
+	}
+	for _, all := range []func() ([]dnsmessage.Resource, error){p.AllAnswers, p.AllAuthorities, p.AllAdditionals} {
+		rrs, err := all()
+		if err != nil {
+			return nil, err
+		}
+		for _, rr := range rrs {
+			types = appendServiceType(types, rr.Header.Name.String())
+			if ptr, ok := rr.Body.(*dnsmessage.PTRResource); ok {
+				types = appendServiceType(types, ptr.PTR.String())
+			}
+		}
+	}
+	return types, nil
+}
+
+// appendServiceType appends the service type of name to types if any.
+func appendServiceType(types []string, name string) []string {
+	labels := strings.Split(strings.ToLower(name), ".")
+	for i := 1; i < len(labels); i++ {
+		if (labels[i] == "_tcp" || labels[i] == "_udp") && strings.HasPrefix(labels[i-1], "_") {
+			return appendUniq(types, labels[i-1]+"."+labels[i])
+		}
+	}
+	return types
+}
+
+type reflectorConn4 struct {
+	*ipv4.PacketConn
+	mu sync.Mutex
+}
+
+func listenReflector4(ifaces map[int]*net.Interface) (reflectorConn, error) {
+	var c *ipv4.PacketConn
+	for _, ifi := range ifaces {
+		if c == nil {
+			conn, err := net.ListenMulticastUDP("udp4", ifi, ipv4Addr)
+			if err != nil {
+				return nil, err
+			}
+			c = ipv4.NewPacketConn(conn)
+			continue
+		}
+		if err := c.JoinGroup(ifi, ipv4Addr); err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("%s: %v", ifi.Name, err)
+		}
+	}
+	if err := c.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	_ = c.SetMulticastTTL(255)
+	_ = c.SetMulticastLoopback(false)
+	return &reflectorConn4{PacketConn: c}, nil
+}
+
+func (c *reflectorConn4) readFrom(b []byte) (n, ifIndex int, src net.IP, err error) {
+	n, cm, addr, err := c.ReadFrom(b)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if cm != nil {
+		ifIndex = cm.IfIndex
+	}
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		src = udpAddr.IP
+	}
+	return n, ifIndex, src, nil
+}
+
+func (c *reflectorConn4) writeTo(b []byte, ifi *net.Interface) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.SetMulticastInterface(ifi); err != nil {
+		return err
+	}
+	_, err := c.WriteTo(b, nil, ipv4Addr)
+	return err
+}
+
+type reflectorConn6 struct {
+	*ipv6.PacketConn
+	mu sync.Mutex
+}
+
+func listenReflector6(ifaces map[int]*net.Interface) (reflectorConn, error) {
+	var c *ipv6.PacketConn
+	for _, ifi := range ifaces {
+		if c == nil {
+			conn, err := net.ListenMulticastUDP("udp6", ifi, ipv6Addr)
+			if err != nil {
+				return nil, err
+			}
+			c = ipv6.NewPacketConn(conn)
+			continue
+		}
+		if err := c.JoinGroup(ifi, ipv6Addr); err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("%s: %v", ifi.Name, err)
+		}
+	}
+	if err := c.SetControlMessage(ipv6.FlagInterface, true); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	_ = c.SetMulticastHopLimit(255)
+	_ = c.SetMulticastLoopback(false)
+	return &reflectorConn6{PacketConn: c}, nil
+}
+
+func (c *reflectorConn6) readFrom(b []byte) (n, ifIndex int, src net.IP, err error) {
+	n, cm, addr, err := c.ReadFrom(b)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if cm != nil {
+		ifIndex = cm.IfIndex
+	}
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		src = udpAddr.IP
+	}
+	return n, ifIndex, src, nil
+}
+
+func (c *reflectorConn6) writeTo(b []byte, ifi *net.Interface) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.SetMulticastInterface(ifi); err != nil {
+		return err
+	}
+	_, err := c.WriteTo(b, nil, ipv6Addr)
+	return err
+}
@@ -0,0 +1,94 @@
+package discovery
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+func newMDNSMessage(t *testing.T, questions []string, ptrs map[string]string) []byte {
+	t.Helper()
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: len(ptrs) > 0})
+	_ = b.StartQuestions()
+	for _, q := range questions {
+		_ = b.Question(dnsmessage.Question{
+			Name:  dnsmessage.MustNewName(q),
+			Type:  dnsmessage.TypePTR,
+			Class: dnsmessage.ClassINET,
+		})
+	}
+	_ = b.StartAnswers()
+	for name, ptr := range ptrs {
+		err := b.PTRResource(dnsmessage.ResourceHeader{
+			Name:  dnsmessage.MustNewName(name),
+			Class: dnsmessage.ClassINET,
+		}, dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(ptr)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func Test_mdnsServiceTypes(t *testing.T) {
+	msg := newMDNSMessage(t, []string{"_googlecast._tcp.local."}, map[string]string{
+		"_services._dns-sd._udp.local.": "_IPP._tcp.local.",
+		"_ipp._tcp.local.":              "Office Printer._ipp._tcp.local.",
+	})
+	got, err := mdnsServiceTypes(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"_dns-sd._udp", "_googlecast._tcp", "_ipp._tcp"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mdnsServiceTypes() = %v, want %v", got, want)
+	}
+}
+
+func TestMDNSReflector_allowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		services []string
+		msg      []byte
+		want     bool
+	}{
+		{"no allowlist", nil, newMDNSMessage(t, []string{"_airplay._tcp.local."}, nil), true},
+		{"allowed", []string{"_airplay._tcp", "_ipp._tcp.local."}, newMDNSMessage(t, nil, map[string]string{"_ipp._tcp.local.": "Printer._ipp._tcp.local."}), true},
+		{"allowed case", []string{"_IPP._tcp"}, newMDNSMessage(t, []string{"_ipp._tcp.local."}, nil), true},
+		{"not allowed", []string{"_ipp._tcp"}, newMDNSMessage(t, []string{"_airplay._tcp.local."}, nil), false},
+		{"host name", []string{"_ipp._tcp"}, newMDNSMessage(t, []string{"printer.local."}, nil), true},
+		{"invalid", nil, []byte{1, 2, 3}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &MDNSReflector{Services: tt.services}
+			if got := r.allowed(tt.msg); got != tt.want {
+				t.Errorf("allowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMDNSReflector_isDuplicate(t *testing.T) {
+	r := &MDNSReflector{}
+	msg := []byte("message")
+	now := time.Now()
+	if r.isDuplicate(msg, now) {
+		t.Error("first message reported as duplicate")
+	}
+	if !r.isDuplicate(msg, now.Add(100*time.Millisecond)) {
+		t.Error("reflected message not reported as duplicate")
+	}
+	if r.isDuplicate([]byte("other"), now.Add(100*time.Millisecond)) {
+		t.Error("other message reported as duplicate")
+	}
+	if r.isDuplicate(msg, now.Add(2*mdnsReflectorDedupWindow)) {
+		t.Error("expired message reported as duplicate")
+	}
+}
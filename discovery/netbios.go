@@ -0,0 +1,463 @@
+package discovery
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/arp"
+)
+
+const (
+	netbiosMaxEntries = 1000
+
+	// netbiosProbeInterval is the minimum interval between two node status
+	// queries for the same address.
+	netbiosProbeInterval = 5 * time.Minute
+
+	netbiosProbeTimeout = time.Second
+
+	// NetBIOS name service record types.
+	netbiosTypeNB     = 0x20
+	netbiosTypeNBSTAT = 0x21
+
+	// NetBIOS name service opcodes of name announcements.
+	netbiosOpRegistration = 5
+	netbiosOpRefresh      = 8
+	netbiosOpRefreshAlt   = 9
+
+	// netbiosGroupFlag is set in NB_FLAGS and name flags for group names.
+	netbiosGroupFlag = 0x8000
+)
+
+// netbiosPort is the NetBIOS name service port.
+var netbiosPort = 137
+
+// NetBIOS is a source discovering the names of Windows hosts. It passively
+// listens for NetBIOS name service registrations, and sends a NetBIOS node
+// status query to private IPv4 addresses it does not know about on lookup.
+// Node status responses also give the MAC address of the host.
+type NetBIOS struct {
+	OnError func(err error)
+
+	mu     sync.RWMutex
+	addrs  map[string]mdnsEntry
+	names  map[string]mdnsEntry
+	macs   map[string]mdnsEntry
+	probes map[string]time.Time
+	probe  bool
+	nets   []*net.IPNet
+}
+
+func (r *NetBIOS) Name() string {
+	return "netbios"
+}
+
+// Start listens for NetBIOS name service messages until ctx is cancelled and
+// enables node status queries. When filter is an interface name rather than
+// "all", only the hosts of the networks of this interface are discovered.
+// Failing to listen on the NetBIOS name service port, which might be used by a
+// local NetBIOS server, is reported to OnError but is not fatal.
+func (r *NetBIOS) Start(ctx context.Context, filter string) error {
+	if filter == "disabled" {
+		return nil
+	}
+	var nets []*net.IPNet
+	if filter != "all" {
+		iface, err := net.InterfaceByName(filter)
+		if err != nil {
+			return fmt.Errorf("unknown interface: %s", filter)
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return err
+		}
+		nets = []*net.IPNet{}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				nets = append(nets, ipNet)
+			}
+		}
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: netbiosPort})
+	if err == nil {
+		go r.read(ctx, conn, r.addNetBIOS)
+	} else if r.OnError != nil {
+		r.OnError(fmt.Errorf("netbios listen: %v", err))
+	}
+	r.mu.Lock()
+	r.probe = true
+	r.nets = nets
+	r.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		r.mu.Lock()
+		r.probe = false
+		r.mu.Unlock()
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}()
+	return nil
+}
+
+func (r *NetBIOS) read(ctx context.Context, conn *net.UDPConn, add func(buf []byte, from net.IP)) {
+	defer conn.Close()
+	buf := make([]byte, 65536)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if err, ok := err.(*net.OpError); ok {
+				if err.Timeout() || err.Temporary() {
+					if ctx.Err() == nil {
+						continue
+					}
+				}
+			}
+			return
+		}
+		if n < 12 {
+			// Silently ignore obviously invalid messages
+			continue
+		}
+		add(buf[:n], from.IP)
+	}
+}
+
+// addNetBIOS records the name and addresses of NetBIOS name registrations.
+func (r *NetBIOS) addNetBIOS(buf []byte, from net.IP) {
+	name, addrs, err := parseNetBIOSRegistration(buf)
+	if err != nil {
+		return
+	}
+	for _, addr := range addrs {
+		if r.inNets(addr) {
+			r.add(addr.String(), name, nil)
+		}
+	}
+}
+
+// inNets returns true if ip is part of the networks hosts are discovered on.
+func (r *NetBIOS) inNets(ip net.IP) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.nets == nil {
+		return true
+	}
+	for _, n := range r.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *NetBIOS) add(addr, name string, mac net.HardwareAddr) {
+	if !isValidName(name) {
+		return
+	}
+	name = absDomainName([]byte(strings.ToLower(name)))
+	if mac == nil {
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil {
+			mac = arp.SearchMAC(ip)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.addrs == nil {
+		r.addrs = map[string]mdnsEntry{}
+		r.names = map[string]mdnsEntry{}
+		r.macs = map[string]mdnsEntry{}
+	}
+	addEntry(r.addrs, addr, name)
+	addEntry(r.names, name, addr)
+	if mac != nil {
+		addEntry(r.macs, mac.String(), name)
+	}
+	for len(r.names) > netbiosMaxEntries {
+		r.removeOldestEntry()
+	}
+}
+
+func (r *NetBIOS) removeOldestEntry() {
+	var oldestName string
+	oldestTime := time.Now()
+	for k, v := range r.names {
+		if v.lastUpdate.Before(oldestTime) {
+			oldestTime = v.lastUpdate
+			oldestName = k
+		}
+	}
+	if oldestName != "" {
+		addrs := r.names[oldestName].values
+		delete(r.names, oldestName)
+		for _, addr := range addrs {
+			removeEntry(r.addrs, addr, oldestName)
+		}
+		for mac, e := range r.macs {
+			for _, name := range e.values {
+				if name == oldestName {
+					removeEntry(r.macs, mac, oldestName)
+					break
+				}
+			}
+		}
+	}
+}
+
+func (r *NetBIOS) Visit(f func(name string, addrs []string)) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for name, addrs := range r.names {
+		f(name, addrs.values)
+	}
+}
+
+// LookupAddr returns the known names of addr. If addr is an unknown private
+// IPv4 address, a node status query is sent in the background so the name is
+// known by subsequent lookups.
+func (r *NetBIOS) LookupAddr(addr string) []string {
+	r.mu.RLock()
+	names := r.addrs[addr].values
+	r.mu.RUnlock()
+	if len(names) == 0 {
+		r.maybeProbe(addr)
+	}
+	return names
+}
+
+func (r *NetBIOS) LookupHost(name string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.names[prepareHostLookup(name)].values
+}
+
+func (r *NetBIOS) LookupMAC(mac string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.macs[mac].values
+}
+
+func (r *NetBIOS) maybeProbe(addr string) {
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.To4() == nil || !isPrivateIP(addr) || !r.inNets(ip) {
+		return
+	}
+	now := time.Now()
+	r.mu.Lock()
+	if !r.probe || now.Sub(r.probes[addr]) < netbiosProbeInterval {
+		r.mu.Unlock()
+		return
+	}
+	if r.probes == nil {
+		r.probes = map[string]time.Time{}
+	}
+	for a, t := range r.probes {
+		if now.Sub(t) >= netbiosProbeInterval {
+			delete(r.probes, a)
+		}
+	}
+	r.probes[addr] = now
+	r.mu.Unlock()
+	go func() {
+		name, mac, err := queryNodeStatus(ip, netbiosProbeTimeout)
+		if err != nil {
+			return
+		}
+		r.add(addr, name, mac)
+	}()
+}
+
+// queryNodeStatus sends a NetBIOS node status query to ip and returns the
+// workstation name and MAC address of the host.
+func queryNodeStatus(ip net.IP, timeout time.Duration) (string, net.HardwareAddr, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: ip, Port: netbiosPort})
+	if err != nil {
+		return "", nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	var id [2]byte
+	_, _ = rand.Read(id[:])
+	if _, err = conn.Write(netbiosNodeStatusRequest(binary.BigEndian.Uint16(id[:]))); err != nil {
+		return "", nil, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return "", nil, err
+		}
+		if n < 12 || buf[0] != id[0] || buf[1] != id[1] {
+			continue
+		}
+		return parseNetBIOSNodeStatus(buf[:n])
+	}
+}
+
+// netbiosNodeStatusRequest returns a node status request for the wildcard
+// name.
+func netbiosNodeStatusRequest(id uint16) []byte {
+	b := make([]byte, 0, 50)
+	b = binary.BigEndian.AppendUint16(b, id)
+	b = append(b, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0) // flags, qdcount = 1
+	var name [16]byte
+	name[0] = '*'
+	b = appendNetBIOSName(b, name)
+	b = binary.BigEndian.AppendUint16(b, netbiosTypeNBSTAT)
+	b = binary.BigEndian.AppendUint16(b, 1) // IN
+	return b
+}
+
+// appendNetBIOSName appends name with the first level encoding of RFC 1001
+// section 14.1.
+func appendNetBIOSName(b []byte, name [16]byte) []byte {
+	b = append(b, 32)
+	for _, c := range name {
+		b = append(b, 'A'+c>>4, 'A'+c&0xf)
+	}
+	return append(b, 0)
+}
+
+// readNetBIOSName reads the name at off of msg and returns the decoded name
+// without its padding, its suffix and the offset following it.
+func readNetBIOSName(msg []byte, off int) (name string, suffix byte, next int, err error) {
+	if off >= len(msg) {
+		return "", 0, 0, errors.New("short name")
+	}
+	if msg[off]&0xc0 == 0xc0 {
+		// Compression pointer, always to the question name.
+		if off+2 > len(msg) {
+			return "", 0, 0, errors.New("short name pointer")
+		}
+		ptr := int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+		if ptr >= off {
+			return "", 0, 0, errors.New("invalid name pointer")
+		}
+		name, suffix, _, err = readNetBIOSName(msg, ptr)
+		return name, suffix, off + 2, err
+	}
+	if msg[off] != 32 || off+33 > len(msg) {
+		return "", 0, 0, errors.New("invalid name length")
+	}
+	var decoded [16]byte
+	for i := range decoded {
+		hi, lo := msg[off+1+2*i]-'A', msg[off+2+2*i]-'A'
+		if hi > 0xf || lo > 0xf {
+			return "", 0, 0, errors.New("invalid name encoding")
+		}
+		decoded[i] = hi<<4 | lo
+	}
+	// Skip the scope labels.
+	next = off + 33
+	for next < len(msg) && msg[next] != 0 {
+		next += int(msg[next]) + 1
+	}
+	if next >= len(msg) {
+		return "", 0, 0, errors.New("short name scope")
+	}
+	return strings.TrimRight(string(decoded[:15]), " \x00"), decoded[15], next + 1, nil
+}
+
+// parseNetBIOSRegistration returns the name and addresses of a NetBIOS name
+// registration or refresh request for a unique workstation or server name.
+func parseNetBIOSRegistration(msg []byte) (string, []net.IP, error) {
+	if len(msg) < 12 {
+		return "", nil, errors.New("short message")
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&0x8000 != 0 {
+		return "", nil, errors.New("not a request")
+	}
+	switch (flags >> 11) & 0xf {
+	case netbiosOpRegistration, netbiosOpRefresh, netbiosOpRefreshAlt:
+	default:
+		return "", nil, errors.New("not a registration")
+	}
+	if binary.BigEndian.Uint16(msg[4:]) != 1 || binary.BigEndian.Uint16(msg[10:]) != 1 {
+		return "", nil, errors.New("unexpected record counts")
+	}
+	name, suffix, off, err := readNetBIOSName(msg, 12)
+	if err != nil {
+		return "", nil, err
+	}
+	if suffix != 0x00 && suffix != 0x20 {
+		return "", nil, errors.New("not a host name")
+	}
+	off += 4 // question type and class
+	if _, _, off, err = readNetBIOSName(msg, off); err != nil {
+		return "", nil, err
+	}
+	if off+10 > len(msg) {
+		return "", nil, errors.New("short record")
+	}
+	typ := binary.BigEndian.Uint16(msg[off:])
+	rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	if typ != netbiosTypeNB || off+rdlen > len(msg) {
+		return "", nil, errors.New("invalid NB record")
+	}
+	var addrs []net.IP
+	for ; rdlen >= 6; rdlen, off = rdlen-6, off+6 {
+		if binary.BigEndian.Uint16(msg[off:])&netbiosGroupFlag != 0 {
+			return "", nil, errors.New("group name")
+		}
+		addrs = append(addrs, net.IPv4(msg[off+2], msg[off+3], msg[off+4], msg[off+5]))
+	}
+	return name, addrs, nil
+}
+
+// parseNetBIOSNodeStatus returns the unique workstation name and MAC address
+// of a node status response.
+func parseNetBIOSNodeStatus(msg []byte) (string, net.HardwareAddr, error) {
+	if len(msg) < 12 {
+		return "", nil, errors.New("short message")
+	}
+	if binary.BigEndian.Uint16(msg[2:])&0x8000 == 0 {
+		return "", nil, errors.New("not a response")
+	}
+	if binary.BigEndian.Uint16(msg[6:]) == 0 {
+		return "", nil, errors.New("no answer")
+	}
+	_, _, off, err := readNetBIOSName(msg, 12)
+	if err != nil {
+		return "", nil, err
+	}
+	if off+11 > len(msg) || binary.BigEndian.Uint16(msg[off:]) != netbiosTypeNBSTAT {
+		return "", nil, errors.New("invalid NBSTAT record")
+	}
+	rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	if rdlen == 0 || off+rdlen > len(msg) {
+		return "", nil, errors.New("short NBSTAT record")
+	}
+	rdata := msg[off : off+rdlen]
+	count := int(rdata[0])
+	if 1+count*18+6 > len(rdata) {
+		return "", nil, errors.New("short node name table")
+	}
+	var name string
+	for i := 0; i < count; i++ {
+		e := rdata[1+i*18 : 1+(i+1)*18]
+		if e[15] == 0x00 && binary.BigEndian.Uint16(e[16:])&netbiosGroupFlag == 0 {
+			name = strings.TrimRight(string(e[:15]), " \x00")
+			break
+		}
+	}
+	if name == "" {
+		return "", nil, errors.New("no workstation name")
+	}
+	mac := net.HardwareAddr(append([]byte(nil), rdata[1+count*18:1+count*18+6]...))
+	if string(mac) == "\x00\x00\x00\x00\x00\x00" {
+		// Samba reports a zero unit ID.
+		mac = nil
+	}
+	return name, mac, nil
+}
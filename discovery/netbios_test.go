@@ -0,0 +1,130 @@
+package discovery
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func netbiosName(name string, suffix byte) [16]byte {
+	var n [16]byte
+	copy(n[:], "               ")
+	copy(n[:], name)
+	n[15] = suffix
+	return n
+}
+
+func Test_parseNetBIOSRegistration(t *testing.T) {
+	msg := []byte{0x12, 0x34, 0x29, 0x10, 0, 1, 0, 0, 0, 0, 0, 1} // registration, broadcast
+	msg = appendNetBIOSName(msg, netbiosName("DESKTOP-42", 0x00))
+	msg = append(msg, 0, netbiosTypeNB, 0, 1)
+	msg = append(msg, 0xc0, 12, 0, netbiosTypeNB, 0, 1, 0, 0, 0x0e, 0x10, 0, 6)
+	msg = append(msg, 0x00, 0x00, 192, 168, 1, 42)
+
+	name, addrs, err := parseNetBIOSRegistration(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []net.IP{net.IPv4(192, 168, 1, 42)}; name != "DESKTOP-42" || !reflect.DeepEqual(addrs, want) {
+		t.Errorf("parseNetBIOSRegistration() = %q, %v, want DESKTOP-42, %v", name, addrs, want)
+	}
+
+	// Group names are ignored.
+	binary.BigEndian.PutUint16(msg[len(msg)-6:], netbiosGroupFlag)
+	if _, _, err := parseNetBIOSRegistration(msg); err == nil {
+		t.Error("parseNetBIOSRegistration(group) = nil error")
+	}
+}
+
+func nodeStatusResponse(id uint16) []byte {
+	msg := binary.BigEndian.AppendUint16(nil, id)
+	msg = append(msg, 0x84, 0x00, 0, 0, 0, 1, 0, 0, 0, 0)
+	var wildcard [16]byte
+	wildcard[0] = '*'
+	msg = appendNetBIOSName(msg, wildcard)
+	var rdata []byte
+	rdata = append(rdata, 3)
+	for _, e := range []struct {
+		name   string
+		suffix byte
+		flags  uint16
+	}{
+		{"WORKGROUP", 0x00, netbiosGroupFlag},
+		{"DESKTOP-42", 0x20, 0},
+		{"DESKTOP-42", 0x00, 0},
+	} {
+		n := netbiosName(e.name, e.suffix)
+		rdata = append(rdata, n[:]...)
+		rdata = binary.BigEndian.AppendUint16(rdata, e.flags)
+	}
+	rdata = append(rdata, 0x00, 0x1c, 0x42, 0x2e, 0x60, 0x4a)
+	msg = append(msg, 0, netbiosTypeNBSTAT, 0, 1, 0, 0, 0, 0)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(rdata)))
+	return append(msg, rdata...)
+}
+
+func Test_queryNodeStatus(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer conn.Close()
+	defer func(port int) { netbiosPort = port }(netbiosPort)
+	netbiosPort = conn.LocalAddr().(*net.UDPAddr).Port
+	go func() {
+		buf := make([]byte, 512)
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if n != 50 || binary.BigEndian.Uint16(buf[n-4:]) != netbiosTypeNBSTAT {
+			t.Errorf("unexpected request: %x", buf[:n])
+			return
+		}
+		_, _ = conn.WriteToUDP(nodeStatusResponse(binary.BigEndian.Uint16(buf)), from)
+	}()
+
+	name, mac, err := queryNodeStatus(net.IPv4(127, 0, 0, 1), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "DESKTOP-42" || mac.String() != "00:1c:42:2e:60:4a" {
+		t.Errorf("queryNodeStatus() = %q, %v, want DESKTOP-42, 00:1c:42:2e:60:4a", name, mac)
+	}
+}
+
+func TestNetBIOS_add(t *testing.T) {
+	r := &NetBIOS{}
+	mac, _ := net.ParseMAC("00:1c:42:2e:60:4a")
+	r.add("192.168.1.42", "DESKTOP-42", mac)
+	if got, want := r.LookupAddr("192.168.1.42"), []string{"desktop-42."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LookupAddr() = %v, want %v", got, want)
+	}
+	if got, want := r.LookupMAC("00:1c:42:2e:60:4a"), []string{"desktop-42."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LookupMAC() = %v, want %v", got, want)
+	}
+	if got, want := r.LookupHost("DESKTOP-42"), []string{"192.168.1.42"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LookupHost() = %v, want %v", got, want)
+	}
+}
+
+func TestNetBIOS_inNets(t *testing.T) {
+	r := &NetBIOS{}
+	if !r.inNets(net.ParseIP("10.0.0.1")) {
+		t.Error("inNets() = false without filter")
+	}
+	_, lan, _ := net.ParseCIDR("192.168.1.0/24")
+	r.nets = []*net.IPNet{lan}
+	if !r.inNets(net.ParseIP("192.168.1.42")) {
+		t.Error("inNets(192.168.1.42) = false")
+	}
+	if r.inNets(net.ParseIP("10.0.0.1")) {
+		t.Error("inNets(10.0.0.1) = true")
+	}
+	r.nets = []*net.IPNet{}
+	if r.inNets(net.ParseIP("192.168.1.42")) {
+		t.Error("inNets() = true with an interface without IPv4 network")
+	}
+}
@@ -0,0 +1,56 @@
+package discovery
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+)
+
+// readODHCPDLease parses the odhcpd state file (/tmp/hosts/odhcpd on OpenWrt).
+// Each lease is described by a comment line followed by hosts entries:
+//
+//	# br-lan 000100012b1e3c4dd83add0a0b0c 3add0a0b laptop 1700000000 a4 128 fd00::a4/128
+//	fd00::a4	laptop.lan	laptop
+//
+// For DHCPv4 leases, the IAID is ipv4 and the DUID field is the MAC address.
+func readODHCPDLease(r io.Reader, l leases) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line, ok := strings.CutPrefix(s.Text(), "#")
+		if !ok {
+			continue
+		}
+		// iface duid iaid hostname validtime assigned prefixlen addrs...
+		fields := strings.Fields(line)
+		if len(fields) < 8 {
+			continue
+		}
+		id, iaid, hostname := fields[1], fields[2], fields[3]
+		if !isValidName(hostname) {
+			continue
+		}
+		var mac, duid string
+		if iaid == "ipv4" {
+			if b, err := hex.DecodeString(id); err == nil && len(b) == 6 {
+				mac = net.HardwareAddr(b).String()
+			}
+		} else {
+			duid = id
+		}
+		found := false
+		for _, addr := range fields[7:] {
+			ip, _, _ := strings.Cut(addr, "/")
+			if net.ParseIP(ip) == nil {
+				continue
+			}
+			found = true
+			l.add(hostname, ip, mac, duid)
+		}
+		if !found {
+			l.add(hostname, "", mac, duid)
+		}
+	}
+	return s.Err()
+}
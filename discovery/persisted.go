@@ -0,0 +1,282 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/arp"
+	"github.com/nextdns/nextdns/ndp"
+)
+
+const (
+	// persistedInterval is the interval at which the associations found by
+	// the recorded sources are recorded.
+	persistedInterval = time.Minute
+
+	// persistedSaveInterval is the maximum interval between two saves when
+	// only timestamps changed, to limit writes on flash storage.
+	persistedSaveInterval = time.Hour
+)
+
+// persistedEntry is the last seen association of a name with a MAC and IPs.
+type persistedEntry struct {
+	Name     string    `json:"name"`
+	MAC      string    `json:"mac,omitempty"`
+	IPs      []string  `json:"ips,omitempty"`
+	Source   string    `json:"source"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Persisted is a source remembering on disk the names found by other sources,
+// so clients keep their name after a restart until they announce themselves
+// again. It is meant to be used as the last source of a Resolver.
+type Persisted struct {
+	// File is the path of the file where the associations are stored.
+	File string
+
+	// Sources returns the sources the associations are recorded from. The
+	// Persisted source is ignored if part of them.
+	Sources func() Resolver
+
+	// MaxAge is the duration after which an association not seen again is
+	// forgotten. If zero, associations never expire.
+	MaxAge time.Duration
+
+	// OnError is called when the file cannot be loaded or saved.
+	OnError func(err error)
+
+	mu      sync.RWMutex
+	loaded  bool
+	dirty   bool
+	saved   time.Time
+	entries map[string]*persistedEntry
+}
+
+func (r *Persisted) Name() string {
+	return "persisted"
+}
+
+func (r *Persisted) logErr(err error) {
+	if r.OnError != nil {
+		r.OnError(err)
+	}
+}
+
+// Run records the associations found by the sources every minute until ctx
+// is cancelled.
+func (r *Persisted) Run(ctx context.Context) {
+	ticker := time.NewTicker(persistedInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			r.save()
+			return
+		case <-ticker.C:
+			r.record(time.Now())
+			r.save()
+		}
+	}
+}
+
+// loadLocked loads the file on first use.
+func (r *Persisted) loadLocked() {
+	if r.loaded {
+		return
+	}
+	r.loaded = true
+	r.entries = map[string]*persistedEntry{}
+	b, err := os.ReadFile(r.File)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			r.logErr(fmt.Errorf("persisted: %v", err))
+		}
+		return
+	}
+	var entries []*persistedEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		r.logErr(fmt.Errorf("persisted: %s: %v", r.File, err))
+		return
+	}
+	for _, e := range entries {
+		slices.Sort(e.IPs)
+		r.entries[strings.ToLower(e.Name)] = e
+	}
+}
+
+func (r *Persisted) load() {
+	r.mu.RLock()
+	loaded := r.loaded
+	r.mu.RUnlock()
+	if !loaded {
+		r.mu.Lock()
+		r.loadLocked()
+		r.mu.Unlock()
+	}
+}
+
+// record updates the associations with the ones currently known by the
+// sources and forgets the expired ones.
+func (r *Persisted) record(now time.Time) {
+	found := map[string]*persistedEntry{}
+	add := func(source, name, ip string) {
+		name = absDomainName([]byte(name))
+		key := strings.ToLower(name)
+		e := found[key]
+		if e == nil {
+			e = &persistedEntry{Name: name, Source: source, LastSeen: now}
+			found[key] = e
+		}
+		if ip := net.ParseIP(ip); ip != nil && e.MAC == "" {
+			mac := arp.SearchMAC(ip)
+			if mac == nil {
+				mac = ndp.SearchMAC(ip)
+			}
+			if mac != nil {
+				e.MAC = mac.String()
+			}
+		}
+		e.IPs = appendUniq(e.IPs, ip)
+	}
+	for _, s := range r.Sources() {
+		if s == Source(r) {
+			continue
+		}
+		source := s.Name()
+		s.Visit(func(name string, addrs []string) {
+			if net.ParseIP(name) != nil {
+				// Reverse lookup caches are keyed by address.
+				for _, n := range addrs {
+					add(source, n, strings.ToLower(name))
+				}
+				return
+			}
+			for _, addr := range addrs {
+				if net.ParseIP(addr) != nil {
+					add(source, name, strings.ToLower(addr))
+				}
+			}
+		})
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loadLocked()
+	for key, e := range found {
+		if old := r.entries[key]; old != nil && old.MAC == e.MAC && old.Source == e.Source && slices.Equal(old.IPs, e.IPs) {
+			// Only refresh the timestamp.
+			old.LastSeen = now
+			continue
+		}
+		r.entries[key] = e
+		r.dirty = true
+	}
+	for key, e := range r.entries {
+		if r.expired(e, now) {
+			delete(r.entries, key)
+			r.dirty = true
+		}
+	}
+}
+
+func (r *Persisted) expired(e *persistedEntry, now time.Time) bool {
+	return r.MaxAge > 0 && now.Sub(e.LastSeen) > r.MaxAge
+}
+
+// save writes the associations to the file if changed since the last save.
+// Timestamp only updates are saved at most every persistedSaveInterval.
+func (r *Persisted) save() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.loaded || (!r.dirty && time.Since(r.saved) < persistedSaveInterval) {
+		return
+	}
+	r.dirty = false
+	r.saved = time.Now()
+	entries := make([]*persistedEntry, 0, len(r.entries))
+	for _, e := range r.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		r.logErr(fmt.Errorf("persisted: %v", err))
+		return
+	}
+	tmp := filepath.Join(filepath.Dir(r.File), "."+filepath.Base(r.File)+".tmp")
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		r.logErr(fmt.Errorf("persisted: %v", err))
+		return
+	}
+	if err := os.Rename(tmp, r.File); err != nil {
+		r.logErr(fmt.Errorf("persisted: %v", err))
+	}
+}
+
+// lookup returns the names of the non-expired entries matching match.
+func (r *Persisted) lookup(match func(e *persistedEntry) bool) []string {
+	r.load()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	now := time.Now()
+	var names []string
+	for _, e := range r.entries {
+		if !r.expired(e, now) && match(e) {
+			names = appendUniq(names, e.Name)
+		}
+	}
+	return names
+}
+
+func (r *Persisted) Visit(f func(name string, addrs []string)) {
+	r.load()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	now := time.Now()
+	for _, e := range r.entries {
+		if r.expired(e, now) {
+			continue
+		}
+		addrs := e.IPs
+		if e.MAC != "" {
+			addrs = append(slices.Clone(addrs), e.MAC)
+		}
+		f(e.Name, addrs)
+	}
+}
+
+func (r *Persisted) LookupMAC(mac string) []string {
+	return r.lookup(func(e *persistedEntry) bool {
+		return e.MAC == mac
+	})
+}
+
+func (r *Persisted) LookupAddr(addr string) []string {
+	return r.lookup(func(e *persistedEntry) bool {
+		_, found := slices.BinarySearch(e.IPs, addr)
+		return found
+	})
+}
+
+func (r *Persisted) LookupHost(name string) []string {
+	name = prepareHostLookup(name)
+	r.load()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if e := r.entries[name]; e != nil && !r.expired(e, time.Now()) {
+		return slices.Clone(e.IPs)
+	}
+	return nil
+}
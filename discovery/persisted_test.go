@@ -0,0 +1,52 @@
+package discovery
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testSource map[string][]string
+
+func (s testSource) Name() string                    { return "test" }
+func (s testSource) LookupAddr(addr string) []string { return nil }
+func (s testSource) LookupHost(name string) []string { return nil }
+func (s testSource) Visit(f func(name string, addrs []string)) {
+	for name, addrs := range s {
+		f(name, addrs)
+	}
+}
+
+func TestPersisted(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "discovered.json")
+	src := testSource{
+		"laptop.":  {"10.0.0.2", "FE80::1"},
+		"10.0.0.3": {"printer."},
+	}
+	onError := func(err error) { t.Error(err) }
+	p := &Persisted{File: file, MaxAge: time.Hour, OnError: onError}
+	p.Sources = func() Resolver { return Resolver{src, p} }
+	now := time.Now()
+	p.record(now)
+	p.save()
+
+	// A new instance loads the associations from the file.
+	p = &Persisted{File: file, MaxAge: time.Hour, OnError: onError}
+	if got, want := p.LookupAddr("10.0.0.2"), []string{"laptop."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LookupAddr() = %v, want %v", got, want)
+	}
+	if got, want := p.LookupAddr("10.0.0.3"), []string{"printer."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LookupAddr() = %v, want %v", got, want)
+	}
+	if got, want := p.LookupHost("Laptop"), []string{"10.0.0.2", "fe80::1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LookupHost() = %v, want %v", got, want)
+	}
+
+	// Entries not seen again expire.
+	p.Sources = func() Resolver { return nil }
+	p.record(now.Add(2 * time.Hour))
+	if got := p.LookupAddr("10.0.0.2"); got != nil {
+		t.Errorf("LookupAddr() = %v, want nil", got)
+	}
+}
@@ -17,6 +17,10 @@ type sourceMAC interface {
 	LookupMAC(mac string) []string
 }
 
+type sourceModel interface {
+	LookupModel(addr string) string
+}
+
 func (r Resolver) Visit(f func(source, name string, addr []string)) {
 	for _, s := range r {
 		sn := s.Name()
@@ -57,3 +61,17 @@ func (r Resolver) LookupMAC(mac string) []string {
 	}
 	return nil
 }
+
+// LookupModel returns the device model of addr reported by the first source
+// knowing it.
+func (r Resolver) LookupModel(addr string) string {
+	addr = strings.ToLower(addr)
+	for _, s := range r {
+		if s, ok := s.(sourceModel); ok {
+			if model := s.LookupModel(addr); model != "" {
+				return model
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,438 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/arp"
+)
+
+const (
+	ssdpMaxEntries = 1000
+
+	// ssdpMaxDescriptionSize is the maximum size of a device description.
+	ssdpMaxDescriptionSize = 64 << 10
+
+	// ssdpFetchTimeout is the timeout of a device description fetch.
+	ssdpFetchTimeout = 5 * time.Second
+
+	// ssdpFetchInterval is the minimum interval between two fetches of the
+	// same device description.
+	ssdpFetchInterval = time.Hour
+
+	// ssdpMaxFetches is the maximum number of concurrent fetches.
+	ssdpMaxFetches = 4
+
+	// ssdpDefaultMaxAge is the announcement validity used when not
+	// advertised, the minimum recommended by UPnP.
+	ssdpDefaultMaxAge = 30 * time.Minute
+
+	// ssdpMaxAgePeriods is the number of announcement validity periods
+	// without announcement after which a device is forgotten.
+	ssdpMaxAgePeriods = 3
+)
+
+// SSDP endpoint address
+var ssdpAddr = &net.UDPAddr{
+	IP:   net.IPv4(239, 255, 255, 250),
+	Port: 1900,
+}
+
+// SSDP is a source discovering UPnP devices. It listens for SSDP
+// announcements, sends a search on start, and fetches the description of
+// each announced device to get its friendly name and model. A device not
+// announced for three times the validity of its last announcement is
+// forgotten, so a reassigned address does not report a former device.
+type SSDP struct {
+	OnError func(err error)
+
+	mu      sync.RWMutex
+	devices map[string]ssdpDevice
+	names   map[string]mdnsEntry
+	fetches map[string]time.Time
+	sem     chan struct{}
+}
+
+type ssdpDevice struct {
+	lastUpdate time.Time
+	expires    time.Time
+	location   string
+	name       string
+	model      string
+}
+
+// ssdpAnnouncement is the part of an alive notification or search response
+// used.
+type ssdpAnnouncement struct {
+	location string
+	maxAge   time.Duration
+}
+
+// ssdpDescription is the part of a UPnP device description used.
+type ssdpDescription struct {
+	Device struct {
+		FriendlyName string `xml:"friendlyName"`
+		Manufacturer string `xml:"manufacturer"`
+		ModelName    string `xml:"modelName"`
+	} `xml:"device"`
+}
+
+func (r *SSDP) Name() string {
+	return "ssdp"
+}
+
+// Start listens for SSDP announcements on the interfaces matching filter
+// ("all" or an interface name) until ctx is cancelled.
+func (r *SSDP) Start(ctx context.Context, filter string) error {
+	if filter == "disabled" {
+		return nil
+	}
+	ifs, err := multicastInterfaces()
+	if err != nil {
+		return err
+	}
+	var conns []*net.UDPConn
+	found := false
+	for _, iface := range ifs {
+		if filter != "all" && iface.Name != filter {
+			continue
+		}
+		found = true
+		if iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		var conn *net.UDPConn
+		if conn, err = net.ListenMulticastUDP("udp4", &iface, ssdpAddr); err == nil {
+			go r.read(ctx, conn)
+			conns = append(conns, conn)
+		}
+	}
+	if !found {
+		return fmt.Errorf("unknown interface: %s", filter)
+	}
+	if len(conns) == 0 {
+		return err
+	}
+	// Search responses are sent to the unicast address of the sender.
+	if conn, err := net.ListenUDP("udp4", nil); err == nil {
+		go r.read(ctx, conn)
+		conns = append(conns, conn)
+		if err := r.search(conn); err != nil && !isErrNetUnreachableOrInvalid(err) && r.OnError != nil {
+			r.OnError(fmt.Errorf("search: %v", err))
+		}
+	}
+	go func() {
+		<-ctx.Done()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+	return nil
+}
+
+func (r *SSDP) search(conn *net.UDPConn) error {
+	msg := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: upnp:rootdevice\r\n" +
+		"\r\n"
+	_, err := conn.WriteTo([]byte(msg), ssdpAddr)
+	return err
+}
+
+func (r *SSDP) read(ctx context.Context, conn *net.UDPConn) {
+	defer conn.Close()
+	buf := make([]byte, 65536)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if err, ok := err.(*net.OpError); ok {
+				if err.Timeout() || err.Temporary() {
+					if ctx.Err() == nil {
+						continue
+					}
+				}
+			}
+			return
+		}
+		a, err := parseSSDPAnnouncement(buf[:n])
+		if err != nil {
+			continue
+		}
+		if !r.refresh(from.IP.String(), a, time.Now()) {
+			r.maybeFetch(ctx, from.IP, a)
+		}
+	}
+}
+
+// parseSSDPAnnouncement returns the device description location and the
+// validity of an alive notification or a search response.
+func parseSSDPAnnouncement(msg []byte) (a ssdpAnnouncement, err error) {
+	tr := textproto.NewReader(bufio.NewReader(bytes.NewReader(msg)))
+	line, err := tr.ReadLine()
+	if err != nil {
+		return a, err
+	}
+	if !strings.HasPrefix(line, "NOTIFY ") && !strings.HasPrefix(line, "HTTP/1.1 200") {
+		return a, errors.New("not an announcement")
+	}
+	h, err := tr.ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return a, err
+	}
+	if nts := h.Get("Nts"); nts != "" && nts != "ssdp:alive" {
+		return a, errors.New("not an alive notification")
+	}
+	if a.location = h.Get("Location"); a.location == "" {
+		return a, errors.New("missing location")
+	}
+	a.maxAge = ssdpDefaultMaxAge
+	for _, directive := range strings.Split(h.Get("Cache-Control"), ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if !strings.EqualFold(strings.TrimSpace(k), "max-age") {
+			continue
+		}
+		if secs, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && secs > 0 {
+			a.maxAge = time.Duration(secs) * time.Second
+		}
+	}
+	return a, nil
+}
+
+// refresh extends the validity of the device at addr if it was described by
+// the location of a, and returns true if so.
+func (r *SSDP) refresh(addr string, a ssdpAnnouncement, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d, found := r.devices[addr]
+	if !found || d.location != a.location || !now.Before(d.expires) {
+		return false
+	}
+	d.lastUpdate = now
+	d.expires = now.Add(ssdpMaxAgePeriods * a.maxAge)
+	r.devices[addr] = d
+	return true
+}
+
+// maybeFetch fetches the description at the location of a in the background
+// unless recently fetched. The location must be an http URL on the sender
+// address.
+func (r *SSDP) maybeFetch(ctx context.Context, from net.IP, a ssdpAnnouncement) {
+	location := a.location
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "http" {
+		return
+	}
+	if ip := net.ParseIP(u.Hostname()); ip == nil || !ip.Equal(from) {
+		return
+	}
+	now := time.Now()
+	r.mu.Lock()
+	if now.Sub(r.fetches[location]) < ssdpFetchInterval {
+		r.mu.Unlock()
+		return
+	}
+	if r.fetches == nil {
+		r.fetches = map[string]time.Time{}
+		r.sem = make(chan struct{}, ssdpMaxFetches)
+	}
+	for l, t := range r.fetches {
+		if now.Sub(t) >= ssdpFetchInterval {
+			delete(r.fetches, l)
+		}
+	}
+	r.fetches[location] = now
+	sem := r.sem
+	r.mu.Unlock()
+	go func() {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		defer func() { <-sem }()
+		if err := r.fetch(ctx, from.String(), a); err != nil && r.OnError != nil {
+			r.OnError(fmt.Errorf("fetch %s: %v", location, err))
+		}
+	}()
+}
+
+// fetch fetches the device description at the location of a and records it
+// for addr.
+func (r *SSDP) fetch(ctx context.Context, addr string, a ssdpAnnouncement) error {
+	ctx, cancel := context.WithTimeout(ctx, ssdpFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.location, nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", res.StatusCode)
+	}
+	b, err := io.ReadAll(io.LimitReader(res.Body, ssdpMaxDescriptionSize+1))
+	if err != nil {
+		return err
+	}
+	if len(b) > ssdpMaxDescriptionSize {
+		return errors.New("description too large")
+	}
+	var desc ssdpDescription
+	if err := xml.Unmarshal(b, &desc); err != nil {
+		return err
+	}
+	r.add(addr, a, desc, time.Now())
+	return nil
+}
+
+func (r *SSDP) add(addr string, a ssdpAnnouncement, desc ssdpDescription, now time.Time) {
+	name := strings.TrimSpace(desc.Device.FriendlyName)
+	model := strings.TrimSpace(desc.Device.ModelName)
+	if manufacturer := strings.TrimSpace(desc.Device.Manufacturer); manufacturer != "" &&
+		!strings.Contains(strings.ToLower(model), strings.ToLower(manufacturer)) {
+		model = strings.TrimSpace(manufacturer + " " + model)
+	}
+	if !isValidName(name) {
+		name = ""
+	}
+	if name == "" && model == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.devices == nil {
+		r.devices = map[string]ssdpDevice{}
+		r.names = map[string]mdnsEntry{}
+	}
+	if old, found := r.devices[addr]; found {
+		r.removeLocked(addr, old)
+	}
+	r.devices[addr] = ssdpDevice{
+		lastUpdate: now,
+		expires:    now.Add(ssdpMaxAgePeriods * a.maxAge),
+		location:   a.location,
+		name:       name,
+		model:      model,
+	}
+	if name != "" {
+		addEntry(r.names, prepareHostLookup(name), addr)
+	}
+	for k, d := range r.devices {
+		if !now.Before(d.expires) {
+			r.removeLocked(k, d)
+		}
+	}
+	for len(r.devices) > ssdpMaxEntries {
+		r.removeOldestEntry()
+	}
+}
+
+func (r *SSDP) removeLocked(addr string, d ssdpDevice) {
+	if d.name != "" {
+		removeEntry(r.names, prepareHostLookup(d.name), addr)
+	}
+	delete(r.devices, addr)
+}
+
+func (r *SSDP) removeOldestEntry() {
+	var oldestAddr string
+	oldestTime := time.Now()
+	for k, v := range r.devices {
+		if v.lastUpdate.Before(oldestTime) {
+			oldestTime = v.lastUpdate
+			oldestAddr = k
+		}
+	}
+	if oldestAddr != "" {
+		r.removeLocked(oldestAddr, r.devices[oldestAddr])
+	}
+}
+
+// deviceLocked returns the device at addr if not expired.
+func (r *SSDP) deviceLocked(addr string, now time.Time) (ssdpDevice, bool) {
+	d, found := r.devices[addr]
+	if !found || !now.Before(d.expires) {
+		return ssdpDevice{}, false
+	}
+	return d, true
+}
+
+// liveAddrsLocked returns the addresses of addrs with a device not expired.
+func (r *SSDP) liveAddrsLocked(addrs []string, now time.Time) []string {
+	var live []string
+	for _, addr := range addrs {
+		if _, found := r.deviceLocked(addr, now); found {
+			live = append(live, addr)
+		}
+	}
+	return live
+}
+
+func (r *SSDP) Visit(f func(name string, addrs []string)) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	now := time.Now()
+	for name, addrs := range r.names {
+		if live := r.liveAddrsLocked(addrs.values, now); len(live) > 0 {
+			f(name, live)
+		}
+	}
+}
+
+func (r *SSDP) LookupAddr(addr string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if d, _ := r.deviceLocked(addr, time.Now()); d.name != "" {
+		return []string{absDomainName([]byte(d.name))}
+	}
+	return nil
+}
+
+func (r *SSDP) LookupHost(name string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.liveAddrsLocked(r.names[prepareHostLookup(name)].values, time.Now())
+}
+
+func (r *SSDP) LookupMAC(mac string) []string {
+	m, err := net.ParseMAC(mac)
+	if err != nil {
+		return nil
+	}
+	if ip := arp.SearchIP(m); ip != nil {
+		return r.LookupAddr(ip.String())
+	}
+	return nil
+}
+
+// LookupModel returns the model of the device at addr.
+func (r *SSDP) LookupModel(addr string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	d, _ := r.deviceLocked(addr, time.Now())
+	return d.model
+}
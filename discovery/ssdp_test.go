@@ -0,0 +1,137 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseSSDPAnnouncement(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     string
+		want    ssdpAnnouncement
+		wantErr bool
+	}{
+		{"Notify",
+			"NOTIFY * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nNT: upnp:rootdevice\r\nNTS: ssdp:alive\r\nLOCATION: http://192.168.1.20:8008/ssdp/device-desc.xml\r\n\r\n",
+			ssdpAnnouncement{"http://192.168.1.20:8008/ssdp/device-desc.xml", ssdpDefaultMaxAge}, false},
+		{"SearchResponse",
+			"HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=1800\r\nLocation: http://192.168.1.21:9197/dmr\r\nST: upnp:rootdevice\r\n\r\n",
+			ssdpAnnouncement{"http://192.168.1.21:9197/dmr", 1800 * time.Second}, false},
+		{"MaxAge",
+			"NOTIFY * HTTP/1.1\r\nCache-Control: no-cache=\"Ext\", max-age = 120\r\nNTS: ssdp:alive\r\nLOCATION: http://192.168.1.20/\r\n\r\n",
+			ssdpAnnouncement{"http://192.168.1.20/", 120 * time.Second}, false},
+		{"ByeBye",
+			"NOTIFY * HTTP/1.1\r\nNTS: ssdp:byebye\r\nLOCATION: http://192.168.1.20/\r\n\r\n",
+			ssdpAnnouncement{}, true},
+		{"Search",
+			"M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\n\r\n",
+			ssdpAnnouncement{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSSDPAnnouncement([]byte(tt.msg))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSSDPAnnouncement() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("parseSSDPAnnouncement() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSDP_fetch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/desc.xml":
+			_, _ = w.Write([]byte(`<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:MediaRenderer:1</deviceType>
+    <friendlyName>Living Room TV</friendlyName>
+    <manufacturer>Samsung Electronics</manufacturer>
+    <modelName>QN55Q70</modelName>
+  </device>
+</root>`))
+		case "/large.xml":
+			_, _ = w.Write([]byte(strings.Repeat(" ", ssdpMaxDescriptionSize+1)))
+		}
+	}))
+	defer ts.Close()
+
+	r := &SSDP{}
+	if err := r.fetch(context.Background(), "127.0.0.1", ssdpAnnouncement{ts.URL + "/desc.xml", ssdpDefaultMaxAge}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.LookupAddr("127.0.0.1"), []string{"Living Room TV."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LookupAddr() = %v, want %v", got, want)
+	}
+	if got, want := r.LookupHost("living room tv"), []string{"127.0.0.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LookupHost() = %v, want %v", got, want)
+	}
+	if got, want := (Resolver{Dummy{}, r}).LookupModel("127.0.0.1"), "Samsung Electronics QN55Q70"; got != want {
+		t.Errorf("LookupModel() = %v, want %v", got, want)
+	}
+	if err := r.fetch(context.Background(), "127.0.0.2", ssdpAnnouncement{ts.URL + "/large.xml", ssdpDefaultMaxAge}); err == nil {
+		t.Error("fetch(large) = nil error")
+	}
+}
+
+func TestSSDP_maybeFetch(t *testing.T) {
+	r := &SSDP{}
+	// Descriptions hosted on another address than the sender are ignored.
+	r.maybeFetch(context.Background(), net.IPv4(192, 168, 1, 2), ssdpAnnouncement{"http://192.168.1.3/desc.xml", ssdpDefaultMaxAge})
+	r.maybeFetch(context.Background(), net.IPv4(192, 168, 1, 2), ssdpAnnouncement{"https://192.168.1.2/desc.xml", ssdpDefaultMaxAge})
+	if len(r.fetches) != 0 {
+		t.Errorf("fetches = %v, want none", r.fetches)
+	}
+}
+
+func TestSSDP_expire(t *testing.T) {
+	r := &SSDP{}
+	a := ssdpAnnouncement{"http://192.168.1.20/desc.xml", time.Minute}
+	desc := ssdpDescription{}
+	desc.Device.FriendlyName = "TV"
+	desc.Device.ModelName = "Model"
+	now := time.Now()
+	r.add("192.168.1.20", a, desc, now.Add(-2*time.Minute))
+	if got := r.LookupModel("192.168.1.20"); got != "Model" {
+		t.Errorf("LookupModel() = %q, want Model", got)
+	}
+
+	// Announcements from another location do not refresh the device.
+	if r.refresh("192.168.1.20", ssdpAnnouncement{"http://192.168.1.20/other.xml", time.Minute}, now) {
+		t.Error("refresh(other location) = true")
+	}
+	if !r.refresh("192.168.1.20", a, now) {
+		t.Error("refresh() = false")
+	}
+	r.mu.RLock()
+	expires := r.devices["192.168.1.20"].expires
+	r.mu.RUnlock()
+	if want := now.Add(3 * time.Minute); !expires.Equal(want) {
+		t.Errorf("expires = %v, want %v", expires, want)
+	}
+
+	// A device not announced for three periods is forgotten.
+	r.add("192.168.1.21", a, desc, now.Add(-4*time.Minute))
+	if got := r.LookupAddr("192.168.1.21"); got != nil {
+		t.Errorf("LookupAddr(expired) = %v", got)
+	}
+	if got := r.LookupModel("192.168.1.21"); got != "" {
+		t.Errorf("LookupModel(expired) = %q", got)
+	}
+	if got, want := r.LookupHost("tv"), []string{"192.168.1.20"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LookupHost() = %v, want %v", got, want)
+	}
+	if r.refresh("192.168.1.21", a, now) {
+		t.Error("refresh(expired) = true")
+	}
+}
@@ -0,0 +1,253 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/fileinfo"
+)
+
+// StaticDevice is a device defined in a static inventory file.
+type StaticDevice struct {
+	// MAC or Prefix identify the device. A single IP is stored as a /32 or
+	// /128 prefix.
+	MAC    net.HardwareAddr
+	Prefix netip.Prefix
+
+	Name    string
+	Model   string
+	Profile string
+}
+
+// Static is a source reading device names from a user defined file. Each line
+// maps a MAC address, an IP or a CIDR to a name, followed by optional model
+// and profile:
+//
+//	# MAC, IP or CIDR   name              options
+//	00:1c:42:2e:60:4a   kids-ipad         model=iPad profile=abcdef
+//	192.168.1.10        nas               model="DS920+"
+//	10.0.3.0/24         "Guest device"    profile=123456
+//
+// MAC entries take precedence over IP entries, and the first matching IP
+// entry wins. The file is reloaded when it changes.
+type Static struct {
+	File    string
+	OnError func(err error)
+
+	mu       sync.RWMutex
+	devices  []StaticDevice
+	macs     map[string]*StaticDevice
+	fileInfo fileinfo.Info
+	expires  time.Time
+}
+
+func (r *Static) Name() string {
+	return "static"
+}
+
+func (r *Static) refresh() {
+	r.mu.RLock()
+	expired := !time.Now().Before(r.expires)
+	r.mu.RUnlock()
+	if !expired {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if now.Before(r.expires) {
+		return
+	}
+	r.expires = now.Add(5 * time.Second)
+	if r.fileInfo.Equal(r.File) {
+		return
+	}
+	if err := r.readLocked(); err != nil && r.OnError != nil {
+		r.OnError(fmt.Errorf("readStatic(%s): %v", r.File, err))
+	}
+}
+
+func (r *Static) readLocked() error {
+	b, err := os.ReadFile(r.File)
+	if err != nil {
+		return err
+	}
+	devices, err := readStaticDevices(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	r.devices = devices
+	r.macs = map[string]*StaticDevice{}
+	for i := range r.devices {
+		if d := &r.devices[i]; d.MAC != nil {
+			r.macs[d.MAC.String()] = d
+		}
+	}
+	r.fileInfo, err = fileinfo.Get(r.File)
+	return err
+}
+
+// Lookup returns the device matching mac or ip. Any of ip and mac can be nil.
+func (r *Static) Lookup(ip net.IP, mac net.HardwareAddr) (StaticDevice, bool) {
+	r.refresh()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(mac) > 0 {
+		if d := r.macs[mac.String()]; d != nil {
+			return *d, true
+		}
+	}
+	if addr, ok := netip.AddrFromSlice(ip); ok {
+		addr = addr.Unmap()
+		for _, d := range r.devices {
+			if d.Prefix.IsValid() && d.Prefix.Contains(addr) {
+				return d, true
+			}
+		}
+	}
+	return StaticDevice{}, false
+}
+
+func (r *Static) Visit(f func(name string, addrs []string)) {
+	r.refresh()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, d := range r.devices {
+		var addrs []string
+		if d.Prefix.IsValid() && d.Prefix.IsSingleIP() {
+			addrs = []string{d.Prefix.Addr().String()}
+		} else if d.MAC != nil {
+			addrs = []string{d.MAC.String()}
+		} else {
+			addrs = []string{d.Prefix.String()}
+		}
+		f(absDomainName([]byte(d.Name)), addrs)
+	}
+}
+
+func (r *Static) LookupMAC(mac string) []string {
+	m, err := net.ParseMAC(mac)
+	if err != nil {
+		return nil
+	}
+	if d, found := r.Lookup(nil, m); found {
+		return []string{absDomainName([]byte(d.Name))}
+	}
+	return nil
+}
+
+func (r *Static) LookupAddr(addr string) []string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil
+	}
+	if d, found := r.Lookup(ip, nil); found {
+		return []string{absDomainName([]byte(d.Name))}
+	}
+	return nil
+}
+
+// LookupHost returns the IPs of the single IP entries named name.
+func (r *Static) LookupHost(name string) []string {
+	r.refresh()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	name = prepareHostLookup(name)
+	var addrs []string
+	for _, d := range r.devices {
+		if !d.Prefix.IsValid() || !d.Prefix.IsSingleIP() {
+			continue
+		}
+		if prepareHostLookup(d.Name) == name || prepareHostLookup(d.Name)+"local." == name {
+			addrs = appendUniq(addrs, d.Prefix.Addr().String())
+		}
+	}
+	return addrs
+}
+
+func readStaticDevices(r io.Reader) ([]StaticDevice, error) {
+	var devices []StaticDevice
+	s := bufio.NewScanner(r)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		fields, err := splitQuoted(s.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: missing name", lineNum)
+		}
+		var d StaticDevice
+		match := fields[0]
+		if mac, err := net.ParseMAC(match); err == nil {
+			d.MAC = mac
+		} else if p, err := netip.ParsePrefix(match); err == nil {
+			d.Prefix = p.Masked()
+		} else if ip, err := netip.ParseAddr(match); err == nil {
+			ip = ip.Unmap()
+			d.Prefix = netip.PrefixFrom(ip, ip.BitLen())
+		} else {
+			return nil, fmt.Errorf("line %d: %s: not a MAC, IP or CIDR", lineNum, match)
+		}
+		d.Name = fields[1]
+		for _, opt := range fields[2:] {
+			key, value, _ := strings.Cut(opt, "=")
+			switch key {
+			case "model":
+				d.Model = value
+			case "profile":
+				d.Profile = value
+			default:
+				return nil, fmt.Errorf("line %d: %s: unknown option", lineNum, key)
+			}
+		}
+		devices = append(devices, d)
+	}
+	return devices, s.Err()
+}
+
+// splitQuoted splits line in space separated fields. Double quotes can be used
+// to include spaces in a field and a # outside of quotes starts a comment.
+func splitQuoted(line string) ([]string, error) {
+	var fields []string
+	var sb strings.Builder
+	inField, quoted := false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+			inField = true
+		case quoted:
+			sb.WriteByte(c)
+		case c == '#':
+			i = len(line)
+		case c == ' ' || c == '\t':
+			if inField {
+				fields = append(fields, sb.String())
+				sb.Reset()
+				inField = false
+			}
+		default:
+			sb.WriteByte(c)
+			inField = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inField {
+		fields = append(fields, sb.String())
+	}
+	return fields, nil
+}
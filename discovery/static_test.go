@@ -0,0 +1,89 @@
+package discovery
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_readStaticDevices(t *testing.T) {
+	data := `# MAC, IP or CIDR   name              options
+00:1c:42:2e:60:4a   kids-ipad         model=iPad profile=abcdef
+192.168.1.10        nas               model="DS920+" # comment
+
+10.0.3.0/24         "Guest device"    profile=123456
+`
+	devices, err := readStaticDevices(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range devices {
+		match := d.Prefix.String()
+		if d.MAC != nil {
+			match = d.MAC.String()
+		}
+		got = append(got, strings.Join([]string{match, d.Name, d.Model, d.Profile}, "|"))
+	}
+	want := []string{
+		"00:1c:42:2e:60:4a|kids-ipad|iPad|abcdef",
+		"192.168.1.10/32|nas|DS920+|",
+		"10.0.3.0/24|Guest device||123456",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readStaticDevices() = %v, want %v", got, want)
+	}
+
+	for _, bad := range []string{
+		"10.0.0.1",
+		"foo bar",
+		"10.0.0.1 nas color=red",
+		`10.0.0.1 "nas`,
+	} {
+		if _, err := readStaticDevices(strings.NewReader(bad)); err == nil {
+			t.Errorf("readStaticDevices(%q) = nil error", bad)
+		}
+	}
+}
+
+func TestStatic(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "devices")
+	data := "00:1c:42:2e:60:4a kids-ipad model=iPad\n" +
+		"192.168.1.10 nas\n" +
+		"192.168.1.0/24 lan\n"
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := &Static{File: file, OnError: func(err error) { t.Error(err) }}
+
+	mac, _ := net.ParseMAC("00:1c:42:2e:60:4a")
+	if d, found := r.Lookup(net.ParseIP("192.168.1.10"), mac); !found || d.Name != "kids-ipad" || d.Model != "iPad" {
+		t.Errorf("Lookup() = %v, %v, want kids-ipad", d, found)
+	}
+	tests := []struct {
+		addr string
+		want []string
+	}{
+		{"192.168.1.10", []string{"nas."}},
+		{"192.168.1.11", []string{"lan."}},
+		{"::ffff:192.168.1.12", []string{"lan."}},
+		{"10.0.0.1", nil},
+	}
+	for _, tt := range tests {
+		if got := r.LookupAddr(tt.addr); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("LookupAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+	if got, want := r.LookupMAC("00:1c:42:2e:60:4a"), []string{"kids-ipad."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LookupMAC() = %v, want %v", got, want)
+	}
+	if got, want := r.LookupHost("NAS.local."), []string{"192.168.1.10"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LookupHost() = %v, want %v", got, want)
+	}
+	if got := r.LookupHost("lan"); got != nil {
+		t.Errorf("LookupHost(lan) = %v, want nil", got)
+	}
+}
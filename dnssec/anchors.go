@@ -0,0 +1,253 @@
+package dnssec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+// holdDown is the add hold-down time of RFC 5011 section 2.4.1.
+const holdDown = 30 * 24 * time.Hour
+
+// Trust anchor states as defined in RFC 5011 section 4.
+const (
+	stateAddPend = "addpend"
+	stateValid   = "valid"
+	stateMissing = "missing"
+	stateRevoked = "revoked"
+)
+
+// anchor is a root zone trust anchor. Built-in anchors are defined by their DS
+// digest until the key is first seen.
+type anchor struct {
+	KeyTag     uint16    `json:"key_tag"`
+	Algorithm  uint8     `json:"algorithm"`
+	DigestType uint8     `json:"digest_type,omitempty"`
+	Digest     string    `json:"digest,omitempty"`
+	PublicKey  []byte    `json:"public_key,omitempty"`
+	State      string    `json:"state"`
+	FirstSeen  time.Time `json:"first_seen,omitzero"`
+}
+
+// rootAnchors are the root zone KSKs published by IANA (KSK-2017 and
+// KSK-2024).
+var rootAnchors = []anchor{
+	{
+		KeyTag:     20326,
+		Algorithm:  algRSASHA256,
+		DigestType: digestSHA256,
+		Digest:     "E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+		State:      stateValid,
+	},
+	{
+		KeyTag:     38696,
+		Algorithm:  algRSASHA256,
+		DigestType: digestSHA256,
+		Digest:     "683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+		State:      stateValid,
+	},
+}
+
+// matches returns true if key is the key of the anchor, ignoring the revoke
+// flag.
+func (a *anchor) matches(key *dnsmessage.DNSKEYResource) bool {
+	if a.Algorithm != key.Algorithm {
+		return false
+	}
+	if a.PublicKey != nil {
+		return bytes.Equal(a.PublicKey, key.PublicKey)
+	}
+	digest, err := hex.DecodeString(a.Digest)
+	if err != nil {
+		return false
+	}
+	k := *key
+	k.Flags &^= dnsmessage.DNSKEYFlagRevoke
+	ds := &dnsmessage.DSResource{
+		KeyTag:     a.KeyTag,
+		Algorithm:  a.Algorithm,
+		DigestType: a.DigestType,
+		Digest:     digest,
+	}
+	return dsMatches(ds, ".", &k)
+}
+
+func (a *anchor) trusted() bool {
+	return a.State == stateValid || a.State == stateMissing
+}
+
+// Anchors holds the root zone trust anchors and tracks key rollovers as
+// defined in RFC 5011. New keys are trusted once they have been seen in a
+// validated root DNSKEY set for the hold-down period, and keys revoked by
+// their owner are no longer trusted.
+//
+// The zero value starts from the built-in root anchors and keeps the state in
+// memory.
+type Anchors struct {
+	// File is the path of the file where the trust anchor state is persisted.
+	// If empty, the state is kept in memory only.
+	File string
+
+	// OnError is called when the state cannot be loaded or saved.
+	OnError func(err error)
+
+	mu      sync.Mutex
+	loaded  bool
+	anchors []anchor
+}
+
+func (a *Anchors) logErr(err error) {
+	if a.OnError != nil {
+		a.OnError(err)
+	}
+}
+
+// loadLocked loads the state file on first use.
+func (a *Anchors) loadLocked() {
+	if a.loaded {
+		return
+	}
+	a.loaded = true
+	if a.anchors == nil {
+		a.anchors = slices.Clone(rootAnchors)
+	}
+	if a.File == "" {
+		return
+	}
+	b, err := os.ReadFile(a.File)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			a.logErr(fmt.Errorf("trust anchors: %v", err))
+		}
+		return
+	}
+	var anchors []anchor
+	if err := json.Unmarshal(b, &anchors); err != nil {
+		a.logErr(fmt.Errorf("trust anchors: %s: %v", a.File, err))
+		return
+	}
+	a.anchors = anchors
+}
+
+func (a *Anchors) saveLocked() {
+	if a.File == "" {
+		return
+	}
+	b, err := json.MarshalIndent(a.anchors, "", "  ")
+	if err != nil {
+		a.logErr(fmt.Errorf("trust anchors: %v", err))
+		return
+	}
+	tmp := filepath.Join(filepath.Dir(a.File), "."+filepath.Base(a.File)+".tmp")
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		a.logErr(fmt.Errorf("trust anchors: %v", err))
+		return
+	}
+	if err := os.Rename(tmp, a.File); err != nil {
+		a.logErr(fmt.Errorf("trust anchors: %v", err))
+	}
+}
+
+// trusted returns the keys of the root DNSKEY set matching a trusted anchor.
+func (a *Anchors) trusted(keys []*dnsmessage.DNSKEYResource) []*dnsmessage.DNSKEYResource {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.loadLocked()
+	var trusted []*dnsmessage.DNSKEYResource
+	for _, key := range keys {
+		if key.Flags&dnsmessage.DNSKEYFlagRevoke != 0 {
+			continue
+		}
+		for i := range a.anchors {
+			if a.anchors[i].trusted() && a.anchors[i].matches(key) {
+				trusted = append(trusted, key)
+				break
+			}
+		}
+	}
+	return trusted
+}
+
+// update applies the RFC 5011 state transitions for a validated root DNSKEY
+// set. selfSigned returns true if a key signed the set.
+func (a *Anchors) update(keys []*dnsmessage.DNSKEYResource, selfSigned func(*dnsmessage.DNSKEYResource) bool, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.loadLocked()
+	changed := false
+	seen := make([]bool, len(a.anchors))
+	for _, key := range keys {
+		if key.Flags&dnsmessage.DNSKEYFlagSEP == 0 || key.Flags&dnsmessage.DNSKEYFlagZone == 0 {
+			continue
+		}
+		idx := slices.IndexFunc(a.anchors, func(an anchor) bool { return an.matches(key) })
+		if key.Flags&dnsmessage.DNSKEYFlagRevoke != 0 {
+			if idx >= 0 && a.anchors[idx].State != stateRevoked && selfSigned(key) {
+				a.anchors[idx].State = stateRevoked
+				changed = true
+			}
+			if idx >= 0 {
+				seen[idx] = true
+			}
+			continue
+		}
+		if idx < 0 {
+			a.anchors = append(a.anchors, anchor{
+				KeyTag:    key.KeyTag(),
+				Algorithm: key.Algorithm,
+				PublicKey: slices.Clone(key.PublicKey),
+				State:     stateAddPend,
+				FirstSeen: now,
+			})
+			seen = append(seen, true)
+			changed = true
+			continue
+		}
+		seen[idx] = true
+		an := &a.anchors[idx]
+		if an.PublicKey == nil {
+			an.PublicKey = slices.Clone(key.PublicKey)
+			an.DigestType, an.Digest = 0, ""
+			changed = true
+		}
+		switch an.State {
+		case stateAddPend:
+			if now.Sub(an.FirstSeen) >= holdDown {
+				an.State = stateValid
+				changed = true
+			}
+		case stateMissing:
+			an.State = stateValid
+			changed = true
+		}
+	}
+	anchors := a.anchors[:0]
+	for i, an := range a.anchors {
+		if !seen[i] {
+			switch an.State {
+			case stateValid:
+				an.State = stateMissing
+				changed = true
+			case stateAddPend:
+				// Pending keys removed before the end of the hold-down period
+				// are forgotten.
+				changed = true
+				continue
+			}
+		}
+		anchors = append(anchors, an)
+	}
+	a.anchors = anchors
+	if changed {
+		a.saveLocked()
+	}
+}
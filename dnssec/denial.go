@@ -0,0 +1,241 @@
+package dnssec
+
+import (
+	"crypto/sha1"
+	"encoding/base32"
+	"strings"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+// nsec3MaxIterations is the number of NSEC3 iterations above which answers are
+// treated as insecure as recommended by RFC 9276.
+const nsec3MaxIterations = 150
+
+var base32Hex = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+type nsecRecord struct {
+	owner string
+	next  string
+	*dnsmessage.NSECResource
+}
+
+// covers returns true if name sorts strictly between the owner and the next
+// name of the record.
+func (r nsecRecord) covers(name string) bool {
+	if compareNames(r.owner, r.next) < 0 {
+		return compareNames(r.owner, name) < 0 && compareNames(name, r.next) < 0
+	}
+	// Last NSEC of the zone.
+	return compareNames(r.owner, name) < 0 || compareNames(name, r.next) < 0
+}
+
+type nsec3Record struct {
+	hash string // owner hash, base32hex encoded
+	next string // next hashed owner, base32hex encoded
+	zone string
+	*dnsmessage.NSEC3Resource
+}
+
+// hashName returns the base32hex encoded NSEC3 hash of name using the
+// parameters of the record.
+func (r nsec3Record) hashName(name string) string {
+	wire, err := canonicalOwner(name)
+	if err != nil {
+		return ""
+	}
+	h := sha1.Sum(append(wire, r.Salt...))
+	for range r.Iterations {
+		h = sha1.Sum(append(h[:], r.Salt...))
+	}
+	return base32Hex.EncodeToString(h[:])
+}
+
+func (r nsec3Record) matches(name string) bool {
+	return isSubdomain(name, r.zone) && r.hashName(name) == r.hash
+}
+
+func (r nsec3Record) covers(name string) bool {
+	if !isSubdomain(name, r.zone) {
+		return false
+	}
+	h := r.hashName(name)
+	if r.hash < r.next {
+		return r.hash < h && h < r.next
+	}
+	return r.hash < h || h < r.next
+}
+
+// denial holds the NSEC and NSEC3 records of a validated authority section.
+type denial struct {
+	nsec  []nsecRecord
+	nsec3 []nsec3Record
+}
+
+func newDenial(sets []*rrset) denial {
+	var d denial
+	for _, s := range sets {
+		for _, rr := range s.rrs {
+			switch b := rr.Body.(type) {
+			case *dnsmessage.NSECResource:
+				d.nsec = append(d.nsec, nsecRecord{
+					owner:        s.name,
+					next:         canonicalName(b.NextDomain.String()),
+					NSECResource: b,
+				})
+			case *dnsmessage.NSEC3Resource:
+				hash, zone, _ := strings.Cut(s.name, ".")
+				if zone == "" {
+					zone = "."
+				}
+				d.nsec3 = append(d.nsec3, nsec3Record{
+					hash:          strings.ToUpper(hash),
+					next:          base32Hex.EncodeToString(b.NextHashed),
+					zone:          zone,
+					NSEC3Resource: b,
+				})
+			}
+		}
+	}
+	return d
+}
+
+// types returns the types listed by the NSEC or NSEC3 record matching name.
+func (d denial) types(name string) ([]dnsmessage.Type, bool) {
+	for _, r := range d.nsec {
+		if r.owner == name {
+			return r.Types, true
+		}
+	}
+	for _, r := range d.nsec3 {
+		if r.matches(name) {
+			return r.Types, true
+		}
+	}
+	return nil, false
+}
+
+// prove checks that the records prove that name does not exist (nxdomain) or
+// has no record of type typ. The result is insecure if the proof relies on an
+// NSEC3 opt-out span or on unsupported NSEC3 parameters.
+func (d denial) prove(name string, typ dnsmessage.Type, nxdomain bool) status {
+	if len(d.nsec) > 0 {
+		if d.proveNSEC(name, typ, nxdomain) {
+			return secure
+		}
+		return bogus
+	}
+	if len(d.nsec3) > 0 {
+		return d.proveNSEC3(name, typ, nxdomain)
+	}
+	return bogus
+}
+
+func (d denial) proveNSEC(name string, typ dnsmessage.Type, nxdomain bool) bool {
+	if !nxdomain {
+		for _, r := range d.nsec {
+			if r.owner == name {
+				return !r.HasType(typ) && !r.HasType(dnsmessage.TypeCNAME)
+			}
+		}
+	}
+	// The name does not exist, either for NXDOMAIN or for a NODATA answer
+	// synthesized from a wildcard: a record must cover the name and the
+	// wildcard of the closest encloser must be covered (NXDOMAIN) or match
+	// without the type (NODATA).
+	for _, r := range d.nsec {
+		if !r.covers(name) {
+			continue
+		}
+		ce := commonSuffix(name, r.owner)
+		if c := commonSuffix(name, r.next); len(c) > len(ce) {
+			ce = c
+		}
+		wildcard := "*." + strings.TrimPrefix(ce, ".")
+		for _, w := range d.nsec {
+			if nxdomain && w.covers(wildcard) {
+				return true
+			}
+			if !nxdomain && w.owner == wildcard {
+				return !w.HasType(typ) && !w.HasType(dnsmessage.TypeCNAME)
+			}
+		}
+	}
+	return false
+}
+
+func (d denial) proveNSEC3(name string, typ dnsmessage.Type, nxdomain bool) status {
+	for _, r := range d.nsec3 {
+		if r.HashAlgorithm != 1 || r.Iterations > nsec3MaxIterations {
+			return insecure
+		}
+	}
+	if !nxdomain {
+		for _, r := range d.nsec3 {
+			if r.matches(name) {
+				if r.HasType(typ) || r.HasType(dnsmessage.TypeCNAME) {
+					return bogus
+				}
+				return secure
+			}
+		}
+	}
+	ce, nextCloser := d.closestEncloser(name)
+	if ce == "" {
+		return bogus
+	}
+	cover := d.covering(nextCloser)
+	if cover == nil {
+		return bogus
+	}
+	if !nxdomain && typ == dnsmessage.TypeDS && cover.Flags&dnsmessage.NSEC3FlagOptOut != 0 {
+		// Insecure delegation in an opt-out span.
+		return insecure
+	}
+	wildcard := "*." + strings.TrimPrefix(ce, ".")
+	if nxdomain {
+		if d.covering(wildcard) == nil {
+			return bogus
+		}
+		if cover.Flags&dnsmessage.NSEC3FlagOptOut != 0 {
+			return insecure
+		}
+		return secure
+	}
+	for _, r := range d.nsec3 {
+		if r.matches(wildcard) && !r.HasType(typ) && !r.HasType(dnsmessage.TypeCNAME) {
+			return secure
+		}
+	}
+	return bogus
+}
+
+// closestEncloser returns the closest provable encloser of name and the next
+// closer name as defined in RFC 5155 section 7.2.1.
+func (d denial) closestEncloser(name string) (ce, nextCloser string) {
+	candidate := name
+	for {
+		for _, r := range d.nsec3 {
+			if r.matches(candidate) {
+				if candidate == name {
+					return "", ""
+				}
+				return candidate, nextCloser
+			}
+		}
+		if candidate == "." {
+			return "", ""
+		}
+		nextCloser = candidate
+		candidate = parent(candidate)
+	}
+}
+
+func (d denial) covering(name string) *nsec3Record {
+	for i, r := range d.nsec3 {
+		if r.covers(name) {
+			return &d.nsec3[i]
+		}
+	}
+	return nil
+}
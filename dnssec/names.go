@@ -0,0 +1,102 @@
+package dnssec
+
+import (
+	"strings"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+// canonicalName returns the lowercase fully qualified form of name.
+func canonicalName(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// labels returns the labels of name from the leftmost one, excluding the root.
+func labels(name string) []string {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, ".")
+}
+
+// labelCount returns the number of labels of name as defined for the RRSIG
+// labels field: the root and a leading wildcard label are not counted.
+func labelCount(name string) int {
+	l := labels(name)
+	if len(l) > 0 && l[0] == "*" {
+		return len(l) - 1
+	}
+	return len(l)
+}
+
+// parent returns the parent of name, or the root for the root itself.
+func parent(name string) string {
+	if _, p, ok := strings.Cut(name, "."); ok && p != "" {
+		return p
+	}
+	return "."
+}
+
+// isSubdomain returns true if name is equal to or below zone.
+func isSubdomain(name, zone string) bool {
+	return zone == "." || name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// ancestors returns the names between the TLD of name and name itself,
+// starting with the TLD.
+func ancestors(name string) []string {
+	l := labels(name)
+	names := make([]string, len(l))
+	for i := range l {
+		names[len(l)-1-i] = strings.Join(l[i:], ".") + "."
+	}
+	return names
+}
+
+// suffix returns the ancestor of name made of its last n labels.
+func suffix(name string, n int) string {
+	l := labels(name)
+	if n <= 0 {
+		return "."
+	}
+	if n >= len(l) {
+		return name
+	}
+	return strings.Join(l[len(l)-n:], ".") + "."
+}
+
+// commonSuffix returns the longest common ancestor of a and b.
+func commonSuffix(a, b string) string {
+	la, lb := labels(a), labels(b)
+	n := 0
+	for n < len(la) && n < len(lb) && la[len(la)-1-n] == lb[len(lb)-1-n] {
+		n++
+	}
+	return suffix(a, n)
+}
+
+// compareNames compares two lowercase names using the canonical DNS name
+// order defined in RFC 4034 section 6.1.
+func compareNames(a, b string) int {
+	la, lb := labels(a), labels(b)
+	for i := 0; i < len(la) && i < len(lb); i++ {
+		if c := strings.Compare(la[len(la)-1-i], lb[len(lb)-1-i]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+// canonicalOwner returns the canonical wire format of name.
+func canonicalOwner(name string) ([]byte, error) {
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	return dnsmessage.AppendCanonicalName(nil, n)
+}
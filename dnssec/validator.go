@@ -0,0 +1,606 @@
+// Package dnssec implements a DNSSEC validating resolver on top of a non
+// trusted upstream resolver.
+package dnssec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+type status int
+
+const (
+	bogus status = iota
+	insecure
+	secure
+)
+
+func (s status) String() string {
+	switch s {
+	case secure:
+		return "secure"
+	case insecure:
+		return "insecure"
+	}
+	return "bogus"
+}
+
+const (
+	minCacheTTL   = 60 * time.Second
+	maxCacheTTL   = time.Hour
+	bogusCacheTTL = 10 * time.Second
+	maxCacheSize  = 10000
+	maxMsgSize    = 65535
+)
+
+var errTooLarge = errors.New("response too large")
+
+// Validator is a resolver.Resolver validating the DNSSEC signatures of the
+// answers returned by Upstream.
+//
+// Queries are sent upstream with the DO and CD bits set and the chain of trust
+// is fetched through Upstream from the root trust anchors down to the signer
+// of the answer. Bogus answers are replaced by SERVFAIL, secure answers have
+// the AD bit set. DNSSEC records are removed from answers if the client did
+// not set the DO bit. Queries with the CD bit set are not validated.
+type Validator struct {
+	Upstream resolver.Resolver
+
+	// Anchors holds the root trust anchors. If nil, the built-in root anchors
+	// are used and their state is kept in memory.
+	Anchors *Anchors
+
+	// OnBogus is called with the reason why an answer has been found bogus.
+	OnBogus func(qname string, qtype query.Type, err error)
+
+	mu      sync.Mutex
+	anchors *Anchors
+	keys    map[string]keysEntry
+	ds      map[string]dsEntry
+}
+
+type keysEntry struct {
+	keys    []*dnsmessage.DNSKEYResource
+	st      status
+	err     error
+	expires time.Time
+}
+
+type dsEntry struct {
+	ds      []*dnsmessage.DSResource
+	st      status
+	err     error
+	expires time.Time
+}
+
+// Resolve implements the resolver.Resolver interface.
+func (v *Validator) Resolve(ctx context.Context, q query.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	if q.Class != query.ClassINET {
+		return v.Upstream.Resolve(ctx, q, buf)
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(q.Payload); err != nil {
+		return v.Upstream.Resolve(ctx, q, buf)
+	}
+	hasOPT := setDNSSECFlags(&msg)
+	payload, err := msg.Pack()
+	if err != nil {
+		return 0, resolver.ResolveInfo{}, err
+	}
+	subq := q
+	subq.Payload = payload
+	subq.DNSSECOK = true
+	subq.CheckingDisabled = true
+	n, i, err := v.Upstream.Resolve(ctx, subq, buf)
+	if err != nil || n <= 0 {
+		return n, i, err
+	}
+	var resp dnsmessage.Message
+	if err := resp.Unpack(buf[:n]); err != nil {
+		return n, i, err
+	}
+	st := insecure
+	// Truncated answers are passed through unvalidated for the client to
+	// retry over TCP.
+	if !q.CheckingDisabled && !resp.Header.Truncated &&
+		(resp.Header.RCode == dnsmessage.RCodeSuccess || resp.Header.RCode == dnsmessage.RCodeNameError) {
+		if st, err = v.validate(ctx, q, &resp); st == bogus {
+			if v.OnBogus != nil {
+				v.OnBogus(q.Name, q.Type, err)
+			}
+			n, err = servfail(q, buf, hasOPT)
+			return n, i, err
+		}
+	}
+	resp.Header.AuthenticData = st == secure
+	if !q.DNSSECOK {
+		stripDNSSEC(&resp, dnsmessage.Type(q.Type))
+	}
+	restoreOPT(&resp, hasOPT, q.DNSSECOK)
+	out, err := resp.AppendPack(buf[:0])
+	if err != nil {
+		return 0, i, err
+	}
+	if len(out) > len(buf) {
+		return 0, i, errTooLarge
+	}
+	return copy(buf, out), i, nil
+}
+
+// setDNSSECFlags sets the CD and DO bits of msg and returns whether msg had an
+// OPT record.
+func setDNSSECFlags(msg *dnsmessage.Message) bool {
+	msg.Header.CheckingDisabled = true
+	for i, rr := range msg.Additionals {
+		if rr.Header.Type == dnsmessage.TypeOPT {
+			msg.Additionals[i].Header.TTL |= 1 << 15
+			return true
+		}
+	}
+	var h dnsmessage.ResourceHeader
+	_ = h.SetEDNS0(1232, dnsmessage.RCodeSuccess, true)
+	msg.Additionals = append(msg.Additionals, dnsmessage.Resource{Header: h, Body: &dnsmessage.OPTResource{}})
+	return false
+}
+
+// restoreOPT removes the OPT record added to the query or sets its DO bit to
+// the value sent by the client.
+func restoreOPT(msg *dnsmessage.Message, hasOPT, do bool) {
+	additionals := msg.Additionals[:0]
+	for _, rr := range msg.Additionals {
+		if rr.Header.Type == dnsmessage.TypeOPT {
+			if !hasOPT {
+				continue
+			}
+			if !do {
+				rr.Header.TTL &^= 1 << 15
+			}
+		}
+		additionals = append(additionals, rr)
+	}
+	msg.Additionals = additionals
+}
+
+// stripDNSSEC removes DNSSEC records not explicitly queried from msg.
+func stripDNSSEC(msg *dnsmessage.Message, qtype dnsmessage.Type) {
+	strip := func(section []dnsmessage.Resource) []dnsmessage.Resource {
+		rrs := section[:0]
+		for _, rr := range section {
+			switch rr.Header.Type {
+			case dnsmessage.TypeRRSIG, dnsmessage.TypeNSEC, dnsmessage.TypeNSEC3:
+				if rr.Header.Type != qtype {
+					continue
+				}
+			}
+			rrs = append(rrs, rr)
+		}
+		return rrs
+	}
+	msg.Answers = strip(msg.Answers)
+	msg.Authorities = strip(msg.Authorities)
+	msg.Additionals = strip(msg.Additionals)
+}
+
+// servfail writes a SERVFAIL answer to q in buf.
+func servfail(q query.Query, buf []byte, hasOPT bool) (int, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(q.Payload); err != nil {
+		return 0, err
+	}
+	msg.Header.Response = true
+	msg.Header.RecursionAvailable = true
+	msg.Header.AuthenticData = false
+	msg.Header.RCode = dnsmessage.RCodeServerFailure
+	msg.Answers = nil
+	msg.Authorities = nil
+	var additionals []dnsmessage.Resource
+	for _, rr := range msg.Additionals {
+		if rr.Header.Type == dnsmessage.TypeOPT && hasOPT {
+			rr.Body = &dnsmessage.OPTResource{}
+			additionals = append(additionals, rr)
+			break
+		}
+	}
+	msg.Additionals = additionals
+	out, err := msg.AppendPack(buf[:0])
+	if err != nil {
+		return 0, err
+	}
+	if len(out) > len(buf) {
+		return 0, errTooLarge
+	}
+	return copy(buf, out), nil
+}
+
+// validate returns the security status of resp, an answer to q.
+func (v *Validator) validate(ctx context.Context, q query.Query, resp *dnsmessage.Message) (status, error) {
+	qname := canonicalName(q.Name)
+	if answers := groupRRsets(resp.Answers); len(answers) > 0 {
+		// Answers following a CNAME chain ending with a negative answer are
+		// validated on their answer section only.
+		st := secure
+		for _, set := range answers {
+			s, err := v.verifyRRset(ctx, q, set, set.sigs, ownerZone(set.name, set.typ))
+			if s == bogus {
+				return bogus, fmt.Errorf("%s %v: %v", set.name, set.typ, err)
+			}
+			st = min(st, s)
+		}
+		return st, nil
+	}
+	return v.validateDenial(ctx, q, resp, qname, dnsmessage.Type(q.Type))
+}
+
+// validateDenial validates a negative answer for name and typ.
+func (v *Validator) validateDenial(ctx context.Context, q query.Query, resp *dnsmessage.Message, name string, typ dnsmessage.Type) (status, error) {
+	var sets []*rrset
+	signed := false
+	for _, set := range groupRRsets(resp.Authorities) {
+		if set.typ == dnsmessage.TypeNS {
+			continue
+		}
+		sets = append(sets, set)
+		signed = signed || len(set.sigs) > 0
+	}
+	if !signed {
+		st, err := v.nameStatus(ctx, q, ownerZone(name, typ))
+		if st == secure {
+			return bogus, fmt.Errorf("%s: unsigned negative answer in signed zone", name)
+		}
+		return st, err
+	}
+	st := secure
+	for _, set := range sets {
+		s, err := v.verifyRRset(ctx, q, set, set.sigs, ownerZone(name, typ))
+		if s == bogus {
+			return bogus, fmt.Errorf("%s %v: %v", set.name, set.typ, err)
+		}
+		st = min(st, s)
+	}
+	if st != secure {
+		return st, nil
+	}
+	nxdomain := resp.Header.RCode == dnsmessage.RCodeNameError
+	if s := newDenial(sets).prove(name, typ, nxdomain); s != secure {
+		if s == bogus {
+			return bogus, fmt.Errorf("%s: missing denial of existence proof", name)
+		}
+		return s, nil
+	}
+	return secure, nil
+}
+
+// verifyRRset verifies that one of sigs is a valid signature of set by the
+// keys of a secure zone. Unsigned sets are insecure if name is at or below an
+// insecure delegation, bogus otherwise.
+func (v *Validator) verifyRRset(ctx context.Context, q query.Query, set *rrset, sigs []*dnsmessage.RRSIGResource, name string) (status, error) {
+	if len(sigs) == 0 {
+		st, err := v.nameStatus(ctx, q, name)
+		if st == secure {
+			return bogus, errors.New("missing signature")
+		}
+		return st, err
+	}
+	err := errors.New("no valid signature")
+	for _, sig := range sigs {
+		signer := canonicalName(sig.SignerName.String())
+		if !isSubdomain(set.name, signer) {
+			err = fmt.Errorf("signer %s out of zone", signer)
+			continue
+		}
+		keys, st, kerr := v.zoneKeys(ctx, q, signer)
+		switch st {
+		case insecure:
+			return insecure, nil
+		case bogus:
+			err = kerr
+			continue
+		}
+		now := time.Now()
+		for _, key := range keys {
+			if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+				continue
+			}
+			if err = verify(set, sig, key, now); err == nil {
+				return secure, nil
+			}
+		}
+	}
+	return bogus, err
+}
+
+// ownerZone returns the name whose delegation status determines whether an
+// unsigned set of type typ owned by name is insecure: DS records are served by
+// the parent zone.
+func ownerZone(name string, typ dnsmessage.Type) string {
+	if typ == dnsmessage.TypeDS {
+		return parent(name)
+	}
+	return name
+}
+
+// nameStatus returns insecure if name is at or below an insecure delegation,
+// secure if the chain of trust can be established down to name.
+func (v *Validator) nameStatus(ctx context.Context, q query.Query, name string) (status, error) {
+	for _, a := range ancestors(name) {
+		if st, err := v.delegationStatus(ctx, q, a); st != secure {
+			return st, err
+		}
+	}
+	return secure, nil
+}
+
+// delegationStatus returns the security status of the delegation of name by
+// its parent: insecure for a delegation without DS, secure for a secure
+// delegation or when name is not a zone cut.
+func (v *Validator) delegationStatus(ctx context.Context, q query.Query, name string) (status, error) {
+	_, st, err := v.dsRRset(ctx, q, name)
+	return st, err
+}
+
+// dsRRset returns the validated DS records of zone. A secure status with no
+// records is returned if zone is not a zone cut.
+func (v *Validator) dsRRset(ctx context.Context, q query.Query, zone string) ([]*dnsmessage.DSResource, status, error) {
+	v.mu.Lock()
+	e, found := v.ds[zone]
+	v.mu.Unlock()
+	if found && time.Now().Before(e.expires) {
+		return e.ds, e.st, e.err
+	}
+	e = dsEntry{}
+	ttl := uint32(0)
+	resp, err := v.lookup(ctx, q, zone, dnsmessage.TypeDS)
+	if err != nil {
+		e.st, e.err = bogus, err
+	} else if sets := groupRRsets(resp.Answers); len(sets) > 0 && sets[0].typ == dnsmessage.TypeDS {
+		set := sets[0]
+		ttl = set.ttl()
+		if e.st, e.err = v.verifyRRset(ctx, q, set, set.sigsAbove(zone), parent(zone)); e.st == secure {
+			for _, rr := range set.rrs {
+				if ds, ok := rr.Body.(*dnsmessage.DSResource); ok {
+					e.ds = append(e.ds, ds)
+				}
+			}
+		}
+	} else {
+		var sets []*rrset
+		for _, set := range groupRRsets(resp.Authorities) {
+			if set.typ == dnsmessage.TypeNS {
+				continue
+			}
+			set.sigs = set.sigsAbove(zone)
+			sets = append(sets, set)
+			if t := set.ttl(); ttl == 0 || t < ttl {
+				ttl = t
+			}
+		}
+		e.st, e.err = v.verifyDSDenial(ctx, q, zone, sets, resp.Header.RCode == dnsmessage.RCodeNameError)
+	}
+	e.expires = time.Now().Add(cacheTTL(ttl, e.st))
+	v.mu.Lock()
+	if v.ds == nil || len(v.ds) > maxCacheSize {
+		v.ds = map[string]dsEntry{}
+	}
+	v.ds[zone] = e
+	v.mu.Unlock()
+	return e.ds, e.st, e.err
+}
+
+// verifyDSDenial validates the proof that zone has no DS record.
+func (v *Validator) verifyDSDenial(ctx context.Context, q query.Query, zone string, sets []*rrset, nxdomain bool) (status, error) {
+	st := secure
+	for _, set := range sets {
+		s, err := v.verifyRRset(ctx, q, set, set.sigs, parent(zone))
+		if s == bogus {
+			return bogus, fmt.Errorf("%s DS: %s %v: %v", zone, set.name, set.typ, err)
+		}
+		st = min(st, s)
+	}
+	if st != secure || len(sets) == 0 {
+		if len(sets) == 0 {
+			return bogus, fmt.Errorf("%s DS: missing denial of existence", zone)
+		}
+		return st, nil
+	}
+	d := newDenial(sets)
+	if types, found := d.types(zone); found && !nxdomain {
+		if slices.Contains(types, dnsmessage.TypeDS) {
+			return bogus, fmt.Errorf("%s DS: denied DS listed in type bitmap", zone)
+		}
+		if slices.Contains(types, dnsmessage.TypeNS) && !slices.Contains(types, dnsmessage.TypeSOA) {
+			// Delegation without DS.
+			return insecure, nil
+		}
+		// Not a zone cut.
+		return secure, nil
+	}
+	switch d.prove(zone, dnsmessage.TypeDS, nxdomain) {
+	case insecure:
+		return insecure, nil
+	case secure:
+		// Empty non-terminal or non existent name, not a zone cut.
+		return secure, nil
+	}
+	return bogus, fmt.Errorf("%s DS: missing denial of existence proof", zone)
+}
+
+// zoneKeys returns the validated keys of zone.
+func (v *Validator) zoneKeys(ctx context.Context, q query.Query, zone string) ([]*dnsmessage.DNSKEYResource, status, error) {
+	v.mu.Lock()
+	e, found := v.keys[zone]
+	v.mu.Unlock()
+	if found && time.Now().Before(e.expires) {
+		return e.keys, e.st, e.err
+	}
+	var ttl uint32
+	e = keysEntry{}
+	e.keys, ttl, e.st, e.err = v.fetchZoneKeys(ctx, q, zone)
+	e.expires = time.Now().Add(cacheTTL(ttl, e.st))
+	v.mu.Lock()
+	if v.keys == nil || len(v.keys) > maxCacheSize {
+		v.keys = map[string]keysEntry{}
+	}
+	v.keys[zone] = e
+	v.mu.Unlock()
+	return e.keys, e.st, e.err
+}
+
+func (v *Validator) fetchZoneKeys(ctx context.Context, q query.Query, zone string) ([]*dnsmessage.DNSKEYResource, uint32, status, error) {
+	var ds []*dnsmessage.DSResource
+	if zone != "." {
+		var st status
+		var err error
+		if ds, st, err = v.dsRRset(ctx, q, zone); st != secure {
+			return nil, 0, st, err
+		}
+		if len(ds) == 0 {
+			return nil, 0, bogus, fmt.Errorf("%s: no DS for signer zone", zone)
+		}
+		if !slices.ContainsFunc(ds, func(ds *dnsmessage.DSResource) bool {
+			return supportedAlgorithm(ds.Algorithm) && supportedDigest(ds.DigestType)
+		}) {
+			return nil, 0, insecure, nil
+		}
+	}
+	resp, err := v.lookup(ctx, q, zone, dnsmessage.TypeDNSKEY)
+	if err != nil {
+		return nil, 0, bogus, err
+	}
+	var set *rrset
+	for _, s := range groupRRsets(resp.Answers) {
+		if s.name == zone && s.typ == dnsmessage.TypeDNSKEY {
+			set = s
+		}
+	}
+	if set == nil {
+		return nil, 0, bogus, fmt.Errorf("%s: no DNSKEY", zone)
+	}
+	var keys []*dnsmessage.DNSKEYResource
+	for _, rr := range set.rrs {
+		if key, ok := rr.Body.(*dnsmessage.DNSKEYResource); ok {
+			keys = append(keys, key)
+		}
+	}
+
+	// Find the keys allowed to sign the DNSKEY set.
+	var sep []*dnsmessage.DNSKEYResource
+	if zone == "." {
+		sep = v.rootAnchors().trusted(keys)
+	} else {
+		for _, key := range keys {
+			for _, d := range ds {
+				if dsMatches(d, zone, key) {
+					sep = append(sep, key)
+					break
+				}
+			}
+		}
+	}
+	now := time.Now()
+	signedBy := func(key *dnsmessage.DNSKEYResource) bool {
+		for _, sig := range set.sigs {
+			if canonicalName(sig.SignerName.String()) == zone && verify(set, sig, key, now) == nil {
+				return true
+			}
+		}
+		return false
+	}
+	secureSet := false
+	for _, key := range sep {
+		if signedBy(key) {
+			secureSet = true
+			break
+		}
+	}
+	if !secureSet {
+		return nil, 0, bogus, fmt.Errorf("%s: DNSKEY not signed by a trusted key", zone)
+	}
+	if zone == "." {
+		v.rootAnchors().update(keys, signedBy, now)
+	}
+	var zoneKeys []*dnsmessage.DNSKEYResource
+	for _, key := range keys {
+		if key.Flags&dnsmessage.DNSKEYFlagZone != 0 && key.Flags&dnsmessage.DNSKEYFlagRevoke == 0 {
+			zoneKeys = append(zoneKeys, key)
+		}
+	}
+	return zoneKeys, set.ttl(), secure, nil
+}
+
+func (v *Validator) rootAnchors() *Anchors {
+	if v.Anchors != nil {
+		return v.Anchors
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.anchors == nil {
+		v.anchors = &Anchors{}
+	}
+	return v.anchors
+}
+
+// lookup sends a DNSSEC query for name and typ through the upstream resolver.
+func (v *Validator) lookup(ctx context.Context, q query.Query, name string, typ dnsmessage.Type) (*dnsmessage.Message, error) {
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	id := uint16(rand.Uint32())
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true, CheckingDisabled: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{Name: n, Type: typ, Class: dnsmessage.ClassINET})
+	_ = b.StartAdditionals()
+	var h dnsmessage.ResourceHeader
+	_ = h.SetEDNS0(1232, dnsmessage.RCodeSuccess, true)
+	_ = b.OPTResource(h, dnsmessage.OPTResource{})
+	payload, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+	subq := query.Query{
+		ID:               id,
+		Class:            query.ClassINET,
+		Type:             query.Type(typ),
+		RecursionDesired: true,
+		MsgSize:          1232,
+		Name:             name,
+		LocalIP:          q.LocalIP,
+		PeerIP:           q.PeerIP,
+		MAC:              q.MAC,
+		Payload:          payload,
+		DNSSECOK:         true,
+		CheckingDisabled: true,
+	}
+	buf := make([]byte, maxMsgSize)
+	rn, _, err := v.Upstream.Resolve(ctx, subq, buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s %v: %v", name, typ, err)
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(buf[:rn]); err != nil {
+		return nil, fmt.Errorf("%s %v: %v", name, typ, err)
+	}
+	if msg.Header.Truncated {
+		return nil, fmt.Errorf("%s %v: truncated answer", name, typ)
+	}
+	if rc := msg.Header.RCode; rc != dnsmessage.RCodeSuccess && rc != dnsmessage.RCodeNameError {
+		return nil, fmt.Errorf("%s %v: %v", name, typ, rc)
+	}
+	return &msg, nil
+}
+
+func cacheTTL(ttl uint32, st status) time.Duration {
+	if st == bogus {
+		return bogusCacheTTL
+	}
+	return min(max(time.Duration(ttl)*time.Second, minCacheTTL), maxCacheTTL)
+}
@@ -0,0 +1,339 @@
+package dnssec
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+type testKey struct {
+	zone   string
+	dnskey *dnsmessage.DNSKEYResource
+	sign   func(data []byte) []byte
+}
+
+func newEd25519Key(t *testing.T, zone string, flags uint16) testKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{
+		zone:   zone,
+		dnskey: &dnsmessage.DNSKEYResource{Flags: flags, Protocol: 3, Algorithm: algED25519, PublicKey: pub},
+		sign:   func(data []byte) []byte { return ed25519.Sign(priv, data) },
+	}
+}
+
+func newECDSAKey(t *testing.T, zone string, flags uint16) testKey {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{
+		zone:   zone,
+		dnskey: &dnsmessage.DNSKEYResource{Flags: flags, Protocol: 3, Algorithm: algECDSAP256SHA256, PublicKey: pub[1:]},
+		sign: func(data []byte) []byte {
+			h := sha256.Sum256(data)
+			r, s, err := ecdsa.Sign(rand.Reader, priv, h[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig := make([]byte, 64)
+			r.FillBytes(sig[:32])
+			s.FillBytes(sig[32:])
+			return sig
+		},
+	}
+}
+
+func (k testKey) ds() *dnsmessage.DSResource {
+	data, _ := canonicalOwner(k.zone)
+	rdata, _ := dnsmessage.CanonicalRData(k.dnskey)
+	d := sha256.Sum256(append(data, rdata...))
+	return &dnsmessage.DSResource{KeyTag: k.dnskey.KeyTag(), Algorithm: k.dnskey.Algorithm, DigestType: digestSHA256, Digest: d[:]}
+}
+
+func rr(name string, body dnsmessage.ResourceBody) dnsmessage.Resource {
+	var typ dnsmessage.Type
+	switch body.(type) {
+	case *dnsmessage.AResource:
+		typ = dnsmessage.TypeA
+	case *dnsmessage.SOAResource:
+		typ = dnsmessage.TypeSOA
+	case *dnsmessage.DSResource:
+		typ = dnsmessage.TypeDS
+	case *dnsmessage.DNSKEYResource:
+		typ = dnsmessage.TypeDNSKEY
+	case *dnsmessage.NSECResource:
+		typ = dnsmessage.TypeNSEC
+	}
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET, TTL: 300},
+		Body:   body,
+	}
+}
+
+// signed returns the records followed by their signature made with k.
+func (k testKey) signed(t *testing.T, rrs ...dnsmessage.Resource) []dnsmessage.Resource {
+	t.Helper()
+	now := uint32(time.Now().Unix())
+	set := groupRRsets(rrs)[0]
+	sig := &dnsmessage.RRSIGResource{
+		TypeCovered: set.typ,
+		Algorithm:   k.dnskey.Algorithm,
+		Labels:      uint8(labelCount(set.name)),
+		OriginalTTL: 300,
+		Expiration:  now + 3600,
+		Inception:   now - 3600,
+		KeyTag:      k.dnskey.KeyTag(),
+		SignerName:  dnsmessage.MustNewName(k.zone),
+	}
+	data, err := signedData(set, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig.Signature = k.sign(data)
+	rrsig := dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: rrs[0].Header.Name, Type: dnsmessage.TypeRRSIG, Class: dnsmessage.ClassINET, TTL: 300},
+		Body:   sig,
+	}
+	return append(rrs, rrsig)
+}
+
+type answer struct {
+	rcode       dnsmessage.RCode
+	answers     []dnsmessage.Resource
+	authorities []dnsmessage.Resource
+}
+
+// fakeUpstream answers queries from a static table indexed by "name type".
+type fakeUpstream map[string]answer
+
+func (f fakeUpstream) Resolve(ctx context.Context, q query.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	var req dnsmessage.Message
+	if err := req.Unpack(q.Payload); err != nil {
+		return 0, resolver.ResolveInfo{}, err
+	}
+	a, found := f[q.Name+" "+q.Type.String()]
+	if !found {
+		a.rcode = dnsmessage.RCodeServerFailure
+	}
+	resp := dnsmessage.Message{
+		Header:      dnsmessage.Header{ID: req.Header.ID, Response: true, RCode: a.rcode},
+		Questions:   req.Questions,
+		Answers:     a.answers,
+		Authorities: a.authorities,
+		Additionals: req.Additionals,
+	}
+	out, err := resp.AppendPack(buf[:0])
+	if err != nil {
+		return 0, resolver.ResolveInfo{}, err
+	}
+	return copy(buf, out), resolver.ResolveInfo{}, nil
+}
+
+func newTestValidator(t *testing.T) *Validator {
+	t.Helper()
+	root := newEd25519Key(t, ".", 257)
+	example := newECDSAKey(t, "example.", 257)
+	soa := &dnsmessage.SOAResource{NS: dnsmessage.MustNewName("ns.example."), MBox: dnsmessage.MustNewName("hostmaster.example."), Serial: 1, MinTTL: 300}
+	rootSOA := &dnsmessage.SOAResource{NS: dnsmessage.MustNewName("a.root."), MBox: dnsmessage.MustNewName("hostmaster.root."), Serial: 1, MinTTL: 300}
+	www := rr("www.example.", &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}})
+	forged := rr("www.example.", &dnsmessage.AResource{A: [4]byte{192, 0, 2, 66}})
+	wwwSigned := example.signed(t, www)
+	up := fakeUpstream{
+		". DNSKEY":        {answers: root.signed(t, rr(".", root.dnskey))},
+		"example. DS":     {answers: root.signed(t, rr("example.", example.ds()))},
+		"example. DNSKEY": {answers: example.signed(t, rr("example.", example.dnskey))},
+		"www.example. A":  {answers: wwwSigned},
+		"forged.example. A": {answers: []dnsmessage.Resource{
+			rr("forged.example.", &dnsmessage.AResource{A: [4]byte{192, 0, 2, 66}}),
+		}},
+		"tampered.example. A": {answers: []dnsmessage.Resource{forged, wwwSigned[1]}},
+		"nx.example. A": {
+			rcode: dnsmessage.RCodeNameError,
+			authorities: append(example.signed(t, rr("example.", soa)),
+				example.signed(t, rr("example.", &dnsmessage.NSECResource{
+					NextDomain: dnsmessage.MustNewName("www.example."),
+					Types:      []dnsmessage.Type{dnsmessage.TypeSOA, dnsmessage.TypeNS, dnsmessage.TypeDNSKEY, dnsmessage.TypeNSEC, dnsmessage.TypeRRSIG},
+				}))...),
+		},
+		"insecure. DS": {
+			authorities: append(root.signed(t, rr(".", rootSOA)),
+				root.signed(t, rr("insecure.", &dnsmessage.NSECResource{
+					NextDomain: dnsmessage.MustNewName("zzz."),
+					Types:      []dnsmessage.Type{dnsmessage.TypeNS, dnsmessage.TypeNSEC, dnsmessage.TypeRRSIG},
+				}))...),
+		},
+		"host.insecure. DS": {},
+		"host.insecure. A": {answers: []dnsmessage.Resource{
+			rr("host.insecure.", &dnsmessage.AResource{A: [4]byte{192, 0, 2, 2}}),
+		}},
+	}
+	return &Validator{
+		Upstream: up,
+		Anchors: &Anchors{
+			loaded: true,
+			anchors: []anchor{{
+				KeyTag:    root.dnskey.KeyTag(),
+				Algorithm: algED25519,
+				PublicKey: root.dnskey.PublicKey,
+				State:     stateValid,
+			}},
+		},
+	}
+}
+
+func newQuery(t *testing.T, name string, do bool) query.Query {
+	t.Helper()
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	_ = b.StartAdditionals()
+	var h dnsmessage.ResourceHeader
+	_ = h.SetEDNS0(1232, dnsmessage.RCodeSuccess, do)
+	_ = b.OPTResource(h, dnsmessage.OPTResource{})
+	payload, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := query.New(payload, net.ParseIP("127.0.0.1"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestValidator_Resolve(t *testing.T) {
+	v := newTestValidator(t)
+	tests := []struct {
+		name      string
+		qname     string
+		do        bool
+		wantRCode dnsmessage.RCode
+		wantAD    bool
+		wantSigs  bool
+	}{
+		{"secure", "www.example.", false, dnsmessage.RCodeSuccess, true, false},
+		{"secure with DO", "www.example.", true, dnsmessage.RCodeSuccess, true, true},
+		{"missing signature", "forged.example.", false, dnsmessage.RCodeServerFailure, false, false},
+		{"tampered", "tampered.example.", false, dnsmessage.RCodeServerFailure, false, false},
+		{"secure nxdomain", "nx.example.", false, dnsmessage.RCodeNameError, true, false},
+		{"insecure delegation", "host.insecure.", false, dnsmessage.RCodeSuccess, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := make([]byte, 65535)
+			n, _, err := v.Resolve(context.Background(), newQuery(t, tt.qname, tt.do), buf)
+			if err != nil {
+				t.Fatalf("Resolve() err = %v", err)
+			}
+			var resp dnsmessage.Message
+			if err := resp.Unpack(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Header.ID != 42 {
+				t.Errorf("Resolve() ID = %d, want 42", resp.Header.ID)
+			}
+			if resp.Header.RCode != tt.wantRCode {
+				t.Errorf("Resolve() RCode = %v, want %v", resp.Header.RCode, tt.wantRCode)
+			}
+			if resp.Header.AuthenticData != tt.wantAD {
+				t.Errorf("Resolve() AD = %v, want %v", resp.Header.AuthenticData, tt.wantAD)
+			}
+			hasSigs := false
+			for _, rr := range append(resp.Answers, resp.Authorities...) {
+				if rr.Header.Type == dnsmessage.TypeRRSIG {
+					hasSigs = true
+				}
+			}
+			if hasSigs != tt.wantSigs {
+				t.Errorf("Resolve() RRSIG present = %v, want %v", hasSigs, tt.wantSigs)
+			}
+		})
+	}
+}
+
+func TestValidator_untrustedRoot(t *testing.T) {
+	v := newTestValidator(t)
+	other := newEd25519Key(t, ".", 257)
+	v.Anchors.anchors[0].PublicKey = other.dnskey.PublicKey
+	buf := make([]byte, 65535)
+	n, _, err := v.Resolve(context.Background(), newQuery(t, "www.example.", false), buf)
+	if err != nil {
+		t.Fatalf("Resolve() err = %v", err)
+	}
+	var resp dnsmessage.Message
+	if err := resp.Unpack(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.RCode != dnsmessage.RCodeServerFailure {
+		t.Errorf("Resolve() RCode = %v, want SERVFAIL", resp.Header.RCode)
+	}
+}
+
+func TestAnchors_update(t *testing.T) {
+	current := newEd25519Key(t, ".", 257)
+	next := newEd25519Key(t, ".", 257)
+	a := &Anchors{
+		File:    t.TempDir() + "/anchors.json",
+		OnError: func(err error) { t.Error(err) },
+		loaded:  true,
+		anchors: []anchor{{KeyTag: current.dnskey.KeyTag(), Algorithm: algED25519, PublicKey: current.dnskey.PublicKey, State: stateValid}},
+	}
+	now := time.Now()
+	all := func(*dnsmessage.DNSKEYResource) bool { return true }
+	trusted := func(a *Anchors, keys ...*dnsmessage.DNSKEYResource) int {
+		return len(a.trusted(keys))
+	}
+
+	// A new key is pending for the hold-down period.
+	a.update([]*dnsmessage.DNSKEYResource{current.dnskey, next.dnskey}, all, now)
+	if got := trusted(a, next.dnskey); got != 0 {
+		t.Errorf("new key trusted before hold-down")
+	}
+	a.update([]*dnsmessage.DNSKEYResource{current.dnskey, next.dnskey}, all, now.Add(holdDown))
+	if got := trusted(a, next.dnskey); got != 1 {
+		t.Errorf("new key not trusted after hold-down")
+	}
+
+	// The old key is revoked.
+	revoked := *current.dnskey
+	revoked.Flags |= dnsmessage.DNSKEYFlagRevoke
+	a.update([]*dnsmessage.DNSKEYResource{&revoked, next.dnskey}, all, now.Add(holdDown))
+	if got := trusted(a, current.dnskey); got != 0 {
+		t.Errorf("revoked key still trusted")
+	}
+
+	// The state is persisted.
+	b := &Anchors{File: a.File, OnError: a.OnError}
+	if got := trusted(b, current.dnskey, next.dnskey); got != 1 {
+		t.Errorf("reloaded anchors trust %d keys, want 1", got)
+	}
+}
+
+func TestRootAnchors(t *testing.T) {
+	// Check the built-in anchors decode properly.
+	for _, a := range rootAnchors {
+		if d := a.Digest; len(d) != 64 || strings.Trim(d, "0123456789ABCDEF") != "" {
+			t.Errorf("anchor %d: invalid digest %q", a.KeyTag, d)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package dnssec
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+// DNSSEC algorithm numbers.
+const (
+	algRSASHA1          = 5
+	algRSASHA1NSEC3SHA1 = 7
+	algRSASHA256        = 8
+	algRSASHA512        = 10
+	algECDSAP256SHA256  = 13
+	algECDSAP384SHA384  = 14
+	algED25519          = 15
+)
+
+// DS digest types.
+const (
+	digestSHA1   = 1
+	digestSHA256 = 2
+	digestSHA384 = 4
+)
+
+var (
+	errSigExpired  = errors.New("signature expired or not yet valid")
+	errSigLabels   = errors.New("invalid signature labels")
+	errBadKey      = errors.New("invalid public key")
+	errUnsupported = errors.New("unsupported algorithm")
+	errBadSig      = errors.New("invalid signature")
+)
+
+func supportedAlgorithm(alg uint8) bool {
+	switch alg {
+	case algRSASHA1, algRSASHA1NSEC3SHA1, algRSASHA256, algRSASHA512,
+		algECDSAP256SHA256, algECDSAP384SHA384, algED25519:
+		return true
+	}
+	return false
+}
+
+func supportedDigest(t uint8) bool {
+	return t == digestSHA1 || t == digestSHA256 || t == digestSHA384
+}
+
+// rrset is a set of records sharing owner name and type, with the signatures
+// covering them.
+type rrset struct {
+	name string
+	typ  dnsmessage.Type
+	rrs  []dnsmessage.Resource
+	sigs []*dnsmessage.RRSIGResource
+}
+
+// groupRRsets groups the records of a message section by owner and type and
+// attaches RRSIG records to the set they cover.
+func groupRRsets(section []dnsmessage.Resource) []*rrset {
+	var sets []*rrset
+	find := func(name string, typ dnsmessage.Type) *rrset {
+		for _, s := range sets {
+			if s.name == name && s.typ == typ {
+				return s
+			}
+		}
+		s := &rrset{name: name, typ: typ}
+		sets = append(sets, s)
+		return s
+	}
+	for _, rr := range section {
+		name := canonicalName(rr.Header.Name.String())
+		if sig, ok := rr.Body.(*dnsmessage.RRSIGResource); ok {
+			s := find(name, sig.TypeCovered)
+			s.sigs = append(s.sigs, sig)
+			continue
+		}
+		s := find(name, rr.Header.Type)
+		s.rrs = append(s.rrs, rr)
+	}
+	// Drop signatures without records.
+	return slices.DeleteFunc(sets, func(s *rrset) bool { return len(s.rrs) == 0 })
+}
+
+// ttl returns the lowest TTL of the records of the set.
+func (s *rrset) ttl() uint32 {
+	var ttl uint32
+	for i, rr := range s.rrs {
+		if i == 0 || rr.Header.TTL < ttl {
+			ttl = rr.Header.TTL
+		}
+	}
+	return ttl
+}
+
+// sigsAbove returns the signatures of the set made by a zone strictly above
+// name.
+func (s *rrset) sigsAbove(name string) []*dnsmessage.RRSIGResource {
+	var sigs []*dnsmessage.RRSIGResource
+	for _, sig := range s.sigs {
+		signer := canonicalName(sig.SignerName.String())
+		if signer != name && isSubdomain(name, signer) {
+			sigs = append(sigs, sig)
+		}
+	}
+	return sigs
+}
+
+// signedData returns the data covered by sig for the set as defined in RFC
+// 4034 section 3.1.8.1.
+func signedData(s *rrset, sig *dnsmessage.RRSIGResource) ([]byte, error) {
+	owner := s.name
+	n := labelCount(owner)
+	if int(sig.Labels) > n {
+		return nil, errSigLabels
+	}
+	if int(sig.Labels) < n {
+		// Wildcard expansion.
+		owner = "*." + strings.TrimPrefix(suffix(owner, int(sig.Labels)), ".")
+	}
+	ownerWire, err := canonicalOwner(owner)
+	if err != nil {
+		return nil, err
+	}
+	rdata := make([][]byte, 0, len(s.rrs))
+	for _, rr := range s.rrs {
+		d, err := dnsmessage.CanonicalRData(rr.Body)
+		if err != nil {
+			return nil, err
+		}
+		rdata = append(rdata, d)
+	}
+	slices.SortFunc(rdata, bytes.Compare)
+	rdata = slices.CompactFunc(rdata, bytes.Equal)
+	data, err := dnsmessage.CanonicalRRSIGData(sig)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range rdata {
+		data = append(data, ownerWire...)
+		data = binary.BigEndian.AppendUint16(data, uint16(s.typ))
+		data = binary.BigEndian.AppendUint16(data, uint16(dnsmessage.ClassINET))
+		data = binary.BigEndian.AppendUint32(data, sig.OriginalTTL)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(d)))
+		data = append(data, d...)
+	}
+	return data, nil
+}
+
+// validPeriod returns true if now is within the validity period of sig, using
+// serial number arithmetic (RFC 1982).
+func validPeriod(sig *dnsmessage.RRSIGResource, now time.Time) bool {
+	t := uint32(now.Unix())
+	return int32(t-sig.Inception) >= 0 && int32(sig.Expiration-t) >= 0
+}
+
+// verify checks that sig is a valid signature of s made with key.
+func verify(s *rrset, sig *dnsmessage.RRSIGResource, key *dnsmessage.DNSKEYResource, now time.Time) error {
+	if sig.Algorithm != key.Algorithm || sig.KeyTag != key.KeyTag() || key.Protocol != 3 ||
+		key.Flags&dnsmessage.DNSKEYFlagZone == 0 || key.Flags&dnsmessage.DNSKEYFlagRevoke != 0 && s.typ != dnsmessage.TypeDNSKEY {
+		return errBadKey
+	}
+	if !validPeriod(sig, now) {
+		return errSigExpired
+	}
+	data, err := signedData(s, sig)
+	if err != nil {
+		return err
+	}
+	return verifySignature(key.Algorithm, key.PublicKey, data, sig.Signature)
+}
+
+func verifySignature(alg uint8, publicKey, data, signature []byte) error {
+	switch alg {
+	case algRSASHA1, algRSASHA1NSEC3SHA1, algRSASHA256, algRSASHA512:
+		pub, err := rsaPublicKey(publicKey)
+		if err != nil {
+			return err
+		}
+		var h crypto.Hash
+		var digest []byte
+		switch alg {
+		case algRSASHA256:
+			d := sha256.Sum256(data)
+			h, digest = crypto.SHA256, d[:]
+		case algRSASHA512:
+			d := sha512.Sum512(data)
+			h, digest = crypto.SHA512, d[:]
+		default:
+			d := sha1.Sum(data)
+			h, digest = crypto.SHA1, d[:]
+		}
+		if rsa.VerifyPKCS1v15(pub, h, digest, signature) != nil {
+			return errBadSig
+		}
+		return nil
+	case algECDSAP256SHA256, algECDSAP384SHA384:
+		curve, digest := elliptic.P256(), sha256.New()
+		if alg == algECDSAP384SHA384 {
+			curve, digest = elliptic.P384(), sha512.New384()
+		}
+		pub, err := ecdsa.ParseUncompressedPublicKey(curve, append([]byte{4}, publicKey...))
+		if err != nil {
+			return errBadKey
+		}
+		if len(signature) != len(publicKey) {
+			return errBadSig
+		}
+		digest.Write(data)
+		r := new(big.Int).SetBytes(signature[:len(signature)/2])
+		s := new(big.Int).SetBytes(signature[len(signature)/2:])
+		if !ecdsa.Verify(pub, digest.Sum(nil), r, s) {
+			return errBadSig
+		}
+		return nil
+	case algED25519:
+		if len(publicKey) != ed25519.PublicKeySize {
+			return errBadKey
+		}
+		if !ed25519.Verify(publicKey, data, signature) {
+			return errBadSig
+		}
+		return nil
+	}
+	return errUnsupported
+}
+
+// rsaPublicKey parses an RSA public key in the format defined in RFC 3110.
+func rsaPublicKey(b []byte) (*rsa.PublicKey, error) {
+	if len(b) < 3 {
+		return nil, errBadKey
+	}
+	elen := int(b[0])
+	b = b[1:]
+	if elen == 0 {
+		elen = int(b[0])<<8 | int(b[1])
+		b = b[2:]
+	}
+	if elen == 0 || elen > 4 || len(b) <= elen {
+		return nil, errBadKey
+	}
+	var e int
+	for _, c := range b[:elen] {
+		e = e<<8 | int(c)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(b[elen:]), E: e}, nil
+}
+
+// dsMatches returns true if ds is a digest of key owned by name.
+func dsMatches(ds *dnsmessage.DSResource, name string, key *dnsmessage.DNSKEYResource) bool {
+	if ds.KeyTag != key.KeyTag() || ds.Algorithm != key.Algorithm {
+		return false
+	}
+	data, err := canonicalOwner(name)
+	if err != nil {
+		return false
+	}
+	rdata, err := dnsmessage.CanonicalRData(key)
+	if err != nil {
+		return false
+	}
+	data = append(data, rdata...)
+	var digest []byte
+	switch ds.DigestType {
+	case digestSHA1:
+		d := sha1.Sum(data)
+		digest = d[:]
+	case digestSHA256:
+		d := sha256.Sum256(data)
+		digest = d[:]
+	case digestSHA384:
+		d := sha512.Sum384(data)
+		digest = d[:]
+	default:
+		return false
+	}
+	return bytes.Equal(digest, ds.Digest)
+}
@@ -222,6 +222,11 @@ type ConfigFileStorer struct {
 	File string
 }
 
+// ConfigFile returns the path of the configuration file.
+func (s ConfigFileStorer) ConfigFile() string {
+	return s.File
+}
+
 func (s ConfigFileStorer) SaveConfig(c map[string]ConfigEntry) error {
 	dir := filepath.Dir(s.File)
 	if st, err := os.Stat(dir); err != nil {
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
@@ -12,6 +13,25 @@ type Runner interface {
 	Log(msg string)
 }
 
+// Reloader is implemented by runners able to reload their configuration
+// without being restarted. Reload is called when the SIGHUP signal is
+// received.
+type Reloader interface {
+	Reload() error
+}
+
+// reload reloads r if it implements Reloader and returns false otherwise.
+func reload(r Runner) bool {
+	rl, ok := r.(Reloader)
+	if !ok {
+		return false
+	}
+	if err := rl.Reload(); err != nil {
+		r.Log(fmt.Sprintf("Reload failed: %v", err))
+	}
+	return true
+}
+
 func Run(name string, r Runner) error {
 	if CurrentRunMode() == RunModeNone {
 		return runForeground(r)
@@ -26,6 +46,11 @@ func runForeground(r Runner) error {
 
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGHUP, syscall.SIGTERM, os.Interrupt)
-	<-sig
+	for s := range sig {
+		if s == syscall.SIGHUP && reload(r) {
+			continue
+		}
+		break
+	}
 	return r.Stop()
 }
@@ -24,6 +24,11 @@ func runService(name string, r Runner) error {
 		case syscall.SIGTERM:
 			r.Log(fmt.Sprintf("Received signal: %s", s))
 			return r.Stop()
+		case syscall.SIGHUP:
+			r.Log(fmt.Sprintf("Received signal: %s", s))
+			if !reload(r) {
+				r.Log("Reload not supported")
+			}
 		case syscall.SIGQUIT:
 			buf := make([]byte, 100*1024)
 			n := runtime.Stack(buf, true)
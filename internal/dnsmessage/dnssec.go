@@ -0,0 +1,649 @@
+package dnsmessage
+
+import "slices"
+
+// DNSSEC Resource Records as defined in RFC 4034 and RFC 5155.
+
+// A DSResource is a DS Resource record.
+type DSResource struct {
+	KeyTag     uint16
+	Algorithm  uint8
+	DigestType uint8
+	Digest     []byte
+}
+
+func (r *DSResource) realType() Type {
+	return TypeDS
+}
+
+// pack appends the wire format of the DSResource to msg.
+func (r *DSResource) pack(msg []byte, compression map[string]uint16, compressionOff int) ([]byte, error) {
+	msg = packUint16(msg, r.KeyTag)
+	msg = append(msg, r.Algorithm, r.DigestType)
+	return packBytes(msg, r.Digest), nil
+}
+
+// GoString implements fmt.GoStringer.GoString.
+func (r *DSResource) GoString() string {
+	return "dnsmessage.DSResource{" +
+		"KeyTag: " + printUint16(r.KeyTag) + ", " +
+		"Algorithm: " + printUint16(uint16(r.Algorithm)) + ", " +
+		"DigestType: " + printUint16(uint16(r.DigestType)) + ", " +
+		"Digest: []byte{" + printByteSlice(r.Digest) + "}}"
+}
+
+func unpackDSResource(msg []byte, off int, length uint16) (DSResource, error) {
+	end := off + int(length)
+	if length < 4 || end > len(msg) {
+		return DSResource{}, errResourceLen
+	}
+	r := DSResource{
+		KeyTag:     uint16(msg[off])<<8 | uint16(msg[off+1]),
+		Algorithm:  msg[off+2],
+		DigestType: msg[off+3],
+		Digest:     slices.Clone(msg[off+4 : end]),
+	}
+	return r, nil
+}
+
+// DNSKEY flags.
+const (
+	DNSKEYFlagZone   = 0x0100
+	DNSKEYFlagRevoke = 0x0080 // RFC 5011
+	DNSKEYFlagSEP    = 0x0001
+)
+
+// A DNSKEYResource is a DNSKEY Resource record.
+type DNSKEYResource struct {
+	Flags     uint16
+	Protocol  uint8
+	Algorithm uint8
+	PublicKey []byte
+}
+
+func (r *DNSKEYResource) realType() Type {
+	return TypeDNSKEY
+}
+
+// pack appends the wire format of the DNSKEYResource to msg.
+func (r *DNSKEYResource) pack(msg []byte, compression map[string]uint16, compressionOff int) ([]byte, error) {
+	msg = packUint16(msg, r.Flags)
+	msg = append(msg, r.Protocol, r.Algorithm)
+	return packBytes(msg, r.PublicKey), nil
+}
+
+// KeyTag returns the key tag of the key as defined in RFC 4034 appendix B.
+func (r *DNSKEYResource) KeyTag() uint16 {
+	data, _ := r.pack(nil, nil, 0)
+	var ac uint32
+	for i, b := range data {
+		if i&1 == 0 {
+			ac += uint32(b) << 8
+		} else {
+			ac += uint32(b)
+		}
+	}
+	ac += ac >> 16 & 0xffff
+	return uint16(ac)
+}
+
+// GoString implements fmt.GoStringer.GoString.
+func (r *DNSKEYResource) GoString() string {
+	return "dnsmessage.DNSKEYResource{" +
+		"Flags: " + printUint16(r.Flags) + ", " +
+		"Protocol: " + printUint16(uint16(r.Protocol)) + ", " +
+		"Algorithm: " + printUint16(uint16(r.Algorithm)) + ", " +
+		"PublicKey: []byte{" + printByteSlice(r.PublicKey) + "}}"
+}
+
+func unpackDNSKEYResource(msg []byte, off int, length uint16) (DNSKEYResource, error) {
+	end := off + int(length)
+	if length < 4 || end > len(msg) {
+		return DNSKEYResource{}, errResourceLen
+	}
+	r := DNSKEYResource{
+		Flags:     uint16(msg[off])<<8 | uint16(msg[off+1]),
+		Protocol:  msg[off+2],
+		Algorithm: msg[off+3],
+		PublicKey: slices.Clone(msg[off+4 : end]),
+	}
+	return r, nil
+}
+
+// An RRSIGResource is an RRSIG Resource record.
+type RRSIGResource struct {
+	TypeCovered Type
+	Algorithm   uint8
+	Labels      uint8
+	OriginalTTL uint32
+	Expiration  uint32
+	Inception   uint32
+	KeyTag      uint16
+	SignerName  Name // Not compressed as per RFC 4034.
+	Signature   []byte
+}
+
+func (r *RRSIGResource) realType() Type {
+	return TypeRRSIG
+}
+
+// pack appends the wire format of the RRSIGResource to msg.
+func (r *RRSIGResource) pack(msg []byte, compression map[string]uint16, compressionOff int) ([]byte, error) {
+	oldMsg := msg
+	msg, err := r.packHeader(msg)
+	if err != nil {
+		return oldMsg, err
+	}
+	return packBytes(msg, r.Signature), nil
+}
+
+// packHeader appends the wire format of the RRSIGResource without signature
+// to msg.
+func (r *RRSIGResource) packHeader(msg []byte) ([]byte, error) {
+	oldMsg := msg
+	msg = packType(msg, r.TypeCovered)
+	msg = append(msg, r.Algorithm, r.Labels)
+	msg = packUint32(msg, r.OriginalTTL)
+	msg = packUint32(msg, r.Expiration)
+	msg = packUint32(msg, r.Inception)
+	msg = packUint16(msg, r.KeyTag)
+	msg, err := r.SignerName.pack(msg, nil, 0)
+	if err != nil {
+		return oldMsg, &nestedError{"RRSIGResource.SignerName", err}
+	}
+	return msg, nil
+}
+
+// GoString implements fmt.GoStringer.GoString.
+func (r *RRSIGResource) GoString() string {
+	return "dnsmessage.RRSIGResource{" +
+		"TypeCovered: " + r.TypeCovered.GoString() + ", " +
+		"Algorithm: " + printUint16(uint16(r.Algorithm)) + ", " +
+		"Labels: " + printUint16(uint16(r.Labels)) + ", " +
+		"OriginalTTL: " + printUint32(r.OriginalTTL) + ", " +
+		"Expiration: " + printUint32(r.Expiration) + ", " +
+		"Inception: " + printUint32(r.Inception) + ", " +
+		"KeyTag: " + printUint16(r.KeyTag) + ", " +
+		"SignerName: " + r.SignerName.GoString() + ", " +
+		"Signature: []byte{" + printByteSlice(r.Signature) + "}}"
+}
+
+func unpackRRSIGResource(msg []byte, off int, length uint16) (RRSIGResource, error) {
+	end := off + int(length)
+	if length < 18 || end > len(msg) {
+		return RRSIGResource{}, errResourceLen
+	}
+	var r RRSIGResource
+	r.TypeCovered = Type(uint16(msg[off])<<8 | uint16(msg[off+1]))
+	r.Algorithm = msg[off+2]
+	r.Labels = msg[off+3]
+	off += 4
+	var err error
+	if r.OriginalTTL, off, err = unpackUint32(msg, off); err != nil {
+		return RRSIGResource{}, &nestedError{"OriginalTTL", err}
+	}
+	if r.Expiration, off, err = unpackUint32(msg, off); err != nil {
+		return RRSIGResource{}, &nestedError{"Expiration", err}
+	}
+	if r.Inception, off, err = unpackUint32(msg, off); err != nil {
+		return RRSIGResource{}, &nestedError{"Inception", err}
+	}
+	if r.KeyTag, off, err = unpackUint16(msg, off); err != nil {
+		return RRSIGResource{}, &nestedError{"KeyTag", err}
+	}
+	if off, err = r.SignerName.unpack(msg, off); err != nil {
+		return RRSIGResource{}, &nestedError{"SignerName", err}
+	}
+	if off > end {
+		return RRSIGResource{}, &nestedError{"Signature", errCalcLen}
+	}
+	r.Signature = slices.Clone(msg[off:end])
+	return r, nil
+}
+
+// An NSECResource is an NSEC Resource record.
+type NSECResource struct {
+	NextDomain Name // Not compressed as per RFC 4034.
+	Types      []Type
+}
+
+func (r *NSECResource) realType() Type {
+	return TypeNSEC
+}
+
+// pack appends the wire format of the NSECResource to msg.
+func (r *NSECResource) pack(msg []byte, compression map[string]uint16, compressionOff int) ([]byte, error) {
+	oldMsg := msg
+	msg, err := r.NextDomain.pack(msg, nil, 0)
+	if err != nil {
+		return oldMsg, &nestedError{"NSECResource.NextDomain", err}
+	}
+	return packTypeBitmap(msg, r.Types), nil
+}
+
+// HasType returns true if t is listed in the type bitmap of the record.
+func (r *NSECResource) HasType(t Type) bool {
+	return slices.Contains(r.Types, t)
+}
+
+// GoString implements fmt.GoStringer.GoString.
+func (r *NSECResource) GoString() string {
+	return "dnsmessage.NSECResource{" +
+		"NextDomain: " + r.NextDomain.GoString() + ", " +
+		"Types: " + printTypes(r.Types) + "}"
+}
+
+func unpackNSECResource(msg []byte, off int, length uint16) (NSECResource, error) {
+	end := off + int(length)
+	if end > len(msg) {
+		return NSECResource{}, errResourceLen
+	}
+	var r NSECResource
+	off, err := r.NextDomain.unpack(msg, off)
+	if err != nil {
+		return NSECResource{}, &nestedError{"NextDomain", err}
+	}
+	if r.Types, err = unpackTypeBitmap(msg, off, end); err != nil {
+		return NSECResource{}, &nestedError{"Types", err}
+	}
+	return r, nil
+}
+
+// NSEC3FlagOptOut is the NSEC3 opt-out flag defined in RFC 5155.
+const NSEC3FlagOptOut = 0x01
+
+// An NSEC3Resource is an NSEC3 Resource record.
+type NSEC3Resource struct {
+	HashAlgorithm uint8
+	Flags         uint8
+	Iterations    uint16
+	Salt          []byte
+	NextHashed    []byte
+	Types         []Type
+}
+
+func (r *NSEC3Resource) realType() Type {
+	return TypeNSEC3
+}
+
+// pack appends the wire format of the NSEC3Resource to msg.
+func (r *NSEC3Resource) pack(msg []byte, compression map[string]uint16, compressionOff int) ([]byte, error) {
+	if len(r.Salt) > 255 || len(r.NextHashed) > 255 {
+		return msg, errStringTooLong
+	}
+	msg = append(msg, r.HashAlgorithm, r.Flags)
+	msg = packUint16(msg, r.Iterations)
+	msg = append(msg, byte(len(r.Salt)))
+	msg = packBytes(msg, r.Salt)
+	msg = append(msg, byte(len(r.NextHashed)))
+	msg = packBytes(msg, r.NextHashed)
+	return packTypeBitmap(msg, r.Types), nil
+}
+
+// HasType returns true if t is listed in the type bitmap of the record.
+func (r *NSEC3Resource) HasType(t Type) bool {
+	return slices.Contains(r.Types, t)
+}
+
+// GoString implements fmt.GoStringer.GoString.
+func (r *NSEC3Resource) GoString() string {
+	return "dnsmessage.NSEC3Resource{" +
+		"HashAlgorithm: " + printUint16(uint16(r.HashAlgorithm)) + ", " +
+		"Flags: " + printUint16(uint16(r.Flags)) + ", " +
+		"Iterations: " + printUint16(r.Iterations) + ", " +
+		"Salt: []byte{" + printByteSlice(r.Salt) + "}, " +
+		"NextHashed: []byte{" + printByteSlice(r.NextHashed) + "}, " +
+		"Types: " + printTypes(r.Types) + "}"
+}
+
+func unpackNSEC3Resource(msg []byte, off int, length uint16) (NSEC3Resource, error) {
+	end := off + int(length)
+	if length < 5 || end > len(msg) {
+		return NSEC3Resource{}, errResourceLen
+	}
+	r := NSEC3Resource{
+		HashAlgorithm: msg[off],
+		Flags:         msg[off+1],
+		Iterations:    uint16(msg[off+2])<<8 | uint16(msg[off+3]),
+	}
+	off += 4
+	saltLen := int(msg[off])
+	off++
+	if off+saltLen+1 > end {
+		return NSEC3Resource{}, &nestedError{"Salt", errCalcLen}
+	}
+	r.Salt = slices.Clone(msg[off : off+saltLen])
+	off += saltLen
+	hashLen := int(msg[off])
+	off++
+	if off+hashLen > end {
+		return NSEC3Resource{}, &nestedError{"NextHashed", errCalcLen}
+	}
+	r.NextHashed = slices.Clone(msg[off : off+hashLen])
+	off += hashLen
+	var err error
+	if r.Types, err = unpackTypeBitmap(msg, off, end); err != nil {
+		return NSEC3Resource{}, &nestedError{"Types", err}
+	}
+	return r, nil
+}
+
+// DSResource adds a single DSResource.
+func (b *Builder) DSResource(h ResourceHeader, r DSResource) error {
+	if err := b.checkResourceSection(); err != nil {
+		return err
+	}
+	h.Type = r.realType()
+	msg, lenOff, err := h.pack(b.msg, b.compression, b.start)
+	if err != nil {
+		return &nestedError{"ResourceHeader", err}
+	}
+	preLen := len(msg)
+	if msg, err = r.pack(msg, b.compression, b.start); err != nil {
+		return &nestedError{"DSResource body", err}
+	}
+	if err := h.fixLen(msg, lenOff, preLen); err != nil {
+		return err
+	}
+	if err := b.incrementSectionCount(); err != nil {
+		return err
+	}
+	b.msg = msg
+	return nil
+}
+
+// DSResource parses a single DSResource.
+//
+// One of the XXXHeader methods must have been called before calling this
+// method.
+func (p *Parser) DSResource() (DSResource, error) {
+	if !p.resHeaderValid || p.resHeaderType != TypeDS {
+		return DSResource{}, ErrNotStarted
+	}
+	r, err := unpackDSResource(p.msg, p.off, p.resHeaderLength)
+	if err != nil {
+		return DSResource{}, err
+	}
+	p.off += int(p.resHeaderLength)
+	p.resHeaderValid = false
+	p.index++
+	return r, nil
+}
+
+// DNSKEYResource adds a single DNSKEYResource.
+func (b *Builder) DNSKEYResource(h ResourceHeader, r DNSKEYResource) error {
+	if err := b.checkResourceSection(); err != nil {
+		return err
+	}
+	h.Type = r.realType()
+	msg, lenOff, err := h.pack(b.msg, b.compression, b.start)
+	if err != nil {
+		return &nestedError{"ResourceHeader", err}
+	}
+	preLen := len(msg)
+	if msg, err = r.pack(msg, b.compression, b.start); err != nil {
+		return &nestedError{"DNSKEYResource body", err}
+	}
+	if err := h.fixLen(msg, lenOff, preLen); err != nil {
+		return err
+	}
+	if err := b.incrementSectionCount(); err != nil {
+		return err
+	}
+	b.msg = msg
+	return nil
+}
+
+// DNSKEYResource parses a single DNSKEYResource.
+//
+// One of the XXXHeader methods must have been called before calling this
+// method.
+func (p *Parser) DNSKEYResource() (DNSKEYResource, error) {
+	if !p.resHeaderValid || p.resHeaderType != TypeDNSKEY {
+		return DNSKEYResource{}, ErrNotStarted
+	}
+	r, err := unpackDNSKEYResource(p.msg, p.off, p.resHeaderLength)
+	if err != nil {
+		return DNSKEYResource{}, err
+	}
+	p.off += int(p.resHeaderLength)
+	p.resHeaderValid = false
+	p.index++
+	return r, nil
+}
+
+// RRSIGResource adds a single RRSIGResource.
+func (b *Builder) RRSIGResource(h ResourceHeader, r RRSIGResource) error {
+	if err := b.checkResourceSection(); err != nil {
+		return err
+	}
+	h.Type = r.realType()
+	msg, lenOff, err := h.pack(b.msg, b.compression, b.start)
+	if err != nil {
+		return &nestedError{"ResourceHeader", err}
+	}
+	preLen := len(msg)
+	if msg, err = r.pack(msg, b.compression, b.start); err != nil {
+		return &nestedError{"RRSIGResource body", err}
+	}
+	if err := h.fixLen(msg, lenOff, preLen); err != nil {
+		return err
+	}
+	if err := b.incrementSectionCount(); err != nil {
+		return err
+	}
+	b.msg = msg
+	return nil
+}
+
+// RRSIGResource parses a single RRSIGResource.
+//
+// One of the XXXHeader methods must have been called before calling this
+// method.
+func (p *Parser) RRSIGResource() (RRSIGResource, error) {
+	if !p.resHeaderValid || p.resHeaderType != TypeRRSIG {
+		return RRSIGResource{}, ErrNotStarted
+	}
+	r, err := unpackRRSIGResource(p.msg, p.off, p.resHeaderLength)
+	if err != nil {
+		return RRSIGResource{}, err
+	}
+	p.off += int(p.resHeaderLength)
+	p.resHeaderValid = false
+	p.index++
+	return r, nil
+}
+
+// NSECResource adds a single NSECResource.
+func (b *Builder) NSECResource(h ResourceHeader, r NSECResource) error {
+	if err := b.checkResourceSection(); err != nil {
+		return err
+	}
+	h.Type = r.realType()
+	msg, lenOff, err := h.pack(b.msg, b.compression, b.start)
+	if err != nil {
+		return &nestedError{"ResourceHeader", err}
+	}
+	preLen := len(msg)
+	if msg, err = r.pack(msg, b.compression, b.start); err != nil {
+		return &nestedError{"NSECResource body", err}
+	}
+	if err := h.fixLen(msg, lenOff, preLen); err != nil {
+		return err
+	}
+	if err := b.incrementSectionCount(); err != nil {
+		return err
+	}
+	b.msg = msg
+	return nil
+}
+
+// NSECResource parses a single NSECResource.
+//
+// One of the XXXHeader methods must have been called before calling this
+// method.
+func (p *Parser) NSECResource() (NSECResource, error) {
+	if !p.resHeaderValid || p.resHeaderType != TypeNSEC {
+		return NSECResource{}, ErrNotStarted
+	}
+	r, err := unpackNSECResource(p.msg, p.off, p.resHeaderLength)
+	if err != nil {
+		return NSECResource{}, err
+	}
+	p.off += int(p.resHeaderLength)
+	p.resHeaderValid = false
+	p.index++
+	return r, nil
+}
+
+// NSEC3Resource adds a single NSEC3Resource.
+func (b *Builder) NSEC3Resource(h ResourceHeader, r NSEC3Resource) error {
+	if err := b.checkResourceSection(); err != nil {
+		return err
+	}
+	h.Type = r.realType()
+	msg, lenOff, err := h.pack(b.msg, b.compression, b.start)
+	if err != nil {
+		return &nestedError{"ResourceHeader", err}
+	}
+	preLen := len(msg)
+	if msg, err = r.pack(msg, b.compression, b.start); err != nil {
+		return &nestedError{"NSEC3Resource body", err}
+	}
+	if err := h.fixLen(msg, lenOff, preLen); err != nil {
+		return err
+	}
+	if err := b.incrementSectionCount(); err != nil {
+		return err
+	}
+	b.msg = msg
+	return nil
+}
+
+// NSEC3Resource parses a single NSEC3Resource.
+//
+// One of the XXXHeader methods must have been called before calling this
+// method.
+func (p *Parser) NSEC3Resource() (NSEC3Resource, error) {
+	if !p.resHeaderValid || p.resHeaderType != TypeNSEC3 {
+		return NSEC3Resource{}, ErrNotStarted
+	}
+	r, err := unpackNSEC3Resource(p.msg, p.off, p.resHeaderLength)
+	if err != nil {
+		return NSEC3Resource{}, err
+	}
+	p.off += int(p.resHeaderLength)
+	p.resHeaderValid = false
+	p.index++
+	return r, nil
+}
+
+// packTypeBitmap appends the type bitmap encoding of types defined in RFC 4034
+// section 4.1.2 to msg.
+func packTypeBitmap(msg []byte, types []Type) []byte {
+	types = slices.Clone(types)
+	slices.Sort(types)
+	types = slices.Compact(types)
+	for len(types) > 0 {
+		window := byte(types[0] >> 8)
+		var bitmap [32]byte
+		var n int
+		for len(types) > 0 && byte(types[0]>>8) == window {
+			b := byte(types[0])
+			bitmap[b/8] |= 0x80 >> (b % 8)
+			n = int(b/8) + 1
+			types = types[1:]
+		}
+		msg = append(msg, window, byte(n))
+		msg = append(msg, bitmap[:n]...)
+	}
+	return msg
+}
+
+func unpackTypeBitmap(msg []byte, off, end int) ([]Type, error) {
+	var types []Type
+	for off < end {
+		if off+2 > end {
+			return nil, errBaseLen
+		}
+		window, n := int(msg[off]), int(msg[off+1])
+		off += 2
+		if n == 0 || n > 32 || off+n > end {
+			return nil, errCalcLen
+		}
+		for i, b := range msg[off : off+n] {
+			for j := range 8 {
+				if b&(0x80>>j) != 0 {
+					types = append(types, Type(window<<8|i*8+j))
+				}
+			}
+		}
+		off += n
+	}
+	return types, nil
+}
+
+func printTypes(types []Type) string {
+	s := "[]dnsmessage.Type{"
+	for i, t := range types {
+		if i > 0 {
+			s += ", "
+		}
+		s += t.GoString()
+	}
+	return s + "}"
+}
+
+// AppendCanonicalName appends the canonical wire format of n as defined in RFC
+// 4034 section 6.2: uncompressed and lowercase.
+func AppendCanonicalName(msg []byte, n Name) ([]byte, error) {
+	n = lowerName(n)
+	return n.pack(msg, nil, 0)
+}
+
+// CanonicalRData returns the canonical wire format of the record data of body
+// as defined in RFC 4034 section 6.2 and RFC 6840 section 5.1: names are
+// uncompressed and lowercase.
+func CanonicalRData(body ResourceBody) ([]byte, error) {
+	switch r := body.(type) {
+	case *NSResource:
+		body = &NSResource{NS: lowerName(r.NS)}
+	case *CNAMEResource:
+		body = &CNAMEResource{CNAME: lowerName(r.CNAME)}
+	case *PTRResource:
+		body = &PTRResource{PTR: lowerName(r.PTR)}
+	case *MXResource:
+		body = &MXResource{Pref: r.Pref, MX: lowerName(r.MX)}
+	case *SRVResource:
+		c := *r
+		c.Target = lowerName(r.Target)
+		body = &c
+	case *SOAResource:
+		c := *r
+		c.NS = lowerName(r.NS)
+		c.MBox = lowerName(r.MBox)
+		body = &c
+	case *RRSIGResource:
+		c := *r
+		c.SignerName = lowerName(r.SignerName)
+		body = &c
+	}
+	return body.pack(nil, nil, 0)
+}
+
+// CanonicalRRSIGData returns the RRSIG record data covered by its signature:
+// the record without signature, with the signer name in canonical form.
+func CanonicalRRSIGData(r *RRSIGResource) ([]byte, error) {
+	c := *r
+	c.SignerName = lowerName(r.SignerName)
+	return c.packHeader(nil)
+}
+
+func lowerName(n Name) Name {
+	for i := range n.Data[:n.Length] {
+		if c := n.Data[i]; c >= 'A' && c <= 'Z' {
+			n.Data[i] = c + 'a' - 'A'
+		}
+	}
+	return n
+}
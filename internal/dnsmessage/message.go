@@ -40,18 +40,23 @@ type Type uint16
 
 const (
 	// ResourceHeader.Type and Question.Type
-	TypeA     Type = 1
-	TypeNS    Type = 2
-	TypeCNAME Type = 5
-	TypeSOA   Type = 6
-	TypePTR   Type = 12
-	TypeMX    Type = 15
-	TypeTXT   Type = 16
-	TypeAAAA  Type = 28
-	TypeSRV   Type = 33
-	TypeOPT   Type = 41
-	TypeSVCB  Type = 64
-	TypeHTTPS Type = 65
+	TypeA      Type = 1
+	TypeNS     Type = 2
+	TypeCNAME  Type = 5
+	TypeSOA    Type = 6
+	TypePTR    Type = 12
+	TypeMX     Type = 15
+	TypeTXT    Type = 16
+	TypeAAAA   Type = 28
+	TypeSRV    Type = 33
+	TypeOPT    Type = 41
+	TypeDS     Type = 43
+	TypeRRSIG  Type = 46
+	TypeNSEC   Type = 47
+	TypeDNSKEY Type = 48
+	TypeNSEC3  Type = 50
+	TypeSVCB   Type = 64
+	TypeHTTPS  Type = 65
 
 	// Question.Type
 	TypeWKS   Type = 11
@@ -62,23 +67,28 @@ const (
 )
 
 var typeNames = map[Type]string{
-	TypeA:     "TypeA",
-	TypeNS:    "TypeNS",
-	TypeCNAME: "TypeCNAME",
-	TypeSOA:   "TypeSOA",
-	TypePTR:   "TypePTR",
-	TypeMX:    "TypeMX",
-	TypeTXT:   "TypeTXT",
-	TypeAAAA:  "TypeAAAA",
-	TypeSRV:   "TypeSRV",
-	TypeOPT:   "TypeOPT",
-	TypeSVCB:  "TypeSVCB",
-	TypeHTTPS: "TypeHTTPS",
-	TypeWKS:   "TypeWKS",
-	TypeHINFO: "TypeHINFO",
-	TypeMINFO: "TypeMINFO",
-	TypeAXFR:  "TypeAXFR",
-	TypeALL:   "TypeALL",
+	TypeA:      "TypeA",
+	TypeNS:     "TypeNS",
+	TypeCNAME:  "TypeCNAME",
+	TypeSOA:    "TypeSOA",
+	TypePTR:    "TypePTR",
+	TypeMX:     "TypeMX",
+	TypeTXT:    "TypeTXT",
+	TypeAAAA:   "TypeAAAA",
+	TypeSRV:    "TypeSRV",
+	TypeOPT:    "TypeOPT",
+	TypeDS:     "TypeDS",
+	TypeRRSIG:  "TypeRRSIG",
+	TypeNSEC:   "TypeNSEC",
+	TypeDNSKEY: "TypeDNSKEY",
+	TypeNSEC3:  "TypeNSEC3",
+	TypeSVCB:   "TypeSVCB",
+	TypeHTTPS:  "TypeHTTPS",
+	TypeWKS:    "TypeWKS",
+	TypeHINFO:  "TypeHINFO",
+	TypeMINFO:  "TypeMINFO",
+	TypeAXFR:   "TypeAXFR",
+	TypeALL:    "TypeALL",
 }
 
 // String implements fmt.Stringer.String.
@@ -2304,6 +2314,31 @@ func unpackResourceBody(msg []byte, off int, hdr ResourceHeader) (ResourceBody,
 		rb, err = unpackOPTResource(msg, off, hdr.Length)
 		r = &rb
 		name = "OPT"
+	case TypeDS:
+		var rb DSResource
+		rb, err = unpackDSResource(msg, off, hdr.Length)
+		r = &rb
+		name = "DS"
+	case TypeRRSIG:
+		var rb RRSIGResource
+		rb, err = unpackRRSIGResource(msg, off, hdr.Length)
+		r = &rb
+		name = "RRSIG"
+	case TypeNSEC:
+		var rb NSECResource
+		rb, err = unpackNSECResource(msg, off, hdr.Length)
+		r = &rb
+		name = "NSEC"
+	case TypeDNSKEY:
+		var rb DNSKEYResource
+		rb, err = unpackDNSKEYResource(msg, off, hdr.Length)
+		r = &rb
+		name = "DNSKEY"
+	case TypeNSEC3:
+		var rb NSEC3Resource
+		rb, err = unpackNSEC3Resource(msg, off, hdr.Length)
+		r = &rb
+		name = "NSEC3"
 	default:
 		var rb UnknownResource
 		rb, err = unpackUnknownResource(hdr.Type, msg, off, hdr.Length)
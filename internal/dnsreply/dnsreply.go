@@ -0,0 +1,45 @@
+// Package dnsreply builds responses to queries answered locally.
+package dnsreply
+
+import (
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+// Write writes a response to q with the given rcode and records in buf and
+// returns its length. The header and question are copied from the query.
+func Write(q query.Query, buf []byte, rcode dnsmessage.RCode, authoritative bool, answers, authorities []dnsmessage.Resource) (int, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(q.Payload)
+	if err != nil {
+		return 0, err
+	}
+	q1, err := p.Question()
+	if err != nil {
+		return 0, err
+	}
+	h.Response = true
+	h.Authoritative = authoritative
+	h.RecursionAvailable = true
+	h.Truncated = false
+	h.AuthenticData = false
+	h.RCode = rcode
+	b := dnsmessage.NewBuilder(buf[:0], h)
+	b.EnableCompression()
+	_ = b.StartQuestions()
+	_ = b.Question(q1)
+	_ = b.StartAnswers()
+	for _, rr := range answers {
+		if err = b.Resource(rr); err != nil {
+			return 0, err
+		}
+	}
+	_ = b.StartAuthorities()
+	for _, rr := range authorities {
+		if err = b.Resource(rr); err != nil {
+			return 0, err
+		}
+	}
+	buf, err = b.Finish()
+	return len(buf), err
+}
@@ -0,0 +1,81 @@
+// Package dnstest provides helpers to test resolvers.
+package dnstest
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+// NewQuery returns an INET query for name and typ from 127.0.0.1.
+func NewQuery(t testing.TB, name string, typ dnsmessage.Type) query.Query {
+	t.Helper()
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(name),
+		Type:  typ,
+		Class: dnsmessage.ClassINET,
+	})
+	payload, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := query.New(payload, net.ParseIP("127.0.0.1"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+// Result is the rcode and records of a response, formatted by RRString.
+type Result struct {
+	RCode       dnsmessage.RCode
+	Answers     []string
+	Authorities []string
+}
+
+// ParseResult parses the response msg.
+func ParseResult(t testing.TB, msg []byte) Result {
+	t.Helper()
+	var m dnsmessage.Message
+	if err := m.Unpack(msg); err != nil {
+		t.Fatal(err)
+	}
+	r := Result{RCode: m.Header.RCode}
+	for _, rr := range m.Answers {
+		r.Answers = append(r.Answers, RRString(rr))
+	}
+	for _, rr := range m.Authorities {
+		r.Authorities = append(r.Authorities, RRString(rr))
+	}
+	return r
+}
+
+// RRString formats rr as "NAME TYPE VALUE TTL".
+func RRString(rr dnsmessage.Resource) string {
+	var v string
+	switch b := rr.Body.(type) {
+	case *dnsmessage.AResource:
+		v = net.IP(b.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		v = net.IP(b.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		v = b.CNAME.String()
+	case *dnsmessage.PTRResource:
+		v = b.PTR.String()
+	case *dnsmessage.MXResource:
+		v = b.MX.String()
+	case *dnsmessage.SRVResource:
+		v = b.Target.String()
+	case *dnsmessage.TXTResource:
+		v = strings.Join(b.TXT, "|")
+	case *dnsmessage.SOAResource:
+		v = b.NS.String()
+	}
+	return strings.Join([]string{rr.Header.Name.String(), strings.TrimPrefix(rr.Header.Type.String(), "Type"), v, strconv.FormatUint(uint64(rr.Header.TTL), 10)}, " ")
+}
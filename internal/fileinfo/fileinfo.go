@@ -0,0 +1,41 @@
+// Package fileinfo tracks the changes of files by modification time and size.
+package fileinfo
+
+import (
+	"os"
+	"time"
+)
+
+// Info is the state of a file.
+type Info struct {
+	Path  string
+	mtime time.Time
+	size  int64
+}
+
+// Get returns the current state of the file at path. On error, the returned
+// Info records path as a missing file.
+func Get(path string) (fi Info, err error) {
+	fi.Path = path
+	st, err := os.Stat(path)
+	if err != nil {
+		return fi, err
+	}
+	fi.mtime = st.ModTime()
+	fi.size = st.Size()
+	return
+}
+
+// Equal returns true if the file at path is still in the state of fi. A file
+// missing in both states is unchanged.
+func (fi Info) Equal(path string) bool {
+	if fi.Path != path {
+		return false
+	}
+	fi2, err := Get(path)
+	if err != nil {
+		// Still missing.
+		return fi.mtime.IsZero()
+	}
+	return fi.mtime.Equal(fi2.mtime) && fi.size == fi2.size
+}
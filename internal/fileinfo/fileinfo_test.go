@@ -0,0 +1,48 @@
+package fileinfo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInfo_Equal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if (Info{}).Equal(path) {
+		t.Error("zero Info is equal")
+	}
+	fi, err := Get(path)
+	if err == nil {
+		t.Fatal("Get() on a missing file: nil error")
+	}
+	if !fi.Equal(path) {
+		t.Error("missing file changed")
+	}
+	if err := os.WriteFile(path, []byte("a"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if fi.Equal(path) {
+		t.Error("created file unchanged")
+	}
+	if fi, err = Get(path); err != nil {
+		t.Fatal(err)
+	}
+	if !fi.Equal(path) {
+		t.Error("file changed")
+	}
+	if fi.Equal(path + ".other") {
+		t.Error("other path equal")
+	}
+	if err := os.WriteFile(path, []byte("ab"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if fi.Equal(path) {
+		t.Error("modified file unchanged")
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if fi.Equal(path) {
+		t.Error("removed file unchanged")
+	}
+}
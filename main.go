@@ -39,11 +39,15 @@ func init() {
 		{"deactivate", activation, "restore the resolver configuration"},
 
 		{"discovered", ctlCmd, "display discovered clients"},
+		{"clients", ctlCmd, "display the clients of the network merged by MAC address"},
+		{"events", ctlEvents, "stream control events like new clients joining the network"},
+		{"reload", ctlCmd, "reload the configuration without restarting"},
 		{"cache-stats", ctlCmd, "display cache statistics"},
 		{"cache-keys", ctlCmd, "dump the list of cached entries"},
 		{"trace", ctlCmd, "display a stack trace dump"},
 		{"arp", ctlCmd, "dump the ARP table"},
 		{"ndp", ctlCmd, "dump the NDP table"},
+		{"throttled", ctlCmd, "display rate limited clients"},
 
 		{"version", showVersion, "show current version"},
 
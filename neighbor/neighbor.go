@@ -0,0 +1,138 @@
+// Package neighbor keeps the arp and ndp tables up to date with the neighbor
+// events of the kernel and actively resolves the MAC address of unknown
+// peers.
+package neighbor
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nextdns/nextdns/arp"
+	"github.com/nextdns/nextdns/ndp"
+	"github.com/nextdns/nextdns/netstatus"
+)
+
+// solicitInterval is the minimum interval between two resolutions of the same
+// address.
+const solicitInterval = time.Minute
+
+var (
+	watching atomic.Bool
+
+	// links is the current snapshot of the local interfaces.
+	links atomic.Pointer[linkInfo]
+
+	mu        sync.Mutex
+	solicited = map[string]time.Time{}
+)
+
+// linkInfo holds the networks and addresses of the local interfaces.
+type linkInfo struct {
+	nets  []*net.IPNet
+	local map[string]struct{}
+}
+
+// Watching returns true if Watch is running.
+func Watching() bool {
+	return watching.Load()
+}
+
+// Resolve asks the kernel for the neighbor entry of ip in the background,
+// soliciting ip if not known yet, so its MAC address is found in the arp or
+// ndp table by later queries. It does nothing if Watch is not running, if ip
+// is not on a local network, is an address of the host, or was resolved less
+// than a minute ago.
+func Resolve(ip net.IP) {
+	if !watching.Load() || ip == nil || ip.IsLoopback() || !isOnLink(ip) {
+		return
+	}
+	key := ip.String()
+	now := time.Now()
+	mu.Lock()
+	if now.Sub(solicited[key]) < solicitInterval {
+		mu.Unlock()
+		return
+	}
+	for k, t := range solicited {
+		if now.Sub(t) >= solicitInterval {
+			delete(solicited, k)
+		}
+	}
+	solicited[key] = now
+	mu.Unlock()
+
+	go func() {
+		if mac := lookup(ip); mac != nil {
+			return
+		}
+		if !ip.IsLinkLocalUnicast() || ip.To4() != nil {
+			// IPv6 link-local addresses cannot be solicited without a zone.
+			_ = solicit(ip)
+		}
+	}()
+}
+
+// update records mac as the MAC address of ip in the arp or ndp table.
+func update(ip net.IP, mac net.HardwareAddr) {
+	if ip.To4() != nil {
+		arp.Set(ip, mac)
+	} else {
+		ndp.Set(ip, mac)
+	}
+}
+
+// isOnLink returns true if ip is part of the network of one of the local
+// interfaces or is an IPv6 link-local address, and is not an address of the
+// host.
+func isOnLink(ip net.IP) bool {
+	l := links.Load()
+	if l == nil {
+		l = refreshLinks()
+	}
+	if _, found := l.local[ip.String()]; found {
+		return false
+	}
+	if ip.IsLinkLocalUnicast() && ip.To4() == nil {
+		return true
+	}
+	for _, n := range l.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshLinks updates and returns the snapshot of the local interfaces.
+func refreshLinks() *linkInfo {
+	l := &linkInfo{local: map[string]struct{}{}}
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+			l.nets = append(l.nets, ipNet)
+			l.local[ipNet.IP.String()] = struct{}{}
+		}
+	}
+	links.Store(l)
+	return l
+}
+
+// watchLinks refreshes the snapshot of the local interfaces on network
+// changes until ctx is cancelled.
+func watchLinks(ctx context.Context) {
+	refreshLinks()
+	ch := make(chan netstatus.Change, 1)
+	netstatus.Notify(ch)
+	defer netstatus.Stop(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+			refreshLinks()
+		}
+	}
+}
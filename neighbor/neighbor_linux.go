@@ -0,0 +1,140 @@
+package neighbor
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// validStates are the states of the neighbor entries with a usable MAC
+// address.
+const validStates = unix.NUD_REACHABLE | unix.NUD_STALE | unix.NUD_DELAY | unix.NUD_PROBE | unix.NUD_PERMANENT
+
+// Watch listens for the RTM_NEWNEIGH events of the kernel and records the
+// resolved neighbors in the arp and ndp tables until ctx is cancelled.
+func Watch(ctx context.Context) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("netlink socket: %v", err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: unix.RTMGRP_NEIGH}); err != nil {
+		return fmt.Errorf("netlink bind: %v", err)
+	}
+	// Wake up every second to check for ctx cancellation.
+	tv := unix.Timeval{Sec: 1}
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		return fmt.Errorf("netlink timeout: %v", err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go watchLinks(ctx)
+	watching.Store(true)
+	defer watching.Store(false)
+	buf := make([]byte, 1<<16)
+	for ctx.Err() == nil {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) || errors.Is(err, unix.ENOBUFS) {
+				// ENOBUFS means events were lost, the tables are still
+				// refreshed periodically.
+				continue
+			}
+			return fmt.Errorf("netlink read: %v", err)
+		}
+		handleMessages(buf[:n], nil)
+	}
+	return nil
+}
+
+// lookup asks the kernel for the neighbor table of the family of ip, records
+// it and returns the MAC address of ip if found.
+func lookup(ip net.IP) net.HardwareAddr {
+	family := unix.AF_INET6
+	if ip.To4() != nil {
+		family = unix.AF_INET
+	}
+	b, err := syscall.NetlinkRIB(unix.RTM_GETNEIGH, family)
+	if err != nil {
+		return nil
+	}
+	return handleMessages(b, ip)
+}
+
+// handleMessages records the neighbors of the RTM_NEWNEIGH messages of b and
+// returns the MAC address of ip if part of them.
+func handleMessages(b []byte, ip net.IP) (mac net.HardwareAddr) {
+	msgs, err := syscall.ParseNetlinkMessage(b)
+	if err != nil {
+		return nil
+	}
+	for _, m := range msgs {
+		if m.Header.Type != unix.RTM_NEWNEIGH {
+			continue
+		}
+		if nip, nmac, ok := parseNeighbor(m.Data); ok {
+			update(nip, nmac)
+			if ip != nil && nip.Equal(ip) {
+				mac = nmac
+			}
+		}
+	}
+	return mac
+}
+
+// parseNeighbor returns the address and MAC address of a neighbor message if
+// resolved.
+func parseNeighbor(b []byte) (ip net.IP, mac net.HardwareAddr, ok bool) {
+	if len(b) < unix.SizeofNdMsg {
+		return nil, nil, false
+	}
+	family := b[0]
+	state := binary.NativeEndian.Uint16(b[8:10])
+	if state&validStates == 0 {
+		return nil, nil, false
+	}
+	attrs := b[unix.SizeofNdMsg:]
+	for len(attrs) >= unix.SizeofRtAttr {
+		l := int(binary.NativeEndian.Uint16(attrs[0:2]))
+		typ := binary.NativeEndian.Uint16(attrs[2:4])
+		if l < unix.SizeofRtAttr || l > len(attrs) {
+			break
+		}
+		v := attrs[unix.SizeofRtAttr:l]
+		switch typ {
+		case unix.NDA_DST:
+			ip = net.IP(append([]byte(nil), v...))
+		case unix.NDA_LLADDR:
+			mac = net.HardwareAddr(append([]byte(nil), v...))
+		}
+		l = (l + unix.RTA_ALIGNTO - 1) &^ (unix.RTA_ALIGNTO - 1)
+		if l > len(attrs) {
+			break
+		}
+		attrs = attrs[l:]
+	}
+	switch {
+	case family == unix.AF_INET && len(ip) == net.IPv4len:
+	case family == unix.AF_INET6 && len(ip) == net.IPv6len:
+	default:
+		return nil, nil, false
+	}
+	return ip, mac, len(mac) == 6
+}
+
+// solicit sends an empty datagram to ip so the kernel resolves its MAC
+// address.
+func solicit(ip net.IP) error {
+	c, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: ip, Port: 9})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_, err = c.Write(nil)
+	return err
+}
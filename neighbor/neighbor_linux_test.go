@@ -0,0 +1,66 @@
+package neighbor
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func newNeighborMessage(family uint8, state uint16, ip net.IP, mac net.HardwareAddr) []byte {
+	b := make([]byte, unix.SizeofNdMsg)
+	b[0] = family
+	binary.NativeEndian.PutUint16(b[8:10], state)
+	attr := func(typ uint16, v []byte) {
+		a := make([]byte, unix.SizeofRtAttr, unix.SizeofRtAttr+len(v)+3)
+		binary.NativeEndian.PutUint16(a[0:2], uint16(unix.SizeofRtAttr+len(v)))
+		binary.NativeEndian.PutUint16(a[2:4], typ)
+		a = append(a, v...)
+		for len(a)%unix.RTA_ALIGNTO != 0 {
+			a = append(a, 0)
+		}
+		b = append(b, a...)
+	}
+	attr(unix.NDA_DST, ip)
+	if mac != nil {
+		attr(unix.NDA_LLADDR, mac)
+	}
+	return b
+}
+
+func Test_parseNeighbor(t *testing.T) {
+	mac, _ := net.ParseMAC("00:1c:42:2e:60:4a")
+	ip4 := net.ParseIP("192.168.1.10").To4()
+	ip6 := net.ParseIP("fd00::10")
+	tests := []struct {
+		name   string
+		msg    []byte
+		wantIP string
+		wantOK bool
+	}{
+		{"ipv4 reachable", newNeighborMessage(unix.AF_INET, unix.NUD_REACHABLE, ip4, mac), "192.168.1.10", true},
+		{"ipv6 stale", newNeighborMessage(unix.AF_INET6, unix.NUD_STALE, ip6, mac), "fd00::10", true},
+		{"incomplete", newNeighborMessage(unix.AF_INET, unix.NUD_INCOMPLETE, ip4, nil), "", false},
+		{"failed", newNeighborMessage(unix.AF_INET, unix.NUD_FAILED, ip4, mac), "", false},
+		{"family mismatch", newNeighborMessage(unix.AF_INET, unix.NUD_REACHABLE, ip6, mac), "", false},
+		{"short", []byte{unix.AF_INET, 0, 0}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, gotMAC, ok := parseNeighbor(tt.msg)
+			if ok != tt.wantOK {
+				t.Fatalf("parseNeighbor() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if ip.String() != tt.wantIP {
+				t.Errorf("parseNeighbor() ip = %v, want %v", ip, tt.wantIP)
+			}
+			if gotMAC.String() != mac.String() {
+				t.Errorf("parseNeighbor() mac = %v, want %v", gotMAC, mac)
+			}
+		})
+	}
+}
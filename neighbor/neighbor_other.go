@@ -0,0 +1,22 @@
+//go:build !linux
+
+package neighbor
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// Watch is only supported on Linux.
+func Watch(ctx context.Context) error {
+	return errors.New("neighbor events not supported on this platform")
+}
+
+func lookup(ip net.IP) net.HardwareAddr {
+	return nil
+}
+
+func solicit(ip net.IP) error {
+	return errors.New("not supported")
+}
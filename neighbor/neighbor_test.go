@@ -0,0 +1,57 @@
+package neighbor
+
+import (
+	"net"
+	"testing"
+)
+
+func setLinks(t *testing.T, cidrs ...string) {
+	t.Helper()
+	l := &linkInfo{local: map[string]struct{}{}}
+	for _, cidr := range cidrs {
+		ip, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.nets = append(l.nets, n)
+		l.local[ip.String()] = struct{}{}
+	}
+	old := links.Swap(l)
+	t.Cleanup(func() { links.Store(old) })
+}
+
+func Test_isOnLink(t *testing.T) {
+	setLinks(t, "192.168.1.1/24", "fd00::1/64")
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"192.168.1.10", true},
+		{"192.168.1.1", false}, // host address
+		{"10.0.0.1", false},
+		{"fd00::10", true},
+		{"fd00::1", false},
+		{"fe80::1", true},
+		{"2001:db8::1", false},
+	}
+	for _, tt := range tests {
+		if got := isOnLink(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isOnLink(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestResolve_notOnLink(t *testing.T) {
+	setLinks(t, "192.168.1.1/24")
+	watching.Store(true)
+	defer watching.Store(false)
+	for _, ip := range []string{"192.0.2.1", "192.168.1.1"} {
+		Resolve(net.ParseIP(ip))
+		mu.Lock()
+		_, found := solicited[ip]
+		mu.Unlock()
+		if found {
+			t.Errorf("Resolve(%s): solicited", ip)
+		}
+	}
+}
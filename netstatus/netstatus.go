@@ -9,14 +9,82 @@ import (
 	"time"
 )
 
-type Change string
+// Kind is the kind of a network change.
+type Kind int
+
+const (
+	// NoChange is the zero Kind, of a Change reporting no change.
+	NoChange Kind = iota
+	LinkAdded
+	LinkRemoved
+	LinkUp
+	LinkDown
+	LinkFlagsChanged
+	AddrAdded
+	AddrRemoved
+	DefaultRouteChanged
+
+	// Unknown is reported when some changes could not be determined.
+	Unknown
+)
+
+// Change describes a change of the network configuration.
+type Change struct {
+	Kind Kind
+
+	// Interface is the name of the interface the change applies to.
+	Interface string
+
+	// Addr is the address added or removed in CIDR notation, for AddrAdded
+	// and AddrRemoved changes.
+	Addr string
+
+	// Gateway is the new default gateway for DefaultRouteChanged changes,
+	// empty if there is no default route anymore for its address family.
+	Gateway string
+
+	// detail describes LinkFlagsChanged changes.
+	detail string
+}
 
 func (c Change) Changed() bool {
-	return c != ""
+	return c.Kind != NoChange
 }
 
 func (c Change) String() string {
-	return string(c)
+	var s string
+	switch c.Kind {
+	case LinkAdded:
+		s = "added"
+	case LinkRemoved:
+		s = "removed"
+	case LinkUp:
+		s = "up"
+	case LinkDown:
+		s = "down"
+	case LinkFlagsChanged:
+		s = c.detail
+	case AddrAdded:
+		s = c.Addr + " added"
+	case AddrRemoved:
+		s = c.Addr + " removed"
+	case DefaultRouteChanged:
+		if c.Gateway == "" && c.Interface == "" {
+			return "default route removed"
+		}
+		s = "default route"
+		if c.Gateway != "" {
+			s += " via " + c.Gateway
+		}
+	case Unknown:
+		return "network changed"
+	default:
+		return ""
+	}
+	if c.Interface != "" {
+		s = c.Interface + " " + s
+	}
+	return s
 }
 
 var handlers struct {
@@ -29,6 +97,9 @@ var stateMu sync.Mutex
 var prevInterfaces []net.Interface
 
 // Notify sends a Change to c any time the network interfaces status change.
+// Changes are watched using netlink events on Linux, and by polling the
+// interfaces every 10 seconds on other platforms or if netlink is not
+// available.
 func Notify(c chan<- Change) {
 	handlers.Lock()
 	defer handlers.Unlock()
@@ -74,6 +145,15 @@ func broadcast(c Change) {
 }
 
 func startChecker(ctx context.Context) {
+	if err := watch(ctx, broadcast); err == nil || ctx.Err() != nil {
+		return
+	}
+	poll(ctx)
+}
+
+// poll checks the interfaces for changes every 10 seconds until ctx is
+// cancelled.
+func poll(ctx context.Context) {
 	tick := time.NewTicker(10 * time.Second)
 	defer tick.Stop()
 	_, _ = changed() // init
@@ -95,16 +175,16 @@ func changed() (Change, error) {
 
 	newInterfaces, err := net.Interfaces()
 	if err != nil {
-		return "", err
+		return Change{}, err
 	}
-	c := Change(diff(prevInterfaces, newInterfaces))
+	c := diff(prevInterfaces, newInterfaces)
 	prevInterfaces = newInterfaces
 	return c, nil
 }
 
-func diff(old, new []net.Interface) string {
+func diff(old, new []net.Interface) Change {
 	if old == nil || new == nil {
-		return ""
+		return Change{}
 	}
 	sort.Slice(old, func(i, j int) bool {
 		return old[i].Name < old[j].Name
@@ -118,38 +198,43 @@ func diff(old, new []net.Interface) string {
 	}
 	for i := 0; i < l; i++ {
 		if len(old) <= i {
-			return fmt.Sprintf("%s added", new[i].Name)
+			return Change{Kind: LinkAdded, Interface: new[i].Name}
 		}
 		if len(new) <= i {
-			return fmt.Sprintf("%s removed", old[i].Name)
+			return Change{Kind: LinkRemoved, Interface: old[i].Name}
 		}
 		if old[i].Name != new[i].Name {
 			if old[i].Name < new[i].Name {
-				return fmt.Sprintf("%s removed", old[i].Name)
+				return Change{Kind: LinkRemoved, Interface: old[i].Name}
 			}
-			return fmt.Sprintf("%s added", new[i].Name)
+			return Change{Kind: LinkAdded, Interface: new[i].Name}
 		}
 		if old[i].Flags != new[i].Flags {
 			oldUp := old[i].Flags&net.FlagUp != 0
 			newUp := new[i].Flags&net.FlagUp != 0
 			if oldUp != newUp {
 				if oldUp && !newUp {
-					return fmt.Sprintf("%s down", new[i].Name)
+					return Change{Kind: LinkDown, Interface: new[i].Name}
 				}
-				return fmt.Sprintf("%s up", new[i].Name)
+				return Change{Kind: LinkUp, Interface: new[i].Name}
+			}
+			return Change{
+				Kind:      LinkFlagsChanged,
+				Interface: new[i].Name,
+				detail:    fmt.Sprintf("flag %v -> %v", old[i].Flags, new[i].Flags),
 			}
-			return fmt.Sprintf("%s flag %v -> %v", new[i].Name, old[i].Flags, new[i].Flags)
 		}
 		oldAddrs, _ := old[i].Addrs()
 		newAddrs, _ := new[i].Addrs()
-		if d := diffAddrs(oldAddrs, newAddrs); d != "" {
-			return fmt.Sprintf("%s %s", new[i].Name, d)
+		if c := diffAddrs(oldAddrs, newAddrs); c.Changed() {
+			c.Interface = new[i].Name
+			return c
 		}
 	}
-	return ""
+	return Change{}
 }
 
-func diffAddrs(oldAddrs, newAddrs []net.Addr) string {
+func diffAddrs(oldAddrs, newAddrs []net.Addr) Change {
 oldIP:
 	for _, oip := range oldAddrs {
 		for _, nip := range newAddrs {
@@ -157,7 +242,7 @@ oldIP:
 				continue oldIP
 			}
 		}
-		return fmt.Sprintf("%s removed", oip)
+		return Change{Kind: AddrRemoved, Addr: oip.String()}
 	}
 	if len(oldAddrs) != len(newAddrs) {
 	newIP:
@@ -167,8 +252,8 @@ oldIP:
 					continue newIP
 				}
 			}
-			return fmt.Sprintf("%s added", nip)
+			return Change{Kind: AddrAdded, Addr: nip.String()}
 		}
 	}
-	return ""
+	return Change{}
 }
@@ -0,0 +1,265 @@
+package netstatus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// watch sends to emit the changes of links, addresses and default routes
+// reported by netlink until ctx is cancelled. It returns an error if netlink
+// cannot be used.
+func watch(ctx context.Context, emit func(Change)) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("netlink socket: %v", err)
+	}
+	defer unix.Close(fd)
+	groups := uint32(unix.RTMGRP_LINK | unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR |
+		unix.RTMGRP_IPV4_ROUTE | unix.RTMGRP_IPV6_ROUTE)
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: groups}); err != nil {
+		return fmt.Errorf("netlink bind: %v", err)
+	}
+	// Wake up every second to check for ctx cancellation.
+	tv := unix.Timeval{Sec: 1}
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		return fmt.Errorf("netlink timeout: %v", err)
+	}
+
+	// Load the current state without reporting it. Events received in the
+	// meantime are queued on the socket.
+	w := newWatcher()
+	for _, req := range []int{unix.RTM_GETLINK, unix.RTM_GETADDR, unix.RTM_GETROUTE} {
+		b, err := syscall.NetlinkRIB(req, unix.AF_UNSPEC)
+		if err != nil {
+			return fmt.Errorf("netlink dump: %v", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(b)
+		if err != nil {
+			return fmt.Errorf("netlink dump: %v", err)
+		}
+		w.handle(msgs, func(Change) {})
+	}
+
+	buf := make([]byte, 1<<16)
+	for ctx.Err() == nil {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+				continue
+			}
+			if errors.Is(err, unix.ENOBUFS) {
+				// Events were lost.
+				emit(Change{Kind: Unknown})
+				continue
+			}
+			return fmt.Errorf("netlink read: %v", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		w.handle(msgs, emit)
+	}
+	return nil
+}
+
+type link struct {
+	name string
+	up   bool
+}
+
+type route struct {
+	iface   string
+	gateway string
+	metric  uint32
+}
+
+// watcher tracks the state of the network to turn netlink messages, which are
+// also sent when nothing relevant changed, into changes.
+type watcher struct {
+	links  map[int32]link
+	addrs  map[string]struct{}
+	routes map[uint8]map[string]route // default routes by family
+}
+
+func newWatcher() *watcher {
+	return &watcher{
+		links:  map[int32]link{},
+		addrs:  map[string]struct{}{},
+		routes: map[uint8]map[string]route{},
+	}
+}
+
+func (w *watcher) handle(msgs []syscall.NetlinkMessage, emit func(Change)) {
+	for i := range msgs {
+		m := &msgs[i]
+		var c Change
+		switch m.Header.Type {
+		case unix.RTM_NEWLINK, unix.RTM_DELLINK:
+			c = w.handleLink(m)
+		case unix.RTM_NEWADDR, unix.RTM_DELADDR:
+			c = w.handleAddr(m)
+		case unix.RTM_NEWROUTE, unix.RTM_DELROUTE:
+			c = w.handleRoute(m)
+		}
+		if c.Changed() {
+			emit(c)
+		}
+	}
+}
+
+func (w *watcher) handleLink(m *syscall.NetlinkMessage) Change {
+	if len(m.Data) < unix.SizeofIfInfomsg {
+		return Change{}
+	}
+	index := int32(binary.NativeEndian.Uint32(m.Data[4:8]))
+	flags := binary.NativeEndian.Uint32(m.Data[8:12])
+	old, known := w.links[index]
+	if m.Header.Type == unix.RTM_DELLINK {
+		if !known {
+			return Change{}
+		}
+		delete(w.links, index)
+		return Change{Kind: LinkRemoved, Interface: old.name}
+	}
+	attrs, err := syscall.ParseNetlinkRouteAttr(m)
+	if err != nil {
+		return Change{}
+	}
+	l := link{name: old.name, up: flags&unix.IFF_UP != 0 && flags&unix.IFF_RUNNING != 0}
+	for _, a := range attrs {
+		if a.Attr.Type == unix.IFLA_IFNAME {
+			l.name = strings.TrimRight(string(a.Value), "\x00")
+		}
+	}
+	w.links[index] = l
+	switch {
+	case !known:
+		return Change{Kind: LinkAdded, Interface: l.name}
+	case l.up && !old.up:
+		return Change{Kind: LinkUp, Interface: l.name}
+	case !l.up && old.up:
+		return Change{Kind: LinkDown, Interface: l.name}
+	}
+	return Change{}
+}
+
+func (w *watcher) handleAddr(m *syscall.NetlinkMessage) Change {
+	if len(m.Data) < unix.SizeofIfAddrmsg {
+		return Change{}
+	}
+	prefixLen := int(m.Data[1])
+	index := int32(binary.NativeEndian.Uint32(m.Data[4:8]))
+	attrs, err := syscall.ParseNetlinkRouteAttr(m)
+	if err != nil {
+		return Change{}
+	}
+	var ip net.IP
+	for _, a := range attrs {
+		// IFA_LOCAL is the address of point to point interfaces, for which
+		// IFA_ADDRESS is the address of the remote end.
+		if a.Attr.Type == unix.IFA_LOCAL || (a.Attr.Type == unix.IFA_ADDRESS && ip == nil) {
+			ip = net.IP(a.Value)
+		}
+	}
+	if ip == nil {
+		return Change{}
+	}
+	addr := (&net.IPNet{IP: ip, Mask: net.CIDRMask(prefixLen, len(ip)*8)}).String()
+	key := strconv.Itoa(int(index)) + " " + addr
+	_, known := w.addrs[key]
+	c := Change{Interface: w.linkName(index), Addr: addr}
+	switch {
+	case m.Header.Type == unix.RTM_NEWADDR && !known:
+		w.addrs[key] = struct{}{}
+		c.Kind = AddrAdded
+	case m.Header.Type == unix.RTM_DELADDR && known:
+		delete(w.addrs, key)
+		c.Kind = AddrRemoved
+	}
+	return c
+}
+
+func (w *watcher) handleRoute(m *syscall.NetlinkMessage) Change {
+	if len(m.Data) < unix.SizeofRtMsg {
+		return Change{}
+	}
+	family, dstLen, table, typ := m.Data[0], m.Data[1], uint32(m.Data[4]), m.Data[7]
+	if dstLen != 0 || typ != unix.RTN_UNICAST {
+		return Change{}
+	}
+	attrs, err := syscall.ParseNetlinkRouteAttr(m)
+	if err != nil {
+		return Change{}
+	}
+	var r route
+	for _, a := range attrs {
+		switch a.Attr.Type {
+		case unix.RTA_TABLE:
+			if len(a.Value) >= 4 {
+				table = binary.NativeEndian.Uint32(a.Value)
+			}
+		case unix.RTA_GATEWAY:
+			r.gateway = net.IP(a.Value).String()
+		case unix.RTA_OIF:
+			if len(a.Value) >= 4 {
+				r.iface = w.linkName(int32(binary.NativeEndian.Uint32(a.Value)))
+			}
+		case unix.RTA_PRIORITY:
+			if len(a.Value) >= 4 {
+				r.metric = binary.NativeEndian.Uint32(a.Value)
+			}
+		}
+	}
+	if table != unix.RT_TABLE_MAIN {
+		return Change{}
+	}
+	routes := w.routes[family]
+	if routes == nil {
+		routes = map[string]route{}
+		w.routes[family] = routes
+	}
+	before := defaultRoute(routes)
+	key := r.iface + " " + r.gateway + " " + strconv.FormatUint(uint64(r.metric), 10)
+	if m.Header.Type == unix.RTM_NEWROUTE {
+		routes[key] = r
+	} else {
+		delete(routes, key)
+	}
+	after := defaultRoute(routes)
+	if after == before {
+		return Change{}
+	}
+	return Change{Kind: DefaultRouteChanged, Interface: after.iface, Gateway: after.gateway}
+}
+
+// defaultRoute returns the route with the lowest metric.
+func defaultRoute(routes map[string]route) (best route) {
+	found := false
+	for _, r := range routes {
+		if !found || r.metric < best.metric ||
+			(r.metric == best.metric && r.iface+r.gateway < best.iface+best.gateway) {
+			best = r
+			found = true
+		}
+	}
+	return best
+}
+
+func (w *watcher) linkName(index int32) string {
+	if l, found := w.links[index]; found && l.name != "" {
+		return l.name
+	}
+	if ifi, err := net.InterfaceByIndex(int(index)); err == nil {
+		return ifi.Name
+	}
+	return strconv.Itoa(int(index))
+}
@@ -0,0 +1,104 @@
+package netstatus
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func newNetlinkMessage(typ uint16, header []byte, attrs map[uint16][]byte) syscall.NetlinkMessage {
+	b := append([]byte(nil), header...)
+	for t, v := range attrs {
+		a := make([]byte, unix.SizeofRtAttr, unix.SizeofRtAttr+len(v)+3)
+		binary.NativeEndian.PutUint16(a[0:2], uint16(unix.SizeofRtAttr+len(v)))
+		binary.NativeEndian.PutUint16(a[2:4], t)
+		a = append(a, v...)
+		for len(a)%unix.RTA_ALIGNTO != 0 {
+			a = append(a, 0)
+		}
+		b = append(b, a...)
+	}
+	return syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: typ}, Data: b}
+}
+
+func linkMessage(typ uint16, index int32, flags uint32, name string) syscall.NetlinkMessage {
+	h := make([]byte, unix.SizeofIfInfomsg)
+	binary.NativeEndian.PutUint32(h[4:8], uint32(index))
+	binary.NativeEndian.PutUint32(h[8:12], flags)
+	return newNetlinkMessage(typ, h, map[uint16][]byte{unix.IFLA_IFNAME: []byte(name + "\x00")})
+}
+
+func addrMessage(typ uint16, index int32, addr string) syscall.NetlinkMessage {
+	ip, ipNet, _ := net.ParseCIDR(addr)
+	ones, _ := ipNet.Mask.Size()
+	h := make([]byte, unix.SizeofIfAddrmsg)
+	h[0] = unix.AF_INET
+	if ip.To4() != nil {
+		ip = ip.To4()
+	} else {
+		h[0] = unix.AF_INET6
+	}
+	h[1] = byte(ones)
+	binary.NativeEndian.PutUint32(h[4:8], uint32(index))
+	return newNetlinkMessage(typ, h, map[uint16][]byte{unix.IFA_ADDRESS: ip})
+}
+
+func routeMessage(typ uint16, oif int32, gateway string, metric uint32) syscall.NetlinkMessage {
+	h := make([]byte, unix.SizeofRtMsg)
+	h[0] = unix.AF_INET
+	h[4] = unix.RT_TABLE_MAIN
+	h[7] = unix.RTN_UNICAST
+	oifb := binary.NativeEndian.AppendUint32(nil, uint32(oif))
+	metricb := binary.NativeEndian.AppendUint32(nil, metric)
+	return newNetlinkMessage(typ, h, map[uint16][]byte{
+		unix.RTA_OIF:      oifb,
+		unix.RTA_GATEWAY:  net.ParseIP(gateway).To4(),
+		unix.RTA_PRIORITY: metricb,
+	})
+}
+
+func TestWatcher_handle(t *testing.T) {
+	const upFlags = unix.IFF_UP | unix.IFF_RUNNING
+	w := newWatcher()
+	// Initial state.
+	w.handle([]syscall.NetlinkMessage{
+		linkMessage(unix.RTM_NEWLINK, 2, upFlags, "eth0"),
+		addrMessage(unix.RTM_NEWADDR, 2, "192.168.1.10/24"),
+		routeMessage(unix.RTM_NEWROUTE, 2, "192.168.1.1", 100),
+	}, func(Change) {})
+
+	var got []string
+	w.handle([]syscall.NetlinkMessage{
+		linkMessage(unix.RTM_NEWLINK, 3, unix.IFF_UP, "wlan0"),
+		linkMessage(unix.RTM_NEWLINK, 3, unix.IFF_UP, "wlan0"),
+		linkMessage(unix.RTM_NEWLINK, 3, upFlags, "wlan0"),
+		addrMessage(unix.RTM_NEWADDR, 3, "10.0.0.5/16"),
+		addrMessage(unix.RTM_NEWADDR, 3, "10.0.0.5/16"),
+		routeMessage(unix.RTM_NEWROUTE, 3, "10.0.0.1", 600),
+		routeMessage(unix.RTM_NEWROUTE, 3, "10.0.0.1", 50),
+		routeMessage(unix.RTM_DELROUTE, 3, "10.0.0.1", 50),
+		routeMessage(unix.RTM_DELROUTE, 3, "10.0.0.1", 600),
+		routeMessage(unix.RTM_DELROUTE, 2, "192.168.1.1", 100),
+		addrMessage(unix.RTM_DELADDR, 2, "192.168.1.10/24"),
+		linkMessage(unix.RTM_NEWLINK, 2, unix.IFF_UP, "eth0"),
+		linkMessage(unix.RTM_DELLINK, 2, 0, "eth0"),
+	}, func(c Change) { got = append(got, c.String()) })
+	want := []string{
+		"wlan0 added",
+		"wlan0 up",
+		"wlan0 10.0.0.5/16 added",
+		"wlan0 default route via 10.0.0.1",
+		"eth0 default route via 192.168.1.1",
+		"default route removed",
+		"eth0 192.168.1.10/24 removed",
+		"eth0 down",
+		"eth0 removed",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changes =\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package netstatus
+
+import (
+	"context"
+	"errors"
+)
+
+// watch is only supported on Linux, the interfaces are polled on other
+// platforms.
+func watch(ctx context.Context, emit func(Change)) error {
+	return errors.New("not supported")
+}
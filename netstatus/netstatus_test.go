@@ -80,7 +80,7 @@ func Test_diff(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := diff(tt.old, tt.new); got != tt.want {
+			if got := diff(tt.old, tt.new).String(); got != tt.want {
 				t.Errorf("diff() = %v, want %v", got, tt.want)
 			}
 		})
@@ -118,7 +118,7 @@ func Test_diffAddrs(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := diffAddrs(tt.oldAddrs, tt.newAddrs); got != tt.want {
+			if got := diffAddrs(tt.oldAddrs, tt.newAddrs).String(); got != tt.want {
 				t.Errorf("diffAddrs() = %v, want %v", got, tt.want)
 			}
 		})
@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"net"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+// aclDeny checks the client against the ACL. If the client is denied, denied
+// is true and the response to send, if any, is written to buf.
+func (p Proxy) aclDeny(sourceIP, destIP net.IP, q query.Query, buf []byte) (n int, denied bool) {
+	if p.ACL == nil || p.ACL(sourceIP, destIP, aclMAC(sourceIP, q)) {
+		return 0, false
+	}
+	p.logInfof("acl: denied query from %s to %s", sourceIP, destIP)
+	if p.ACLDrop || q.Name == "" {
+		// Do not answer malformed queries from denied clients.
+		return 0, true
+	}
+	return replyRCode(dnsmessage.RCodeRefused, q, buf), true
+}
+
+// aclMAC returns the MAC address of the client to check against the ACL. The
+// EDNS0 MAC option can be forged by any client, so it is only trusted for
+// queries received over loopback from a local forwarder like dnsmasq.
+// Otherwise the MAC address found in the ARP and NDP tables is used.
+func aclMAC(sourceIP net.IP, q query.Query) net.HardwareAddr {
+	if sourceIP.IsLoopback() {
+		return q.MAC
+	}
+	return q.NeighborMAC
+}
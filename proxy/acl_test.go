@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+// newOptionsQuery returns a query from peerIP with the given EDNS0 options.
+func newOptionsQuery(t *testing.T, peerIP net.IP, opts ...dnsmessage.Option) query.Query {
+	t.Helper()
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1, RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName("example.com."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	_ = b.StartAdditionals()
+	_ = b.OPTResource(dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("."), Type: dnsmessage.TypeOPT, Class: 4096},
+		dnsmessage.OPTResource{Options: opts})
+	payload, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := query.New(payload, peerIP, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestProxy_aclDeny_forgedMAC(t *testing.T) {
+	allowed, _ := net.ParseMAC("00:1c:42:2e:60:4a")
+	p := Proxy{ACL: func(sourceIP, destIP net.IP, mac net.HardwareAddr) bool {
+		return mac.String() == allowed.String()
+	}}
+	buf := make([]byte, 512)
+
+	// A remote peer forging the MAC option of an allowed client.
+	remote := net.ParseIP("203.0.113.5")
+	if _, denied := p.aclDeny(remote, nil, newOptionsQuery(t, remote, dnsmessage.Option{Code: query.EDNS0_MAC, Data: allowed}), buf); !denied {
+		t.Error("remote peer with forged MAC option allowed")
+	}
+
+	// A local forwarder like dnsmasq adding the MAC of its client.
+	local := net.ParseIP("127.0.0.1")
+	if _, denied := p.aclDeny(local, nil, newOptionsQuery(t, local, dnsmessage.Option{Code: query.EDNS0_MAC, Data: allowed}), buf); denied {
+		t.Error("local forwarder with MAC option denied")
+	}
+}
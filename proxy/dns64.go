@@ -0,0 +1,278 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+// DefaultDNS64Prefix is the well-known prefix for IPv4/IPv6 translation
+// (RFC 6052).
+var DefaultDNS64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+
+var (
+	errNotHandled = errors.New("not handled")
+	errTooLarge   = errors.New("response too large")
+)
+
+// ipv4OnlyAddrs are the well-known IPv4 addresses of ipv4only.arpa (RFC 7050).
+var ipv4OnlyAddrs = [][4]byte{{192, 0, 0, 170}, {192, 0, 0, 171}}
+
+const ipv4OnlyTTL = 3600
+
+// ValidDNS64Prefix returns an error if prefix is not an IPv6 prefix with one
+// of the lengths allowed by RFC 6052.
+func ValidDNS64Prefix(prefix netip.Prefix) error {
+	if !prefix.Addr().Is6() || prefix.Addr().Is4In6() {
+		return fmt.Errorf("%s: not an IPv6 prefix", prefix)
+	}
+	switch prefix.Bits() {
+	case 32, 40, 48, 56, 64, 96:
+		return nil
+	}
+	return fmt.Errorf("%s: prefix length must be 32, 40, 48, 56, 64 or 96", prefix)
+}
+
+// dns64Embed returns the IPv6 address embedding ip4 in prefix (RFC 6052
+// section 2.2). Bits 64 to 71 (the "u" octet) are always zero.
+func dns64Embed(prefix netip.Prefix, ip4 [4]byte) [16]byte {
+	a := prefix.Masked().Addr().As16()
+	j := prefix.Bits() / 8
+	for _, b := range ip4 {
+		if j == 8 {
+			j++
+		}
+		a[j] = b
+		j++
+	}
+	return a
+}
+
+// dns64Extract returns the IPv4 address embedded in ip6 if ip6 is within
+// prefix.
+func dns64Extract(prefix netip.Prefix, ip6 netip.Addr) (ip4 [4]byte, ok bool) {
+	if !prefix.Contains(ip6) {
+		return ip4, false
+	}
+	a := ip6.As16()
+	j := prefix.Bits() / 8
+	for i := range ip4 {
+		if j == 8 {
+			j++
+		}
+		ip4[i] = a[j]
+		j++
+	}
+	return ip4, true
+}
+
+// dns64Enabled returns true if DNS64 applies to q.
+func (p Proxy) dns64Enabled(q query.Query) bool {
+	return p.DNS64Prefix.IsValid() && q.Class == query.ClassINET
+}
+
+// dns64Local answers queries DNS64 handles without the upstream response for
+// q: ipv4only.arpa and PTR queries within the DNS64 prefix.
+func (p Proxy) dns64Local(ctx context.Context, q query.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	qname := strings.ToLower(q.Name)
+	if qname == "ipv4only.arpa." && (q.Type == query.TypeA || q.Type == query.TypeAAAA) {
+		var answers []dnsmessage.Resource
+		for _, ip4 := range ipv4OnlyAddrs {
+			answers = append(answers, dns64Resource(q.Name, ipv4OnlyTTL, q.Type, p.DNS64Prefix, ip4))
+		}
+		i.Transport = "dns64"
+		n, err = dns64Reply(q, buf, dnsmessage.RCodeSuccess, answers)
+		return n, i, err
+	}
+	if q.Type == query.TypePTR && strings.HasSuffix(qname, ".ip6.arpa.") {
+		ip, ok := netip.AddrFromSlice(ptrIP(qname))
+		if !ok {
+			return 0, i, errNotHandled
+		}
+		ip4, ok := dns64Extract(p.DNS64Prefix, ip)
+		if !ok {
+			return 0, i, errNotHandled
+		}
+		return p.dns64PTR(ctx, q, buf, ip4)
+	}
+	return 0, i, errNotHandled
+}
+
+// dns64PTR answers a PTR query for a synthesized address with a CNAME to the
+// in-addr.arpa name of the embedded IPv4 followed by the answers for this name
+// (RFC 6147 section 5.3.1). The in-addr.arpa name is resolved like a native
+// PTR query, so private addresses are subject to BogusPriv.
+func (p Proxy) dns64PTR(ctx context.Context, q query.Query, buf []byte, ip4 [4]byte) (n int, i resolver.ResolveInfo, err error) {
+	target := fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", ip4[3], ip4[2], ip4[1], ip4[0])
+	q4, err := withQuestion(q, target, query.TypePTR)
+	if err != nil {
+		return 0, i, err
+	}
+	rbuf := make([]byte, len(buf))
+	n, i, err = p.resolveUpstream(ctx, q4, rbuf)
+	if err != nil {
+		return n, i, err
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(rbuf[:n]); err != nil {
+		return 0, i, err
+	}
+	targetName, err := dnsmessage.NewName(target)
+	if err != nil {
+		return 0, i, err
+	}
+	ttl := uint32(ipv4OnlyTTL)
+	for _, rr := range msg.Answers {
+		ttl = min(ttl, rr.Header.TTL)
+	}
+	answers := append([]dnsmessage.Resource{{
+		Header: dnsmessage.ResourceHeader{
+			Name:  dnsmessage.MustNewName(q.Name),
+			Type:  dnsmessage.TypeCNAME,
+			Class: dnsmessage.ClassINET,
+			TTL:   ttl,
+		},
+		Body: &dnsmessage.CNAMEResource{CNAME: targetName},
+	}}, msg.Answers...)
+	n, err = dns64Reply(q, buf, msg.Header.RCode, answers)
+	return n, i, err
+}
+
+// dns64Synthesize checks the AAAA response in buf[:n] and, if it contains no
+// AAAA records, replaces it by AAAA records synthesized from the A records of
+// the same name, queried through the upstream (RFC 6147 section 5.1).
+func (p Proxy) dns64Synthesize(ctx context.Context, q query.Query, buf []byte, n int) int {
+	var msg6 dnsmessage.Message
+	if err := msg6.Unpack(buf[:n]); err != nil || msg6.Header.RCode != dnsmessage.RCodeSuccess {
+		return n
+	}
+	// The negative TTL of the AAAA response limits the TTL of synthesized
+	// records.
+	ttl := ^uint32(0)
+	for _, rr := range msg6.Answers {
+		if rr.Header.Type == dnsmessage.TypeAAAA {
+			return n
+		}
+	}
+	for _, rr := range msg6.Authorities {
+		if soa, ok := rr.Body.(*dnsmessage.SOAResource); ok {
+			ttl = min(rr.Header.TTL, soa.MinTTL)
+		}
+	}
+
+	q4, err := withQuestion(q, q.Name, query.TypeA)
+	if err != nil {
+		return n
+	}
+	rbuf := make([]byte, len(buf))
+	n4, _, err := p.Upstream.Resolve(ctx, q4, rbuf)
+	if err != nil || n4 <= 0 {
+		return n
+	}
+	n4 = p.rebindFilter(q4, rbuf, n4)
+	var msg4 dnsmessage.Message
+	if err := msg4.Unpack(rbuf[:n4]); err != nil || msg4.Header.RCode != dnsmessage.RCodeSuccess {
+		return n
+	}
+	answers := make([]dnsmessage.Resource, 0, len(msg4.Answers))
+	found := false
+	for _, rr := range msg4.Answers {
+		switch b := rr.Body.(type) {
+		case *dnsmessage.CNAMEResource:
+			answers = append(answers, rr)
+		case *dnsmessage.AResource:
+			found = true
+			answers = append(answers, dns64Resource(rr.Header.Name.String(), min(ttl, rr.Header.TTL), query.TypeAAAA, p.DNS64Prefix, b.A))
+		}
+	}
+	if !found {
+		return n
+	}
+	_n, err := dns64Reply(q, buf, dnsmessage.RCodeSuccess, answers)
+	if err != nil {
+		return n
+	}
+	return _n
+}
+
+func dns64Resource(name string, ttl uint32, typ query.Type, prefix netip.Prefix, ip4 [4]byte) dnsmessage.Resource {
+	rr := dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name:  dnsmessage.MustNewName(name),
+			Class: dnsmessage.ClassINET,
+			TTL:   ttl,
+		},
+	}
+	if typ == query.TypeA {
+		rr.Header.Type = dnsmessage.TypeA
+		rr.Body = &dnsmessage.AResource{A: ip4}
+	} else {
+		rr.Header.Type = dnsmessage.TypeAAAA
+		rr.Body = &dnsmessage.AAAAResource{AAAA: dns64Embed(prefix, ip4)}
+	}
+	return rr
+}
+
+// dns64Reply writes in buf a response to q with answers.
+func dns64Reply(q query.Query, buf []byte, rcode dnsmessage.RCode, answers []dnsmessage.Resource) (int, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(q.Payload); err != nil {
+		return 0, err
+	}
+	msg.Header.Response = true
+	msg.Header.RecursionAvailable = true
+	msg.Header.AuthenticData = false
+	msg.Header.RCode = rcode
+	msg.Answers = answers
+	msg.Authorities = nil
+	var additionals []dnsmessage.Resource
+	for _, rr := range msg.Additionals {
+		if rr.Header.Type == dnsmessage.TypeOPT {
+			// Keep the OPT record without the query options.
+			rr.Body = &dnsmessage.OPTResource{}
+			additionals = append(additionals, rr)
+			break
+		}
+	}
+	msg.Additionals = additionals
+	out, err := msg.AppendPack(buf[:0])
+	if err != nil {
+		return 0, err
+	}
+	if len(out) > len(buf) {
+		return 0, errTooLarge
+	}
+	return copy(buf, out), nil
+}
+
+// withQuestion returns a copy of q asking for name and typ instead of the
+// original question.
+func withQuestion(q query.Query, name string, typ query.Type) (query.Query, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(q.Payload); err != nil {
+		return q, err
+	}
+	if len(msg.Questions) != 1 {
+		return q, errNotHandled
+	}
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return q, err
+	}
+	msg.Questions[0].Name = n
+	msg.Questions[0].Type = dnsmessage.Type(typ)
+	payload, err := msg.Pack()
+	if err != nil {
+		return q, err
+	}
+	q.Name = name
+	q.Type = typ
+	q.Payload = payload
+	return q, nil
+}
@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+func Test_dns64Embed(t *testing.T) {
+	ip4 := [4]byte{192, 0, 2, 33}
+	// Examples from RFC 6052 section 2.4.
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"2001:db8::/32", "2001:db8:c000:221::"},
+		{"2001:db8:100::/40", "2001:db8:1c0:2:21::"},
+		{"2001:db8:122::/48", "2001:db8:122:c000:2:2100::"},
+		{"2001:db8:122:300::/56", "2001:db8:122:3c0:0:221::"},
+		{"2001:db8:122:344::/64", "2001:db8:122:344:c0:2:2100:0"},
+		{"2001:db8:122:344::/96", "2001:db8:122:344::192.0.2.33"},
+		{"64:ff9b::/96", "64:ff9b::192.0.2.33"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			prefix := netip.MustParsePrefix(tt.prefix)
+			got := netip.AddrFrom16(dns64Embed(prefix, ip4))
+			if want := netip.MustParseAddr(tt.want); got != want {
+				t.Errorf("dns64Embed() = %v, want %v", got, want)
+			}
+			if back, ok := dns64Extract(prefix, got); !ok || back != ip4 {
+				t.Errorf("dns64Extract() = %v, %v, want %v", back, ok, ip4)
+			}
+		})
+	}
+}
+
+// dns64Upstream answers A queries with 192.0.2.33, AAAA queries with NODATA
+// and PTR queries with host.example.
+type dns64Upstream struct{}
+
+func (dns64Upstream) Resolve(ctx context.Context, q query.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	b := dnsmessage.NewBuilder(buf[:0], dnsmessage.Header{ID: q.ID, Response: true})
+	_ = b.StartQuestions()
+	name := dnsmessage.MustNewName(q.Name)
+	_ = b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.Type(q.Type), Class: dnsmessage.ClassINET})
+	_ = b.StartAnswers()
+	h := dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.Type(q.Type), Class: dnsmessage.ClassINET, TTL: 600}
+	switch q.Type {
+	case query.TypeA:
+		_ = b.AResource(h, dnsmessage.AResource{A: [4]byte{192, 0, 2, 33}})
+	case query.TypePTR:
+		_ = b.PTRResource(h, dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("host.example.")})
+	case query.TypeAAAA:
+		_ = b.StartAuthorities()
+		_ = b.SOAResource(dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("example."), Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: 3600},
+			dnsmessage.SOAResource{NS: name, MBox: name, MinTTL: 300})
+	}
+	buf, err := b.Finish()
+	return len(buf), resolver.ResolveInfo{}, err
+}
+
+func TestProxy_Resolve_dns64(t *testing.T) {
+	p := Proxy{
+		Upstream:    dns64Upstream{},
+		DNS64Prefix: DefaultDNS64Prefix,
+	}
+	tests := []struct {
+		name  string
+		qname string
+		qtype dnsmessage.Type
+		want  []string
+	}{
+		{"synthesized", "host.example.", dnsmessage.TypeAAAA, []string{"host.example. 64:ff9b::c000:221 300"}},
+		{"A untouched", "host.example.", dnsmessage.TypeA, []string{"host.example. 192.0.2.33 600"}},
+		{"ipv4only.arpa A", "ipv4only.arpa.", dnsmessage.TypeA, []string{"ipv4only.arpa. 192.0.0.170 3600", "ipv4only.arpa. 192.0.0.171 3600"}},
+		{"ipv4only.arpa AAAA", "ipv4only.arpa.", dnsmessage.TypeAAAA, []string{"ipv4only.arpa. 64:ff9b::c000:aa 3600", "ipv4only.arpa. 64:ff9b::c000:ab 3600"}},
+		{"PTR", "1.2.2.0.0.0.0.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.b.9.f.f.4.6.0.0.ip6.arpa.", dnsmessage.TypePTR, []string{
+			"1.2.2.0.0.0.0.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.b.9.f.f.4.6.0.0.ip6.arpa. 33.2.0.192.in-addr.arpa. 600",
+			"33.2.0.192.in-addr.arpa. host.example. 600",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1, RecursionDesired: true})
+			_ = b.StartQuestions()
+			_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(tt.qname), Type: tt.qtype, Class: dnsmessage.ClassINET})
+			payload, _ := b.Finish()
+			q, err := query.New(payload, net.ParseIP("10.0.0.1"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 512)
+			n, _, err := p.Resolve(context.Background(), q, buf)
+			if err != nil {
+				t.Fatalf("Resolve() err = %v", err)
+			}
+			var msg dnsmessage.Message
+			if err := msg.Unpack(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Header.ID != 1 {
+				t.Errorf("Resolve() ID = %d, want 1", msg.Header.ID)
+			}
+			var got []string
+			for _, rr := range msg.Answers {
+				var v string
+				switch b := rr.Body.(type) {
+				case *dnsmessage.AResource:
+					v = net.IP(b.A[:]).String()
+				case *dnsmessage.AAAAResource:
+					v = net.IP(b.AAAA[:]).String()
+				case *dnsmessage.CNAMEResource:
+					v = b.CNAME.String()
+				case *dnsmessage.PTRResource:
+					v = b.PTR.String()
+				}
+				got = append(got, rr.Header.Name.String()+" "+v+" "+strconv.FormatUint(uint64(rr.Header.TTL), 10))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type countingResolver struct {
+	resolver.Resolver
+	names []string
+}
+
+func (r *countingResolver) Resolve(ctx context.Context, q query.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	r.names = append(r.names, q.Name)
+	return r.Resolver.Resolve(ctx, q, buf)
+}
+
+func TestProxy_Resolve_dns64PTRBogusPriv(t *testing.T) {
+	upstream := &countingResolver{Resolver: dns64Upstream{}}
+	p := Proxy{
+		Upstream:    upstream,
+		DNS64Prefix: netip.MustParsePrefix("2001:db8:64::/96"),
+		BogusPriv:   true,
+	}
+	// 2001:db8:64::192.168.1.10
+	qname := "a.0.1.0.8.a.0.c.0.0.0.0.0.0.0.0.0.0.0.0.4.6.0.0.8.b.d.0.1.0.0.2.ip6.arpa."
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1, RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(qname), Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET})
+	payload, _ := b.Finish()
+	q, err := query.New(payload, net.ParseIP("10.0.0.1"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 512)
+	n, _, err := p.Resolve(context.Background(), q, buf)
+	if err != nil {
+		t.Fatalf("Resolve() err = %v", err)
+	}
+	if len(upstream.names) > 0 {
+		t.Errorf("private reverse sent upstream: %v", upstream.names)
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.RCode != dnsmessage.RCodeNameError {
+		t.Errorf("RCode = %v, want NXDOMAIN", msg.Header.RCode)
+	}
+}
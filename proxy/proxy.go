@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/netip"
 	"sync"
 	"time"
 
@@ -29,6 +30,9 @@ type QueryInfo struct {
 	Duration          time.Duration
 	FromCache         bool
 	UpstreamTransport string
+	Blocked           bool
+	RateLimited       bool
+	Denied            bool
 	Error             error
 }
 
@@ -42,10 +46,30 @@ type Proxy struct {
 	// Addrs specifies the TCP/UDP address to listen to, :53 if empty.
 	Addrs []string
 
+	// LocalZones is called first to answer queries for locally defined zones
+	// and records. Queries it returns an error for continue to the next
+	// resolvers.
+	LocalZones resolver.Resolver
+
+	// LocalDomain is called after LocalZones to answer queries for the local
+	// domain of discovered hosts and their reverse names. Queries it returns
+	// an error for continue to the next resolvers.
+	LocalDomain resolver.Resolver
+
+	// LocalMDNS is called after LocalDomain to answer queries for .local
+	// names over mDNS. Queries it returns an error for continue to the next
+	// resolvers.
+	LocalMDNS resolver.Resolver
+
 	// LocalResolver is called before the upstream to resolve local hostnames or
 	// IPs.
 	LocalResolver HostResolver
 
+	// Blocklist is called after the local resolvers and before the upstream to
+	// answer queries for blocked domains. Queries it returns an error for are
+	// not blocked.
+	Blocklist resolver.Resolver
+
 	// Upstream specifies the resolver used for incoming queries.
 	Upstream resolver.Resolver
 
@@ -56,6 +80,23 @@ type Proxy struct {
 	// with NXDOMAIN.
 	BogusPriv bool
 
+	// RebindProtection defines how upstream answers resolving public names to
+	// private, loopback or link-local IPs are handled.
+	RebindProtection RebindMode
+
+	// RebindAllowlist lists domains (including their subdomains) allowed to
+	// resolve to private IPs.
+	RebindAllowlist []string
+
+	// RebindExempt optionally returns true for names allowed to resolve to
+	// private IPs, like names sent to conditional forwarders.
+	RebindExempt func(qname string) bool
+
+	// DNS64Prefix enables DNS64 (RFC 6147) when set: AAAA records are
+	// synthesized within this prefix from A records for names without AAAA
+	// records.
+	DNS64Prefix netip.Prefix
+
 	// Timeout defines the maximum allowed time allowed for a request before
 	// being cancelled.
 	Timeout time.Duration
@@ -64,6 +105,17 @@ type Proxy struct {
 	// not be answered.
 	MaxInflightRequests uint
 
+	// ACL optionally returns false for clients not allowed to send queries.
+	// The destIP is the local address the query was received on.
+	ACL func(sourceIP, destIP net.IP, mac net.HardwareAddr) bool
+
+	// ACLDrop silently drops queries from denied clients instead of answering
+	// with REFUSED.
+	ACLDrop bool
+
+	// RateLimiter optionally limits the rate of queries per client.
+	RateLimiter *RateLimiter
+
 	// QueryLog specifies an optional log function called for each received query.
 	QueryLog func(QueryInfo)
 
@@ -74,6 +126,11 @@ type Proxy struct {
 	// ErrorLog specifies an optional log function for errors. If not set,
 	// errors are not reported.
 	ErrorLog func(error)
+
+	// Current optionally returns the settings used to handle each query,
+	// allowing them to be changed while the proxy is running. Addrs and
+	// MaxInflightRequests are only read by ListenAndServe.
+	Current func() *Proxy
 }
 
 const defaultMaxInflightRequests = 256
@@ -170,16 +227,59 @@ func (p Proxy) ListenAndServe(ctx context.Context) error {
 }
 
 func (p Proxy) Resolve(ctx context.Context, q query.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	if p.LocalZones != nil {
+		if _n, _i, _err := p.LocalZones.Resolve(ctx, q, buf); _err == nil {
+			return _n, _i, nil
+		}
+	}
+
+	if p.LocalDomain != nil {
+		if _n, _i, _err := p.LocalDomain.Resolve(ctx, q, buf); _err == nil {
+			return _n, _i, nil
+		}
+	}
+
+	if p.LocalMDNS != nil {
+		if _n, _i, _err := p.LocalMDNS.Resolve(ctx, q, buf); _err == nil {
+			return _n, _i, nil
+		}
+	}
+
 	if p.LocalResolver != nil {
 		if _n, _i, _err := hostsResolve(p.LocalResolver, q, buf); _err == nil {
 			return _n, _i, nil
 		}
 	}
 
+	if p.Blocklist != nil {
+		if _n, _i, _err := p.Blocklist.Resolve(ctx, q, buf); _err == nil {
+			return _n, _i, nil
+		}
+	}
+
+	if p.dns64Enabled(q) {
+		if _n, _i, _err := p.dns64Local(ctx, q, buf); _err != errNotHandled {
+			return _n, _i, _err
+		}
+	}
+
+	return p.resolveUpstream(ctx, q, buf)
+}
+
+// resolveUpstream resolves q with the upstream, falling back to the discovery
+// resolver, and answers private reverse queries with NXDOMAIN if BogusPriv is
+// set.
+func (p Proxy) resolveUpstream(ctx context.Context, q query.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
 	priv := q.Type == query.TypePTR && isPrivateReverse(q.Name)
 
 	if !p.BogusPriv || !priv {
 		n, i, err = p.Upstream.Resolve(ctx, q, buf)
+		if err == nil {
+			n = p.rebindFilter(q, buf, n)
+			if q.Type == query.TypeAAAA && p.dns64Enabled(q) {
+				n = p.dns64Synthesize(ctx, q, buf, n)
+			}
+		}
 	}
 
 	if q.RecursionDesired && p.DiscoveryResolver != nil && (n <= 0 || isNXDomain(buf[:n])) {
@@ -196,6 +296,16 @@ func (p Proxy) Resolve(ctx context.Context, q query.Query, buf []byte) (n int, i
 	return n, i, err
 }
 
+// current returns the settings to use for a new query.
+func (p Proxy) current() Proxy {
+	if p.Current != nil {
+		if cur := p.Current(); cur != nil {
+			return *cur
+		}
+	}
+	return p
+}
+
 func (p Proxy) maxInflightRequests() int {
 	if p.MaxInflightRequests == 0 {
 		return defaultMaxInflightRequests
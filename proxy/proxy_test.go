@@ -27,3 +27,18 @@ func TestProxy_maxInflightRequests(t *testing.T) {
 		})
 	}
 }
+
+func TestProxy_current(t *testing.T) {
+	p := Proxy{BogusPriv: true}
+	if got := p.current(); !got.BogusPriv {
+		t.Errorf("current() without Current: BogusPriv = false, want true")
+	}
+	p.Current = func() *Proxy { return &Proxy{} }
+	if got := p.current(); got.BogusPriv {
+		t.Errorf("current() with Current: BogusPriv = true, want false")
+	}
+	p.Current = func() *Proxy { return nil }
+	if got := p.current(); !got.BogusPriv {
+		t.Errorf("current() with nil Current: BogusPriv = false, want true")
+	}
+}
@@ -0,0 +1,258 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+// RateLimitKey defines how clients are identified for rate limiting.
+type RateLimitKey int
+
+const (
+	// RateLimitByIP applies a limit per client IP.
+	RateLimitByIP RateLimitKey = iota
+
+	// RateLimitByMAC applies a limit per client MAC address, falling back to
+	// the IP when the MAC is unknown.
+	RateLimitByMAC
+
+	// RateLimitBySubnet applies a limit per client subnet.
+	RateLimitBySubnet
+)
+
+// RateLimitAction defines what to do with queries over the limit.
+type RateLimitAction int
+
+const (
+	// RateLimitRefuse answers over limit queries with REFUSED.
+	RateLimitRefuse RateLimitAction = iota
+
+	// RateLimitDrop silently drops over limit queries.
+	RateLimitDrop
+
+	// RateLimitTruncate answers over limit UDP queries with an empty truncated
+	// response to force clients to retry over TCP. Over limit TCP queries are
+	// refused.
+	RateLimitTruncate
+)
+
+// throttledWindow is the time a client stays listed as throttled after its
+// last limited query.
+const throttledWindow = 30 * time.Second
+
+// RateLimiter is a token bucket rate limiter keyed by client.
+type RateLimiter struct {
+	// Rate is the number of queries per second allowed for each client.
+	Rate float64
+
+	// Burst is the maximum number of queries a client can send at once.
+	Burst int
+
+	// Key defines how clients are identified.
+	Key RateLimitKey
+
+	// IPv4Prefix and IPv6Prefix define the subnet size used with
+	// RateLimitBySubnet. They default to 24 and 56.
+	IPv4Prefix int
+	IPv6Prefix int
+
+	// Action defines what to do with queries over the limit.
+	Action RateLimitAction
+
+	allowed atomic.Uint64
+	limited atomic.Uint64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens      float64
+	last        time.Time
+	limited     uint64
+	lastLimited time.Time
+}
+
+// ThrottledClient describes a client currently over its rate limit.
+type ThrottledClient struct {
+	Client      string    `json:"client"`
+	Limited     uint64    `json:"limited"`
+	LastLimited time.Time `json:"last_limited"`
+}
+
+// RateLimitMetrics are counters of the rate limiter.
+type RateLimitMetrics struct {
+	Allowed   uint64            `json:"allowed"`
+	Limited   uint64            `json:"limited"`
+	Clients   int               `json:"clients"`
+	Throttled []ThrottledClient `json:"throttled"`
+}
+
+// ParseKey parses a client identification definition and sets it as the key
+// of l: ip, mac or subnet with optional IPv4 and IPv6 prefix lengths
+// (subnet/24/56).
+func (l *RateLimiter) ParseKey(s string) error {
+	key, prefixes, _ := strings.Cut(s, "/")
+	switch key {
+	case "ip":
+		l.Key = RateLimitByIP
+	case "mac":
+		l.Key = RateLimitByMAC
+	case "subnet":
+		l.Key = RateLimitBySubnet
+	default:
+		return fmt.Errorf("%s: invalid rate limit key", s)
+	}
+	if prefixes == "" {
+		return nil
+	}
+	if l.Key != RateLimitBySubnet {
+		return fmt.Errorf("%s: prefix lengths are only supported with subnet", s)
+	}
+	v4, v6, _ := strings.Cut(prefixes, "/")
+	var err error
+	if l.IPv4Prefix, err = strconv.Atoi(v4); err != nil || l.IPv4Prefix < 1 || l.IPv4Prefix > 32 {
+		return fmt.Errorf("%s: invalid IPv4 prefix length", s)
+	}
+	if v6 != "" {
+		if l.IPv6Prefix, err = strconv.Atoi(v6); err != nil || l.IPv6Prefix < 1 || l.IPv6Prefix > 128 {
+			return fmt.Errorf("%s: invalid IPv6 prefix length", s)
+		}
+	}
+	return nil
+}
+
+// key returns the bucket key for a client.
+func (l *RateLimiter) key(ip net.IP, mac net.HardwareAddr) string {
+	switch l.Key {
+	case RateLimitByMAC:
+		if mac != nil {
+			return mac.String()
+		}
+	case RateLimitBySubnet:
+		if ip4 := ip.To4(); ip4 != nil {
+			bits := l.IPv4Prefix
+			if bits == 0 {
+				bits = 24
+			}
+			return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(bits, 32)), Mask: net.CIDRMask(bits, 32)}).String()
+		}
+		bits := l.IPv6Prefix
+		if bits == 0 {
+			bits = 56
+		}
+		return (&net.IPNet{IP: ip.Mask(net.CIDRMask(bits, 128)), Mask: net.CIDRMask(bits, 128)}).String()
+	}
+	return ip.String()
+}
+
+// Allow returns true if a query from the client identified by ip and mac is
+// within the limit.
+func (l *RateLimiter) Allow(ip net.IP, mac net.HardwareAddr) bool {
+	now := time.Now()
+	k := l.key(ip, mac)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buckets == nil {
+		l.buckets = map[string]*bucket{}
+	}
+	l.sweepLocked(now)
+	b := l.buckets[k]
+	if b == nil {
+		b = &bucket{tokens: float64(l.Burst), last: now}
+		l.buckets[k] = b
+	}
+	b.tokens = min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		b.limited++
+		b.lastLimited = now
+		l.limited.Add(1)
+		return false
+	}
+	b.tokens--
+	l.allowed.Add(1)
+	return true
+}
+
+// sweepLocked removes the buckets of clients that have been refilled and are
+// no longer listed as throttled.
+func (l *RateLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		full := b.tokens+now.Sub(b.last).Seconds()*l.Rate >= float64(l.Burst)
+		if full && now.Sub(b.lastLimited) > throttledWindow {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// Throttled returns the clients that got limited recently.
+func (l *RateLimiter) Throttled() []ThrottledClient {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var clients []ThrottledClient
+	for k, b := range l.buckets {
+		if b.limited > 0 && now.Sub(b.lastLimited) <= throttledWindow {
+			clients = append(clients, ThrottledClient{
+				Client:      k,
+				Limited:     b.limited,
+				LastLimited: b.lastLimited,
+			})
+		}
+	}
+	slices.SortFunc(clients, func(a, b ThrottledClient) int {
+		return b.LastLimited.Compare(a.LastLimited)
+	})
+	return clients
+}
+
+// Metrics returns the rate limiter counters.
+func (l *RateLimiter) Metrics() RateLimitMetrics {
+	throttled := l.Throttled()
+	l.mu.Lock()
+	clients := len(l.buckets)
+	l.mu.Unlock()
+	return RateLimitMetrics{
+		Allowed:   l.allowed.Load(),
+		Limited:   l.limited.Load(),
+		Clients:   clients,
+		Throttled: throttled,
+	}
+}
+
+// rateLimit checks q received from sourceIP against the rate limiter. If the
+// query is over the limit, limited is true and the response to send, if any,
+// is written to buf. Clients are identified by the source address of the
+// packet and the MAC address found in the ARP and NDP tables, not by the ECS
+// and MAC EDNS0 options a client could change for each query.
+func (p Proxy) rateLimit(sourceIP net.IP, q query.Query, buf []byte, udp bool) (n int, limited bool) {
+	if p.RateLimiter == nil || p.RateLimiter.Allow(sourceIP, q.NeighborMAC) {
+		return 0, false
+	}
+	switch p.RateLimiter.Action {
+	case RateLimitDrop:
+		return 0, true
+	case RateLimitTruncate:
+		if udp {
+			n = replyRCode(dnsmessage.RCodeSuccess, q, buf)
+			buf[2] |= 0x2 // mark response as truncated
+			return n, true
+		}
+	}
+	return replyRCode(dnsmessage.RCodeRefused, q, buf), true
+}
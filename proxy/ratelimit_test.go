@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	l := &RateLimiter{Rate: 1, Burst: 3}
+	ip := net.ParseIP("192.168.1.10")
+	for i := range 3 {
+		if !l.Allow(ip, nil) {
+			t.Fatalf("Allow() #%d = false, want true", i)
+		}
+	}
+	if l.Allow(ip, nil) {
+		t.Fatal("Allow() over burst = true, want false")
+	}
+	if !l.Allow(net.ParseIP("192.168.1.11"), nil) {
+		t.Error("Allow() other client = false, want true")
+	}
+	throttled := l.Throttled()
+	if len(throttled) != 1 || throttled[0].Client != "192.168.1.10" || throttled[0].Limited != 1 {
+		t.Errorf("Throttled() = %+v", throttled)
+	}
+
+	// Simulate time passing to refill one token.
+	l.mu.Lock()
+	l.buckets["192.168.1.10"].last = time.Now().Add(-time.Second)
+	l.mu.Unlock()
+	if !l.Allow(ip, nil) {
+		t.Error("Allow() after refill = false, want true")
+	}
+	if m := l.Metrics(); m.Allowed != 5 || m.Limited != 1 || m.Clients != 2 {
+		t.Errorf("Metrics() = %+v", m)
+	}
+}
+
+func TestRateLimiter_key(t *testing.T) {
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	tests := []struct {
+		name string
+		key  RateLimitKey
+		ip   string
+		mac  net.HardwareAddr
+		want string
+	}{
+		{"ip", RateLimitByIP, "192.168.1.10", mac, "192.168.1.10"},
+		{"mac", RateLimitByMAC, "192.168.1.10", mac, "00:11:22:33:44:55"},
+		{"mac unknown", RateLimitByMAC, "192.168.1.10", nil, "192.168.1.10"},
+		{"subnet v4", RateLimitBySubnet, "192.168.1.10", nil, "192.168.1.0/24"},
+		{"subnet v6", RateLimitBySubnet, "2001:db8:1:2ff::1", nil, "2001:db8:1:200::/56"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &RateLimiter{Key: tt.key}
+			if got := l.key(net.ParseIP(tt.ip), tt.mac); got != tt.want {
+				t.Errorf("key() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiter_ParseKey(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    RateLimitKey
+		v4, v6  int
+		wantErr bool
+	}{
+		{"ip", RateLimitByIP, 0, 0, false},
+		{"mac", RateLimitByMAC, 0, 0, false},
+		{"subnet", RateLimitBySubnet, 0, 0, false},
+		{"subnet/20/48", RateLimitBySubnet, 20, 48, false},
+		{"subnet/16", RateLimitBySubnet, 16, 0, false},
+		{"subnet/0", 0, 0, 0, true},
+		{"subnet/24/129", 0, 0, 0, true},
+		{"ip/24", 0, 0, 0, true},
+		{"foo", 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			l := &RateLimiter{}
+			err := l.ParseKey(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKey() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (l.Key != tt.want || l.IPv4Prefix != tt.v4 || l.IPv6Prefix != tt.v6) {
+				t.Errorf("ParseKey() = %v %d/%d, want %v %d/%d", l.Key, l.IPv4Prefix, l.IPv6Prefix, tt.want, tt.v4, tt.v6)
+			}
+		})
+	}
+}
+
+func TestProxy_rateLimit_spoofedOptions(t *testing.T) {
+	for _, key := range []RateLimitKey{RateLimitByIP, RateLimitByMAC} {
+		p := Proxy{RateLimiter: &RateLimiter{Rate: 0.001, Burst: 1, Key: key}}
+		sourceIP := net.ParseIP("203.0.113.5")
+		buf := make([]byte, 512)
+		for i := range 3 {
+			// Each query claims another client with ECS /32 and MAC options.
+			q := newOptionsQuery(t, sourceIP,
+				dnsmessage.Option{Code: query.EDNS0_SUBNET, Data: []byte{0, 1, 32, 0, 10, 0, 0, byte(i)}},
+				dnsmessage.Option{Code: query.EDNS0_MAC, Data: []byte{0, 0x1c, 0x42, 0, 0, byte(i)}})
+			if _, limited := p.rateLimit(sourceIP, q, buf, true); limited != (i > 0) {
+				t.Errorf("key %v: query #%d limited = %v", key, i, limited)
+			}
+		}
+		if m := p.RateLimiter.Metrics(); m.Clients != 1 {
+			t.Errorf("key %v: clients = %d, want 1", key, m.Clients)
+		}
+	}
+}
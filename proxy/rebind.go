@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+// RebindMode defines how upstream answers resolving public names to private
+// IPs are handled (DNS rebinding protection).
+type RebindMode int
+
+const (
+	// RebindOff disables the DNS rebinding protection.
+	RebindOff RebindMode = iota
+
+	// RebindStrip removes private A/AAAA records from answers.
+	RebindStrip
+
+	// RebindRefuse answers with REFUSED when an answer contains private
+	// A/AAAA records.
+	RebindRefuse
+)
+
+// rebindLocalDomains lists domains never considered as public.
+var rebindLocalDomains = []string{"localhost.", "local.", "home.arpa."}
+
+// rebindExempt returns true if qname is allowed to resolve to private IPs.
+func (p Proxy) rebindExempt(qname string) bool {
+	qname = strings.ToLower(qname)
+	if strings.IndexByte(strings.TrimSuffix(qname, "."), '.') == -1 {
+		// Single label names are local.
+		return true
+	}
+	for _, lists := range [][]string{rebindLocalDomains, p.RebindAllowlist} {
+		for _, d := range lists {
+			d = strings.ToLower(fqdn(d))
+			if qname == d || strings.HasSuffix(qname, "."+d) {
+				return true
+			}
+		}
+	}
+	return p.RebindExempt != nil && p.RebindExempt(qname)
+}
+
+// rebindFilter applies the rebinding protection to the response in buf[:n]
+// and returns the new size of the response.
+func (p Proxy) rebindFilter(q query.Query, buf []byte, n int) int {
+	if p.RebindProtection == RebindOff || n <= 0 || p.rebindExempt(q.Name) {
+		return n
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(buf[:n]); err != nil {
+		return n
+	}
+	answers := msg.Answers[:0]
+	stripped := 0
+	for _, rr := range msg.Answers {
+		if isPrivateResource(rr) {
+			stripped++
+			continue
+		}
+		answers = append(answers, rr)
+	}
+	if stripped == 0 {
+		return n
+	}
+	p.logInfof("rebind protection: %s: removed %d private addresses", q.Name, stripped)
+	if p.RebindProtection == RebindRefuse {
+		return replyRCode(dnsmessage.RCodeRefused, q, buf)
+	}
+	msg.Answers = answers
+	out, err := msg.AppendPack(buf[:0])
+	if err != nil || len(out) > len(buf) {
+		return replyRCode(dnsmessage.RCodeServerFailure, q, buf)
+	}
+	return copy(buf, out)
+}
+
+func isPrivateResource(rr dnsmessage.Resource) bool {
+	var ip netip.Addr
+	switch b := rr.Body.(type) {
+	case *dnsmessage.AResource:
+		ip = netip.AddrFrom4(b.A)
+	case *dnsmessage.AAAAResource:
+		ip = netip.AddrFrom16(b.AAAA).Unmap()
+	default:
+		return false
+	}
+	// Unspecified addresses are not considered private as they are commonly
+	// used to answer blocked domains.
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast()
+}
+
+func fqdn(s string) string {
+	if !strings.HasSuffix(s, ".") {
+		s += "."
+	}
+	return s
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+type staticResolver []net.IP
+
+func (r staticResolver) Resolve(ctx context.Context, q query.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	b := dnsmessage.NewBuilder(buf[:0], dnsmessage.Header{ID: q.ID, Response: true})
+	_ = b.StartQuestions()
+	name := dnsmessage.MustNewName(q.Name)
+	_ = b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	_ = b.StartAnswers()
+	for _, ip := range r {
+		var rr dnsmessage.AResource
+		copy(rr.A[:], ip.To4())
+		_ = b.AResource(dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60}, rr)
+	}
+	buf, err := b.Finish()
+	return len(buf), resolver.ResolveInfo{}, err
+}
+
+func TestProxy_Resolve_rebind(t *testing.T) {
+	upstream := staticResolver{net.ParseIP("192.168.1.1"), net.ParseIP("1.2.3.4"), net.ParseIP("127.0.0.1")}
+	tests := []struct {
+		name      string
+		mode      RebindMode
+		qname     string
+		wantRCode dnsmessage.RCode
+		wantIPs   []string
+	}{
+		{"off", RebindOff, "example.com.", dnsmessage.RCodeSuccess, []string{"192.168.1.1", "1.2.3.4", "127.0.0.1"}},
+		{"strip", RebindStrip, "example.com.", dnsmessage.RCodeSuccess, []string{"1.2.3.4"}},
+		{"refuse", RebindRefuse, "example.com.", dnsmessage.RCodeRefused, nil},
+		{"allowlist", RebindRefuse, "host.Allowed.com.", dnsmessage.RCodeSuccess, []string{"192.168.1.1", "1.2.3.4", "127.0.0.1"}},
+		{"exempt", RebindRefuse, "host.exempt.com.", dnsmessage.RCodeSuccess, []string{"192.168.1.1", "1.2.3.4", "127.0.0.1"}},
+		{"single label", RebindRefuse, "router.", dnsmessage.RCodeSuccess, []string{"192.168.1.1", "1.2.3.4", "127.0.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Proxy{
+				Upstream:         upstream,
+				RebindProtection: tt.mode,
+				RebindAllowlist:  []string{"allowed.com"},
+				RebindExempt:     func(qname string) bool { return qname == "host.exempt.com." },
+			}
+			b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1, RecursionDesired: true})
+			_ = b.StartQuestions()
+			_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(tt.qname), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+			payload, _ := b.Finish()
+			q, err := query.New(payload, net.ParseIP("10.0.0.1"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 512)
+			n, _, err := p.Resolve(context.Background(), q, buf)
+			if err != nil {
+				t.Fatalf("Resolve() err = %v", err)
+			}
+			var msg dnsmessage.Message
+			if err := msg.Unpack(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			var ips []string
+			for _, rr := range msg.Answers {
+				ips = append(ips, net.IP(rr.Body.(*dnsmessage.AResource).A[:]).String())
+			}
+			if msg.Header.RCode != tt.wantRCode || !reflect.DeepEqual(ips, tt.wantIPs) {
+				t.Errorf("Resolve() = %v %v, want %v %v", msg.Header.RCode, ips, tt.wantRCode, tt.wantIPs)
+			}
+		})
+	}
+}
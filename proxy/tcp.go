@@ -80,9 +80,11 @@ func (p Proxy) serveTCPConn(c net.Conn, inflightRequests chan struct{}, bpool *s
 		}
 		start := time.Now()
 		go func(bp *tcpBuf, qsize int, start time.Time) {
+			p := p.current()
 			var err error
 			var rsize int
 			var ri resolver.ResolveInfo
+			var limited, denied bool
 			buf := bp[:]
 			q, err := query.New(buf[:qsize], sourceIP, localIP)
 			if err != nil {
@@ -113,10 +115,19 @@ func (p Proxy) serveTCPConn(c net.Conn, inflightRequests chan struct{}, bpool *s
 					Profile:           ri.Profile,
 					FromCache:         ri.FromCache,
 					UpstreamTransport: ri.Transport,
+					Blocked:           ri.Blocked,
+					RateLimited:       limited,
+					Denied:            denied,
 					Error:             err,
 				})
 			}()
 
+			if rsize, denied = p.aclDeny(sourceIP, localIP, q, rbuf); denied {
+				if rsize > 0 {
+					err = writeTCP(c, rbuf[:rsize])
+				}
+				return
+			}
 			if err != nil {
 				// Malformed query: reply with FORMERR and skip upstream resolution.
 				rsize = replyRCode(dnsmessage.RCodeFormatError, q, rbuf)
@@ -127,6 +138,12 @@ func (p Proxy) serveTCPConn(c net.Conn, inflightRequests chan struct{}, bpool *s
 				}
 				return
 			}
+			if rsize, limited = p.rateLimit(sourceIP, q, rbuf, false); limited {
+				if rsize > 0 {
+					err = writeTCP(c, rbuf[:rsize])
+				}
+				return
+			}
 			ctx := context.Background()
 			if p.Timeout > 0 {
 				var cancel context.CancelFunc
@@ -79,9 +79,11 @@ func (p Proxy) serveUDP(l net.PacketConn, inflightRequests chan struct{}) error
 		}
 		start := time.Now()
 		go func(bp *tcpBuf, qsize int, lip net.IP, raddr *net.UDPAddr, start time.Time) {
+			p := p.current()
 			var err error
 			var rsize int
 			var ri resolver.ResolveInfo
+			var limited, denied bool
 			buf := bp[:]
 			sourceIP := addrIP(raddr)
 			remotePort := addrPort(raddr)
@@ -114,10 +116,19 @@ func (p Proxy) serveUDP(l net.PacketConn, inflightRequests chan struct{}) error
 					Profile:           ri.Profile,
 					FromCache:         ri.FromCache,
 					UpstreamTransport: ri.Transport,
+					Blocked:           ri.Blocked,
+					RateLimited:       limited,
+					Denied:            denied,
 					Error:             err,
 				})
 			}()
 
+			if rsize, denied = p.aclDeny(sourceIP, lip, q, rbuf); denied {
+				if rsize > 0 {
+					_, _, err = c.WriteMsgUDP(rbuf[:rsize], oobWithSrc(lip), raddr)
+				}
+				return
+			}
 			if err != nil {
 				// Malformed query: reply with FORMERR and skip upstream resolution.
 				rsize = replyRCode(dnsmessage.RCodeFormatError, q, rbuf)
@@ -127,6 +138,12 @@ func (p Proxy) serveUDP(l net.PacketConn, inflightRequests chan struct{}) error
 				}
 				return
 			}
+			if rsize, limited = p.rateLimit(sourceIP, q, rbuf, true); limited {
+				if rsize > 0 {
+					_, _, err = c.WriteMsgUDP(rbuf[:rsize], oobWithSrc(lip), raddr)
+				}
+				return
+			}
 			ctx := context.Background()
 			if p.Timeout > 0 {
 				var cancel context.CancelFunc
@@ -11,6 +11,21 @@ import (
 	"github.com/nextdns/nextdns/resolver/query"
 )
 
+// dnssecCacheKey returns a string to add to the cache key of q so answers to
+// queries with the DO or CD bit set, which may contain DNSSEC records or data
+// failing validation, are cached separately.
+func dnssecCacheKey(q query.Query) string {
+	switch {
+	case q.DNSSECOK && q.CheckingDisabled:
+		return "+do+cd"
+	case q.DNSSECOK:
+		return "+do"
+	case q.CheckingDisabled:
+		return "+cd"
+	}
+	return ""
+}
+
 type cacheKey struct {
 	ctx    string
 	qclass query.Class
@@ -26,18 +26,26 @@ type DNS53 struct {
 	// TTL value if it is lower. The true TTL value is however kept in the cache
 	// to evaluate cache entries freshness.
 	MaxTTL uint32
+
+	// ECS defines the EDNS client subnet policy. The zero value strips ECS.
+	ECS ECSPolicy
 }
 
 var defaultDialer = &net.Dialer{}
 
 func (r DNS53) resolve(ctx context.Context, q query.Query, buf []byte, addr string) (n int, i ResolveInfo, err error) {
 	i.Transport = "UDP"
+	var ctxKey string
+	if q, ctxKey, err = r.ECS.apply(q); err != nil {
+		return 0, i, fmt.Errorf("ecs: %v", err)
+	}
+	ctxKey += dnssecCacheKey(q)
 	var now time.Time
 	n = 0
 	// RFC1035, section 7.4: The results of an inverse query should not be cached
 	if q.Type != query.TypePTR && r.Cache != nil {
 		now = time.Now()
-		k := cacheKey{"", q.Class, q.Type, q.Name}
+		k := cacheKey{ctxKey, q.Class, q.Type, q.Name}
 		if v, found := r.Cache.Get(k.Hash()); found && v != nil && k.ValidateQuestion(v.msg) {
 			var minTTL uint32
 			n, minTTL = v.AdjustedResponse(buf, q.ID, r.CacheMaxAge, r.MaxTTL, now)
@@ -83,7 +91,7 @@ func (r DNS53) resolve(ctx context.Context, q query.Query, buf []byte, addr stri
 			msg:  make([]byte, n),
 		}
 		copy(v.msg, buf[:n])
-		r.Cache.Set(cacheKey{"", q.Class, q.Type, q.Name}.Hash(), v)
+		r.Cache.Set(cacheKey{ctxKey, q.Class, q.Type, q.Name}.Hash(), v)
 	}
 	if r.MaxTTL > 0 {
 		updateTTL(buf[:n], 0, 0, r.MaxTTL)
@@ -1,7 +1,6 @@
 package resolver
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -10,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/nextdns/nextdns/resolver/endpoint"
 	"github.com/nextdns/nextdns/resolver/query"
 )
 
@@ -50,6 +50,17 @@ type DOH struct {
 	// embed with the request.
 	ClientInfo func(query.Query) ClientInfo
 
+	// ECS defines the EDNS client subnet policy. The zero value strips ECS.
+	ECS ECSPolicy
+
+	// Privacy defines how queries are hardened before being sent upstream.
+	Privacy PrivacyPolicy
+
+	// UseGET sends queries using GET requests with the message ID set to 0
+	// (RFC 8484 section 4.1) so they can be cached by HTTP caches. Queries too
+	// long for a GET request are sent using POST.
+	UseGET bool
+
 	mu           sync.RWMutex
 	lastModified map[string]time.Time // per URL last conf last modified
 }
@@ -67,12 +78,17 @@ func (r *DOH) resolve(ctx context.Context, q query.Query, buf []byte, rt http.Ro
 	if url == "" {
 		url = "https://0.0.0.0"
 	}
+	var ctxKey string
+	if q, ctxKey, err = r.ECS.apply(q); err != nil {
+		return 0, i, fmt.Errorf("ecs: %v", err)
+	}
+	ctxKey += dnssecCacheKey(q)
 	var now time.Time
 	n = 0
 	// RFC1035, section 7.4: The results of an inverse query should not be cached
 	if q.Type != query.TypePTR && r.Cache != nil {
 		now = time.Now()
-		k := cacheKey{url, q.Class, q.Type, q.Name}
+		k := cacheKey{url + ctxKey, q.Class, q.Type, q.Name}
 		if v, found := r.Cache.Get(k.Hash()); found && v != nil && k.ValidateQuestion(v.msg) {
 			var minTTL uint32
 			n, minTTL = v.AdjustedResponse(buf, q.ID, r.CacheMaxAge, r.MaxTTL, now)
@@ -85,11 +101,14 @@ func (r *DOH) resolve(ctx context.Context, q query.Query, buf []byte, rt http.Ro
 			}
 		}
 	}
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(q.Payload))
+	orig := q
+	if q, err = r.Privacy.apply(q); err != nil {
+		return n, i, fmt.Errorf("privacy: %v", err)
+	}
+	req, err := endpoint.NewRequest(ctx, url, q.Payload, r.UseGET)
 	if err != nil {
 		return n, i, err
 	}
-	req.Header.Set("Content-Type", "application/dns-message")
 	req.Header.Set("X-Conf-Last-Modified", "true")
 	maps.Copy(req.Header, r.ExtraHeaders)
 	if ci.ID != "" {
@@ -119,6 +138,12 @@ func (r *DOH) resolve(ctx context.Context, q query.Query, buf []byte, rt http.Ro
 	n, truncated, err = readDNSResponse(res.Body, buf)
 	i.Transport = res.Proto
 	i.FromCache = false
+	if err == nil {
+		endpoint.RestoreID(req, q.Payload, buf[:n])
+		if err = r.Privacy.check(q, orig, buf[:n]); err != nil {
+			return 0, i, err
+		}
+	}
 	if q.Type != query.TypePTR && n > 0 && !truncated && err == nil && r.Cache != nil {
 		if now.IsZero() {
 			now = time.Now()
@@ -129,7 +154,7 @@ func (r *DOH) resolve(ctx context.Context, q query.Query, buf []byte, rt http.Ro
 			trans: res.Proto,
 		}
 		copy(v.msg, buf[:n])
-		r.Cache.Set(cacheKey{url, q.Class, q.Type, q.Name}.Hash(), v)
+		r.Cache.Set(cacheKey{url + ctxKey, q.Class, q.Type, q.Name}.Hash(), v)
 		r.updateLastMod(url, res.Header.Get("X-Conf-Last-Modified"))
 	}
 	if r.MaxTTL > 0 && n > 0 {
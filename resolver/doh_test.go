@@ -0,0 +1,69 @@
+package resolver
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+func TestDOH_resolveGET(t *testing.T) {
+	var method string
+	var id uint16
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		var msg []byte
+		var err error
+		if r.Method == "GET" {
+			msg, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		} else {
+			msg, err = io.ReadAll(r.Body)
+		}
+		if err != nil || len(msg) < 12 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		id = binary.BigEndian.Uint16(msg)
+		msg[2] |= 0x80 // QR bit
+		_, _ = w.Write(msg)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		options    []dnsmessage.Option
+		wantMethod string
+		wantID     uint16
+	}{
+		{"short", nil, "GET", 0},
+		{"long", []dnsmessage.Option{{Code: 65001, Data: make([]byte, 2000)}}, "POST", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &DOH{URL: srv.URL, UseGET: true}
+			q := newOptionsQuery(t, tt.options)
+			buf := make([]byte, 4096)
+			n, _, err := r.resolve(context.Background(), q, buf, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if method != tt.wantMethod {
+				t.Errorf("method = %s, want %s", method, tt.wantMethod)
+			}
+			if id != tt.wantID {
+				t.Errorf("sent ID = %d, want %d", id, tt.wantID)
+			}
+			if got := binary.BigEndian.Uint16(buf[:n]); got != q.ID {
+				t.Errorf("response ID = %d, want %d", got, q.ID)
+			}
+			if got := binary.BigEndian.Uint16(q.Payload); got != q.ID {
+				t.Errorf("query payload ID modified: %d", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+// ECSMode defines how EDNS client subnet (ECS) options are sent upstream.
+type ECSMode int
+
+const (
+	// ECSStrip never sends ECS upstream.
+	ECSStrip ECSMode = iota
+
+	// ECSPassthrough forwards the ECS option sent by the client, if any.
+	ECSPassthrough
+
+	// ECSSynthesize sends an ECS option computed from the client IP truncated
+	// to the policy prefix lengths.
+	ECSSynthesize
+)
+
+const (
+	defaultECSIPv4Prefix = 24
+	defaultECSIPv6Prefix = 56
+)
+
+// ECSPolicy defines the EDNS client subnet behavior of a resolver. The zero
+// value strips ECS.
+type ECSPolicy struct {
+	Mode ECSMode
+
+	// IPv4Prefix and IPv6Prefix define the prefix lengths used with
+	// ECSSynthesize. They default to 24 and 56 when zero. A prefix length of
+	// zero cannot be set: use ECSStrip to send no client address bits.
+	IPv4Prefix int
+	IPv6Prefix int
+}
+
+// ParseECSPolicy parses an ECS policy definition: strip, passthrough or
+// synthesize with optional IPv4 and IPv6 prefix lengths (synthesize/24/56).
+// Prefix lengths must be at least 1.
+func ParseECSPolicy(s string) (ECSPolicy, error) {
+	mode, prefixes, _ := strings.Cut(s, "/")
+	var p ECSPolicy
+	switch mode {
+	case "strip":
+	case "passthrough":
+		p.Mode = ECSPassthrough
+	case "synthesize":
+		p.Mode = ECSSynthesize
+	default:
+		return p, fmt.Errorf("%s: invalid ECS policy", s)
+	}
+	if prefixes == "" {
+		return p, nil
+	}
+	if p.Mode != ECSSynthesize {
+		return p, fmt.Errorf("%s: prefix lengths are only supported with synthesize", s)
+	}
+	v4, v6, _ := strings.Cut(prefixes, "/")
+	var err error
+	if p.IPv4Prefix, err = strconv.Atoi(v4); err != nil || p.IPv4Prefix < 1 || p.IPv4Prefix > 32 {
+		return p, fmt.Errorf("%s: invalid IPv4 prefix length", s)
+	}
+	if v6 != "" {
+		if p.IPv6Prefix, err = strconv.Atoi(v6); err != nil || p.IPv6Prefix < 1 || p.IPv6Prefix > 128 {
+			return p, fmt.Errorf("%s: invalid IPv6 prefix length", s)
+		}
+	}
+	return p, nil
+}
+
+func (p ECSPolicy) String() string {
+	switch p.Mode {
+	case ECSPassthrough:
+		return "passthrough"
+	case ECSSynthesize:
+		return fmt.Sprintf("synthesize/%d/%d", p.prefix(false), p.prefix(true))
+	}
+	return "strip"
+}
+
+func (p ECSPolicy) prefix(ipv6 bool) int {
+	if ipv6 {
+		if p.IPv6Prefix == 0 {
+			return defaultECSIPv6Prefix
+		}
+		return p.IPv6Prefix
+	}
+	if p.IPv4Prefix == 0 {
+		return defaultECSIPv4Prefix
+	}
+	return p.IPv4Prefix
+}
+
+// subnet returns the subnet to send upstream for q, or nil if none.
+func (p ECSPolicy) subnet(q query.Query) *net.IPNet {
+	switch p.Mode {
+	case ECSPassthrough:
+		return q.ClientSubnet
+	case ECSSynthesize:
+		if q.PeerIP == nil {
+			return nil
+		}
+		if ip4 := q.PeerIP.To4(); ip4 != nil {
+			mask := net.CIDRMask(p.prefix(false), 32)
+			return &net.IPNet{IP: ip4.Mask(mask), Mask: mask}
+		}
+		mask := net.CIDRMask(p.prefix(true), 128)
+		return &net.IPNet{IP: q.PeerIP.Mask(mask), Mask: mask}
+	}
+	return nil
+}
+
+// apply returns q with the ECS option set according to the policy, and a
+// string to add to the cache key so responses for different subnets are cached
+// separately.
+func (p ECSPolicy) apply(q query.Query) (query.Query, string, error) {
+	subnet := p.subnet(q)
+	if subnet == nil {
+		return q, "", nil
+	}
+	q, err := q.WithClientSubnet(subnet)
+	if err != nil {
+		return q, "", err
+	}
+	return q, subnet.String(), nil
+}
@@ -0,0 +1,123 @@
+package resolver
+
+import (
+	"net"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+func TestParseECSPolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"strip", "strip", false},
+		{"passthrough", "passthrough", false},
+		{"synthesize", "synthesize/24/56", false},
+		{"synthesize/20/48", "synthesize/20/48", false},
+		{"synthesize/16", "synthesize/16/56", false},
+		{"synthesize/33", "", true},
+		{"synthesize/0/0", "", true},
+		{"synthesize/24/0", "", true},
+		{"passthrough/24", "", true},
+		{"foo", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			p, err := ParseECSPolicy(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseECSPolicy() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && p.String() != tt.want {
+				t.Errorf("ParseECSPolicy() = %v, want %v", p, tt.want)
+			}
+		})
+	}
+}
+
+// newOptionsQuery returns a query for example.com with options set in its OPT
+// record. No OPT record is added if options is nil.
+func newOptionsQuery(t *testing.T, options []dnsmessage.Option) query.Query {
+	t.Helper()
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1, RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName("example.com."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	if options != nil {
+		_ = b.StartAdditionals()
+		_ = b.OPTResource(dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("."), Type: dnsmessage.TypeOPT, Class: 4096},
+			dnsmessage.OPTResource{Options: options})
+	}
+	payload, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := query.New(payload, net.ParseIP("192.168.1.10"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+// sentOptions returns the options of the OPT record found in payload.
+func sentOptions(t *testing.T, payload []byte) (options []dnsmessage.Option) {
+	t.Helper()
+	var msg dnsmessage.Message
+	if err := msg.Unpack(payload); err != nil {
+		t.Fatal(err)
+	}
+	for _, rr := range msg.Additionals {
+		if opt, ok := rr.Body.(*dnsmessage.OPTResource); ok {
+			options = append(options, opt.Options...)
+		}
+	}
+	return options
+}
+
+// sentECS returns the ECS option data found in payload, or nil.
+func sentECS(t *testing.T, payload []byte) []byte {
+	t.Helper()
+	for _, o := range sentOptions(t, payload) {
+		if o.Code == query.EDNS0_SUBNET {
+			return o.Data
+		}
+	}
+	return nil
+}
+
+func TestECSPolicy_apply(t *testing.T) {
+	clientECS := []byte{0, 1, 16, 0, 10, 20}
+	tests := []struct {
+		name    string
+		policy  ECSPolicy
+		subnet  []byte
+		want    string
+		wantKey string
+	}{
+		{"strip", ECSPolicy{}, clientECS, "", ""},
+		{"passthrough", ECSPolicy{Mode: ECSPassthrough}, clientECS, "\x00\x01\x10\x00\x0a\x14", "10.20.0.0/16"},
+		{"passthrough without ECS", ECSPolicy{Mode: ECSPassthrough}, nil, "", ""},
+		{"synthesize", ECSPolicy{Mode: ECSSynthesize}, nil, "\x00\x01\x18\x00\xc0\xa8\x01", "192.168.1.0/24"},
+		{"synthesize replaces client", ECSPolicy{Mode: ECSSynthesize, IPv4Prefix: 20}, clientECS, "\x00\x01\x14\x00\xc0\xa8\x00", "192.168.0.0/20"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var options []dnsmessage.Option
+			if tt.subnet != nil {
+				options = []dnsmessage.Option{{Code: query.EDNS0_SUBNET, Data: tt.subnet}}
+			}
+			q, key, err := tt.policy.apply(newOptionsQuery(t, options))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(sentECS(t, q.Payload)); got != tt.want {
+				t.Errorf("apply() ECS = %x, want %x", got, tt.want)
+			}
+			if key != tt.wantKey {
+				t.Errorf("apply() key = %q, want %q", key, tt.wantKey)
+			}
+		})
+	}
+}
@@ -3,15 +3,31 @@ package endpoint
 import (
 	"context"
 	"net"
+	"net/url"
 )
 
 type parallelDialer struct {
 	net.Dialer
+
+	// Proxy returns the proxy to connect through or nil to connect directly.
+	Proxy func() (*url.URL, error)
 }
 
 func (d *parallelDialer) DialParallel(ctx context.Context, network string, addrs []string) (net.Conn, error) {
+	dial := d.DialContext
+	if d.Proxy != nil {
+		u, err := d.Proxy()
+		if err != nil {
+			return nil, err
+		}
+		if u != nil {
+			dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialProxy(ctx, &d.Dialer, u, network, addr)
+			}
+		}
+	}
 	if len(addrs) == 1 {
-		return d.DialContext(ctx, network, addrs[0])
+		return dial(ctx, network, addrs[0])
 	}
 	returned := make(chan struct{})
 	defer close(returned)
@@ -23,7 +39,7 @@ func (d *parallelDialer) DialParallel(ctx context.Context, network string, addrs
 	results := make(chan dialResult)
 
 	racer := func(addr string) {
-		c, err := d.DialContext(ctx, network, addr)
+		c, err := dial(ctx, network, addr)
 		select {
 		case results <- dialResult{Conn: c, error: err}:
 		case <-returned:
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"context"
 	"crypto/x509"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
 )
@@ -38,10 +40,19 @@ type DOHEndpoint struct {
 	// through HTTPSSVC or Alt-Svc. If missing, h2 is assumed.
 	ALPN []string
 
+	// UseGET sends queries using GET requests with the message ID set to 0 to
+	// make them cacheable. Queries too long for a GET request use POST.
+	UseGET bool `json:"-"`
+
+	// TLS holds custom TLS settings for the endpoint. If nil, the bundled and
+	// system root CAs are trusted and TLS 1.3 is required.
+	TLS *TLSConfig `json:"-"`
+
 	once        sync.Once
 	transport   http.RoundTripper
 	onConnectMu sync.RWMutex
 	onConnect   func(*ConnectInfo)
+	proxy       ProxyFunc
 }
 
 func (e *DOHEndpoint) Protocol() Protocol {
@@ -71,9 +82,10 @@ func (e *DOHEndpoint) String() string {
 }
 
 func (e *DOHEndpoint) Exchange(ctx context.Context, payload, buf []byte) (n int, err error) {
-	req, _ := http.NewRequest("POST", "https://nowhere"+e.Path, bytes.NewReader(payload))
-	req.Header.Set("Content-Type", "application/dns-message")
-	req = req.WithContext(ctx)
+	req, err := NewRequest(ctx, "https://nowhere"+e.Path, payload, e.UseGET)
+	if err != nil {
+		return 0, err
+	}
 	res, err := e.RoundTrip(req)
 	if err != nil {
 		var uaeErr x509.UnknownAuthorityError
@@ -81,7 +93,7 @@ func (e *DOHEndpoint) Exchange(ctx context.Context, payload, buf []byte) (n int,
 			return 0, fmt.Errorf("roundtrip: %v (subject=%v, issuer=%v)",
 				err, uaeErr.Cert.Subject, uaeErr.Cert.Issuer)
 		}
-		return 0, fmt.Errorf("roundtrip: %v", err)
+		return 0, fmt.Errorf("roundtrip: %w", err)
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
@@ -91,9 +103,53 @@ func (e *DOHEndpoint) Exchange(ctx context.Context, payload, buf []byte) (n int,
 	if err != nil && !errors.Is(err, io.EOF) {
 		return n, fmt.Errorf("read: %v", err)
 	}
+	RestoreID(req, payload, buf[:n])
 	return n, nil
 }
 
+// maxGETURLLength is the maximum length of a GET request URL. Longer queries
+// are sent using POST.
+const maxGETURLLength = 2048
+
+// NewRequest returns a DoH request for the DNS message payload. If get is
+// true, the message is sent base64url encoded in a GET request with its ID set
+// to 0 as recommended by RFC 8484 section 4.1 so responses can be cached by
+// HTTP caches, unless the resulting URL is too long, in which case POST is
+// used. RestoreID must be called on the response.
+func NewRequest(ctx context.Context, url string, payload []byte, get bool) (*http.Request, error) {
+	if get && len(payload) >= 2 {
+		msg := make([]byte, len(payload))
+		copy(msg[2:], payload[2:])
+		sep := "?"
+		if strings.Contains(url, "?") {
+			sep = "&"
+		}
+		u := url + sep + "dns=" + base64.RawURLEncoding.EncodeToString(msg)
+		if len(u) <= maxGETURLLength {
+			req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Accept", "application/dns-message")
+			return req, nil
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	return req, nil
+}
+
+// RestoreID sets the ID of the query payload on the response msg when req was
+// sent with a zero ID.
+func RestoreID(req *http.Request, payload, msg []byte) {
+	if req.Method == "GET" && len(payload) >= 2 && len(msg) >= 2 {
+		copy(msg[:2], payload[:2])
+	}
+}
+
 // initTransport lazily builds the transport exactly once. Both RoundTrip and
 // closeTransport go through it so every read of e.transport is ordered after the
 // single write -- important now that queries read the active endpoint lock-free
@@ -150,3 +206,20 @@ func (e *DOHEndpoint) getOnConnect() func(*ConnectInfo) {
 	e.onConnectMu.RUnlock()
 	return fn
 }
+
+func (e *DOHEndpoint) setProxy(proxy ProxyFunc) {
+	e.onConnectMu.Lock()
+	e.proxy = proxy
+	e.onConnectMu.Unlock()
+}
+
+// proxyURL returns the proxy to use to connect to the endpoint, or nil.
+func (e *DOHEndpoint) proxyURL() (*url.URL, error) {
+	e.onConnectMu.RLock()
+	proxy := e.proxy
+	e.onConnectMu.RUnlock()
+	if proxy == nil {
+		return nil, nil
+	}
+	return proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: e.Hostname}})
+}
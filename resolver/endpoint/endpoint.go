@@ -22,6 +22,8 @@ func (p Protocol) String() string {
 		return "doh"
 	case ProtocolDNS:
 		return "dns"
+	case ProtocolODOH:
+		return "odoh"
 	default:
 		return "unknown"
 	}
@@ -30,6 +32,7 @@ func (p Protocol) String() string {
 const (
 	ProtocolDOH Protocol = iota
 	ProtocolDNS
+	ProtocolODOH
 )
 
 // Endpoint represents a DNS server endpoint.
@@ -52,6 +55,7 @@ type Endpoint interface {
 //
 //   - DoH:   https://doh.server.com/path
 //   - DoH:   https://doh.server.com/path#1.2.3.4 // with bootstrap
+//   - ODoH:  odoh://odoh.target.com/dns-query?relay=https://relay.com/proxy
 //   - DNS53: 1.2.3.4
 //   - DNS53: 1.2.3.4:5353
 func New(server string) (Endpoint, error) {
@@ -69,6 +73,21 @@ func New(server string) (Endpoint, error) {
 		}
 		return e, nil
 	}
+	if strings.HasPrefix(server, "odoh://") {
+		u, err := url.Parse(server)
+		if err != nil {
+			return nil, err
+		}
+		relay := u.Query().Get("relay")
+		if !strings.HasPrefix(relay, "https://") {
+			return nil, errors.New("missing or invalid ODoH relay")
+		}
+		return &ODOHEndpoint{
+			Target:     u.Host,
+			TargetPath: u.Path,
+			Relay:      relay,
+		}, nil
+	}
 
 	host, port, err := net.SplitHostPort(server)
 	if err != nil {
@@ -231,6 +250,9 @@ func (p *SourceURLProvider) GetEndpoints(ctx context.Context) ([]Endpoint, error
 type SourceHTTPSSVCProvider struct {
 	Hostname string
 	Source   Endpoint
+
+	// Proxy is used to reach Source when it is a DoH endpoint.
+	Proxy ProxyFunc
 }
 
 func (p *SourceHTTPSSVCProvider) String() string {
@@ -239,6 +261,9 @@ func (p *SourceHTTPSSVCProvider) String() string {
 
 // GetEndpoints implements the Provider interface.
 func (p *SourceHTTPSSVCProvider) GetEndpoints(ctx context.Context) ([]Endpoint, error) {
+	if doh, ok := p.Source.(*DOHEndpoint); ok {
+		doh.setProxy(p.Proxy)
+	}
 	payload := make([]byte, 0, 1220)
 	b := dnsmessage.NewBuilder(payload, dnsmessage.Header{
 		RecursionDesired: true,
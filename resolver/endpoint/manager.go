@@ -72,6 +72,10 @@ type Manager struct {
 	// endpoints).
 	OnConnect func(*ConnectInfo)
 
+	// Proxy returns the proxy to use to connect to DoH endpoints. If nil,
+	// connections are direct. Endpoint tests go through the proxy as well.
+	Proxy ProxyFunc
+
 	// OnError is called each time a test on e failed, forcing Manager to
 	// fallback to the next endpoint. If e is nil, the error happened on the
 	// Provider.
@@ -143,8 +147,11 @@ func (m *Manager) testLocked(ctx context.Context) error {
 	if prev := m.activeEndpoint.Load(); prev == nil || !prev.Endpoint.Equal(ae.Endpoint) {
 		m.activeEndpoint.Store(ae)
 		if prev != nil {
-			if doh, ok := prev.Endpoint.(*DOHEndpoint); ok {
-				doh.closeTransport()
+			switch e := prev.Endpoint.(type) {
+			case *DOHEndpoint:
+				e.closeTransport()
+			case *ODOHEndpoint:
+				e.closeTransport()
 			}
 		}
 		if m.OnChange != nil {
@@ -253,6 +260,11 @@ func (m *Manager) newActiveEndpointLocked(e Endpoint) (ae *activeEnpoint) {
 			doh.transport = m.testNewTransport(doh)
 		}
 		doh.setOnConnect(m.OnConnect)
+		doh.setProxy(m.Proxy)
+	}
+	if odoh, ok := e.(*ODOHEndpoint); ok {
+		odoh.setOnConnect(m.OnConnect)
+		odoh.setProxy(m.Proxy)
 	}
 	return ae
 }
@@ -292,6 +304,15 @@ func (m *Manager) getActiveEndpoint(ctx context.Context) (*activeEnpoint, error)
 	return ae, nil
 }
 
+// ActiveEndpoint returns the currently active endpoint without triggering any
+// test. It returns nil if no endpoint has been selected yet.
+func (m *Manager) ActiveEndpoint() Endpoint {
+	if ae := m.activeEndpoint.Load(); ae != nil {
+		return ae.Endpoint
+	}
+	return nil
+}
+
 // Do runs action against the current active endpoint. Reads of the active
 // endpoint are lock-free and may briefly return the endpoint from just before an
 // in-flight background test swaps in a new one (availability over freshness); the
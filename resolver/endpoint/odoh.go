@@ -0,0 +1,383 @@
+package endpoint
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hpke"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	odohVersion         = 0x0001
+	odohMessageQuery    = 0x01
+	odohMessageResponse = 0x02
+
+	// odohConfigMaxAge is the maximum time a target configuration is used
+	// before being fetched again.
+	odohConfigMaxAge = 24 * time.Hour
+
+	odohContentType = "application/oblivious-dns-message"
+)
+
+var errODOHKeyRejected = errors.New("target rejected the key")
+
+// ODOHEndpoint represents an Oblivious DoH (RFC 9230) server endpoint. Queries
+// are encrypted for the target and sent through the relay so the target does
+// not see the IP of the client and the relay cannot read the queries.
+type ODOHEndpoint struct {
+	// Target is the hostname of the ODoH target.
+	Target string
+
+	// TargetPath is the path of the DoH service of the target.
+	TargetPath string
+
+	// Relay is the URL of the ODoH relay (also called proxy) the queries are
+	// sent to.
+	Relay string
+
+	once   sync.Once
+	relay  *DOHEndpoint
+	target *DOHEndpoint
+
+	mu        sync.Mutex
+	config    *odohConfig
+	fetchedAt time.Time
+}
+
+func (e *ODOHEndpoint) Protocol() Protocol {
+	return ProtocolODOH
+}
+
+func (e *ODOHEndpoint) Equal(e2 Endpoint) bool {
+	if e2, ok := e2.(*ODOHEndpoint); ok {
+		return e.Target == e2.Target && e.TargetPath == e2.TargetPath && e.Relay == e2.Relay
+	}
+	return false
+}
+
+func (e *ODOHEndpoint) String() string {
+	return fmt.Sprintf("odoh://%s%s?relay=%s", e.Target, e.TargetPath, e.Relay)
+}
+
+// init creates the DoH endpoints used to contact the relay and the target.
+func (e *ODOHEndpoint) init() {
+	e.once.Do(func() {
+		e.target = &DOHEndpoint{Hostname: e.Target}
+		e.relay = &DOHEndpoint{}
+		if u, err := url.Parse(e.Relay); err == nil {
+			e.relay.Hostname = u.Host
+		}
+	})
+}
+
+func (e *ODOHEndpoint) setOnConnect(fn func(*ConnectInfo)) {
+	e.init()
+	e.relay.setOnConnect(fn)
+	e.target.setOnConnect(fn)
+}
+
+func (e *ODOHEndpoint) setProxy(proxy ProxyFunc) {
+	e.init()
+	e.relay.setProxy(proxy)
+	e.target.setProxy(proxy)
+}
+
+func (e *ODOHEndpoint) closeTransport() {
+	if e == nil {
+		return
+	}
+	e.init()
+	e.relay.closeTransport()
+	e.target.closeTransport()
+}
+
+// Exchange encrypts payload for the target, sends it through the relay and
+// decrypts the response in buf.
+func (e *ODOHEndpoint) Exchange(ctx context.Context, payload, buf []byte) (n int, err error) {
+	e.init()
+	c, err := e.getConfig(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("config: %v", err)
+	}
+	body, sender, qplain, err := c.sealQuery(payload)
+	if err != nil {
+		return 0, fmt.Errorf("seal: %v", err)
+	}
+	u, err := url.Parse(e.Relay)
+	if err != nil {
+		return 0, err
+	}
+	u.Host = "nowhere"
+	v := u.Query()
+	v.Set("targethost", e.Target)
+	v.Set("targetpath", e.TargetPath)
+	u.RawQuery = v.Encode()
+	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", odohContentType)
+	req.Header.Set("Accept", odohContentType)
+	res, err := e.relay.RoundTrip(req)
+	if err != nil {
+		return 0, fmt.Errorf("roundtrip: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusUnauthorized {
+		// The target rotated its key, fetch the new configuration on next
+		// query.
+		e.resetConfig(c)
+		return 0, errODOHKeyRejected
+	}
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("status: %d", res.StatusCode)
+	}
+	resp, err := io.ReadAll(io.LimitReader(res.Body, 65535+512))
+	if err != nil {
+		return 0, fmt.Errorf("read: %v", err)
+	}
+	msg, err := c.openResponse(sender, qplain, resp)
+	if err != nil {
+		return 0, fmt.Errorf("open: %v", err)
+	}
+	n = copy(buf, msg)
+	if n < len(msg) && n > 2 {
+		buf[2] |= 0x2 // mark response as truncated
+	}
+	return n, nil
+}
+
+// getConfig returns the target configuration, fetching it if needed.
+func (e *ODOHEndpoint) getConfig(ctx context.Context) (*odohConfig, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.config != nil && time.Since(e.fetchedAt) < odohConfigMaxAge {
+		return e.config, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://nowhere/.well-known/odohconfigs", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := e.target.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status: %d", res.StatusCode)
+	}
+	b, err := io.ReadAll(io.LimitReader(res.Body, 65535+2))
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseODOHConfigs(b)
+	if err != nil {
+		return nil, err
+	}
+	e.config, e.fetchedAt = c, time.Now()
+	return c, nil
+}
+
+func (e *ODOHEndpoint) resetConfig(c *odohConfig) {
+	e.mu.Lock()
+	if e.config == c {
+		e.config = nil
+	}
+	e.mu.Unlock()
+}
+
+// odohConfig is a target configuration using a supported cipher suite.
+type odohConfig struct {
+	kem       hpke.KEM
+	kdf       hpke.KDF
+	aead      hpke.AEAD
+	hash      func() hash.Hash
+	keySize   int
+	publicKey hpke.PublicKey
+	keyID     []byte
+}
+
+// readVector reads a vector with a 16 bits length prefix.
+func readVector(b []byte) (v, rest []byte, err error) {
+	if len(b) < 2 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	l := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+l {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	return b[2 : 2+l], b[2+l:], nil
+}
+
+func appendVector(b, v []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(v)))
+	return append(b, v...)
+}
+
+// parseODOHConfigs returns the first configuration of the ObliviousDoHConfigs
+// structure b with a supported version and cipher suite.
+func parseODOHConfigs(b []byte) (*odohConfig, error) {
+	configs, _, err := readVector(b)
+	if err != nil {
+		return nil, err
+	}
+	for len(configs) > 0 {
+		if len(configs) < 2 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		version := binary.BigEndian.Uint16(configs)
+		var contents []byte
+		if contents, configs, err = readVector(configs[2:]); err != nil {
+			return nil, err
+		}
+		if version != odohVersion {
+			continue
+		}
+		if c, err := parseODOHConfigContents(contents); err == nil {
+			return c, nil
+		}
+	}
+	return nil, errors.New("no supported configuration")
+}
+
+func parseODOHConfigContents(contents []byte) (*odohConfig, error) {
+	if len(contents) < 6 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	kemID := binary.BigEndian.Uint16(contents)
+	kdfID := binary.BigEndian.Uint16(contents[2:])
+	aeadID := binary.BigEndian.Uint16(contents[4:])
+	pk, _, err := readVector(contents[6:])
+	if err != nil {
+		return nil, err
+	}
+	c := &odohConfig{}
+	if c.kem, err = hpke.NewKEM(kemID); err != nil {
+		return nil, err
+	}
+	if c.kdf, err = hpke.NewKDF(kdfID); err != nil {
+		return nil, err
+	}
+	switch kdfID {
+	case 0x0001:
+		c.hash = sha256.New
+	case 0x0002:
+		c.hash = sha512.New384
+	case 0x0003:
+		c.hash = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported KDF %d", kdfID)
+	}
+	// The response is encrypted directly with the AEAD, only AES-GCM is
+	// supported.
+	switch aeadID {
+	case 0x0001:
+		c.keySize = 16
+	case 0x0002:
+		c.keySize = 32
+	default:
+		return nil, fmt.Errorf("unsupported AEAD %d", aeadID)
+	}
+	if c.aead, err = hpke.NewAEAD(aeadID); err != nil {
+		return nil, err
+	}
+	if c.publicKey, err = c.kem.NewPublicKey(pk); err != nil {
+		return nil, err
+	}
+	prk, err := hkdf.Extract(c.hash, contents, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.keyID, err = hkdf.Expand(c.hash, prk, "odoh key id", c.hash().Size()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// sealQuery encrypts the DNS message msg and returns the ObliviousDoHMessage
+// to send, with the HPKE context and the plaintext needed to decrypt the
+// response.
+func (c *odohConfig) sealQuery(msg []byte) (body []byte, sender *hpke.Sender, qplain []byte, err error) {
+	qplain = appendVector(nil, msg)
+	qplain = appendVector(qplain, nil) // no padding
+	enc, sender, err := hpke.NewSender(c.publicKey, c.kdf, c.aead, []byte("odoh query"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	aad := appendVector([]byte{odohMessageQuery}, c.keyID)
+	ct, err := sender.Seal(aad, qplain)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	body = appendVector(aad, append(enc, ct...))
+	return body, sender, qplain, nil
+}
+
+// openResponse decrypts the ObliviousDoHMessage response body and returns the
+// DNS message it contains.
+func (c *odohConfig) openResponse(sender *hpke.Sender, qplain, body []byte) ([]byte, error) {
+	if len(body) < 1 || body[0] != odohMessageResponse {
+		return nil, errors.New("not a response message")
+	}
+	nonce, rest, err := readVector(body[1:])
+	if err != nil {
+		return nil, err
+	}
+	ct, _, err := readVector(rest)
+	if err != nil {
+		return nil, err
+	}
+	aead, aeadNonce, err := c.responseAEAD(sender, qplain, nonce)
+	if err != nil {
+		return nil, err
+	}
+	aad := appendVector([]byte{odohMessageResponse}, nonce)
+	rplain, err := aead.Open(nil, aeadNonce, ct, aad)
+	if err != nil {
+		return nil, err
+	}
+	msg, _, err := readVector(rplain)
+	return msg, err
+}
+
+// responseAEAD derives the response key and nonce as defined in RFC 9230
+// section 6.4.
+func (c *odohConfig) responseAEAD(sender *hpke.Sender, qplain, respNonce []byte) (aead cipher.AEAD, nonce []byte, err error) {
+	secret, err := sender.Export("odoh response", c.keySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	salt := appendVector(bytes.Clone(qplain), respNonce)
+	prk, err := hkdf.Extract(c.hash, secret, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := hkdf.Expand(c.hash, prk, "odoh key", c.keySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if aead, err = cipher.NewGCM(block); err != nil {
+		return nil, nil, err
+	}
+	if nonce, err = hkdf.Expand(c.hash, prk, "odoh nonce", aead.NonceSize()); err != nil {
+		return nil, nil, err
+	}
+	return aead, nonce, nil
+}
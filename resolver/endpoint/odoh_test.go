@@ -0,0 +1,199 @@
+package endpoint
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hpke"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+// odohTestTarget is an ODoH target and relay served by the same server.
+type odohTestTarget struct {
+	mu       sync.Mutex
+	key      hpke.PrivateKey
+	contents []byte
+	keyID    []byte
+	queries  int
+}
+
+func (tt *odohTestTarget) rotate(t *testing.T) {
+	t.Helper()
+	key, err := hpke.DHKEM(ecdh.X25519()).GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := []byte{0x00, 0x20, 0x00, 0x01, 0x00, 0x01} // X25519, HKDF-SHA256, AES-128-GCM
+	contents = appendVector(contents, key.PublicKey().Bytes())
+	prk, _ := hkdf.Extract(sha256.New, contents, nil)
+	keyID, _ := hkdf.Expand(sha256.New, prk, "odoh key id", sha256.Size)
+	tt.mu.Lock()
+	tt.key, tt.contents, tt.keyID = key, contents, keyID
+	tt.mu.Unlock()
+}
+
+func (tt *odohTestTarget) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	switch {
+	case r.Host == "target.example.com" && r.URL.Path == "/.well-known/odohconfigs":
+		config := appendVector([]byte{0x00, 0x01}, tt.contents)
+		_, _ = w.Write(appendVector(nil, config))
+	case r.Host == "relay.example.com" && r.URL.Path == "/proxy":
+		if r.URL.Query().Get("targethost") != "target.example.com" || r.URL.Query().Get("targetpath") != "/dns-query" ||
+			r.Header.Get("Content-Type") != odohContentType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		resp, status := tt.answer(body)
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		tt.queries++
+		w.Header().Set("Content-Type", odohContentType)
+		_, _ = w.Write(resp)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// answer decrypts the query and returns an encrypted response echoing it.
+func (tt *odohTestTarget) answer(body []byte) ([]byte, int) {
+	if len(body) < 1 || body[0] != odohMessageQuery {
+		return nil, http.StatusBadRequest
+	}
+	keyID, rest, err := readVector(body[1:])
+	if err != nil {
+		return nil, http.StatusBadRequest
+	}
+	if !bytes.Equal(keyID, tt.keyID) {
+		return nil, http.StatusUnauthorized
+	}
+	encrypted, _, err := readVector(rest)
+	if err != nil || len(encrypted) < 32 {
+		return nil, http.StatusBadRequest
+	}
+	recipient, err := hpke.NewRecipient(encrypted[:32], tt.key, hpke.HKDFSHA256(), hpke.AES128GCM(), []byte("odoh query"))
+	if err != nil {
+		return nil, http.StatusBadRequest
+	}
+	qplain, err := recipient.Open(appendVector([]byte{odohMessageQuery}, keyID), encrypted[32:])
+	if err != nil {
+		return nil, http.StatusBadRequest
+	}
+	msg, _, err := readVector(qplain)
+	if err != nil || len(msg) < 12 {
+		return nil, http.StatusBadRequest
+	}
+	msg = bytes.Clone(msg)
+	msg[2] |= 0x80 // QR bit
+
+	respNonce := make([]byte, 16)
+	_, _ = rand.Read(respNonce)
+	secret, _ := recipient.Export("odoh response", 16)
+	prk, _ := hkdf.Extract(sha256.New, secret, appendVector(bytes.Clone(qplain), respNonce))
+	key, _ := hkdf.Expand(sha256.New, prk, "odoh key", 16)
+	nonce, _ := hkdf.Expand(sha256.New, prk, "odoh nonce", 12)
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	aad := appendVector([]byte{odohMessageResponse}, respNonce)
+	rplain := appendVector(appendVector(nil, msg), nil)
+	return appendVector(aad, aead.Seal(nil, nonce, rplain, aad)), http.StatusOK
+}
+
+func TestODOHEndpoint_Exchange(t *testing.T) {
+	target := &odohTestTarget{}
+	target.rotate(t)
+	srv := httptest.NewUnstartedServer(target)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+	getRootCAs().AddCert(srv.Certificate())
+
+	// Route the port 443 connections to the test server through a proxy.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	p := &testProxy{Listener: ln, backend: srv.Listener.Addr().String()}
+	go p.serve(httpConnectHandshake)
+	proxy, _ := ParseProxy("http://" + ln.Addr().String())
+
+	e, err := New("odoh://target.example.com/dns-query?relay=https://relay.example.com/proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Protocol() != ProtocolODOH {
+		t.Fatalf("Protocol() = %v, want odoh", e.Protocol())
+	}
+	defer e.(*ODOHEndpoint).closeTransport()
+	m := &Manager{
+		Providers: []Provider{StaticProvider{e}},
+		Proxy:     proxy,
+		OnError: func(e Endpoint, err error) {
+			t.Errorf("endpoint test failed: %v", err)
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Test(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName("example.com."), Type: dnsmessage.TypeAAAA, Class: dnsmessage.ClassINET})
+	payload, _ := b.Finish()
+	buf := make([]byte, 512)
+	n, err := e.Exchange(ctx, payload, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bytes.Clone(payload)
+	want[2] |= 0x80
+	if !bytes.Equal(buf[:n], want) {
+		t.Errorf("Exchange() = %x, want %x", buf[:n], want)
+	}
+
+	// After a key rotation, the first query is rejected and the configuration
+	// is fetched again.
+	target.rotate(t)
+	if _, err := e.Exchange(ctx, payload, buf); !errors.Is(err, errODOHKeyRejected) {
+		t.Fatalf("Exchange() err = %v, want %v", err, errODOHKeyRejected)
+	}
+	if _, err := e.Exchange(ctx, payload, buf); err != nil {
+		t.Fatalf("Exchange() after rotation err = %v", err)
+	}
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	if target.queries != 3 {
+		t.Errorf("target answered %d queries, want 3", target.queries)
+	}
+}
+
+func TestNew_ODOH(t *testing.T) {
+	if _, err := New("odoh://target.example.com/dns-query"); err == nil {
+		t.Error("New() without relay: expected error")
+	}
+	e := MustNew("odoh://target.example.com/dns-query?relay=https://relay.example.com/proxy")
+	if got, want := e.String(), "odoh://target.example.com/dns-query?relay=https://relay.example.com/proxy"; got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,241 @@
+package endpoint
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newDoHTestServer starts a TLS DoH server answering queries with the query
+// itself flagged as a response. Its certificate is added to the root CAs.
+func newDoHTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if len(b) > 2 {
+			b[2] |= 0x80 // QR bit
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(b)
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	getRootCAs().AddCert(srv.Certificate())
+	return srv
+}
+
+// testProxy is a proxy forwarding all the tunnels to backend and recording
+// the requested targets.
+type testProxy struct {
+	net.Listener
+	backend string
+
+	mu      sync.Mutex
+	targets []string
+	auth    string
+}
+
+func (p *testProxy) serve(handshake func(c net.Conn) (target, auth string, err error)) {
+	for {
+		c, err := p.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer c.Close()
+			target, auth, err := handshake(c)
+			if err != nil {
+				return
+			}
+			p.mu.Lock()
+			p.targets = append(p.targets, target)
+			p.auth = auth
+			p.mu.Unlock()
+			bc, err := net.Dial("tcp", p.backend)
+			if err != nil {
+				return
+			}
+			defer bc.Close()
+			go func() { _, _ = io.Copy(bc, c) }()
+			_, _ = io.Copy(c, bc)
+		}()
+	}
+}
+
+func (p *testProxy) result() ([]string, string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.targets, p.auth
+}
+
+func httpConnectHandshake(c net.Conn) (string, string, error) {
+	req, err := http.ReadRequest(bufio.NewReader(c))
+	if err != nil {
+		return "", "", err
+	}
+	user, pass, _ := (&http.Request{Header: http.Header{"Authorization": req.Header["Proxy-Authorization"]}}).BasicAuth()
+	_, err = io.WriteString(c, "HTTP/1.0 200 Connection established\r\n\r\n")
+	return req.Host, user + ":" + pass, err
+}
+
+func socks5Handshake(c net.Conn) (string, string, error) {
+	r := bufio.NewReader(c)
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return "", "", err
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return "", "", err
+	}
+	var auth string
+	if _, err := c.Write([]byte{5, 2}); err != nil { // username/password
+		return "", "", err
+	}
+	// RFC 1929 sub-negotiation.
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return "", "", err
+	}
+	user := make([]byte, hdr[1])
+	if _, err := io.ReadFull(r, user); err != nil {
+		return "", "", err
+	}
+	plen, _ := r.ReadByte()
+	pass := make([]byte, plen)
+	if _, err := io.ReadFull(r, pass); err != nil {
+		return "", "", err
+	}
+	auth = string(user) + ":" + string(pass)
+	if _, err := c.Write([]byte{1, 0}); err != nil {
+		return "", "", err
+	}
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(r, req); err != nil {
+		return "", "", err
+	}
+	var host string
+	switch req[3] {
+	case 1, 4:
+		ip := make(net.IP, 4)
+		if req[3] == 4 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", "", err
+		}
+		host = ip.String()
+	case 3:
+		l, _ := r.ReadByte()
+		name := make([]byte, l)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", "", err
+		}
+		host = string(name)
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", "", err
+	}
+	_, err := c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), auth, err
+}
+
+func TestManager_Proxy(t *testing.T) {
+	srv := newDoHTestServer(t)
+	tests := []struct {
+		scheme    string
+		handshake func(c net.Conn) (string, string, error)
+	}{
+		{"http", httpConnectHandshake},
+		{"https", httpConnectHandshake},
+		{"socks5", socks5Handshake},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scheme, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			p := &testProxy{Listener: ln, backend: srv.Listener.Addr().String()}
+			if tt.scheme == "https" {
+				p.Listener = tls.NewListener(ln, &tls.Config{Certificates: srv.TLS.Certificates})
+			}
+			go p.serve(tt.handshake)
+
+			proxy, err := ParseProxy(tt.scheme + "://user:secret@" + ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := &DOHEndpoint{Hostname: "example.com", Bootstrap: []string{"192.0.2.1"}}
+			defer e.closeTransport()
+			var mu sync.Mutex
+			var ci *ConnectInfo
+			m := &Manager{
+				Providers: []Provider{StaticProvider{e}},
+				Proxy:     proxy,
+				OnConnect: func(c *ConnectInfo) {
+					mu.Lock()
+					ci = c
+					mu.Unlock()
+				},
+				OnError: func(e Endpoint, err error) {
+					t.Errorf("endpoint test failed: %v", err)
+				},
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := m.Test(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			targets, auth := p.result()
+			if len(targets) != 1 || targets[0] != "192.0.2.1:443" {
+				t.Errorf("proxy targets = %v, want [192.0.2.1:443]", targets)
+			}
+			if auth != "user:secret" {
+				t.Errorf("proxy auth = %q, want user:secret", auth)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if ci == nil {
+				t.Fatal("OnConnect not called")
+			}
+			if ci.ServerAddr != "192.0.2.1:443" {
+				t.Errorf("ServerAddr = %s, want 192.0.2.1:443", ci.ServerAddr)
+			}
+			if ci.Proxy != ln.Addr().String() {
+				t.Errorf("Proxy = %s, want %s", ci.Proxy, ln.Addr())
+			}
+			if _, found := ci.ConnectTimes[ci.Proxy]; !found {
+				t.Errorf("ConnectTimes = %v, missing proxy", ci.ConnectTimes)
+			}
+		})
+	}
+}
+
+func TestParseProxy(t *testing.T) {
+	for _, s := range []string{"ftp://proxy:21", "http://", "socks4://proxy"} {
+		if _, err := ParseProxy(s); err == nil {
+			t.Errorf("ParseProxy(%q): expected error", s)
+		}
+	}
+	p, err := ParseProxy("")
+	if err != nil || p != nil {
+		t.Errorf("ParseProxy(\"\") = %v, %v, want nil, nil", p, err)
+	}
+	p, err = ParseProxy("env")
+	if err != nil || p == nil {
+		t.Fatalf("ParseProxy(env) = %v, %v", p, err)
+	}
+}
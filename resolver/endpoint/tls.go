@@ -0,0 +1,144 @@
+package endpoint
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// TLSConfig holds the TLS settings of an endpoint.
+type TLSConfig struct {
+	// CAFile is the path of a PEM file with root CAs trusted in addition to
+	// the bundled and system ones.
+	CAFile string
+
+	// Pins is a set of base64 encoded SHA-256 hashes of certificate
+	// SubjectPublicKeyInfo. When set, one of the certificates of the verified
+	// chain must match one of the pins.
+	Pins []string
+
+	// CertFile and KeyFile are the paths of the PEM encoded client certificate
+	// and key to present to the server.
+	CertFile string
+	KeyFile  string
+
+	// MinVersion is the minimum TLS version accepted. If zero, TLS 1.3 is
+	// required.
+	MinVersion uint16
+}
+
+// PinError is returned when none of the certificates presented by the server
+// match the configured pins.
+type PinError struct {
+	ServerName string
+
+	// Got is the list of pins of the certificates presented by the server.
+	Got []string
+}
+
+func (e *PinError) Error() string {
+	return fmt.Sprintf("%s: certificate pin mismatch (got %s)", e.ServerName, strings.Join(e.Got, ", "))
+}
+
+// ParseTLSVersion parses a TLS version in the 1.2 form. Only TLS 1.2 and 1.3
+// are supported.
+func ParseTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("%s: invalid TLS version", v)
+}
+
+// Validate loads the files referenced by c and checks the pins.
+func (c *TLSConfig) Validate() error {
+	_, err := c.config("")
+	return err
+}
+
+// config returns the client TLS configuration to connect to serverName.
+func (c *TLSConfig) config(serverName string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		RootCAs:            getRootCAs(),
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		MinVersion:         tls.VersionTLS13,
+	}
+	if c == nil {
+		return cfg, nil
+	}
+	if c.MinVersion != 0 {
+		cfg.MinVersion = c.MinVersion
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		var pool *x509.CertPool
+		if cfg.RootCAs != nil {
+			pool = cfg.RootCAs.Clone()
+		} else if pool, err = x509.SystemCertPool(); err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificate found", c.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if len(c.Pins) > 0 {
+		for _, pin := range c.Pins {
+			if b, err := base64.StdEncoding.DecodeString(pin); err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("%s: invalid pin: not a base64 SHA-256 hash", pin)
+			}
+		}
+		pins := slices.Clone(c.Pins)
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			certs := cs.PeerCertificates
+			if len(cs.VerifiedChains) > 0 {
+				certs = slices.Concat(cs.VerifiedChains...)
+			}
+			var got []string
+			for _, cert := range certs {
+				h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				pin := base64.StdEncoding.EncodeToString(h[:])
+				if slices.Contains(pins, pin) {
+					return nil
+				}
+				if !slices.Contains(got, pin) {
+					got = append(got, pin)
+				}
+			}
+			return &PinError{ServerName: cs.ServerName, Got: got}
+		}
+	}
+	return cfg, nil
+}
+
+// failedTransport is a transport failing all requests, used when the transport
+// cannot be configured.
+type failedTransport struct {
+	err error
+}
+
+func (t failedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, t.err
+}
@@ -0,0 +1,191 @@
+package endpoint
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, tmpl x509.Certificate) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.Subject = pkix.Name{CommonName: cn}
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := &tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+func (c *testCert) pin() string {
+	h := sha256.Sum256(c.cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// writeFiles writes the certificate and key in PEM files and returns their
+// paths.
+func (c *testCert) writeFiles(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestDOHEndpoint_TLS(t *testing.T) {
+	ca := newTestCert(t, "Test CA", nil, x509.Certificate{
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	server := newTestCert(t, "example.com", ca, x509.Certificate{
+		DNSNames:    []string{"doh.example.com"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	client := newTestCert(t, "client", ca, x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	caFile, _ := ca.writeFiles(t)
+	certFile, keyFile := client.writeFiles(t)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if len(b) > 2 {
+			b[2] |= 0x80 // QR bit
+		}
+		_, _ = w.Write(b)
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{server.tlsCertificate()},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	// Route the port 443 connections to the test server through a proxy.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	p := &testProxy{Listener: ln, backend: srv.Listener.Addr().String()}
+	go p.serve(httpConnectHandshake)
+	proxy := http.ProxyURL(&url.URL{Scheme: "http", Host: ln.Addr().String()})
+
+	tests := []struct {
+		name    string
+		tls     *TLSConfig
+		wantErr bool
+		wantPin bool
+	}{
+		{"unknown CA", &TLSConfig{CertFile: certFile, KeyFile: keyFile}, true, false},
+		{"no client cert", &TLSConfig{CAFile: caFile}, true, false},
+		{"mtls", &TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, false, false},
+		{"pin", &TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, Pins: []string{client.pin(), ca.pin()}}, false, false},
+		{"pin mismatch", &TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, Pins: []string{client.pin()}}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &DOHEndpoint{Hostname: "doh.example.com", Bootstrap: []string{"192.0.2.1"}, TLS: tt.tls}
+			defer e.closeTransport()
+			var testErr error
+			m := &Manager{
+				Providers: []Provider{StaticProvider{e}},
+				Proxy:     proxy,
+				OnError: func(e Endpoint, err error) {
+					testErr = err
+				},
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := m.Test(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if (testErr != nil) != tt.wantErr {
+				t.Fatalf("OnError err = %v, want error %v", testErr, tt.wantErr)
+			}
+			var pinErr *PinError
+			if errors.As(testErr, &pinErr) != tt.wantPin {
+				t.Errorf("OnError err = %v, want pin error %v", testErr, tt.wantPin)
+			}
+		})
+	}
+}
+
+func TestTLSConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tls     *TLSConfig
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"missing CA file", &TLSConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")}, true},
+		{"missing key", &TLSConfig{CertFile: "cert.pem"}, true},
+		{"invalid pin", &TLSConfig{Pins: []string{"not-a-pin"}}, true},
+		{"valid pin", &TLSConfig{Pins: []string{base64.StdEncoding.EncodeToString(make([]byte, 32))}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.tls.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
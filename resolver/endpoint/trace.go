@@ -17,6 +17,10 @@ type ConnectInfo struct {
 	Protocol     string
 	TLSTime      time.Duration
 	TLSVersion   string
+
+	// Proxy is the address of the proxy the connection goes through, if any.
+	// ConnectTimes is then keyed by the proxy address.
+	Proxy string
 }
 
 type timer struct {
@@ -62,6 +66,7 @@ func withConnectInfo(ctx context.Context) (context.Context, *ConnectInfo) {
 			ci.Connect = true
 			if hci.Conn != nil {
 				ci.ServerAddr = hci.Conn.RemoteAddr().String()
+				ci.Proxy = connProxy(hci.Conn)
 				ci.ConnectTimes = make(map[string]time.Duration, len(connectTimes))
 				for addr, t := range connectTimes {
 					ci.ConnectTimes[addr] = t.dur
@@ -2,7 +2,7 @@ package endpoint
 
 import (
 	"context"
-	"crypto/tls"
+	"fmt"
 	"net"
 	"net/http"
 	"runtime"
@@ -19,13 +19,13 @@ type transport struct {
 func newTransportH2(e *DOHEndpoint, addrs []string) http.RoundTripper {
 	d := &parallelDialer{}
 	d.FallbackDelay = -1 // disable happy eyeball, we do our own
+	d.Proxy = e.proxyURL
+	tlsConfig, err := e.TLS.config(e.Hostname)
+	if err != nil {
+		return failedTransport{err: fmt.Errorf("tls: %v", err)}
+	}
 	var t http.RoundTripper = &http.Transport{
-		TLSClientConfig: &tls.Config{
-			ServerName:         e.Hostname,
-			RootCAs:            getRootCAs(),
-			ClientSessionCache: tls.NewLRUClientSessionCache(0),
-			MinVersion:         tls.VersionTLS13,
-		},
+		TLSClientConfig: tlsConfig,
 		DialContext: func(ctx context.Context, network, _ string) (c net.Conn, err error) {
 			c, err = d.DialParallel(ctx, network, addrs)
 			if c != nil {
@@ -0,0 +1,82 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nextdns/nextdns/resolver/endpoint"
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+// ODOH is an Oblivious DNS over HTTPS (RFC 9230) implementation of the
+// Resolver interface. Unlike DOH, no client information is sent upstream
+// unless requested by the ECS policy.
+type ODOH struct {
+	// Cache defines the cache storage implementation for DNS response cache. If
+	// nil, caching is disabled.
+	Cache Cacher
+
+	// CacheMaxAge defines the maximum age in second allowed for a cached entry
+	// before being considered stale regardless of the records TTL.
+	CacheMaxAge uint32
+
+	// MaxTTL defines the maximum TTL value that will be handed out to clients.
+	// The specified maximum TTL will be given to clients instead of the true
+	// TTL value if it is lower. The true TTL value is however kept in the cache
+	// to evaluate cache entries freshness.
+	MaxTTL uint32
+
+	// ECS defines the EDNS client subnet policy. The zero value strips ECS.
+	ECS ECSPolicy
+
+	// Privacy defines how queries are hardened before being sent upstream.
+	Privacy PrivacyPolicy
+}
+
+func (r ODOH) resolve(ctx context.Context, q query.Query, buf []byte, e *endpoint.ODOHEndpoint) (n int, i ResolveInfo, err error) {
+	i.Transport = "ODoH"
+	var ctxKey string
+	if q, ctxKey, err = r.ECS.apply(q); err != nil {
+		return 0, i, fmt.Errorf("ecs: %v", err)
+	}
+	ctxKey += dnssecCacheKey(q)
+	key := cacheKey{e.String() + ctxKey, q.Class, q.Type, q.Name}
+	var now time.Time
+	// RFC1035, section 7.4: The results of an inverse query should not be cached
+	if q.Type != query.TypePTR && r.Cache != nil {
+		now = time.Now()
+		if v, found := r.Cache.Get(key.Hash()); found && v != nil && key.ValidateQuestion(v.msg) {
+			var minTTL uint32
+			n, minTTL = v.AdjustedResponse(buf, q.ID, r.CacheMaxAge, r.MaxTTL, now)
+			i.FromCache = true
+			if minTTL > 0 {
+				return n, i, nil
+			}
+		}
+	}
+	orig := q
+	if q, err = r.Privacy.apply(q); err != nil {
+		return 0, i, fmt.Errorf("privacy: %v", err)
+	}
+	if n, err = e.Exchange(ctx, q.Payload, buf); err != nil {
+		return 0, i, err
+	}
+	if err = r.Privacy.check(q, orig, buf[:n]); err != nil {
+		return 0, i, err
+	}
+	i.FromCache = false
+	if q.Type != query.TypePTR && r.Cache != nil && n > 2 && buf[2]&0x2 == 0 {
+		v := &cacheValue{
+			time:  now,
+			msg:   make([]byte, n),
+			trans: i.Transport,
+		}
+		copy(v.msg, buf[:n])
+		r.Cache.Set(key.Hash(), v)
+	}
+	if r.MaxTTL > 0 {
+		updateTTL(buf[:n], 0, 0, r.MaxTTL)
+	}
+	return n, i, nil
+}
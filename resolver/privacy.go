@@ -0,0 +1,193 @@
+package resolver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+const (
+	edns0Padding = 0xc // RFC 7830
+
+	// paddingBlockSize is the query block length recommended by RFC 8467.
+	paddingBlockSize = 128
+)
+
+var errCaseMismatch = errors.New("0x20: response question does not match query")
+
+// PrivacyPolicy defines how queries are hardened before being sent over
+// encrypted transports. The zero value sends queries as received.
+type PrivacyPolicy struct {
+	// Padding pads queries to a multiple of 128 bytes using the EDNS(0)
+	// padding option (RFC 7830, RFC 8467).
+	Padding bool
+
+	// Randomize0x20 randomizes the case of the query name and checks the
+	// response echoes it unchanged.
+	Randomize0x20 bool
+
+	// StripOptions removes EDNS(0) options sent by the client, like cookies or
+	// the MAC address option. The client subnet option is governed by the ECS
+	// policy.
+	StripOptions bool
+}
+
+// ParsePrivacyPolicy parses a comma separated list of privacy features:
+// padding, 0x20 and strip-options. The value off disables them all.
+func ParsePrivacyPolicy(s string) (PrivacyPolicy, error) {
+	var p PrivacyPolicy
+	if s == "" || s == "off" {
+		return p, nil
+	}
+	for f := range strings.SplitSeq(s, ",") {
+		switch strings.TrimSpace(f) {
+		case "padding":
+			p.Padding = true
+		case "0x20":
+			p.Randomize0x20 = true
+		case "strip-options":
+			p.StripOptions = true
+		default:
+			return p, fmt.Errorf("%s: invalid privacy feature", f)
+		}
+	}
+	return p, nil
+}
+
+func (p PrivacyPolicy) String() string {
+	var f []string
+	if p.Padding {
+		f = append(f, "padding")
+	}
+	if p.Randomize0x20 {
+		f = append(f, "0x20")
+	}
+	if p.StripOptions {
+		f = append(f, "strip-options")
+	}
+	if len(f) == 0 {
+		return "off"
+	}
+	return strings.Join(f, ",")
+}
+
+// apply returns q with its payload rewritten according to the policy.
+func (p PrivacyPolicy) apply(q query.Query) (query.Query, error) {
+	if p.Padding || p.StripOptions {
+		payload, err := p.rewriteOptions(q)
+		if err != nil {
+			return q, err
+		}
+		q.Payload = payload
+	}
+	if p.Randomize0x20 {
+		payload := make([]byte, len(q.Payload))
+		copy(payload, q.Payload)
+		randomizeCase(questionName(payload))
+		q.Payload = payload
+	}
+	return q, nil
+}
+
+// rewriteOptions strips client EDNS(0) options and adds padding.
+func (p PrivacyPolicy) rewriteOptions(q query.Query) ([]byte, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(q.Payload); err != nil {
+		return nil, err
+	}
+	var opt *dnsmessage.OPTResource
+	for _, rr := range msg.Additionals {
+		if o, ok := rr.Body.(*dnsmessage.OPTResource); ok {
+			opt = o
+			break
+		}
+	}
+	if opt == nil {
+		if !p.Padding {
+			return q.Payload, nil
+		}
+		opt = &dnsmessage.OPTResource{}
+		msg.Additionals = append(msg.Additionals, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{
+				Name:  dnsmessage.MustNewName("."),
+				Type:  dnsmessage.TypeOPT,
+				Class: dnsmessage.Class(q.MsgSize),
+			},
+			Body: opt,
+		})
+	}
+	options := opt.Options[:0]
+	for _, o := range opt.Options {
+		if o.Code == edns0Padding {
+			continue
+		}
+		if p.StripOptions && o.Code != query.EDNS0_SUBNET {
+			continue
+		}
+		options = append(options, o)
+	}
+	opt.Options = options
+	if !p.Padding {
+		return msg.Pack()
+	}
+	opt.Options = append(opt.Options, dnsmessage.Option{Code: edns0Padding})
+	payload, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+	if pad := len(payload) % paddingBlockSize; pad != 0 {
+		opt.Options[len(opt.Options)-1].Data = make([]byte, paddingBlockSize-pad)
+		payload, err = msg.Pack()
+	}
+	return payload, err
+}
+
+// check verifies that the response in buf echoes the randomized question name
+// of q and restores the name case sent by the client.
+func (p PrivacyPolicy) check(q, orig query.Query, buf []byte) error {
+	if !p.Randomize0x20 || len(buf) < 12 || buf[4]|buf[5] == 0 {
+		return nil
+	}
+	sent := questionName(q.Payload)
+	got := questionName(buf)
+	if !bytes.Equal(got, sent) {
+		return errCaseMismatch
+	}
+	copy(got, questionName(orig.Payload))
+	return nil
+}
+
+// questionName returns the wire format name of the first question of msg, or
+// nil if the name is compressed or truncated.
+func questionName(msg []byte) []byte {
+	off := 12
+	for off < len(msg) {
+		l := int(msg[off])
+		if l == 0 {
+			return msg[12 : off+1]
+		}
+		if l&0xc0 != 0 {
+			return nil
+		}
+		off += l + 1
+	}
+	return nil
+}
+
+// randomizeCase randomly flips the case of letters in the wire format name.
+func randomizeCase(name []byte) {
+	var bits uint64
+	for i, c := range name {
+		if i%64 == 0 {
+			bits = rand.Uint64()
+		}
+		if c|0x20 >= 'a' && c|0x20 <= 'z' && bits&(1<<(i%64)) != 0 {
+			name[i] ^= 0x20
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package resolver
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+func TestParsePrivacyPolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"off", "off", false},
+		{"", "off", false},
+		{"padding", "padding", false},
+		{"strip-options,0x20,padding", "padding,0x20,strip-options", false},
+		{"padding,foo", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			p, err := ParsePrivacyPolicy(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePrivacyPolicy() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && p.String() != tt.want {
+				t.Errorf("ParsePrivacyPolicy() = %v, want %v", p, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrivacyPolicy_apply(t *testing.T) {
+	cookie := dnsmessage.Option{Code: 10, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}
+	mac := dnsmessage.Option{Code: query.EDNS0_MAC, Data: []byte{0, 1, 2, 3, 4, 5}}
+	tests := []struct {
+		name    string
+		policy  PrivacyPolicy
+		options []dnsmessage.Option
+		want    []uint16
+	}{
+		{"off", PrivacyPolicy{}, []dnsmessage.Option{cookie}, []uint16{10}},
+		{"strip", PrivacyPolicy{StripOptions: true}, []dnsmessage.Option{cookie, mac}, nil},
+		{"padding without OPT", PrivacyPolicy{Padding: true}, nil, []uint16{edns0Padding}},
+		{"padding and strip", PrivacyPolicy{Padding: true, StripOptions: true}, []dnsmessage.Option{cookie, mac}, []uint16{edns0Padding}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := tt.policy.apply(newOptionsQuery(t, tt.options))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.policy.Padding && len(q.Payload)%paddingBlockSize != 0 {
+				t.Errorf("apply() payload length = %d, want multiple of %d", len(q.Payload), paddingBlockSize)
+			}
+			var got []uint16
+			for _, o := range sentOptions(t, q.Payload) {
+				got = append(got, o.Code)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("apply() options = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("apply() options = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPrivacyPolicy_check(t *testing.T) {
+	p := PrivacyPolicy{Randomize0x20: true}
+	orig := newOptionsQuery(t, nil)
+	q, err := p.apply(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.EqualFold(questionName(q.Payload), questionName(orig.Payload)) {
+		t.Fatalf("apply() changed the query name")
+	}
+
+	// Echoed name: the client case is restored.
+	resp := append([]byte{}, q.Payload...)
+	resp[2] |= 0x80
+	if err := p.check(q, orig, resp); err != nil {
+		t.Fatalf("check() err = %v", err)
+	}
+	if !bytes.Equal(questionName(resp), questionName(orig.Payload)) {
+		t.Errorf("check() did not restore the query name case")
+	}
+
+	// Name with a different case: the response is rejected.
+	resp = append([]byte{}, q.Payload...)
+	name := questionName(resp)
+	name[1] ^= 0x20
+	if err := p.check(q, orig, resp); err != errCaseMismatch {
+		t.Errorf("check() err = %v, want %v", err, errCaseMismatch)
+	}
+}
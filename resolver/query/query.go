@@ -1,6 +1,7 @@
 package query
 
 import (
+	"encoding/binary"
 	"fmt"
 	"net"
 	"strconv"
@@ -8,6 +9,7 @@ import (
 	"github.com/nextdns/nextdns/arp"
 	"github.com/nextdns/nextdns/internal/dnsmessage"
 	"github.com/nextdns/nextdns/ndp"
+	"github.com/nextdns/nextdns/neighbor"
 )
 
 type Query struct {
@@ -21,6 +23,19 @@ type Query struct {
 	PeerIP           net.IP
 	MAC              net.HardwareAddr
 	Payload          []byte
+
+	// NeighborMAC is the MAC address of PeerIP found in the ARP and NDP
+	// tables. Unlike MAC, it cannot be set by the client with the EDNS0 MAC
+	// option and is thus suitable for access control.
+	NeighborMAC net.HardwareAddr
+
+	// DNSSECOK and CheckingDisabled reflect the DO and CD bits of the query.
+	DNSSECOK         bool
+	CheckingDisabled bool
+
+	// ClientSubnet is the EDNS client subnet sent by the client if any. The
+	// option itself is neutralized in Payload to avoid leaking it upstream.
+	ClientSubnet *net.IPNet
 }
 
 type Class uint16
@@ -56,16 +71,21 @@ type Type uint16
 
 const (
 	// ResourceHeader.Type and Question.Type
-	TypeA     Type = 1
-	TypeNS    Type = 2
-	TypeCNAME Type = 5
-	TypeSOA   Type = 6
-	TypePTR   Type = 12
-	TypeMX    Type = 15
-	TypeTXT   Type = 16
-	TypeAAAA  Type = 28
-	TypeSRV   Type = 33
-	TypeOPT   Type = 41
+	TypeA      Type = 1
+	TypeNS     Type = 2
+	TypeCNAME  Type = 5
+	TypeSOA    Type = 6
+	TypePTR    Type = 12
+	TypeMX     Type = 15
+	TypeTXT    Type = 16
+	TypeAAAA   Type = 28
+	TypeSRV    Type = 33
+	TypeOPT    Type = 41
+	TypeDS     Type = 43
+	TypeRRSIG  Type = 46
+	TypeNSEC   Type = 47
+	TypeDNSKEY Type = 48
+	TypeNSEC3  Type = 50
 
 	// Question.Type
 	TypeWKS   Type = 11
@@ -76,21 +96,26 @@ const (
 )
 
 var typeNames = map[Type]string{
-	TypeA:     "A",
-	TypeNS:    "NS",
-	TypeCNAME: "CNAME",
-	TypeSOA:   "SOA",
-	TypePTR:   "PTR",
-	TypeMX:    "MX",
-	TypeTXT:   "TXT",
-	TypeAAAA:  "AAAA",
-	TypeSRV:   "SRV",
-	TypeOPT:   "OPT",
-	TypeWKS:   "WKS",
-	TypeHINFO: "HINFO",
-	TypeMINFO: "MINFO",
-	TypeAXFR:  "AXFR",
-	TypeALL:   "ALL",
+	TypeA:      "A",
+	TypeNS:     "NS",
+	TypeCNAME:  "CNAME",
+	TypeSOA:    "SOA",
+	TypePTR:    "PTR",
+	TypeMX:     "MX",
+	TypeTXT:    "TXT",
+	TypeAAAA:   "AAAA",
+	TypeSRV:    "SRV",
+	TypeOPT:    "OPT",
+	TypeDS:     "DS",
+	TypeRRSIG:  "RRSIG",
+	TypeNSEC:   "NSEC",
+	TypeDNSKEY: "DNSKEY",
+	TypeNSEC3:  "NSEC3",
+	TypeWKS:    "WKS",
+	TypeHINFO:  "HINFO",
+	TypeMINFO:  "MINFO",
+	TypeAXFR:   "AXFR",
+	TypeALL:    "ALL",
 }
 
 func (t Type) String() string {
@@ -121,11 +146,16 @@ func New(payload []byte, peerIP, localIP net.IP) (Query, error) {
 
 	if !peerIP.IsLoopback() {
 		if peerIP.To4() != nil {
-			q.MAC = arp.SearchMAC(peerIP)
+			q.NeighborMAC = arp.SearchMAC(peerIP)
 		} else {
-			q.MAC = ndp.SearchMAC(peerIP)
+			q.NeighborMAC = ndp.SearchMAC(peerIP)
 		}
-
+		if q.NeighborMAC == nil {
+			// Ask the kernel for peers not in the tables yet, if neighbor
+			// events are watched, so the MAC is known by later queries.
+			neighbor.Resolve(peerIP)
+		}
+		q.MAC = q.NeighborMAC
 	}
 
 	if err := q.parse(); err != nil {
@@ -159,6 +189,7 @@ func (qry *Query) parse() error {
 	}
 	qry.ID = h.ID
 	qry.RecursionDesired = h.RecursionDesired
+	qry.CheckingDisabled = h.CheckingDisabled
 	qry.Class = Class(q.Class)
 	qry.Type = Type(q.Type)
 	qry.Name = q.Name.String()
@@ -179,32 +210,31 @@ func (qry *Query) parse() error {
 				return fmt.Errorf("parse OPT: %v", err)
 			}
 			qry.MsgSize = uint16(h.Class)
+			qry.DNSSECOK = h.DNSSECAllowed()
 			for _, o := range opt.Options {
 				switch o.Code {
 				case EDNS0_MAC:
 					qry.MAC = net.HardwareAddr(o.Data)
 				case EDNS0_SUBNET:
-					if len(o.Data) < 8 {
+					if len(o.Data) < 4 {
 						continue
 					}
+					qry.ClientSubnet = parseECS(o.Data)
 					switch o.Data[1] {
 					case 0x1: // IPv4
-						if o.Data[2] == 32 {
+						if o.Data[2] == 32 && len(o.Data) >= 8 {
 							// Only consider full IPs
 							qry.PeerIP = net.IP(o.Data[4:8])
 						}
-
-						// Avoid leaking ECS to the upstream.
-						nutterECSOption(qry.Payload, o)
 					case 0x2: // IPv6
 						if o.Data[2] == 128 && len(o.Data) >= 20 {
 							// Only consider full IPs
 							qry.PeerIP = net.IP(o.Data[4:20])
 						}
-
-						// Avoid leaking ECS to the upstream.
-						nutterECSOption(qry.Payload, o)
 					}
+
+					// Avoid leaking ECS to the upstream.
+					nutterECSOption(qry.Payload, o)
 				}
 			}
 			break
@@ -234,3 +264,87 @@ func nutterECSOption(payload []byte, o dnsmessage.Option) {
 	payload[off] = 0xFF
 	payload[off+1] = 0xFF
 }
+
+// parseECS parses the data of an EDNS client subnet option.
+func parseECS(data []byte) *net.IPNet {
+	var l int
+	switch binary.BigEndian.Uint16(data[0:2]) {
+	case 0x1:
+		l = net.IPv4len
+	case 0x2:
+		l = net.IPv6len
+	default:
+		return nil
+	}
+	bits := int(data[2])
+	if bits > l*8 || len(data)-4 > l {
+		return nil
+	}
+	ip := make(net.IP, l)
+	copy(ip, data[4:])
+	mask := net.CIDRMask(bits, l*8)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
+
+// WithClientSubnet returns a copy of q with the EDNS client subnet options of
+// the payload, including neutralized ones, replaced by subnet. If subnet is
+// nil, client subnet options are removed.
+func (qry Query) WithClientSubnet(subnet *net.IPNet) (Query, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(qry.Payload); err != nil {
+		return qry, err
+	}
+	var opt *dnsmessage.OPTResource
+	for _, rr := range msg.Additionals {
+		if o, ok := rr.Body.(*dnsmessage.OPTResource); ok {
+			opt = o
+			break
+		}
+	}
+	if opt == nil {
+		if subnet == nil {
+			return qry, nil
+		}
+		opt = &dnsmessage.OPTResource{}
+		msg.Additionals = append(msg.Additionals, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{
+				Name:  dnsmessage.MustNewName("."),
+				Type:  dnsmessage.TypeOPT,
+				Class: dnsmessage.Class(qry.MsgSize),
+			},
+			Body: opt,
+		})
+	}
+	options := opt.Options[:0]
+	for _, o := range opt.Options {
+		if o.Code != EDNS0_SUBNET && o.Code != 0xFFFF {
+			options = append(options, o)
+		}
+	}
+	if subnet != nil {
+		options = append(options, dnsmessage.Option{Code: EDNS0_SUBNET, Data: ecsData(subnet)})
+	}
+	opt.Options = options
+	payload, err := msg.Pack()
+	if err != nil {
+		return qry, err
+	}
+	qry.Payload = payload
+	return qry, nil
+}
+
+// ecsData returns the EDNS client subnet option data for subnet (RFC 7871).
+func ecsData(subnet *net.IPNet) []byte {
+	family := uint16(0x1)
+	ip := subnet.IP.To4()
+	if ip == nil {
+		family = 0x2
+		ip = subnet.IP.To16()
+	}
+	bits, _ := subnet.Mask.Size()
+	addr := ip.Mask(subnet.Mask)[:(bits+7)/8]
+	data := make([]byte, 4, 4+len(addr))
+	binary.BigEndian.PutUint16(data[0:2], family)
+	data[2] = byte(bits)
+	return append(data, addr...)
+}
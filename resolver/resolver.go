@@ -42,6 +42,7 @@ type CacheStats struct {
 type DNS struct {
 	DOH        DOH
 	DNS53      DNS53
+	ODOH       ODOH
 	Manager    *endpoint.Manager
 	cacheStats CacheStats
 }
@@ -50,6 +51,7 @@ type ResolveInfo struct {
 	Transport string
 	Profile   string
 	FromCache bool
+	Blocked   bool
 }
 
 // New instances a DNS53 or DoH resolver for endpoint.
@@ -59,6 +61,7 @@ type ResolveInfo struct {
 //   - DoH:   https://doh.server.com/path
 //   - DoH:   https://doh.server.com/path#1.2.3.4 // with bootstrap
 //   - DoH:   https://doh.server.com/path,https://doh2.server.com/path
+//   - ODoH:  odoh://odoh.target.com/dns-query?relay=https://relay.com/proxy
 //   - DNS53: 1.2.3.4
 //   - DNS53: 1.2.3.4,1.2.3.5
 func New(servers string) (Resolver, error) {
@@ -93,6 +96,10 @@ func (r *DNS) Resolve(ctx context.Context, q query.Query, buf []byte) (n int, i
 			if n, i, err2 = r.DNS53.resolve(ctx, q, buf, e.Addr); err2 != nil {
 				return fmt.Errorf("dns resolve: %v", err2)
 			}
+		case *endpoint.ODOHEndpoint:
+			if n, i, err2 = r.ODOH.resolve(ctx, q, buf, e); err2 != nil {
+				return fmt.Errorf("odoh resolve: %v", err2)
+			}
 		default:
 			return fmt.Errorf("dns resolve: unsupported type: %T", e)
 		}
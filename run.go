@@ -6,10 +6,13 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -18,28 +21,45 @@ import (
 	"github.com/denisbrodbeck/machineid"
 
 	"github.com/nextdns/nextdns/arp"
+	"github.com/nextdns/nextdns/blocklist"
 	"github.com/nextdns/nextdns/config"
 	"github.com/nextdns/nextdns/ctl"
 	"github.com/nextdns/nextdns/discovery"
+	"github.com/nextdns/nextdns/dnssec"
 	"github.com/nextdns/nextdns/host"
 	"github.com/nextdns/nextdns/host/service"
 	"github.com/nextdns/nextdns/hosts"
+	"github.com/nextdns/nextdns/internal/dnsmessage"
 	"github.com/nextdns/nextdns/internal/resolved"
 	"github.com/nextdns/nextdns/ndp"
+	"github.com/nextdns/nextdns/neighbor"
 	"github.com/nextdns/nextdns/netstatus"
 	"github.com/nextdns/nextdns/proxy"
 	"github.com/nextdns/nextdns/resolver"
 	"github.com/nextdns/nextdns/resolver/endpoint"
 	"github.com/nextdns/nextdns/resolver/query"
 	"github.com/nextdns/nextdns/router"
+	"github.com/nextdns/nextdns/zone"
 )
 
 type proxySvc struct {
 	proxy.Proxy
 	log      host.Logger
 	resolver *resolver.DNS
-	stopFunc func()
-	stopped  chan struct{}
+
+	// ctx is cancelled when the proxy is stopped or restarted, while
+	// stopListen only closes the listeners so they can be rebound.
+	ctx        context.Context
+	stopFunc   func()
+	stopListen func()
+	stopped    chan struct{}
+
+	// live holds the proxy settings used for each query, which can be
+	// changed by reload while the proxy is running.
+	live atomic.Pointer[proxy.Proxy]
+
+	reloadMu sync.Mutex
+	reload   func() error
 
 	// OnInit is called every time the proxy is started or restarted. The ctx is
 	// cancelled on stop or restart.
@@ -85,18 +105,29 @@ func isErrNetUnreachable(err error) bool {
 	return false
 }
 
-func (p *proxySvc) start() (err error) {
+func (p *proxySvc) start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, f := range p.OnInit {
+		go f(ctx)
+	}
+	if err := p.listen(ctx); err != nil {
+		cancel()
+		return err
+	}
+	p.ctx, p.stopFunc = ctx, cancel
+	return nil
+}
+
+// listen serves the proxy on its listen addresses until ctx is cancelled or
+// stopListen is called.
+func (p *proxySvc) listen(ctx context.Context) error {
 	errC := make(chan error)
-	var ctx context.Context
+	ctx, cancel := context.WithCancel(ctx)
+	stopped := make(chan struct{})
 	go func() {
-		ctx, p.stopFunc = context.WithCancel(context.Background())
-		defer p.stopFunc()
-		p.stopped = make(chan struct{})
-		defer close(p.stopped)
-		for _, f := range p.OnInit {
-			go f(ctx)
-		}
-		if err = p.ListenAndServe(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		defer cancel()
+		defer close(stopped)
+		if err := p.ListenAndServe(ctx); err != nil && !errors.Is(err, context.Canceled) {
 			select {
 			case errC <- err:
 			default:
@@ -105,12 +136,35 @@ func (p *proxySvc) start() (err error) {
 	}()
 	select {
 	case err := <-errC:
+		<-stopped
 		return err
 	case <-time.After(5 * time.Second):
 	}
+	p.stopListen, p.stopped = cancel, stopped
 	return nil
 }
 
+// rebind closes the listeners and reopens them on addrs, leaving the OnInit
+// goroutines running.
+func (p *proxySvc) rebind(addrs []string) error {
+	p.stopListeners()
+	p.Proxy.Addrs = addrs
+	if p.stopFunc == nil {
+		return nil
+	}
+	p.log.Infof("Listening on %s", strings.Join(addrs, ", "))
+	return p.listen(p.ctx)
+}
+
+func (p *proxySvc) stopListeners() {
+	if p.stopListen == nil {
+		return
+	}
+	p.stopListen()
+	p.stopListen = nil
+	<-p.stopped
+}
+
 func (p *proxySvc) Restart() error {
 	p.log.Infof("Restarting NextDNS %s/%s on %s", version, platform, strings.Join(p.Addrs, ", "))
 	_ = p.stop()
@@ -134,10 +188,22 @@ func (p *proxySvc) stop() bool {
 	}
 	p.stopFunc()
 	p.stopFunc = nil
-	<-p.stopped
+	p.stopListeners()
 	return true
 }
 
+// Reload re-reads the configuration and applies the changes in place. The
+// listeners are only rebound when the listen addresses changed.
+func (p *proxySvc) Reload() error {
+	if p.reload == nil {
+		return errors.New("reload not supported")
+	}
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+	p.log.Infof("Reloading NextDNS %s/%s configuration", version, platform)
+	return p.reload()
+}
+
 func (p *proxySvc) Log(msg string) {
 	p.log.Info(msg)
 }
@@ -238,6 +304,11 @@ func run(args []string) error {
 		})
 	}
 
+	upstreamProxy, err := endpoint.ParseProxy(c.Proxy)
+	if err != nil {
+		return err
+	}
+
 	var startupUnix atomic.Int64
 	startupUnix.Store(time.Now().UnixNano())
 	p.resolver = &resolver.DNS{
@@ -246,7 +317,7 @@ func run(args []string) error {
 				"User-Agent": []string{fmt.Sprintf("nextdns-cli/%s (%s; %s; %s)", version, platform, runtime.GOARCH, host.InitType())},
 			},
 		},
-		Manager: nextdnsEndpointManager(log, c.Debug, func() bool {
+		Manager: nextdnsEndpointManager(log, c.Debug, upstreamProxy, func() bool {
 			// Backward compat: the captive portal is now somewhat always enabled,
 			// but for those who enabled it in the past, disable the delay after which
 			// the fallback is disabled.
@@ -307,24 +378,52 @@ func run(args []string) error {
 	maxTTL := uint32(c.MaxTTL / time.Second)
 	p.resolver.DNS53.MaxTTL = maxTTL
 	p.resolver.DOH.MaxTTL = maxTTL
+	p.resolver.ODOH.MaxTTL = maxTTL
 
-	if len(c.Profile) == 0 || (len(c.Profile) == 1 && c.Profile.Get(nil, nil, nil) != "") {
-		// Optimize for no dynamic configuration.
-		profileID := c.Profile.Get(nil, nil, nil)
-		profileURL := "https://dns.nextdns.io/" + profileID
-		p.resolver.DOH.GetProfileURL = func(q query.Query) (string, string) {
-			return profileURL, profileID
-		}
-	} else {
-		hasUserRules := c.Profile.HasUserRules()
-		p.resolver.DOH.GetProfileURL = func(q query.Query) (string, string) {
-			profileID := c.Profile.Get(q.PeerIP, q.LocalIP, q.MAC)
-			if hasUserRules && q.PeerIP.IsLoopback() {
-				profileID = c.Profile.GetWithUser(q.PeerIP, q.LocalIP, q.MAC, host.ActiveUser())
+	privacy, err := resolver.ParsePrivacyPolicy(c.Privacy)
+	if err != nil {
+		return err
+	}
+	p.resolver.DOH.Privacy = privacy
+	p.resolver.ODOH.Privacy = privacy
+
+	ecs, err := resolver.ParseECSPolicy(c.ECS)
+	if err != nil {
+		return err
+	}
+	p.resolver.DNS53.ECS = ecs
+	p.resolver.DOH.ECS = ecs
+	p.resolver.ODOH.ECS = ecs
+
+	// cur holds the running configuration, replaced on reload.
+	var cur atomic.Pointer[config.Config]
+	cur.Store(&c)
+
+	// discoverStatic holds the user defined devices, replaced on reload.
+	var discoverStatic *discovery.Static
+	setStaticDevices := func(file string) {
+		discoverStatic = nil
+		if file != "" {
+			discoverStatic = &discovery.Static{
+				File:    file,
+				OnError: func(err error) { log.Errorf("static: %v", err) },
 			}
-			return "https://dns.nextdns.io/" + profileID, profileID
 		}
 	}
+	setStaticDevices(c.StaticDevices)
+
+	var getProfileURL atomic.Pointer[func(q query.Query) (string, string)]
+	setProfiles := func(profiles config.Profiles) {
+		if profiles.HasDeviceRules() && discoverStatic == nil {
+			log.Warning("Profile device conditions require static-devices to be set")
+		}
+		f := profileURLFunc(profiles, discoverStatic)
+		getProfileURL.Store(&f)
+	}
+	setProfiles(c.Profile)
+	p.resolver.DOH.GetProfileURL = func(q query.Query) (string, string) {
+		return (*getProfileURL.Load())(q)
+	}
 
 	p.Proxy = proxy.Proxy{
 		Addrs:               c.Listens,
@@ -332,89 +431,398 @@ func run(args []string) error {
 		BogusPriv:           c.BogusPriv,
 		Timeout:             c.Timeout,
 		MaxInflightRequests: c.MaxInflightRequests,
+		RebindAllowlist:     c.RebindAllowlist,
+	}
+
+	// setupACL sets the access control rules of px from c.
+	setupACL := func(c *config.Config, px *proxy.Proxy) error {
+		px.ACL = nil
+		px.ACLDrop = false
+		if len(c.ACLAllow) == 0 && len(c.ACLDeny) == 0 {
+			return nil
+		}
+		allow, deny := c.ACLAllow, c.ACLDeny
+		px.ACL = func(sourceIP, destIP net.IP, mac net.HardwareAddr) bool {
+			if deny.Match(sourceIP, destIP, mac) {
+				return false
+			}
+			return len(allow) == 0 || allow.Match(sourceIP, destIP, mac)
+		}
+		switch c.ACLAction {
+		case "refuse":
+		case "drop":
+			px.ACLDrop = true
+		default:
+			return fmt.Errorf("%s: invalid deny action", c.ACLAction)
+		}
+		return nil
+	}
+	if err := setupACL(&c, &p.Proxy); err != nil {
+		return err
+	}
+
+	// setupRateLimit sets a new rate limiter on px from c, dropping the
+	// state of the previous one.
+	setupRateLimit := func(c *config.Config, px *proxy.Proxy) error {
+		px.RateLimiter = nil
+		if c.RateLimit == 0 {
+			return nil
+		}
+		rl := &proxy.RateLimiter{
+			Rate:  float64(c.RateLimit),
+			Burst: int(max(c.RateLimitBurst, 1)),
+		}
+		if err := rl.ParseKey(c.RateLimitKey); err != nil {
+			return err
+		}
+		switch c.RateLimitAction {
+		case "refuse":
+		case "drop":
+			rl.Action = proxy.RateLimitDrop
+		case "truncate":
+			rl.Action = proxy.RateLimitTruncate
+		default:
+			return fmt.Errorf("%s: invalid rate limit action", c.RateLimitAction)
+		}
+		px.RateLimiter = rl
+		return nil
+	}
+	if err := setupRateLimit(&c, &p.Proxy); err != nil {
+		return err
+	}
+	ctl.Command("throttled", func(data any) any {
+		px := p.live.Load()
+		if px == nil || px.RateLimiter == nil {
+			return "rate limiting disabled"
+		}
+		return px.RateLimiter.Metrics()
+	})
+
+	switch c.RebindProtection {
+	case "off":
+	case "strip":
+		p.Proxy.RebindProtection = proxy.RebindStrip
+	case "refuse":
+		p.Proxy.RebindProtection = proxy.RebindRefuse
+	default:
+		return fmt.Errorf("%s: invalid rebind protection mode", c.RebindProtection)
+	}
+
+	if c.DNS64 {
+		prefix, err := netip.ParsePrefix(c.DNS64Prefix)
+		if err != nil {
+			return fmt.Errorf("%s: cannot parse DNS64 prefix: %v", c.DNS64Prefix, err)
+		}
+		if err := proxy.ValidDNS64Prefix(prefix); err != nil {
+			return err
+		}
+		p.Proxy.DNS64Prefix = prefix.Masked()
+	}
+
+	if c.LocalDomain != "" {
+		domain := strings.Trim(c.LocalDomain, ".")
+		if _, err := dnsmessage.NewName(domain + "."); err != nil || domain == "" {
+			return fmt.Errorf("%s: invalid local domain", c.LocalDomain)
+		}
+	}
+
+	if len(c.MDNSReflector) == 1 {
+		return fmt.Errorf("%s: mdns-reflector requires at least two interfaces", c.MDNSReflector[0])
+	}
+
+	// setupZones sets the local zones of px from c.
+	setupZones := func(c *config.Config, px *proxy.Proxy) {
+		px.LocalZones = nil
+		if len(c.ZoneFiles) > 0 {
+			px.LocalZones = &zone.Zones{
+				Files:   c.ZoneFiles,
+				OnError: func(err error) { log.Errorf("zone: %v", err) },
+			}
+		}
+	}
+	setupZones(&c, &p.Proxy)
+
+	// setupBlocklist sets the blocklist of px from c.
+	setupBlocklist := func(c *config.Config, px *proxy.Proxy) error {
+		px.Blocklist = nil
+		if len(c.Blocklists) == 0 {
+			return nil
+		}
+		resp, err := blocklist.ParseResponse(c.BlocklistResponse)
+		if err != nil {
+			return err
+		}
+		bl := &blocklist.Blocklist{
+			Files:    c.Blocklists,
+			Response: resp,
+			OnError:  func(err error) { log.Errorf("blocklist: %v", err) },
+		}
+		switch c.BlocklistMode {
+		case "always":
+		case "fallback":
+			m := p.resolver.Manager
+			bl.Enabled = func() bool {
+				e := m.ActiveEndpoint()
+				return e != nil && e.Protocol() == endpoint.ProtocolDNS
+			}
+		default:
+			return fmt.Errorf("%s: invalid blocklist mode", c.BlocklistMode)
+		}
+		px.Blocklist = bl
+		return nil
+	}
+	if err := setupBlocklist(&c, &p.Proxy); err != nil {
+		return err
 	}
 
 	discoverHosts := &discovery.Hosts{OnError: func(err error) { log.Errorf("hosts: %v", err) }}
-	if c.UseHosts {
-		p.Proxy.LocalResolver = discovery.Resolver{discoverHosts}
+	discoverDHCP := &discovery.DHCP{OnError: func(err error) { log.Errorf("dhcp: %v", err) }}
+	for _, v := range c.DHCPLeases {
+		lf, err := discovery.ParseLeaseFile(v)
+		if err != nil {
+			return err
+		}
+		discoverDHCP.LeaseFiles = append(discoverDHCP.LeaseFiles, lf)
 	}
-	localhostMode := isLocalhostMode(&c)
-	if c.ReportClientInfo {
+	discoverMDNS := &discovery.MDNS{OnError: func(err error) { log.Errorf("mdns: %v", err) }}
+	mdns := &discoveryRunner{
+		source: discoverMDNS,
+		name:   "mDNS",
+		log:    log,
+		filter: "disabled",
+	}
+	p.OnInit = append(p.OnInit, mdns.run)
+	discoverNetBIOS := &discovery.NetBIOS{OnError: func(err error) { log.Errorf("netbios: %v", err) }}
+	netbios := &discoveryRunner{
+		source: discoverNetBIOS,
+		name:   "NetBIOS",
+		log:    log,
+		filter: "disabled",
+	}
+	p.OnInit = append(p.OnInit, netbios.run)
+	discoverSSDP := &discovery.SSDP{OnError: func(err error) { log.Errorf("ssdp: %v", err) }}
+	ssdp := &discoveryRunner{
+		source: discoverSSDP,
+		name:   "SSDP",
+		log:    log,
+		filter: "disabled",
+	}
+	p.OnInit = append(p.OnInit, ssdp.run)
+	if c.WatchNeighbors {
+		p.OnInit = append(p.OnInit, func(ctx context.Context) {
+			log.Info("Watching neighbor events")
+			if err := neighbor.Watch(ctx); err != nil {
+				log.Errorf("Cannot watch neighbor events: %v", err)
+			}
+		})
+	}
+	if len(c.MDNSReflector) > 0 {
+		reflector := &discovery.MDNSReflector{
+			Interfaces: c.MDNSReflector,
+			Services:   c.MDNSReflectorServices,
+			OnError:    func(err error) { log.Errorf("mdns reflector: %v", err) },
+		}
+		p.OnInit = append(p.OnInit, func(ctx context.Context) {
+			log.Infof("Starting mDNS reflector between %s", strings.Join(c.MDNSReflector, ", "))
+			if err := reflector.Run(ctx); err != nil {
+				log.Errorf("Cannot start mDNS reflector: %v", err)
+			}
+		})
+	}
+	var discovered atomic.Pointer[discovery.Resolver]
+	var clientInfo atomic.Pointer[func(q query.Query) resolver.ClientInfo]
+	p.resolver.DOH.ClientInfo = func(q query.Query) resolver.ClientInfo {
+		if f := clientInfo.Load(); f != nil {
+			return (*f)(q)
+		}
+		return resolver.ClientInfo{}
+	}
+	var discoverPersisted *discovery.Persisted
+	if c.DiscoveryStore != "" {
+		discoverPersisted = &discovery.Persisted{
+			File:    c.DiscoveryStore,
+			MaxAge:  c.DiscoveryStoreMaxAge,
+			Sources: func() discovery.Resolver { return *discovered.Load() },
+			OnError: func(err error) { log.Errorf("discovery store: %v", err) },
+		}
+		p.OnInit = append(p.OnInit, discoverPersisted.Run)
+	}
+	ctl.Command("discovered", func(data any) any {
+		d := map[string]map[string][]string{}
+		discovered.Load().Visit(func(source, name string, addrs []string) {
+			if d[source] == nil {
+				d[source] = map[string][]string{}
+			}
+			d[source][name] = addrs
+		})
+		return d
+	})
+	inventory := &discovery.Inventory{
+		Sources: func() discovery.Resolver { return *discovered.Load() },
+		Profile: func(ip net.IP, mac net.HardwareAddr) string {
+			_, profileID := (*getProfileURL.Load())(query.Query{PeerIP: ip, MAC: mac})
+			return profileID
+		},
+		OnNewClient: func(client discovery.Client) {
+			log.Infof("New client: %s %s", client.MAC, strings.Join(client.IPs, ", "))
+			_ = ctl.Broadcast(clientEvent(client))
+		},
+	}
+	if !isLocalhostMode(&c) {
+		p.OnInit = append(p.OnInit, inventory.Run)
+	}
+	ctl.Command("clients", func(data any) any {
+		return inventory.Clients()
+	})
+	// setupDiscovery sets the local and discovery resolvers of px and the
+	// client reporting according to c.
+	setupDiscovery := func(c *config.Config, px *proxy.Proxy) {
+		px.LocalResolver = nil
+		px.DiscoveryResolver = nil
+		if c.UseHosts {
+			px.LocalResolver = discovery.Resolver{discoverHosts}
+		}
+		mdnsFilter, netbiosFilter, ssdpFilter := "disabled", "disabled", "disabled"
+		var r discovery.Resolver
+		var static discovery.Source = discovery.Dummy{}
+		if discoverStatic != nil {
+			static = discoverStatic
+		}
+		var ci *func(q query.Query) resolver.ClientInfo
 		// Only enable discovery if configured to listen to requests outside
 		// the local host or if setup router is on.
-		enableDiscovery := !localhostMode
+		enableDiscovery := !isLocalhostMode(c)
 		if !enableDiscovery {
-			log.Warningf("report-client-info is enabled but client discovery is disabled because NextDNS is listening on a loopback address only (%s); devices will appear in the dashboard without names. Set a non-loopback listen address (e.g. 0.0.0.0:53) to enable discovery.", strings.Join(c.Listens, ", "))
+			if c.ReportClientInfo {
+				log.Warningf("report-client-info is enabled but client discovery is disabled because NextDNS is listening on a loopback address only (%s); devices will appear in the dashboard without names. Set a non-loopback listen address (e.g. 0.0.0.0:53) to enable discovery.", strings.Join(c.Listens, ", "))
+			}
+			if c.LocalDomain != "" {
+				log.Warningf("local-domain is enabled but client discovery is disabled because NextDNS is listening on a loopback address only (%s)", strings.Join(c.Listens, ", "))
+			}
 		}
-		var r discovery.Resolver
-		if enableDiscovery {
-			discoverDHCP := &discovery.DHCP{OnError: func(err error) { log.Errorf("dhcp: %v", err) }}
+		if (c.ReportClientInfo || c.LocalDomain != "") && enableDiscovery {
 			discoverDNS := &discovery.DNS{Upstream: c.DiscoveryDNS}
-			var discoverMDNS discovery.Source = discovery.Dummy{}
+			var mdnsSource, netbiosSource, ssdpSource discovery.Source = discovery.Dummy{}, discovery.Dummy{}, discovery.Dummy{}
 			if c.MDNS != "disabled" {
-				mdns := &discovery.MDNS{OnError: func(err error) { log.Errorf("mdns: %v", err) }}
-				discoverMDNS = mdns
-				p.OnInit = append(p.OnInit, func(ctx context.Context) {
-					log.Info("Starting mDNS discovery")
-					if err := mdns.Start(ctx, c.MDNS); err != nil {
-						log.Errorf("Cannot start mDNS: %v", err)
-					}
-				})
+				mdnsSource = discoverMDNS
+				mdnsFilter = c.MDNS
+			}
+			if c.NetBIOS != "disabled" {
+				netbiosSource = discoverNetBIOS
+				netbiosFilter = c.NetBIOS
+			}
+			if c.SSDP != "disabled" {
+				ssdpSource = discoverSSDP
+				ssdpFilter = c.SSDP
 			}
-			discoveryResolver := discovery.Resolver{discoverMDNS, discoverDHCP}
+			discoveryResolver := discovery.Resolver{static, mdnsSource, discoverDHCP, netbiosSource}
 			if c.DiscoveryDNS != "" {
 				// Only include discovery DNS as discovery resolver if
 				// explicitly specified as auto-discovered DNS discovery can
 				// create loops.
-				discoveryResolver = append(discovery.Resolver{discoverDNS}, discoveryResolver...)
+				discoveryResolver = slices.Insert(discoveryResolver, 1, discovery.Source(discoverDNS))
 			}
-			p.Proxy.DiscoveryResolver = discoveryResolver
+			px.DiscoveryResolver = discoveryResolver
 			r = discovery.Resolver{
+				static,
 				discoverHosts,
 				&discovery.Merlin{},
 				&discovery.Ubios{},
 				&discovery.Firewalla{},
-				discoverMDNS,
+				mdnsSource,
 				discoverDHCP,
+				ssdpSource,
 				discoverDNS,
+				netbiosSource,
+			}
+			if discoverPersisted != nil {
+				r = append(r, discoverPersisted)
 			}
-			ctl.Command("discovered", func(data any) any {
-				d := map[string]map[string][]string{}
-				r.Visit(func(source, name string, addrs []string) {
-					if d[source] == nil {
-						d[source] = map[string][]string{}
-					}
-					d[source][name] = addrs
-				})
-				return d
-			})
 		}
-		setupClientReporting(p, &c.Profile, r)
-	}
-	if p.Proxy.DiscoveryResolver == nil && c.DiscoveryDNS != "" {
-		p.Proxy.DiscoveryResolver = &discovery.DNS{Upstream: c.DiscoveryDNS}
+		if c.ReportClientInfo {
+			f := clientReporting(&c.Profile, r, discoverStatic)
+			ci = &f
+		}
+		px.LocalDomain = nil
+		if c.LocalDomain != "" {
+			px.LocalDomain = &discovery.Domain{Name: c.LocalDomain, Resolver: r}
+		}
+		px.LocalMDNS = nil
+		if c.MDNSGateway {
+			if c.MDNS == "disabled" {
+				log.Warningf("mdns-gateway is enabled but mdns is disabled")
+			} else {
+				mdnsFilter = c.MDNS
+				px.LocalMDNS = &discovery.MDNSGateway{MDNS: discoverMDNS}
+			}
+		}
+		if px.DiscoveryResolver == nil && c.DiscoveryDNS != "" {
+			px.DiscoveryResolver = &discovery.DNS{Upstream: c.DiscoveryDNS}
+		}
+		mdns.setFilter(mdnsFilter)
+		netbios.setFilter(netbiosFilter)
+		ssdp.setFilter(ssdpFilter)
+		discovered.Store(&r)
+		clientInfo.Store(ci)
 	}
+	setupDiscovery(&c, &p.Proxy)
+	localhostMode := isLocalhostMode(&c)
 
-	if len(c.Forwarders) > 0 {
+	// setupForwarders sets the upstream of px to send queries to the forwarders
+	// defined in c, with NextDNS as a catch all.
+	setupForwarders := func(c *config.Config, px *proxy.Proxy) {
+		px.Upstream = p.resolver
+		px.RebindExempt = nil
+		if len(c.Forwarders) == 0 {
+			return
+		}
 		// Append default doh server at the end of the forwarder list as a catch all.
 		fwd := make(config.Forwarders, 0, len(c.Forwarders)+1)
 		fwd = append(fwd, c.Forwarders...)
 		fwd = append(fwd, config.Resolver{Resolver: p.resolver})
-		if sharedCache != nil {
-			for i := range fwd {
-				if r, ok := fwd[i].Resolver.(*resolver.DNS); ok {
+		anchors := &dnssec.Anchors{
+			File:    c.DNSSECTrustAnchors,
+			OnError: func(err error) { log.Errorf("dnssec: %v", err) },
+		}
+		for i := range fwd {
+			res := fwd[i].Resolver
+			if v, ok := res.(*dnssec.Validator); ok {
+				v.Anchors = anchors
+				v.OnBogus = func(qname string, qtype query.Type, err error) {
+					log.Warningf("dnssec: %s %s: bogus answer: %v", qname, qtype, err)
+				}
+				res = v.Upstream
+			}
+			if r, ok := res.(*resolver.DNS); ok {
+				r.DOH.Privacy = privacy
+				r.ODOH.Privacy = privacy
+				r.Manager.Proxy = upstreamProxy
+				if r.Manager.OnError == nil {
+					r.Manager.OnError = func(e endpoint.Endpoint, err error) {
+						log.Warningf("Forwarder endpoint failed: %v: %v", e, err)
+					}
+				}
+				if sharedCache != nil {
 					r.DNS53.Cache = sharedCache
 					r.DNS53.CacheMaxAge = cacheMaxAge
 					r.DOH.Cache = sharedCache
 					r.DOH.CacheMaxAge = cacheMaxAge
+					r.ODOH.Cache = sharedCache
+					r.ODOH.CacheMaxAge = cacheMaxAge
 				}
 			}
 		}
-		p.Upstream = &fwd
+		px.Upstream = &fwd
+		// Names sent to forwarders other than NextDNS are exempt from rebind
+		// protection.
+		px.RebindExempt = func(qname string) bool {
+			return fwd.Get(qname) != resolver.Resolver(p.resolver)
+		}
 	}
+	setupForwarders(&c, &p.Proxy)
 
 	p.QueryLog = func(q proxy.QueryInfo) {
-		if !c.LogQueries && q.Error == nil {
+		if !cur.Load().LogQueries && q.Error == nil {
 			return
 		}
 		sourceIP := q.SourceIP.String()
@@ -436,6 +844,12 @@ func run(args []string) error {
 		if !q.FromCache {
 			dur = fmt.Sprintf("%dms", q.Duration/time.Millisecond)
 		}
+		if q.RateLimited {
+			dur = "rate limited"
+		}
+		if q.Denied {
+			dur = "denied"
+		}
 		profile := q.Profile
 		if profile == "" {
 			profile = "none"
@@ -486,6 +900,93 @@ func run(args []string) error {
 		})
 	}
 
+	p.Proxy.Current = p.live.Load
+	live := p.Proxy
+	p.live.Store(&live)
+	p.reload = func() error {
+		var nc config.Config
+		if err := nc.Load("nextdns "+cmd, slices.Clone(args), useStorage); err != nil {
+			return err
+		}
+		maybeUseResolvedCompatListen(&nc, log)
+		oc := cur.Load()
+		changes := oc.Diff(&nc)
+		if len(changes) == 0 {
+			log.Info("Configuration unchanged")
+			return nil
+		}
+		changed := func(names ...string) bool {
+			for _, name := range names {
+				if slices.Contains(changes, name) {
+					return true
+				}
+			}
+			return false
+		}
+		px := *p.live.Load()
+		if changed("allow", "deny", "deny-action") {
+			if err := setupACL(&nc, &px); err != nil {
+				return err
+			}
+		}
+		if changed("rate-limit", "rate-limit-burst", "rate-limit-key", "rate-limit-action") {
+			if err := setupRateLimit(&nc, &px); err != nil {
+				return err
+			}
+		}
+		if changed("blocklist", "blocklist-mode", "blocklist-response") {
+			if err := setupBlocklist(&nc, &px); err != nil {
+				return err
+			}
+		}
+		if changed("zone-file") {
+			setupZones(&nc, &px)
+		}
+		if changed("static-devices") {
+			setStaticDevices(nc.StaticDevices)
+		}
+		if changed("profile", "static-devices") {
+			setProfiles(nc.Profile)
+		}
+		if changed("forwarder", "dnssec-trust-anchors") {
+			setupForwarders(&nc, &px)
+		}
+		px.BogusPriv = nc.BogusPriv
+		px.Timeout = nc.Timeout
+		if changed("profile", "listen", "report-client-info", "use-hosts", "mdns", "discovery-dns", "static-devices", "netbios", "ssdp", "local-domain", "mdns-gateway") {
+			setupDiscovery(&nc, &px)
+		}
+		p.live.Store(&px)
+		cur.Store(&nc)
+
+		var ignored []string
+		for _, name := range changes {
+			switch name {
+			case "profile", "forwarder", "dnssec-trust-anchors", "log-queries", "bogus-priv",
+				"timeout", "listen", "report-client-info", "use-hosts", "mdns", "discovery-dns",
+				"static-devices", "netbios", "ssdp", "local-domain", "mdns-gateway",
+				"allow", "deny", "deny-action", "rate-limit", "rate-limit-burst", "rate-limit-key",
+				"rate-limit-action", "blocklist", "blocklist-mode", "blocklist-response", "zone-file":
+			default:
+				ignored = append(ignored, name)
+			}
+		}
+		log.Infof("Configuration reloaded: %s changed", strings.Join(changes, ", "))
+		if len(ignored) > 0 {
+			log.Warningf("Changes to %s require a restart", strings.Join(ignored, ", "))
+		}
+		if changed("listen") {
+			return p.rebind(nc.Listens)
+		}
+		return nil
+	}
+	ctl.Command("reload", func(data any) any {
+		if err := p.Reload(); err != nil {
+			return fmt.Sprintf("reload failed: %v", err)
+		}
+		return "configuration reloaded"
+	})
+
 	if err = service.Run("nextdns", p); err != nil {
 		log.Errorf("Startup failed: %v", err)
 		return err
@@ -561,13 +1062,14 @@ func resolvedStubConflictsWithLocalhost(stubAddrs []net.IP) bool {
 
 // nextdnsEndpointManager returns a endpoint.Manager configured to connect to
 // NextDNS using different steering techniques.
-func nextdnsEndpointManager(log host.Logger, debug bool, canFallback func() bool) *endpoint.Manager {
+func nextdnsEndpointManager(log host.Logger, debug bool, upstreamProxy endpoint.ProxyFunc, canFallback func() bool) *endpoint.Manager {
 	m := &endpoint.Manager{
 		Providers: []endpoint.Provider{
 			// Prefer unicast routing.
 			&endpoint.SourceHTTPSSVCProvider{
 				Hostname: "dns.nextdns.io",
 				Source:   endpoint.MustNew("https://dns.nextdns.io#45.90.28.0,2a07:a8c0::,45.90.30.0,2a07:a8c1::"),
+				Proxy:    upstreamProxy,
 			},
 			// Try routing without anycast bootstrap.
 			// TOFIX: this creates circular dependency if the /etc/resolv.conf is setup to localhost.
@@ -582,6 +1084,7 @@ func nextdnsEndpointManager(log host.Logger, debug bool, canFallback func() bool
 			}),
 		},
 		InitEndpoint: endpoint.MustNew("https://dns.nextdns.io#45.90.28.0,2a07:a8c0::,45.90.30.0,2a07:a8c1::"),
+		Proxy:        upstreamProxy,
 		OnError: func(e endpoint.Endpoint, err error) {
 			log.Warningf("Endpoint failed: %v: %v", e, err)
 		},
@@ -589,6 +1092,16 @@ func nextdnsEndpointManager(log host.Logger, debug bool, canFallback func() bool
 			log.Warningf("Endpoint provider failed: %v: %v", p, err)
 		},
 		OnConnect: func(ci *endpoint.ConnectInfo) {
+			if ci.Proxy != "" {
+				log.Infof("Connected %s via proxy %s (con=%dms tls=%dms, %s, %s)",
+					ci.ServerAddr,
+					ci.Proxy,
+					ci.ConnectTimes[ci.Proxy]/time.Millisecond,
+					ci.TLSTime/time.Millisecond,
+					ci.Protocol,
+					ci.TLSVersion)
+				return
+			}
 			log.Infof("Connected %s (con=%dms tls=%dms, %s, %s)",
 				ci.ServerAddr,
 				ci.ConnectTimes[ci.ServerAddr]/time.Millisecond,
@@ -648,7 +1161,94 @@ func nextdnsEndpointManager(log host.Logger, debug bool, canFallback func() bool
 	return m
 }
 
-func setupClientReporting(p *proxySvc, conf *config.Profiles, r discovery.Resolver) {
+// profileURLFunc returns a function returning the NextDNS URL and profile ID
+// to use for a query. The profile of a device defined in devices takes
+// precedence over profiles, and its name can be matched by profile rules.
+// devices may be nil.
+func profileURLFunc(profiles config.Profiles, devices *discovery.Static) func(q query.Query) (string, string) {
+	if devices == nil && (len(profiles) == 0 || (len(profiles) == 1 && profiles.Get(nil, nil, nil) != "")) {
+		// Optimize for no dynamic configuration.
+		profileID := profiles.Get(nil, nil, nil)
+		profileURL := "https://dns.nextdns.io/" + profileID
+		return func(q query.Query) (string, string) {
+			return profileURL, profileID
+		}
+	}
+	hasUserRules := profiles.HasUserRules()
+	return func(q query.Query) (string, string) {
+		var device string
+		if devices != nil {
+			if d, found := devices.Lookup(q.PeerIP, q.MAC); found {
+				if d.Profile != "" {
+					return "https://dns.nextdns.io/" + d.Profile, d.Profile
+				}
+				device = d.Name
+			}
+		}
+		profileID := profiles.GetWithDevice(q.PeerIP, q.LocalIP, q.MAC, "", device)
+		if hasUserRules && q.PeerIP.IsLoopback() {
+			profileID = profiles.GetWithDevice(q.PeerIP, q.LocalIP, q.MAC, host.ActiveUser(), device)
+		}
+		return "https://dns.nextdns.io/" + profileID, profileID
+	}
+}
+
+// discoveryRunner runs a listening discovery source while the proxy is
+// running and restarts it when the interface filter changes.
+type discoveryRunner struct {
+	source interface {
+		Start(ctx context.Context, filter string) error
+	}
+	name string
+	log  host.Logger
+
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+	filter string
+}
+
+// run starts the discovery until ctx is cancelled.
+func (m *discoveryRunner) run(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ctx = ctx
+	m.startLocked()
+}
+
+// setFilter sets the interface filter, restarting the discovery if running.
+func (m *discoveryRunner) setFilter(filter string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if filter == m.filter {
+		return
+	}
+	m.filter = filter
+	if m.ctx != nil {
+		m.startLocked()
+	}
+}
+
+func (m *discoveryRunner) startLocked() {
+	if m.cancel != nil {
+		m.cancel()
+		m.cancel = nil
+	}
+	if m.filter == "disabled" || m.ctx.Err() != nil {
+		return
+	}
+	var ctx context.Context
+	ctx, m.cancel = context.WithCancel(m.ctx)
+	m.log.Infof("Starting %s discovery", m.name)
+	if err := m.source.Start(ctx, m.filter); err != nil {
+		m.log.Errorf("Cannot start %s: %v", m.name, err)
+	}
+}
+
+// clientReporting returns a function returning the client information sent
+// upstream for a query. The model reported by discovery sources, or defined in
+// devices which may be nil, is reported instead of the MAC vendor.
+func clientReporting(conf *config.Profiles, r discovery.Resolver, devices *discovery.Static) func(q query.Query) resolver.ClientInfo {
 	deviceName, _ := host.Name()
 	deviceID, _ := machineid.ProtectedID("NextDNS")
 	deviceModel := host.Model()
@@ -658,7 +1258,7 @@ func setupClientReporting(p *proxySvc, conf *config.Profiles, r discovery.Resolv
 	}
 	deviceID = strings.ToUpper(deviceID)
 
-	p.resolver.DOH.ClientInfo = func(q query.Query) (ci resolver.ClientInfo) {
+	return func(q query.Query) (ci resolver.ClientInfo) {
 		if !q.PeerIP.IsLoopback() {
 			// When acting as router, try to guess as much info as possible from
 			// LAN client.
@@ -684,6 +1284,14 @@ func setupClientReporting(p *proxySvc, conf *config.Profiles, r discovery.Resolv
 			if ci.ID == "" {
 				ci.ID = shortID(conf.Get(q.PeerIP, q.LocalIP, q.MAC), q.PeerIP)
 			}
+			if model := r.LookupModel(q.PeerIP.String()); model != "" {
+				ci.Model = model
+			}
+			if devices != nil {
+				if d, found := devices.Lookup(q.PeerIP, q.MAC); found && d.Model != "" {
+					ci.Model = d.Model
+				}
+			}
 			return
 		}
 
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/nextdns/nextdns/config"
+	"github.com/nextdns/nextdns/discovery"
+	"github.com/nextdns/nextdns/resolver/query"
 )
 
 func Test_isLocalhostMode(t *testing.T) {
@@ -26,3 +31,60 @@ func Test_isLocalhostMode(t *testing.T) {
 		})
 	}
 }
+
+func Test_profileURLFunc(t *testing.T) {
+	var profiles config.Profiles
+	for _, v := range []string{"10.0.0.0/8=123456", "abcdef"} {
+		if err := profiles.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := profileURLFunc(profiles, nil)
+	tests := []struct {
+		ip          string
+		wantURL     string
+		wantProfile string
+	}{
+		{"10.0.0.1", "https://dns.nextdns.io/123456", "123456"},
+		{"192.168.0.1", "https://dns.nextdns.io/abcdef", "abcdef"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			url, profile := f(query.Query{PeerIP: net.ParseIP(tt.ip)})
+			if url != tt.wantURL || profile != tt.wantProfile {
+				t.Errorf("profileURLFunc() = %s, %s, want %s, %s", url, profile, tt.wantURL, tt.wantProfile)
+			}
+		})
+	}
+}
+
+func Test_profileURLFunc_staticDevices(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "devices")
+	data := "10.0.0.1 kids-ipad profile=kids\n" +
+		"10.0.0.2 nas\n"
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var profiles config.Profiles
+	for _, v := range []string{"device:nas=123456", "abcdef"} {
+		if err := profiles.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := profileURLFunc(profiles, &discovery.Static{File: file})
+	tests := []struct {
+		ip          string
+		wantProfile string
+	}{
+		{"10.0.0.1", "kids"},
+		{"10.0.0.2", "123456"},
+		{"10.0.0.3", "abcdef"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if _, profile := f(query.Query{PeerIP: net.ParseIP(tt.ip)}); profile != tt.wantProfile {
+				t.Errorf("profileURLFunc() = %s, want %s", profile, tt.wantProfile)
+			}
+		})
+	}
+}
@@ -0,0 +1,393 @@
+package zone
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+// parser reads a zone file in the RFC 1035 master file format. Only the subset
+// of the format useful to define local records is supported: the $ORIGIN and
+// $TTL directives, parentheses to span records over multiple lines, comments
+// and the A, AAAA, CNAME, TXT, SRV, MX, PTR, NS and SOA record types.
+type parser struct {
+	origin     string
+	defaultTTL uint32
+	lastOwner  string
+	line       int
+}
+
+type parseError struct {
+	line int
+	err  error
+}
+
+func (e parseError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.line, e.err)
+}
+
+func (e parseError) Unwrap() error {
+	return e.err
+}
+
+// parse reads r and calls add for each record found.
+func (p *parser) parse(r io.Reader, add func(owner string, r record)) error {
+	s := bufio.NewScanner(r)
+	var tokens []string
+	var depth int
+	var startLine int
+	var continued bool
+	for s.Scan() {
+		p.line++
+		line := s.Text()
+		toks, open, err := tokenize(line)
+		if err != nil {
+			return parseError{p.line, err}
+		}
+		if depth == 0 {
+			if len(toks) == 0 {
+				continue
+			}
+			tokens = toks
+			startLine = p.line
+			// A record starting with a blank refers to the previous owner.
+			continued = len(line) > 0 && (line[0] == ' ' || line[0] == '\t')
+		} else {
+			tokens = append(tokens, toks...)
+		}
+		depth += open
+		if depth < 0 {
+			return parseError{p.line, errors.New("unbalanced parentheses")}
+		}
+		if depth > 0 {
+			continue
+		}
+		if err := p.entry(tokens, continued, add); err != nil {
+			return parseError{startLine, err}
+		}
+		tokens = nil
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if depth != 0 {
+		return parseError{startLine, errors.New("unclosed parenthesis")}
+	}
+	return nil
+}
+
+// Walk reads a zone file from r and calls fn for each record found. Owner
+// names are lowercased and fully qualified. Without $ORIGIN directive, the
+// origin is the root.
+func Walk(r io.Reader, fn func(rr dnsmessage.Resource)) error {
+	p := &parser{origin: ".", defaultTTL: DefaultTTL}
+	return p.parse(r, func(owner string, r record) {
+		fn(resource(owner, r))
+	})
+}
+
+// tokenize splits line in tokens, removing comments and parentheses. The
+// returned open value is the balance of opened minus closed parentheses.
+func tokenize(line string) (tokens []string, open int, err error) {
+	var sb strings.Builder
+	inToken := false
+	flush := func() {
+		if inToken {
+			tokens = append(tokens, sb.String())
+			sb.Reset()
+			inToken = false
+		}
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ';':
+			flush()
+			return tokens, open, nil
+		case c == '(':
+			flush()
+			open++
+		case c == ')':
+			flush()
+			open--
+		case c == ' ' || c == '\t':
+			flush()
+		case c == '"':
+			flush()
+			j := i + 1
+			for ; j < len(line) && line[j] != '"'; j++ {
+				if line[j] == '\\' && j+1 < len(line) {
+					j++
+				}
+				sb.WriteByte(line[j])
+			}
+			if j >= len(line) {
+				return nil, 0, errors.New("unterminated quoted string")
+			}
+			// Quoted strings are kept with a leading quote to distinguish
+			// them from bare words.
+			tokens = append(tokens, `"`+sb.String())
+			sb.Reset()
+			i = j
+		default:
+			inToken = true
+			sb.WriteByte(c)
+		}
+	}
+	flush()
+	return tokens, open, nil
+}
+
+func (p *parser) entry(tokens []string, continued bool, add func(owner string, r record)) error {
+	switch strings.ToUpper(tokens[0]) {
+	case "$ORIGIN":
+		if len(tokens) != 2 {
+			return errors.New("$ORIGIN: invalid syntax")
+		}
+		p.origin = p.name(tokens[1])
+		return nil
+	case "$TTL":
+		if len(tokens) != 2 {
+			return errors.New("$TTL: invalid syntax")
+		}
+		ttl, err := parseTTL(tokens[1])
+		if err != nil {
+			return fmt.Errorf("$TTL: %v", err)
+		}
+		p.defaultTTL = ttl
+		return nil
+	case "$INCLUDE", "$GENERATE":
+		return fmt.Errorf("%s: unsupported directive", tokens[0])
+	}
+
+	owner := p.lastOwner
+	if !continued {
+		owner = p.name(tokens[0])
+		tokens = tokens[1:]
+	}
+	if owner == "" {
+		return errors.New("missing owner name")
+	}
+	p.lastOwner = owner
+
+	ttl := p.defaultTTL
+	// TTL and class are optional and can appear in any order.
+	for len(tokens) > 0 {
+		if strings.EqualFold(tokens[0], "IN") {
+			tokens = tokens[1:]
+			continue
+		}
+		if t, err := parseTTL(tokens[0]); err == nil {
+			ttl = t
+			tokens = tokens[1:]
+			continue
+		}
+		break
+	}
+	if len(tokens) == 0 {
+		return errors.New("missing record type")
+	}
+	typ, found := types[strings.ToUpper(tokens[0])]
+	if !found {
+		return fmt.Errorf("%s: unsupported record type", tokens[0])
+	}
+	body, err := p.rdata(typ, tokens[1:])
+	if err != nil {
+		return fmt.Errorf("%s %s: %v", owner, tokens[0], err)
+	}
+	add(owner, record{typ: typ, ttl: ttl, body: body})
+	return nil
+}
+
+var types = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"TXT":   dnsmessage.TypeTXT,
+	"SRV":   dnsmessage.TypeSRV,
+	"MX":    dnsmessage.TypeMX,
+	"PTR":   dnsmessage.TypePTR,
+	"NS":    dnsmessage.TypeNS,
+	"SOA":   dnsmessage.TypeSOA,
+}
+
+func (p *parser) rdata(typ dnsmessage.Type, args []string) (dnsmessage.ResourceBody, error) {
+	want := map[dnsmessage.Type]int{
+		dnsmessage.TypeA:     1,
+		dnsmessage.TypeAAAA:  1,
+		dnsmessage.TypeCNAME: 1,
+		dnsmessage.TypePTR:   1,
+		dnsmessage.TypeNS:    1,
+		dnsmessage.TypeMX:    2,
+		dnsmessage.TypeSRV:   4,
+		dnsmessage.TypeSOA:   7,
+	}
+	if n, found := want[typ]; found && len(args) != n {
+		return nil, fmt.Errorf("expected %d arguments, got %d", n, len(args))
+	}
+	switch typ {
+	case dnsmessage.TypeA:
+		ip := net.ParseIP(args[0]).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("%s: invalid IPv4 address", args[0])
+		}
+		var rr dnsmessage.AResource
+		copy(rr.A[:], ip)
+		return &rr, nil
+	case dnsmessage.TypeAAAA:
+		ip := net.ParseIP(args[0])
+		if ip == nil || ip.To4() != nil {
+			return nil, fmt.Errorf("%s: invalid IPv6 address", args[0])
+		}
+		var rr dnsmessage.AAAAResource
+		copy(rr.AAAA[:], ip.To16())
+		return &rr, nil
+	case dnsmessage.TypeCNAME:
+		n, err := p.target(args[0])
+		return &dnsmessage.CNAMEResource{CNAME: n}, err
+	case dnsmessage.TypePTR:
+		n, err := p.target(args[0])
+		return &dnsmessage.PTRResource{PTR: n}, err
+	case dnsmessage.TypeNS:
+		n, err := p.target(args[0])
+		return &dnsmessage.NSResource{NS: n}, err
+	case dnsmessage.TypeMX:
+		pref, err := strconv.ParseUint(args[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid preference", args[0])
+		}
+		n, err := p.target(args[1])
+		return &dnsmessage.MXResource{Pref: uint16(pref), MX: n}, err
+	case dnsmessage.TypeSRV:
+		var v [3]uint16
+		for i := range v {
+			n, err := strconv.ParseUint(args[i], 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid number", args[i])
+			}
+			v[i] = uint16(n)
+		}
+		n, err := p.target(args[3])
+		return &dnsmessage.SRVResource{Priority: v[0], Weight: v[1], Port: v[2], Target: n}, err
+	case dnsmessage.TypeTXT:
+		if len(args) == 0 {
+			return nil, errors.New("missing text")
+		}
+		txt := make([]string, 0, len(args))
+		for _, a := range args {
+			a = strings.TrimPrefix(a, `"`)
+			if len(a) > 255 {
+				return nil, errors.New("text string too long")
+			}
+			txt = append(txt, a)
+		}
+		return &dnsmessage.TXTResource{TXT: txt}, nil
+	case dnsmessage.TypeSOA:
+		ns, err := p.target(args[0])
+		if err != nil {
+			return nil, err
+		}
+		mbox, err := p.target(args[1])
+		if err != nil {
+			return nil, err
+		}
+		var v [5]uint32
+		for i := range v {
+			if i == 0 {
+				n, err := strconv.ParseUint(args[2], 10, 32)
+				if err != nil {
+					return nil, fmt.Errorf("%s: invalid serial", args[2])
+				}
+				v[0] = uint32(n)
+				continue
+			}
+			if v[i], err = parseTTL(args[2+i]); err != nil {
+				return nil, err
+			}
+		}
+		return &dnsmessage.SOAResource{
+			NS:      ns,
+			MBox:    mbox,
+			Serial:  v[0],
+			Refresh: v[1],
+			Retry:   v[2],
+			Expire:  v[3],
+			MinTTL:  v[4],
+		}, nil
+	}
+	return nil, errors.New("unsupported record type")
+}
+
+// name returns the fully qualified version of s relative to the current
+// origin. Without origin, relative names are considered absolute.
+func (p *parser) name(s string) string {
+	if s == "@" {
+		return p.origin
+	}
+	s = strings.ToLower(s)
+	if strings.HasSuffix(s, ".") {
+		return s
+	}
+	if p.origin == "" || p.origin == "." {
+		return s + "."
+	}
+	return s + "." + p.origin
+}
+
+func (p *parser) target(s string) (dnsmessage.Name, error) {
+	n := p.name(s)
+	if n == "" {
+		return dnsmessage.Name{}, fmt.Errorf("%s: invalid name", s)
+	}
+	return dnsmessage.NewName(n)
+}
+
+// parseTTL parses a TTL expressed either as a number of seconds or with BIND
+// style units (1h30m, 1d, 1w).
+func parseTTL(s string) (uint32, error) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(n), nil
+	}
+	var total time.Duration
+	var num uint64
+	var hasNum bool
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= '0' && c <= '9' {
+			num = num*10 + uint64(c-'0')
+			hasNum = true
+			continue
+		}
+		if !hasNum {
+			return 0, fmt.Errorf("%s: invalid TTL", s)
+		}
+		var unit time.Duration
+		switch c | 0x20 {
+		case 's':
+			unit = time.Second
+		case 'm':
+			unit = time.Minute
+		case 'h':
+			unit = time.Hour
+		case 'd':
+			unit = 24 * time.Hour
+		case 'w':
+			unit = 7 * 24 * time.Hour
+		default:
+			return 0, fmt.Errorf("%s: invalid TTL", s)
+		}
+		total += time.Duration(num) * unit
+		num, hasNum = 0, false
+	}
+	if s == "" || hasNum || total/time.Second > 1<<31-1 {
+		return 0, fmt.Errorf("%s: invalid TTL", s)
+	}
+	return uint32(total / time.Second), nil
+}
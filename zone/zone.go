@@ -0,0 +1,301 @@
+// Package zone implements local authoritative zones and static records loaded
+// from zone files.
+package zone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/internal/dnsreply"
+	"github.com/nextdns/nextdns/internal/fileinfo"
+	"github.com/nextdns/nextdns/resolver"
+	"github.com/nextdns/nextdns/resolver/query"
+)
+
+// DefaultTTL is the TTL used for records without explicit TTL when no $TTL
+// directive is defined.
+const DefaultTTL = 300
+
+const maxCNAMEChain = 8
+
+var errNotFound = errors.New("not found")
+
+// Zones answers queries using records defined in zone files.
+//
+// Names under a zone with a SOA record are answered authoritatively: unknown
+// names get a NXDOMAIN and names existing with other types get an empty
+// NOERROR (NODATA) response. Names defined outside of any zone are static
+// records: only names defined in the files are answered, other queries are left
+// to the upstream.
+//
+// Files are reloaded automatically when they change.
+type Zones struct {
+	// Files is the list of zone files to load.
+	Files []string
+
+	// DefaultTTL is the TTL used for records without explicit TTL when no $TTL
+	// directive is defined. If zero, DefaultTTL is used.
+	DefaultTTL uint32
+
+	// OnError is called when a zone file cannot be loaded. The previously
+	// loaded version of the zones is kept in such case.
+	OnError func(err error)
+
+	mu        sync.RWMutex
+	data      *data
+	fileInfos []fileinfo.Info
+	expires   time.Time
+}
+
+type record struct {
+	typ  dnsmessage.Type
+	ttl  uint32
+	body dnsmessage.ResourceBody
+}
+
+type data struct {
+	// records lists records per lowercase FQDN owner name. Wildcard owners are
+	// stored with their "*." prefix.
+	records map[string][]record
+
+	// nonTerminals lists names existing in authoritative zones only because
+	// they have descendants (RFC 4592 empty non-terminals).
+	nonTerminals map[string]struct{}
+
+	// soa lists SOA records per zone apex.
+	soa map[string]record
+}
+
+func newData() *data {
+	return &data{
+		records:      map[string][]record{},
+		nonTerminals: map[string]struct{}{},
+		soa:          map[string]record{},
+	}
+}
+
+func (d *data) add(owner string, r record) {
+	if r.typ == dnsmessage.TypeSOA {
+		d.soa[owner] = r
+	}
+	d.records[owner] = append(d.records[owner], r)
+}
+
+// finalize computes the empty non-terminals once all the records are loaded.
+func (d *data) finalize() {
+	for owner := range d.records {
+		apex := d.apex(owner)
+		if apex == "" {
+			continue
+		}
+		for p := parent(owner); p != "" && p != apex; p = parent(p) {
+			d.nonTerminals[p] = struct{}{}
+		}
+	}
+}
+
+// apex returns the closest zone apex name belongs to or an empty string if not
+// under any authoritative zone.
+func (d *data) apex(name string) string {
+	for p := name; p != ""; p = parent(p) {
+		if _, found := d.soa[p]; found {
+			return p
+		}
+	}
+	return ""
+}
+
+// find returns the records matching name, either directly or via a wildcard.
+// The exists value is true if name exists, even with no records.
+func (d *data) find(name string) (rrs []record, exists bool) {
+	if rrs, found := d.records[name]; found {
+		return rrs, true
+	}
+	if _, found := d.nonTerminals[name]; found {
+		return nil, true
+	}
+	for p := parent(name); p != ""; p = parent(p) {
+		if rrs, found := d.records["*."+p]; found {
+			return rrs, true
+		}
+		// The closest encloser stops the wildcard search.
+		if _, found := d.records[p]; found {
+			break
+		}
+		if _, found := d.nonTerminals[p]; found {
+			break
+		}
+	}
+	return nil, false
+}
+
+// parent returns the parent domain of name or an empty string for TLDs.
+func parent(name string) string {
+	idx := strings.IndexByte(name, '.')
+	if idx == -1 || idx == len(name)-1 {
+		return ""
+	}
+	return name[idx+1:]
+}
+
+// Resolve implements the resolver.Resolver interface. An error is returned
+// if q is not covered by the local zones.
+func (z *Zones) Resolve(ctx context.Context, q query.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	d := z.get()
+	if d == nil || (q.Class != query.ClassINET && q.Class != query.ClassANY) {
+		return 0, i, errNotFound
+	}
+	i.Transport = "zone"
+
+	qtype := dnsmessage.Type(q.Type)
+	name := strings.ToLower(q.Name)
+	rcode := dnsmessage.RCodeSuccess
+	var answers []dnsmessage.Resource
+	owner := q.Name
+	for hop := range maxCNAMEChain {
+		rrs, exists := d.find(name)
+		if !exists {
+			if hop == 0 && d.apex(name) == "" {
+				return 0, i, errNotFound
+			}
+			if d.apex(name) != "" {
+				// Per RFC 6604, the rcode applies to the last name of
+				// the chain.
+				rcode = dnsmessage.RCodeNameError
+			}
+			break
+		}
+		var cname *record
+		matched := false
+		for j := range rrs {
+			r := rrs[j]
+			if r.typ == qtype || qtype == dnsmessage.TypeALL {
+				answers = append(answers, resource(owner, r))
+				matched = true
+			} else if r.typ == dnsmessage.TypeCNAME {
+				cname = &rrs[j]
+			}
+		}
+		if matched || cname == nil {
+			break
+		}
+		answers = append(answers, resource(owner, *cname))
+		owner = cname.body.(*dnsmessage.CNAMEResource).CNAME.String()
+		name = strings.ToLower(owner)
+	}
+
+	var authorities []dnsmessage.Resource
+	apex := d.apex(name)
+	if (len(answers) == 0 || rcode != dnsmessage.RCodeSuccess) && apex != "" {
+		soa := d.soa[apex]
+		// RFC 2308: the TTL of negative answers is the minimum of the SOA
+		// TTL and its MINIMUM field.
+		soa.ttl = min(soa.ttl, soa.body.(*dnsmessage.SOAResource).MinTTL)
+		authorities = append(authorities, resource(apex, soa))
+	}
+
+	n, err = dnsreply.Write(q, buf, rcode, apex != "", answers, authorities)
+	return n, i, err
+}
+
+func resource(owner string, r record) dnsmessage.Resource {
+	name, _ := dnsmessage.NewName(owner)
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name:  name,
+			Type:  r.typ,
+			Class: dnsmessage.ClassINET,
+			TTL:   r.ttl,
+		},
+		Body: r.body,
+	}
+}
+
+func (z *Zones) get() *data {
+	z.mu.RLock()
+	expired := !time.Now().Before(z.expires)
+	d := z.data
+	z.mu.RUnlock()
+	if !expired {
+		return d
+	}
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.refreshLocked()
+	return z.data
+}
+
+func (z *Zones) refreshLocked() {
+	now := time.Now()
+	if now.Before(z.expires) {
+		return
+	}
+	z.expires = now.Add(5 * time.Second)
+
+	if z.fileInfos != nil && len(z.fileInfos) == len(z.Files) {
+		changed := false
+		for _, fi := range z.fileInfos {
+			if !fi.Equal(fi.Path) {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			return
+		}
+	}
+
+	d, fileInfos, err := z.load()
+	// Record the file states even on error so a broken file is not reparsed
+	// (and reported) until changed again.
+	z.fileInfos = fileInfos
+	if err != nil {
+		if z.OnError != nil {
+			z.OnError(err)
+		}
+		return
+	}
+	z.data = d
+}
+
+func (z *Zones) load() (*data, []fileinfo.Info, error) {
+	defaultTTL := z.DefaultTTL
+	if defaultTTL == 0 {
+		defaultTTL = DefaultTTL
+	}
+	d := newData()
+	fileInfos := make([]fileinfo.Info, 0, len(z.Files))
+	var errs []error
+	for _, file := range z.Files {
+		fi, err := fileinfo.Get(file)
+		fileInfos = append(fileInfos, fi)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := loadFile(file, defaultTTL, d); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", file, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fileInfos, errors.Join(errs...)
+	}
+	d.finalize()
+	return d, fileInfos, nil
+}
+
+func loadFile(file string, defaultTTL uint32, d *data) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	p := &parser{defaultTTL: defaultTTL}
+	return p.parse(f, d.add)
+}
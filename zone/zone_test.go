@@ -0,0 +1,257 @@
+package zone
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/internal/dnstest"
+)
+
+const testZone = `
+$ORIGIN lan.
+$TTL 1h
+@        IN SOA ns.lan. admin.lan. (
+                2024010101 ; serial
+                3600 600 86400 60 )
+router      IN A    192.168.1.1
+            IN AAAA fd00::1
+www      30 IN CNAME router
+ext         CNAME   example.com.
+loop        CNAME   loop
+*.dev       A       10.0.0.5
+host.sub    A       10.0.0.6
+txt         TXT     "hello world" second
+_sip._tcp   SRV     10 5 5060 router
+@           MX      10 router
+1.1.168.192.in-addr.arpa. PTR router.lan.
+static.example.com. 120 A 1.2.3.4
+`
+
+func newTestZones(t *testing.T, content string) *Zones {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "zone")
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &Zones{
+		Files:   []string{file},
+		OnError: func(err error) { t.Errorf("OnError: %v", err) },
+	}
+}
+
+func TestZones_Resolve(t *testing.T) {
+	z := newTestZones(t, testZone)
+	tests := []struct {
+		name     string
+		qname    string
+		qtype    dnsmessage.Type
+		notFound bool
+		want     dnstest.Result
+	}{
+		{
+			name:  "A",
+			qname: "router.lan.",
+			qtype: dnsmessage.TypeA,
+			want:  dnstest.Result{Answers: []string{"router.lan. A 192.168.1.1 3600"}},
+		},
+		{
+			name:  "Case insensitive",
+			qname: "Router.LAN.",
+			qtype: dnsmessage.TypeAAAA,
+			want:  dnstest.Result{Answers: []string{"Router.LAN. AAAA fd00::1 3600"}},
+		},
+		{
+			name:  "CNAME chain",
+			qname: "www.lan.",
+			qtype: dnsmessage.TypeA,
+			want: dnstest.Result{Answers: []string{
+				"www.lan. CNAME router.lan. 30",
+				"router.lan. A 192.168.1.1 3600",
+			}},
+		},
+		{
+			name:  "CNAME out of zone",
+			qname: "ext.lan.",
+			qtype: dnsmessage.TypeA,
+			want:  dnstest.Result{Answers: []string{"ext.lan. CNAME example.com. 3600"}},
+		},
+		{
+			name:  "CNAME loop",
+			qname: "loop.lan.",
+			qtype: dnsmessage.TypeA,
+			want: dnstest.Result{Answers: []string{
+				"loop.lan. CNAME loop.lan. 3600",
+				"loop.lan. CNAME loop.lan. 3600",
+				"loop.lan. CNAME loop.lan. 3600",
+				"loop.lan. CNAME loop.lan. 3600",
+				"loop.lan. CNAME loop.lan. 3600",
+				"loop.lan. CNAME loop.lan. 3600",
+				"loop.lan. CNAME loop.lan. 3600",
+				"loop.lan. CNAME loop.lan. 3600",
+			}},
+		},
+		{
+			name:  "Wildcard",
+			qname: "foo.bar.dev.lan.",
+			qtype: dnsmessage.TypeA,
+			want:  dnstest.Result{Answers: []string{"foo.bar.dev.lan. A 10.0.0.5 3600"}},
+		},
+		{
+			name:  "NODATA",
+			qname: "router.lan.",
+			qtype: dnsmessage.TypeTXT,
+			want:  dnstest.Result{Authorities: []string{"lan. SOA ns.lan. 60"}},
+		},
+		{
+			name:  "Empty non-terminal",
+			qname: "sub.lan.",
+			qtype: dnsmessage.TypeA,
+			want:  dnstest.Result{Authorities: []string{"lan. SOA ns.lan. 60"}},
+		},
+		{
+			name:  "NXDOMAIN",
+			qname: "unknown.lan.",
+			qtype: dnsmessage.TypeA,
+			want: dnstest.Result{
+				RCode:       dnsmessage.RCodeNameError,
+				Authorities: []string{"lan. SOA ns.lan. 60"},
+			},
+		},
+		{
+			name:  "TXT",
+			qname: "txt.lan.",
+			qtype: dnsmessage.TypeTXT,
+			want:  dnstest.Result{Answers: []string{"txt.lan. TXT hello world|second 3600"}},
+		},
+		{
+			name:  "SRV",
+			qname: "_sip._tcp.lan.",
+			qtype: dnsmessage.TypeSRV,
+			want:  dnstest.Result{Answers: []string{"_sip._tcp.lan. SRV router.lan. 3600"}},
+		},
+		{
+			name:  "MX",
+			qname: "lan.",
+			qtype: dnsmessage.TypeMX,
+			want:  dnstest.Result{Answers: []string{"lan. MX router.lan. 3600"}},
+		},
+		{
+			name:  "PTR",
+			qname: "1.1.168.192.in-addr.arpa.",
+			qtype: dnsmessage.TypePTR,
+			want:  dnstest.Result{Answers: []string{"1.1.168.192.in-addr.arpa. PTR router.lan. 3600"}},
+		},
+		{
+			name:  "Static record",
+			qname: "static.example.com.",
+			qtype: dnsmessage.TypeA,
+			want:  dnstest.Result{Answers: []string{"static.example.com. A 1.2.3.4 120"}},
+		},
+		{
+			name:  "Static record NODATA",
+			qname: "static.example.com.",
+			qtype: dnsmessage.TypeAAAA,
+			want:  dnstest.Result{},
+		},
+		{
+			name:     "Outside zones",
+			qname:    "other.example.com.",
+			qtype:    dnsmessage.TypeA,
+			notFound: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := dnstest.NewQuery(t, tt.qname, tt.qtype)
+			buf := make([]byte, 4096)
+			n, i, err := z.Resolve(context.Background(), q, buf)
+			if tt.notFound {
+				if err == nil {
+					t.Fatalf("Resolve() err = nil, want not found")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve() err = %v", err)
+			}
+			if i.Transport != "zone" {
+				t.Errorf("Resolve() transport = %q, want zone", i.Transport)
+			}
+			if got := dnstest.ParseResult(t, buf[:n]); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Resolve() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZones_Reload(t *testing.T) {
+	z := newTestZones(t, "host.example. A 1.2.3.4\n")
+	q := dnstest.NewQuery(t, "host.example.", dnsmessage.TypeA)
+	buf := make([]byte, 4096)
+	if _, _, err := z.Resolve(context.Background(), q, buf); err != nil {
+		t.Fatalf("Resolve() err = %v", err)
+	}
+	if err := os.WriteFile(z.Files[0], []byte("other.example. A 1.2.3.4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	z.mu.Lock()
+	z.expires = time.Time{} // force refresh
+	z.mu.Unlock()
+	if _, _, err := z.Resolve(context.Background(), q, buf); err == nil {
+		t.Fatalf("Resolve() err = nil after reload, want not found")
+	}
+}
+
+func Test_parseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		zone string
+		want string
+	}{
+		{"unknown type", "a.example. HINFO foo bar", "line 1: HINFO: unsupported record type"},
+		{"bad IP", "\na.example. A 1.2.3", "line 2: a.example. A: 1.2.3: invalid IPv4 address"},
+		{"missing owner", " A 1.2.3.4", "line 1: missing owner name"},
+		{"unclosed", "@ SOA a b (", "line 1: unclosed parenthesis"},
+		{"include", "$INCLUDE foo", "line 1: $INCLUDE: unsupported directive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &parser{origin: "example."}
+			err := p.parse(strings.NewReader(tt.zone), newData().add)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("parse() err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseTTL(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint32
+		wantErr bool
+	}{
+		{"300", 300, false},
+		{"1h", 3600, false},
+		{"1h30m", 5400, false},
+		{"1d", 86400, false},
+		{"1W", 604800, false},
+		{"A", 0, true},
+		{"1x", 0, true},
+		{"10m5", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseTTL(tt.in)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseTTL() = %v, %v, want %v, err %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}